@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/SvenShi/ospf-neighbor/ospf_cnn"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// 宣告路由的请求体
+type prefixRequest struct {
+	Prefix            string  `json:"prefix"`
+	Metric            *uint32 `json:"metric,omitempty"`
+	MetricType        string  `json:"metric_type,omitempty"` // E1 或 E2, 默认 E2
+	ForwardingAddress string  `json:"forwarding_address,omitempty"`
+	Tag               uint32  `json:"tag,omitempty"`
+}
+
+type lsaIdentityResponse struct {
+	LSType      uint16 `json:"ls_type"`
+	LinkStateId string `json:"link_state_id"`
+	AdvRouter   string `json:"adv_router"`
+}
+
+// 已宣告路由的响应体
+type prefixResponse struct {
+	Prefix            string              `json:"prefix"`
+	Metric            uint32              `json:"metric"`
+	MetricType        string              `json:"metric_type"`
+	ForwardingAddress string              `json:"forwarding_address,omitempty"`
+	Tag               uint32              `json:"tag"`
+	LSA               lsaIdentityResponse `json:"lsa"`
+}
+
+// 校验 CIDR 格式, 只接受 IPv4 网络地址（主机位必须为0）
+func parsePrefix(s string) (net.IPNet, error) {
+	p, err := netip.ParsePrefix(strings.TrimSpace(s))
+	if err != nil {
+		return net.IPNet{}, fmt.Errorf("invalid prefix %q: %w", s, err)
+	}
+	if !p.Addr().Is4() {
+		return net.IPNet{}, fmt.Errorf("invalid prefix %q: only IPv4 is supported", s)
+	}
+	if p.Masked() != p {
+		return net.IPNet{}, fmt.Errorf("invalid prefix %q: host bits set, do you mean %s", s, p.Masked())
+	}
+	return net.IPNet{IP: p.Addr().AsSlice(), Mask: net.CIDRMask(p.Bits(), 32)}, nil
+}
+
+func uint32ToAddr(ip uint32) netip.Addr {
+	return netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)})
+}
+
+func (req prefixRequest) toExternalRoute() (ospf_cnn.ExternalRoute, error) {
+	ipNet, err := parsePrefix(req.Prefix)
+	if err != nil {
+		return ospf_cnn.ExternalRoute{}, err
+	}
+	rt := ospf_cnn.NewExternalRoute(ipNet)
+	if req.Metric != nil {
+		rt.Metric = *req.Metric
+	}
+	switch strings.ToUpper(req.MetricType) {
+	case "", "E2":
+		rt.MetricType = ospf_cnn.ExternalMetricType2
+	case "E1":
+		rt.MetricType = ospf_cnn.ExternalMetricType1
+	default:
+		return rt, fmt.Errorf("invalid metric_type %q of prefix %s: expecting E1 or E2", req.MetricType, req.Prefix)
+	}
+	if req.ForwardingAddress != "" {
+		fwd, err := netip.ParseAddr(req.ForwardingAddress)
+		if err != nil || !fwd.Is4() {
+			return rt, fmt.Errorf("invalid forwarding_address %q of prefix %s", req.ForwardingAddress, req.Prefix)
+		}
+		rt.ForwardingAddress = fwd.AsSlice()
+	}
+	rt.Tag = req.Tag
+	return rt, nil
+}
+
+func toPrefixResponse(rt ospf_cnn.AnnouncedRoute) prefixResponse {
+	ret := prefixResponse{
+		Prefix:     rt.Prefix.String(),
+		Metric:     rt.Metric,
+		MetricType: rt.MetricType.String(),
+		Tag:        rt.Tag,
+		LSA: lsaIdentityResponse{
+			LSType:      rt.LSA.LSType,
+			LinkStateId: uint32ToAddr(rt.LSA.LinkStateId).String(),
+			AdvRouter:   uint32ToAddr(rt.LSA.AdvRouter).String(),
+		},
+	}
+	if rt.ForwardingAddress != nil {
+		ret.ForwardingAddress = rt.ForwardingAddress.String()
+	}
+	return ret
+}
+
+// 返回指定前缀（为空时返回全部）的宣告状态
+func writeAnnounced(w http.ResponseWriter, only map[string]bool) {
+	ret := make([]prefixResponse, 0)
+	for _, rt := range router.Load().AnnouncedRoutes() {
+		if only == nil || only[rt.Prefix.String()] {
+			ret = append(ret, toPrefixResponse(rt))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ret); err != nil {
+		ospf_cnn.LogErr("err write prefixes response: %v", err)
+	}
+}
+
+func decodeExternalRoutes(r *http.Request) ([]ospf_cnn.ExternalRoute, error) {
+	var reqs []prefixRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	routes := make([]ospf_cnn.ExternalRoute, 0, len(reqs))
+	seen := make(map[string]bool, len(reqs))
+	for _, req := range reqs {
+		rt, err := req.toExternalRoute()
+		if err != nil {
+			return nil, err
+		}
+		if seen[rt.Prefix.String()] {
+			return nil, fmt.Errorf("duplicated prefix %s", rt.Prefix.String())
+		}
+		seen[rt.Prefix.String()] = true
+		routes = append(routes, rt)
+	}
+	return routes, nil
+}
+
+// 注册宣告路由相关的 API
+//
+//	GET    /prefixes  列出已宣告的前缀
+//	POST   /prefixes  新增或更新宣告的前缀
+//	PUT    /prefixes  使用请求中的前缀整体替换已宣告的前缀
+//	DELETE /prefixes  撤销宣告的前缀, 请求体为前缀字符串数组
+func registerPrefixesAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /prefixes", func(w http.ResponseWriter, r *http.Request) {
+		writeAnnounced(w, nil)
+	})
+
+	mux.HandleFunc("POST /prefixes", func(w http.ResponseWriter, r *http.Request) {
+		routes, err := decodeExternalRoutes(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = router.Load().AnnounceASBRRoute(routes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		only := make(map[string]bool, len(routes))
+		for _, rt := range routes {
+			only[rt.Prefix.String()] = true
+		}
+		writeAnnounced(w, only)
+	})
+
+	mux.HandleFunc("PUT /prefixes", func(w http.ResponseWriter, r *http.Request) {
+		routes, err := decodeExternalRoutes(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = router.Load().SetExternalRoutes(routes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeAnnounced(w, nil)
+	})
+
+	mux.HandleFunc("DELETE /prefixes", func(w http.ResponseWriter, r *http.Request) {
+		var prefixes []string
+		if err := json.NewDecoder(r.Body).Decode(&prefixes); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ips := make([]net.IPNet, 0, len(prefixes))
+		for _, p := range prefixes {
+			ipNet, err := parsePrefix(p)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			ips = append(ips, ipNet)
+		}
+		router.Load().RevokeASBRRoute(ips)
+		writeAnnounced(w, nil)
+	})
+}
+
+// TE 数据库的响应体, 带宽单位为字节每秒
+type teLinkResponse struct {
+	OpaqueId               uint32      `json:"opaque_id"`
+	LinkType               uint8       `json:"link_type"`
+	LinkId                 string      `json:"link_id"`
+	LocalAddresses         []string    `json:"local_addresses,omitempty"`
+	RemoteAddresses        []string    `json:"remote_addresses,omitempty"`
+	TEMetric               *uint32     `json:"te_metric,omitempty"`
+	MaxBandwidth           *float32    `json:"max_bandwidth,omitempty"`
+	MaxReservableBandwidth *float32    `json:"max_reservable_bandwidth,omitempty"`
+	UnreservedBandwidth    *[8]float32 `json:"unreserved_bandwidth,omitempty"`
+	AdminGroup             *uint32     `json:"admin_group,omitempty"`
+	InTopology             bool        `json:"in_topology"`
+	Metric                 uint16      `json:"metric"`
+	RemoteRouterIds        []string    `json:"remote_router_ids,omitempty"`
+}
+
+type teNodeResponse struct {
+	AreaId        string           `json:"area_id"`
+	RouterId      string           `json:"router_id"`
+	RouterAddress string           `json:"router_address,omitempty"`
+	Links         []teLinkResponse `json:"links"`
+}
+
+func uint32sToAddrs(ips []uint32) (ret []string) {
+	for _, ip := range ips {
+		ret = append(ret, uint32ToAddr(ip).String())
+	}
+	return
+}
+
+func toTENodeResponse(n ospf_cnn.TENode) teNodeResponse {
+	ret := teNodeResponse{
+		AreaId:   uint32ToAddr(n.AreaId).String(),
+		RouterId: uint32ToAddr(n.RouterId).String(),
+		Links:    make([]teLinkResponse, 0, len(n.Links)),
+	}
+	if n.RouterAddress != 0 {
+		ret.RouterAddress = uint32ToAddr(n.RouterAddress).String()
+	}
+	for _, l := range n.Links {
+		ret.Links = append(ret.Links, teLinkResponse{
+			OpaqueId:               l.OpaqueId,
+			LinkType:               l.LinkType,
+			LinkId:                 uint32ToAddr(l.LinkID).String(),
+			LocalAddresses:         uint32sToAddrs(l.LocalAddresses),
+			RemoteAddresses:        uint32sToAddrs(l.RemoteAddresses),
+			TEMetric:               l.TEMetric,
+			MaxBandwidth:           l.MaxBandwidth,
+			MaxReservableBandwidth: l.MaxReservableBandwidth,
+			UnreservedBandwidth:    l.UnreservedBandwidth,
+			AdminGroup:             l.AdminGroup,
+			InTopology:             l.InTopology,
+			Metric:                 l.Metric,
+			RemoteRouterIds:        uint32sToAddrs(l.RemoteRouterIds),
+		})
+	}
+	return ret
+}
+
+// 注册 TE 数据库相关的 API
+//
+//	GET /te  列出各区域中通过 TE LSA 宣告的路由器及链路
+func registerTEAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /te", func(w http.ResponseWriter, r *http.Request) {
+		ret := make([]teNodeResponse, 0)
+		for _, n := range router.Load().TEDatabase() {
+			ret = append(ret, toTENodeResponse(n))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ret); err != nil {
+			ospf_cnn.LogErr("err write TE database response: %v", err)
+		}
+	})
+}
+
+// 邻居的响应体, 已知主机名时一并返回
+type neighborResponse struct {
+	Interface string `json:"interface"`
+	AreaId    string `json:"area_id"`
+	RouterId  string `json:"router_id"`
+	Hostname  string `json:"hostname,omitempty"`
+	Address   string `json:"address"`
+	Priority  uint8  `json:"priority"`
+	State     string `json:"state"`
+	// 平滑重启 helper 状态, 剩余宽限期以秒为单位
+	GRHelper         bool   `json:"gr_helper"`
+	GraceRemaining   uint32 `json:"grace_remaining,omitempty"`
+	GRHelperLastExit string `json:"gr_helper_last_exit,omitempty"`
+}
+
+// 接口的响应体
+type interfaceResponse struct {
+	Interface  string             `json:"interface"`
+	AreaId     string             `json:"area_id"`
+	Type       string             `json:"type"`
+	State      string             `json:"state"`
+	Address    string             `json:"address"`
+	MTU        uint16             `json:"mtu"`
+	Reassembly reassemblyResponse `json:"reassembly"`
+	// 按原因统计被拒绝的报文及 LSA 数量
+	Rejected map[string]uint64 `json:"rejected"`
+}
+
+// IPv4 分片重组的统计
+type reassemblyResponse struct {
+	Reassembled uint64 `json:"reassembled"`
+	Expired     uint64 `json:"expired"`
+	Oversized   uint64 `json:"oversized"`
+	Discarded   uint64 `json:"discarded"`
+}
+
+// 链路状态数据库中 LSA 的响应体, AS 范围的 LSA 不返回区域
+type lsdbEntryResponse struct {
+	AreaId      string `json:"area_id,omitempty"`
+	Interface   string `json:"interface,omitempty"`
+	LSType      uint16 `json:"ls_type"`
+	LinkStateId string `json:"link_state_id"`
+	AdvRouter   string `json:"adv_router"`
+	Hostname    string `json:"hostname,omitempty"`
+	Age         uint16 `json:"age"`
+	SeqNumber   string `json:"seq_number"`
+	Checksum    string `json:"checksum"`
+	Length      uint16 `json:"length"`
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		ospf_cnn.LogErr("err write response: %v", err)
+	}
+}
+
+// 注册邻居及链路状态数据库相关的 API
+//
+//	GET /interfaces  列出所有接口及其统计
+//	GET /neighbors   列出所有接口上的邻居
+//	GET /lsdb        列出链路状态数据库中的 LSA
+func registerLSDBAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /interfaces", func(w http.ResponseWriter, r *http.Request) {
+		ret := make([]interfaceResponse, 0)
+		for _, ifi := range router.Load().Interfaces() {
+			rejected := make(map[string]uint64, len(ifi.Rejected))
+			for reason, n := range ifi.Rejected {
+				rejected[reason.String()] = n
+			}
+			ret = append(ret, interfaceResponse{
+				Interface: ifi.IfName,
+				AreaId:    uint32ToAddr(ifi.AreaId).String(),
+				Type:      ifi.Type.String(),
+				State:     ifi.State.String(),
+				Address:   ifi.Address.String(),
+				MTU:       ifi.MTU,
+				Reassembly: reassemblyResponse{
+					Reassembled: ifi.Reassembly.Reassembled,
+					Expired:     ifi.Reassembly.Expired,
+					Oversized:   ifi.Reassembly.Oversized,
+					Discarded:   ifi.Reassembly.Discarded,
+				},
+				Rejected: rejected,
+			})
+		}
+		writeJSON(w, ret)
+	})
+
+	mux.HandleFunc("GET /neighbors", func(w http.ResponseWriter, r *http.Request) {
+		ret := make([]neighborResponse, 0)
+		for _, nb := range router.Load().Neighbors() {
+			resp := neighborResponse{
+				Interface: nb.IfName,
+				AreaId:    uint32ToAddr(nb.AreaId).String(),
+				RouterId:  uint32ToAddr(nb.RouterId).String(),
+				Hostname:  nb.Hostname,
+				Address:   nb.Address.String(),
+				Priority:  nb.Priority,
+				State:     nb.State.String(),
+				GRHelper:  nb.GRHelper.InHelper,
+			}
+			if nb.GRHelper.InHelper {
+				resp.GraceRemaining = uint32(max(time.Until(nb.GRHelper.GracePeriodEnd), 0) / time.Second)
+			}
+			if nb.GRHelper.LastExitReason != ospf_cnn.GRHelperExitNone {
+				resp.GRHelperLastExit = nb.GRHelper.LastExitReason.String()
+			}
+			ret = append(ret, resp)
+		}
+		writeJSON(w, ret)
+	})
+
+	mux.HandleFunc("GET /lsdb", func(w http.ResponseWriter, r *http.Request) {
+		ret := make([]lsdbEntryResponse, 0)
+		for _, e := range router.Load().LSDB() {
+			resp := lsdbEntryResponse{
+				Interface:   e.IfName,
+				LSType:      e.LSType,
+				LinkStateId: uint32ToAddr(e.LinkStateID).String(),
+				AdvRouter:   uint32ToAddr(e.AdvRouter).String(),
+				Hostname:    e.Hostname,
+				Age:         e.LSAge,
+				SeqNumber:   fmt.Sprintf("0x%08x", e.LSSeqNumber),
+				Checksum:    fmt.Sprintf("0x%04x", e.LSChecksum),
+				Length:      e.Length,
+			}
+			if !e.IsASScoped() {
+				resp.AreaId = uint32ToAddr(e.AreaId).String()
+			}
+			ret = append(ret, resp)
+		}
+		writeJSON(w, ret)
+	})
+}
+
+// stub router 状态的请求体及响应体, 剩余时间以秒为单位
+type stubRouterRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type stubRouterResponse struct {
+	Enabled            bool   `json:"enabled"`
+	OnStartup          bool   `json:"on_startup"`
+	OnStartupRemaining uint32 `json:"on_startup_remaining,omitempty"`
+	MaxMetricExternal  bool   `json:"max_metric_external"`
+	Active             bool   `json:"active"`
+}
+
+func writeStubRouter(w http.ResponseWriter) {
+	s := router.Load().StubRouter()
+	resp := stubRouterResponse{
+		Enabled:           s.Enabled,
+		OnStartup:         s.OnStartup,
+		MaxMetricExternal: s.MaxMetricExternal,
+		Active:            s.Active(),
+	}
+	if s.OnStartup && !s.OnStartupEnd.IsZero() {
+		resp.OnStartupRemaining = uint32(max(time.Until(s.OnStartupEnd), 0) / time.Second)
+	}
+	writeJSON(w, resp)
+}
+
+// 注册 stub router（RFC6987）相关的 API
+//
+//	GET /stub-router  查看 stub router 状态
+//	PUT /stub-router  开启或关闭 stub router, 请求体示例：{"enabled":true}
+func registerStubRouterAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /stub-router", func(w http.ResponseWriter, r *http.Request) {
+		writeStubRouter(w)
+	})
+
+	mux.HandleFunc("PUT /stub-router", func(w http.ResponseWriter, r *http.Request) {
+		var req stubRouterRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		router.Load().SetStubRouter(req.Enabled)
+		writeStubRouter(w)
+	})
+}
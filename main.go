@@ -1,17 +1,25 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/SvenShi/ospf-neighbor/ospf_cnn"
+	"github.com/SvenShi/ospf-neighbor/ospf_cnn/fib"
+	"github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
 	"net"
 	"net/http"
 	"net/netip"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"text/template"
+	"time"
 )
 
 const serviceTemplate = `[Unit]
@@ -19,7 +27,7 @@ Description=OSPF Neighbor Service
 After=network.target
 
 [Service]
-ExecStart={{.ExecPath}} {{.IfaceFlag}} {{.IpFlag}} {{.DestroyFlag}}
+ExecStart={{.ExecPath}} {{.IfaceFlag}} {{.IpFlag}} {{.DestroyFlag}} {{.PriorityFlag}} {{.NetworkTypeFlag}} {{.AreaFlag}} {{.FIBFlag}} {{.AuthFlag}} {{.HostnameFlag}} {{.GRFlag}} {{.StubRouterFlag}}
 Restart=always
 User=root
 
@@ -30,7 +38,26 @@ WantedBy=multi-user.target
 var iFace string
 var ipNet net.IPNet
 var prefix netip.Prefix
-var router *ospf_cnn.Router
+
+// 当前运行的路由器, /restart 会替换它, 因此通过原子指针读写
+var router atomic.Pointer[ospf_cnn.Router]
+
+// 串行化 /restart 请求
+var restartMu sync.Mutex
+
+var priority uint
+var fibTable, fibProtocol, fibMetric uint
+var authType, authKey, authKeyChain string
+var authKeyId uint
+var networkType, nbmaNeighbors string
+var mtuIgnore bool
+var area, extraIfaces, areaRanges, stubAreas, nssaAreas, virtualLinks string
+var stubDefaultCost uint
+var hostname string
+var gracePeriod uint
+var grStateFile string
+var stubRouter, stubRouterExternal bool
+var stubRouterOnStartup uint
 
 func main() {
 	// 获取第一个非标志参数，检查是否为 install 或 uninstall 命令
@@ -55,6 +82,32 @@ func main() {
 	flag.StringVar(&ip, "ip", "", "IP address with CIDR (e.g., 192.168.1.1/24)")
 	flag.BoolVar(&destroy, "destroy", false, "If true, destroy the router on exit")
 	flag.IntVar(&port, "port", 8796, "Port to listen for HTTP requests")
+	flag.UintVar(&priority, "priority", 0, "Router priority used in DR/BDR election (0-255, 0 means never become DR/BDR)")
+	flag.UintVar(&fibTable, "fib-table", 0, "Kernel routing table ID to install OSPF routes into (0 means do not install)")
+	flag.UintVar(&fibProtocol, "fib-protocol", 188, "Route protocol number of installed OSPF routes (1-255)")
+	flag.UintVar(&fibMetric, "fib-metric", 20, "Route metric of installed OSPF routes")
+	flag.StringVar(&authType, "auth-type", "none", "Authentication type of OSPF packets (none, simple, md5, hmac-sha1, hmac-sha256, hmac-sha384 or hmac-sha512)")
+	flag.StringVar(&authKey, "auth-key", "", "Simple password (up to 8 bytes), MD5 key (up to 16 bytes) or HMAC-SHA key")
+	flag.UintVar(&authKeyId, "auth-key-id", 1, "Key ID of cryptographic authentication (0-255)")
+	flag.StringVar(&networkType, "network-type", "broadcast", "OSPF network type of the interface (broadcast, point-to-point, nbma or point-to-multipoint)")
+	flag.StringVar(&nbmaNeighbors, "nbma-neighbors", "", "Comma separated neighbor addresses of nbma or non-multicast point-to-multipoint network, suffixed with :eligible if eligible to become DR (e.g., 10.0.0.2:eligible,10.0.0.3)")
+	flag.BoolVar(&mtuIgnore, "mtu-ignore", false, "If true, accept Database Description packets whose interface MTU exceeds the MTU of the interface")
+	flag.StringVar(&authKeyChain, "auth-keychain", "", "JSON file of cryptographic authentication keys with lifetimes, overrides -auth-key")
+	flag.StringVar(&area, "area", "0.0.0.0", "OSPF area ID the interface attaches to (e.g., 0.0.0.1 or 1)")
+	flag.StringVar(&extraIfaces, "extra-ifaces", "", "Comma separated additional broadcast interfaces in the form of name:ip/cidr:area (e.g., eth1:10.1.0.1/24:0.0.0.1)")
+	flag.StringVar(&stubAreas, "stub-areas", "", "Comma separated IDs of stub areas, suffixed with :no-summary for totally stubby areas (e.g., 0.0.0.1:no-summary)")
+	flag.StringVar(&nssaAreas, "nssa-areas", "", "Comma separated IDs of NSSAs, suffixed with :no-summary and/or :translate-always (e.g., 0.0.0.2:translate-always)")
+	flag.UintVar(&stubDefaultCost, "stub-default-cost", 1, "Cost of the default summary-LSA advertised into stub areas by area border router")
+	flag.StringVar(&virtualLinks, "virtual-links", "", "Comma separated virtual links in the form of transit-area:router-id of the other area border router (e.g., 0.0.0.1:10.1.0.2)")
+	// 默认使用本机的主机名
+	defaultHostname, _ := os.Hostname()
+	flag.StringVar(&hostname, "hostname", defaultHostname, "Hostname advertised in Router Information LSA (empty means do not advertise)")
+	flag.UintVar(&gracePeriod, "grace-period", 0, "Grace period in seconds asked of the neighbors when restarting gracefully (0 disables graceful restart, at most 1800)")
+	flag.StringVar(&grStateFile, "gr-state-file", "/var/lib/ospf-neighbor/graceful-restart.json", "File the state is saved to before restarting gracefully")
+	flag.BoolVar(&stubRouter, "stub-router", false, "If true, advertise the router as stub router so that other routers route around it")
+	flag.UintVar(&stubRouterOnStartup, "stub-router-on-startup", 0, "Seconds to keep advertising the router as stub router after the first adjacency is established on startup (0 disables)")
+	flag.BoolVar(&stubRouterExternal, "stub-router-external", false, "If true, advertise the external routes at the maximum metric as well while being stub router")
+	flag.StringVar(&areaRanges, "area-ranges", "", "Comma separated address ranges of areas in the form of area:cidr, suffixed with :not-advertise to hide the range (e.g., 0.0.0.1:10.1.0.0/16)")
 
 	err := flag.CommandLine.Parse(args)
 	if err != nil {
@@ -65,7 +118,7 @@ func main() {
 	if command != "" {
 		switch command {
 		case "install":
-			installService(iFace, ip, destroy)
+			installService(iFace, ip, destroy, priority)
 			return
 		case "uninstall":
 			uninstallService()
@@ -78,6 +131,42 @@ func main() {
 		fmt.Println("Usage: ospf -iface=<interface> -ip=<ip/cidr>")
 		os.Exit(1)
 	}
+	if priority > 255 {
+		fmt.Println("priority must be in range 0-255")
+		os.Exit(1)
+	}
+	if gracePeriod > packet.LSRefreshTime {
+		fmt.Println("grace-period must be in range 0-1800")
+		os.Exit(1)
+	}
+	if fibProtocol < 1 || fibProtocol > 255 {
+		fmt.Println("fib-protocol must be in range 1-255")
+		os.Exit(1)
+	}
+	if _, err = interfaceType(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if _, err = parseNBMANeighbors(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if _, err = interfaceAuth(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if _, err = parseAreaId(area); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if _, err = parseExtraIfaces(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if _, err = areaConfigs(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
 	// 解析IP地址
 	prefix, err = netip.ParsePrefix(ip)
@@ -90,31 +179,410 @@ func main() {
 	}
 
 	// 创建路由器
-	router, err = ospf_cnn.NewRouter(iFace, &ipNet, prefix.Addr().String())
+	cfg, err := routerConfig()
 	if err != nil {
 		fmt.Println("Error creating router:", err)
 		os.Exit(1)
 	}
+	rt, err := newRouter(cfg)
+	if err != nil {
+		fmt.Println("Error creating router:", err)
+		os.Exit(1)
+	}
+	router.Store(rt)
+
+	// 以 stub router 身份启动
+	if stubRouter {
+		rt.SetStubRouter(true)
+	}
 
 	// 启动路由器
-	go router.Start()
+	go rt.Start()
 	ospf_cnn.LogInfo("Router started")
 
 	// 启动HTTP服务监听端口
-	go startHTTPServer(router, port)
+	go startHTTPServer(port)
 
-	// 如果用户指定了 destroy 参数，监听关闭信号并在退出时关闭路由器
-	if destroy {
+	// 如果用户指定了 destroy 参数或启用了平滑重启，监听关闭信号并在退出时关闭路由器
+	if destroy || gracePeriod > 0 {
 		// 等待关闭信号
-		stopApp(router)
+		stopApp(destroy)
 	} else {
 		// 使用 select{} 阻塞主线程
 		select {}
 	}
 }
 
+// 根据命令行参数生成路由器配置, 参数无效时返回错误
+func routerConfig() (func(c *ospf_cnn.InstanceConfig), error) {
+	auth, err := interfaceAuth()
+	if err != nil {
+		return nil, err
+	}
+	ifType, err := interfaceType()
+	if err != nil {
+		return nil, err
+	}
+	nbs, err := parseNBMANeighbors()
+	if err != nil {
+		return nil, err
+	}
+	areaId, err := parseAreaId(area)
+	if err != nil {
+		return nil, err
+	}
+	ifcs, err := parseExtraIfaces()
+	if err != nil {
+		return nil, err
+	}
+	areas, err := areaConfigs()
+	if err != nil {
+		return nil, err
+	}
+	vlinks, err := parseVirtualLinks()
+	if err != nil {
+		return nil, err
+	}
+	for _, vc := range vlinks {
+		vc.Auth = auth
+	}
+	for _, ifc := range ifcs {
+		ifc.RouterPriority = uint8(priority)
+		ifc.Auth = auth
+		ifc.MTUIgnore = mtuIgnore
+	}
+	return func(c *ospf_cnn.InstanceConfig) {
+		c.RouterPriority = uint8(priority)
+		c.Auth = auth
+		c.IfType = ifType
+		c.NBMANeighbors = nbs
+		c.MTUIgnore = mtuIgnore
+		c.AreaId = areaId
+		c.Interfaces = ifcs
+		c.Areas = areas
+		c.VirtualLinks = vlinks
+		c.Hostname = hostname
+		c.GracefulRestart = ospf_cnn.GracefulRestartConfig{
+			GracePeriod: uint32(gracePeriod),
+			StateFile:   grStateFile,
+		}
+		c.StubRouter = ospf_cnn.StubRouterConfig{
+			OnStartup:         uint32(stubRouterOnStartup),
+			MaxMetricExternal: stubRouterExternal,
+		}
+	}, nil
+}
+
+// 根据路由器配置创建路由器, 配置会先经过校验
+func newRouter(cfg func(c *ospf_cnn.InstanceConfig)) (*ospf_cnn.Router, error) {
+	rtId := prefix.Addr().String()
+	if err := ospf_cnn.ValidateConfig(iFace, &ipNet, rtId, cfg); err != nil {
+		return nil, err
+	}
+	var f fib.FIB
+	if fibTable > 0 {
+		// 将计算出的 OSPF 路由写入内核路由表
+		var err error
+		f, err = fib.NewNetlinkFIB(fib.NetlinkConfig{
+			Table:    uint32(fibTable),
+			Protocol: uint8(fibProtocol),
+			Metric:   uint32(fibMetric),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ospf_cnn.NewRouter(iFace, &ipNet, rtId, cfg, func(c *ospf_cnn.InstanceConfig) {
+		c.FIB = f
+	})
+}
+
+// 解析区域ID, 支持点分十进制（如 0.0.0.1）或整数（如 1）
+func parseAreaId(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	if ip, err := netip.ParseAddr(s); err == nil && ip.Is4() {
+		b := ip.As4()
+		return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3]), nil
+	}
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid area ID %q", s)
+	}
+	return uint32(id), nil
+}
+
+// 解析附加接口, 格式为 name:ip/cidr:area, area 省略时为骨干区域
+func parseExtraIfaces() ([]*ospf_cnn.InterfaceConfig, error) {
+	var ret []*ospf_cnn.InterfaceConfig
+	for _, s := range strings.Split(extraIfaces, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		parts := strings.Split(s, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid extra iface %q: expecting name:ip/cidr:area", s)
+		}
+		p, err := netip.ParsePrefix(parts[1])
+		if err != nil || !p.Addr().Is4() {
+			return nil, fmt.Errorf("invalid ip %q of extra iface %s", parts[1], parts[0])
+		}
+		var areaId uint32
+		if len(parts) == 3 {
+			if areaId, err = parseAreaId(parts[2]); err != nil {
+				return nil, err
+			}
+		}
+		ret = append(ret, &ospf_cnn.InterfaceConfig{
+			IfName:  parts[0],
+			AreaId:  areaId,
+			Address: &net.IPNet{IP: p.Addr().AsSlice(), Mask: net.CIDRMask(p.Bits(), 32)},
+		})
+	}
+	return ret, nil
+}
+
+// 解析虚连接, 格式为 transit-area:router-id
+func parseVirtualLinks() ([]*ospf_cnn.VirtualLinkConfig, error) {
+	var ret []*ospf_cnn.VirtualLinkConfig
+	for _, s := range strings.Split(virtualLinks, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		transit, rtId, ok := strings.Cut(s, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid virtual link %q: expecting transit-area:router-id", s)
+		}
+		areaId, err := parseAreaId(transit)
+		if err != nil {
+			return nil, err
+		}
+		addr, err := netip.ParseAddr(rtId)
+		if err != nil || !addr.Is4() {
+			return nil, fmt.Errorf("invalid router ID %q of virtual link", rtId)
+		}
+		b := addr.As4()
+		ret = append(ret, &ospf_cnn.VirtualLinkConfig{
+			TransitAreaId: areaId,
+			RouterId:      uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3]),
+		})
+	}
+	return ret, nil
+}
+
+// 根据区域相关的命令行参数生成各区域的配置
+func areaConfigs() ([]*ospf_cnn.AreaConfig, error) {
+	var ret []*ospf_cnn.AreaConfig
+	getOrAdd := func(areaId uint32) *ospf_cnn.AreaConfig {
+		for _, exist := range ret {
+			if exist.AreaId == areaId {
+				return exist
+			}
+		}
+		ac := &ospf_cnn.AreaConfig{AreaId: areaId}
+		ret = append(ret, ac)
+		return ac
+	}
+	// 区域地址范围, 格式为 area:cidr, 加上 :not-advertise 后缀表示不对外宣告该范围
+	for _, s := range strings.Split(areaRanges, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		parts := strings.Split(s, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid area range %q: expecting area:cidr", s)
+		}
+		areaId, err := parseAreaId(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) == 3 && parts[2] != "not-advertise" {
+			return nil, fmt.Errorf("invalid area range option %q: expecting not-advertise", parts[2])
+		}
+		ipNet, err := parsePrefix(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		ac := getOrAdd(areaId)
+		ac.Addresses = append(ac.Addresses, &ospf_cnn.AreaAddress{
+			Address:        &ipNet,
+			DoNotAdvertise: len(parts) == 3,
+		})
+	}
+	// Stub 区域, 加上 :no-summary 后缀表示完全 Stub 区域
+	for _, s := range strings.Split(stubAreas, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		id, opt, _ := strings.Cut(s, ":")
+		if opt != "" && opt != "no-summary" {
+			return nil, fmt.Errorf("invalid stub area option %q: expecting no-summary", opt)
+		}
+		areaId, err := parseAreaId(id)
+		if err != nil {
+			return nil, err
+		}
+		ac := getOrAdd(areaId)
+		ac.Stub = true
+		ac.NoSummary = opt == "no-summary"
+		ac.StubDefaultCost = int(stubDefaultCost)
+	}
+	// NSSA 区域, 可加上 :no-summary 和 :translate-always 后缀
+	for _, s := range strings.Split(nssaAreas, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		parts := strings.Split(s, ":")
+		areaId, err := parseAreaId(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		ac := getOrAdd(areaId)
+		ac.NSSA = true
+		ac.StubDefaultCost = int(stubDefaultCost)
+		for _, opt := range parts[1:] {
+			switch opt {
+			case "no-summary":
+				ac.NoSummary = true
+			case "translate-always":
+				ac.NSSATranslatorAlways = true
+			default:
+				return nil, fmt.Errorf("invalid NSSA option %q: expecting no-summary or translate-always", opt)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// 根据命令行参数确定接口的网络类型
+func interfaceType() (ospf_cnn.InterfaceType, error) {
+	switch networkType {
+	case "broadcast", "point-to-point", "nbma", "point-to-multipoint":
+		return ospf_cnn.ParseInterfaceType(networkType)
+	}
+	return 0, fmt.Errorf("unsupported network-type %q: expecting broadcast, point-to-point, nbma or point-to-multipoint", networkType)
+}
+
+// 解析 NBMA 网络中静态配置的邻居
+func parseNBMANeighbors() ([]ospf_cnn.NBMANeighbor, error) {
+	var ret []ospf_cnn.NBMANeighbor
+	for _, s := range strings.Split(nbmaNeighbors, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		addr, opt, _ := strings.Cut(s, ":")
+		ip, err := netip.ParseAddr(addr)
+		if err != nil || !ip.Is4() {
+			return nil, fmt.Errorf("invalid nbma neighbor address %q", addr)
+		}
+		if opt != "" && opt != "eligible" {
+			return nil, fmt.Errorf("invalid nbma neighbor option %q: expecting eligible", opt)
+		}
+		ret = append(ret, ospf_cnn.NBMANeighbor{Address: ip.AsSlice(), Eligible: opt == "eligible"})
+	}
+	if networkType == "nbma" && len(ret) == 0 {
+		return nil, fmt.Errorf("nbma-neighbors must be set when network-type is nbma")
+	}
+	return ret, nil
+}
+
+// 根据命令行参数生成接口的认证配置
+func interfaceAuth() (ospf_cnn.InterfaceAuth, error) {
+	switch authType {
+	case "", "none":
+		return ospf_cnn.InterfaceAuth{AuType: packet.AuTypeNull}, nil
+	case "simple":
+		if len(authKey) > 8 {
+			return ospf_cnn.InterfaceAuth{}, fmt.Errorf("auth-key of simple authentication must not exceed 8 bytes")
+		}
+		return ospf_cnn.InterfaceAuth{AuType: packet.AuTypeSimplePassword, Password: authKey}, nil
+	}
+	auth := ospf_cnn.InterfaceAuth{AuType: packet.AuTypeCryptographic}
+	if authKeyChain != "" {
+		keys, err := loadAuthKeyChain(authKeyChain)
+		if err != nil {
+			return auth, err
+		}
+		auth.KeyChain = keys
+		return auth, nil
+	}
+	alg, err := parseAuthAlgorithm(authType)
+	if err != nil {
+		return auth, err
+	}
+	if authKeyId > 255 {
+		return auth, fmt.Errorf("auth-key-id must be in range 0-255")
+	}
+	if l := alg.MaxKeyLen(); l >= 0 && len(authKey) > l {
+		return auth, fmt.Errorf("auth-key of %s authentication must not exceed %d bytes", authType, l)
+	}
+	auth.KeyChain = []ospf_cnn.AuthKey{{KeyId: uint8(authKeyId), Algorithm: alg, Key: authKey}}
+	return auth, nil
+}
+
+func parseAuthAlgorithm(s string) (packet.AuthAlgorithm, error) {
+	switch strings.ToLower(s) {
+	case "md5":
+		return packet.AuthAlgorithmMD5, nil
+	case "hmac-sha1":
+		return packet.AuthAlgorithmHMACSHA1, nil
+	case "hmac-sha256":
+		return packet.AuthAlgorithmHMACSHA256, nil
+	case "hmac-sha384":
+		return packet.AuthAlgorithmHMACSHA384, nil
+	case "hmac-sha512":
+		return packet.AuthAlgorithmHMACSHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported auth-type %q: expecting none, simple, md5, hmac-sha1, hmac-sha256, hmac-sha384 or hmac-sha512", s)
+}
+
+// 密钥链文件中的一个密钥, 生效时间为空表示不限制
+type authKeyConfig struct {
+	KeyId       uint8     `json:"key_id"`
+	Algorithm   string    `json:"algorithm"`
+	Key         string    `json:"key"`
+	SendStart   time.Time `json:"send_start"`
+	SendEnd     time.Time `json:"send_end"`
+	AcceptStart time.Time `json:"accept_start"`
+	AcceptEnd   time.Time `json:"accept_end"`
+}
+
+// 从 JSON 文件加载密钥链
+func loadAuthKeyChain(path string) ([]ospf_cnn.AuthKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("err read auth-keychain: %w", err)
+	}
+	var cfgs []authKeyConfig
+	if err = json.Unmarshal(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("err parse auth-keychain %s: %w", path, err)
+	}
+	keys := make([]ospf_cnn.AuthKey, 0, len(cfgs))
+	for _, c := range cfgs {
+		alg, err := parseAuthAlgorithm(c.Algorithm)
+		if err != nil {
+			return nil, fmt.Errorf("key ID %d of auth-keychain: %w", c.KeyId, err)
+		}
+		keys = append(keys, ospf_cnn.AuthKey{
+			KeyId:       c.KeyId,
+			Algorithm:   alg,
+			Key:         c.Key,
+			SendStart:   c.SendStart,
+			SendEnd:     c.SendEnd,
+			AcceptStart: c.AcceptStart,
+			AcceptEnd:   c.AcceptEnd,
+		})
+	}
+	return keys, nil
+}
+
 // 安装 OSPF 应用为 systemd 服务
-func installService(iface, ip string, destroy bool) {
+func installService(iface, ip string, destroy bool, priority uint) {
 	// 获取当前程序的路径
 	execPath, err := os.Executable()
 	if err != nil {
@@ -124,15 +592,35 @@ func installService(iface, ip string, destroy bool) {
 
 	// 填充 systemd 服务文件模板
 	serviceFileContent := &struct {
-		ExecPath    string
-		IfaceFlag   string
-		IpFlag      string
-		DestroyFlag string
+		ExecPath        string
+		IfaceFlag       string
+		IpFlag          string
+		DestroyFlag     string
+		PriorityFlag    string
+		NetworkTypeFlag string
+		AreaFlag        string
+		FIBFlag         string
+		AuthFlag        string
+		HostnameFlag    string
+		GRFlag          string
+		StubRouterFlag  string
 	}{
-		ExecPath:    execPath,
-		IfaceFlag:   fmt.Sprintf("-iface=%s", iface),
-		IpFlag:      fmt.Sprintf("-ip=%s", ip),
-		DestroyFlag: fmt.Sprintf("-destroy=%v", destroy),
+		ExecPath:        execPath,
+		IfaceFlag:       fmt.Sprintf("-iface=%s", iface),
+		IpFlag:          fmt.Sprintf("-ip=%s", ip),
+		DestroyFlag:     fmt.Sprintf("-destroy=%v", destroy),
+		PriorityFlag:    fmt.Sprintf("-priority=%d", priority),
+		NetworkTypeFlag: fmt.Sprintf("-network-type=%s -nbma-neighbors=%s -mtu-ignore=%t", networkType, nbmaNeighbors, mtuIgnore),
+		AreaFlag: fmt.Sprintf("-area=%s -extra-ifaces=%s -area-ranges=%s -stub-areas=%s -nssa-areas=%s -stub-default-cost=%d -virtual-links=%s",
+			area, extraIfaces, areaRanges, stubAreas, nssaAreas, stubDefaultCost, virtualLinks),
+		FIBFlag: fmt.Sprintf("-fib-table=%d -fib-protocol=%d -fib-metric=%d",
+			fibTable, fibProtocol, fibMetric),
+		AuthFlag: fmt.Sprintf("-auth-type=%s %q -auth-key-id=%d %q",
+			authType, "-auth-key="+authKey, authKeyId, "-auth-keychain="+authKeyChain),
+		HostnameFlag: fmt.Sprintf("%q", "-hostname="+hostname),
+		GRFlag:       fmt.Sprintf("-grace-period=%d %q", gracePeriod, "-gr-state-file="+grStateFile),
+		StubRouterFlag: fmt.Sprintf("-stub-router=%v -stub-router-on-startup=%d -stub-router-external=%v",
+			stubRouter, stubRouterOnStartup, stubRouterExternal),
 	}
 
 	// 生成 systemd 服务文件
@@ -229,20 +717,33 @@ func uninstallService() {
 }
 
 // 停止应用并优雅地关闭路由器
-func stopApp(router *ospf_cnn.Router) {
+func stopApp(destroy bool) {
 	// 捕获系统终止信号（SIGINT 或 SIGTERM）
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	// 等待信号
-	<-sigChan
+	sig := <-sigChan
+
+	// systemd 停止或重启服务时发送 SIGTERM, 启用平滑重启时不撤销 LSA 也不删除内核路由,
+	// 而是请求邻居在宽限期内继续将本机宣告为完全邻接. 指定了 destroy 参数时优先关闭路由器
+	if sig == syscall.SIGTERM && gracePeriod > 0 && !destroy {
+		ospf_cnn.LogInfo("Shutting down router for graceful restart...")
+		if err := router.Load().GracefulShutdown(); err != nil {
+			ospf_cnn.LogInfo("Router graceful shutdown failed: %v", err)
+		}
+		os.Exit(0)
+	}
+	if !destroy {
+		os.Exit(0)
+	}
 
 	// 如果 destroy 参数为 true，关闭路由器
 	ospf_cnn.LogInfo("Shutting down router...")
-	err := router.Close()
+	err := router.Load().Close()
 	if err != nil {
 		// 退出程序
-		ospf_cnn.LogInfo("Router close failed.", err.Error())
+		ospf_cnn.LogInfo("Router close failed: %v", err)
 		os.Exit(0)
 		return
 	}
@@ -253,26 +754,56 @@ func stopApp(router *ospf_cnn.Router) {
 }
 
 // 启动 HTTP 服务来监听指定端口
-func startHTTPServer(router *ospf_cnn.Router, port int) {
+func startHTTPServer(port int) {
 	http.HandleFunc("/restart", func(w http.ResponseWriter, r *http.Request) {
 		// 关闭路由器
 		ospf_cnn.LogInfo("Received request to restart the router.")
-		err := router.Close()
+		restartMu.Lock()
+		defer restartMu.Unlock()
+		// 关闭旧的路由器前先生成并校验新的配置, 配置无效时旧的路由器继续运行
+		cfg, err := routerConfig()
+		if err == nil {
+			err = ospf_cnn.ValidateConfig(iFace, &ipNet, prefix.Addr().String(), cfg)
+		}
+		if err != nil {
+			http.Error(w, "Invalid router config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		old := router.Load()
+		if gracePeriod > 0 {
+			// 平滑重启, 新的路由器从保存的状态恢复, 无需撤销并重新宣告所有 LSA
+			err = old.GracefulShutdown()
+		} else {
+			err = old.Close()
+		}
 		if err != nil {
 			http.Error(w, "Failed to close router: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// 创建路由器
-		router, err = ospf_cnn.NewRouter(iFace, &ipNet, prefix.Addr().String())
+		// 创建路由器, 并重新宣告之前宣告的路由, 保持 stub router 状态
+		announced := old.AnnouncedRoutes()
+		stub := old.StubRouter().Enabled
+		rt, err := newRouter(cfg)
 		if err != nil {
-			http.Error(w, "Failed to new router: "+err.Error(), http.StatusInternalServerError)
+			// 旧的路由器已关闭, 保留其指针避免其他请求访问空指针, 但路由器已停止运行
+			ospf_cnn.LogErr("Router is down, failed to new router: %v", err)
+			http.Error(w, "Router is down, failed to new router: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		routes := make([]ospf_cnn.ExternalRoute, 0, len(announced))
+		for _, rt := range announced {
+			routes = append(routes, rt.ExternalRoute)
+		}
+		if err = rt.AnnounceASBRRoute(routes); err != nil {
+			ospf_cnn.LogErr("Re-announce routes failed: %v", err)
+		}
+		rt.SetStubRouter(stub)
 
 		// 重新启动路由器
 		ospf_cnn.LogInfo("Restarting router...")
-		router.Start()
+		rt.Start()
+		router.Store(rt)
 
 		// 响应成功
 		fmt.Println(w, "Router restarted successfully.")
@@ -283,6 +814,27 @@ func startHTTPServer(router *ospf_cnn.Router, port int) {
 		}
 	})
 
+	// 查看当前 OSPF 路由表
+	http.HandleFunc("/routes", func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(router.Load().RoutingTable().String()))
+		if err != nil {
+			http.Error(w, "Failed to write response: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	// 宣告路由的增删查改
+	registerPrefixesAPI(http.DefaultServeMux)
+
+	// TE 数据库查询
+	registerTEAPI(http.DefaultServeMux)
+
+	// 邻居及链路状态数据库查询
+	registerLSDBAPI(http.DefaultServeMux)
+
+	// stub router 状态查询及切换
+	registerStubRouterAPI(http.DefaultServeMux)
+
 	// 启动 HTTP 服务
 	addr := fmt.Sprintf(":%d", port)
 	fmt.Printf("Listening on port %d...\n", port)
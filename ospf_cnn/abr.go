@@ -0,0 +1,241 @@
+package ospf_cnn
+
+import (
+	"cmp"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"slices"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// summaryRoute is a route to be advertised into an area via a summary-LSA.
+type summaryRoute struct {
+	lsType uint16
+	// The destination network's address and mask for Type 3 summary-LSAs,
+	// or the AS boundary router's Router ID for Type 4 summary-LSAs.
+	addr, mask uint32
+	cost       int
+}
+
+// isNextHopsInArea checks whether any of the next hops belongs to area a.
+func isNextHopsInArea(nhs []RoutingNextHop, a *Area) bool {
+	for _, nh := range nhs {
+		if nh.Interface != nil && nh.Interface.Area == a {
+			return true
+		}
+	}
+	return false
+}
+
+// summaryRoutesForArea determines the routes advertised into area a by
+// summary-LSAs per RFC2328 12.4.3.
+func (i *Instance) summaryRoutesForArea(rt *routingTableBuilder, a *Area) (ret []summaryRoute) {
+	if !a.ExternalRoutingCapability {
+		// In stub areas, routing to AS external destinations is based
+		// solely on a default summary route. The area border router
+		// advertises it with Link State ID DefaultDestination and cost
+		// StubDefaultCost. NSSAs only get the default summary route when
+		// summaries are not imported (RFC3101 2.2).
+		if !a.NSSA || !a.importSummaries {
+			ret = append(ret, summaryRoute{layers.SummaryLSANetworktypeV2, 0, 0, a.StubDefaultCost})
+		}
+		if !a.importSummaries {
+			// Only the default summary-LSA is imported into totally
+			// stubby areas.
+			return
+		}
+	}
+	// The summaries of the address ranges containing at least one
+	// intra-area network.
+	ranges := make(map[*AreaAddress]*summaryRoute)
+	for _, e := range rt.networks {
+		// Only intra-area and inter-area paths are advertised. If the
+		// area associated with this set of paths is the Area A itself,
+		// do not generate a summary-LSA for the route.
+		if e.PathType > RoutingPathInterArea || e.Area == a.AreaId {
+			continue
+		}
+		// If the next hops associated with this set of paths belong to
+		// Area A itself, do not generate a summary-LSA for the route.
+		// This is the logical equivalent of a Distance Vector protocol's
+		// split horizon logic.
+		if isNextHopsInArea(e.NextHops, a) {
+			continue
+		}
+		// Else, if the routing table cost equals or exceeds the value
+		// LSInfinity, a summary-LSA cannot be generated for this route.
+		if e.Cost >= packet2.LSInfinity {
+			continue
+		}
+		addr, mask := e.DestinationId, ipv4MaskToUint32(e.AddressMask)
+		if e.PathType == RoutingPathInterArea {
+			// Inter-area routes are advertised individually.
+			ret = append(ret, summaryRoute{layers.SummaryLSANetworktypeV2, addr, mask, e.Cost})
+			continue
+		}
+		// Else the destination is a network of the associated area, whose
+		// routing information is condensed by the area address ranges.
+		// A range is advertised with the cost of the most distant network
+		// in it, unless its status is DoNotAdvertise.
+		assoc := i.getArea(e.Area)
+		if assoc == nil {
+			continue
+		}
+		var rg *AreaAddress
+		for _, r := range assoc.Addresses {
+			if r.Address != nil && r.contains(addr, mask) {
+				rg = r
+				break
+			}
+		}
+		if rg == nil {
+			// The network not contained in any explicitly configured
+			// address range is advertised individually.
+			ret = append(ret, summaryRoute{layers.SummaryLSANetworktypeV2, addr, mask, e.Cost})
+			continue
+		}
+		if rg.DoNotAdvertise {
+			continue
+		}
+		if sm, ok := ranges[rg]; ok {
+			sm.cost = max(sm.cost, e.Cost)
+			continue
+		}
+		ranges[rg] = &summaryRoute{
+			lsType: layers.SummaryLSANetworktypeV2,
+			addr:   ipv4BytesToUint32(rg.Address.IP.To4()) & ipv4MaskToUint32(rg.Address.Mask),
+			mask:   ipv4MaskToUint32(rg.Address.Mask),
+			cost:   e.Cost,
+		}
+	}
+	for _, sm := range ranges {
+		ret = append(ret, *sm)
+	}
+
+	// Type 4 summary-LSAs are not originated into stub areas and NSSAs.
+	if !a.ExternalRoutingCapability {
+		return
+	}
+	asbrs := make(map[uint32]struct{})
+	for k, e := range rt.routers {
+		if e.IsASBR {
+			asbrs[k.rtId] = struct{}{}
+		}
+	}
+	for rtId := range asbrs {
+		// If the destination of this route is an AS boundary router,
+		// a summary-LSA should be originated if and only if the routing
+		// table entry describes the preferred path to the AS boundary
+		// router.
+		e := rt.preferredASBR(rtId)
+		if e == nil || e.PathType > RoutingPathInterArea || e.Area == a.AreaId ||
+			isNextHopsInArea(e.NextHops, a) || e.Cost >= packet2.LSInfinity {
+			continue
+		}
+		// The AS boundary routers within NSSAs are not advertised, since
+		// their Type-7 LSAs are translated by the NSSA border router.
+		if assoc := i.getArea(e.Area); assoc != nil && assoc.NSSA {
+			continue
+		}
+		ret = append(ret, summaryRoute{layers.SummaryLSAASBRtypeV2, rtId, 0, e.Cost})
+	}
+	return
+}
+
+// newSummaryLSAs builds the summary-LSAs of the routes for area a.
+func (a *Area) newSummaryLSAs(routes []summaryRoute) (ret []packet2.LSAdvertisement) {
+	slices.SortFunc(routes, func(x, y summaryRoute) int {
+		return cmp.Or(cmp.Compare(x.lsType, y.lsType), compareNetworksByMaskLength(x.addr, x.mask, y.addr, y.mask),
+			cmp.Compare(x.cost, y.cost))
+	})
+	used := make(map[uint16]linkStateIdAssigner)
+	for idx, r := range routes {
+		if idx > 0 && routes[idx-1].lsType == r.lsType && routes[idx-1].addr == r.addr && routes[idx-1].mask == r.mask {
+			// the same destination of a higher cost.
+			continue
+		}
+		if used[r.lsType] == nil {
+			used[r.lsType] = make(linkStateIdAssigner)
+		}
+		id := packet2.LSAIdentity{
+			LSType:    r.lsType,
+			AdvRouter: a.ins.RouterId,
+		}
+		var ok bool
+		if r.lsType == layers.SummaryLSAASBRtypeV2 {
+			// The Link State ID is the AS boundary router's Router ID.
+			id.LinkStateId = r.addr
+		} else if id.LinkStateId, ok = used[r.lsType].assign(r.addr, r.mask); !ok {
+			LogWarn("area %v skipped summary of %v/%v: Link State ID conflicted",
+				a.AreaId, uint32ToIPv4(r.addr), uint32ToIPv4(r.mask))
+			continue
+		}
+		ret = append(ret, packet2.LSAdvertisement{
+			LSAheader: packet2.LSAheader{
+				LSType:      id.LSType,
+				LinkStateID: id.LinkStateId,
+				AdvRouter:   id.AdvRouter,
+				LSSeqNumber: packet2.InitialSequenceNumber,
+				LSOptions: func() uint8 {
+					ret := packet2.BitOption(0)
+					if a.ExternalRoutingCapability {
+						ret = ret.SetBit(packet2.CapabilityEbit)
+					}
+					return uint8(ret)
+				}(),
+			},
+			Content: packet2.V2SummaryLSAImpl{
+				NetworkMask: r.mask,
+				Metric:      uint32(r.cost),
+			},
+		})
+	}
+	return
+}
+
+// syncSummaryLSAs makes the self-originated summary-LSAs of the area match
+// the desired ones exactly. Those no longer desired are prematurely aged.
+func (a *Area) syncSummaryLSAs(lsas []packet2.LSAdvertisement) {
+	desired := make(map[packet2.LSAIdentity]struct{}, len(lsas))
+	for _, l := range lsas {
+		desired[l.GetLSAIdentity()] = struct{}{}
+	}
+	var stale []packet2.LSAIdentity
+	a.lsDbRw.RLock()
+	for id, l := range a.SummaryLSAs {
+		if id.AdvRouter != a.ins.RouterId || l.h.LSAge >= packet2.MaxAge {
+			// not ours or already being flushed.
+			continue
+		}
+		if _, ok := desired[id]; !ok {
+			stale = append(stale, id)
+		}
+	}
+	a.lsDbRw.RUnlock()
+
+	lsas = a.skipUnchangedSelfOriginatedLSAs(lsas)
+	if nonExistLSAs := a.batchTryUpdatingExistingLSAs(lsas, nil, func(idx int, lsa *packet2.LSAdvertisement) {
+		lsa.LSOptions = lsas[idx].LSOptions
+		lsa.Content = lsas[idx].Content
+	}); len(nonExistLSAs) > 0 {
+		a.batchOriginatingNewLSAs(nonExistLSAs)
+	}
+	if len(stale) > 0 {
+		LogDebug("area %v flushing %d self-originated summary LSAs no longer desired", a.AreaId, len(stale))
+		a.prematureLSA(stale...)
+	}
+}
+
+// originateSummaryLSAs originates the summary-LSAs into each attached area
+// from the calculated routing table. Only area border routers originate
+// summary-LSAs, otherwise any previously originated ones are flushed.
+func (i *Instance) originateSummaryLSAs(rt *routingTableBuilder) {
+	isABR := i.isABR()
+	for _, a := range i.attachedAreas() {
+		var lsas []packet2.LSAdvertisement
+		if isABR {
+			lsas = a.newSummaryLSAs(i.summaryRoutesForArea(rt, a))
+		}
+		a.syncSummaryLSAs(lsas)
+	}
+}
@@ -0,0 +1,249 @@
+package ospf_cnn
+
+import (
+	"cmp"
+	"fmt"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func testAreaAddress(cidr string, doNotAdvertise bool) *AreaAddress {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return &AreaAddress{Address: network, DoNotAdvertise: doNotAdvertise}
+}
+
+func (r summaryRoute) String() string {
+	return fmt.Sprintf("type %d %v/%v cost %d", r.lsType, uint32ToIPv4(r.addr), uint32ToIPv4(r.mask), r.cost)
+}
+
+func TestSummaryRoutesForArea(t *testing.T) {
+	network := func(addr, mask string, cost int) summaryRoute {
+		return summaryRoute{layers.SummaryLSANetworktypeV2, ip(addr), ip(mask), cost}
+	}
+	asbr := func(rtId string, cost int) summaryRoute {
+		return summaryRoute{layers.SummaryLSAASBRtypeV2, ip(rtId), 0, cost}
+	}
+	for _, tt := range []struct {
+		name     string
+		setup    func(i *Instance)
+		area     string
+		expected []summaryRoute
+	}{
+		{
+			name: "backbone networks into area",
+			area: "0.0.0.1",
+			expected: []summaryRoute{
+				network("10.0.0.0", "255.255.255.0", 10),
+				network("10.2.0.0", "255.255.255.0", 15),
+				network("10.9.0.0", "255.255.255.0", 15),
+				asbr("5.5.5.5", 10),
+			},
+		},
+		{
+			name: "area networks into backbone",
+			area: "0.0.0.0",
+			expected: []summaryRoute{
+				network("10.1.0.0", "255.255.255.0", 50),
+				asbr("4.4.4.4", 50),
+			},
+		},
+		{
+			name: "range advertised with max cost",
+			setup: func(i *Instance) {
+				i.Backbone.Addresses = []*AreaAddress{testAreaAddress("10.0.0.0/12", false)}
+			},
+			area: "0.0.0.1",
+			expected: []summaryRoute{
+				network("10.0.0.0", "255.240.0.0", 15),
+				asbr("5.5.5.5", 10),
+			},
+		},
+		{
+			name: "networks out of range advertised individually",
+			setup: func(i *Instance) {
+				i.Backbone.Addresses = []*AreaAddress{testAreaAddress("10.0.0.0/15", false)}
+			},
+			area: "0.0.0.1",
+			expected: []summaryRoute{
+				network("10.0.0.0", "255.254.0.0", 10),
+				network("10.2.0.0", "255.255.255.0", 15),
+				network("10.9.0.0", "255.255.255.0", 15),
+				asbr("5.5.5.5", 10),
+			},
+		},
+		{
+			name: "range not advertised",
+			setup: func(i *Instance) {
+				i.Backbone.Addresses = []*AreaAddress{testAreaAddress("10.8.0.0/13", true)}
+			},
+			area: "0.0.0.1",
+			expected: []summaryRoute{
+				network("10.0.0.0", "255.255.255.0", 10),
+				network("10.2.0.0", "255.255.255.0", 15),
+				asbr("5.5.5.5", 10),
+			},
+		},
+		{
+			name: "stub area",
+			setup: func(i *Instance) {
+				a := i.getArea(ip("0.0.0.1"))
+				a.ExternalRoutingCapability, a.StubDefaultCost = false, 3
+			},
+			area: "0.0.0.1",
+			expected: []summaryRoute{
+				network("0.0.0.0", "0.0.0.0", 3),
+				network("10.0.0.0", "255.255.255.0", 10),
+				network("10.2.0.0", "255.255.255.0", 15),
+				network("10.9.0.0", "255.255.255.0", 15),
+			},
+		},
+		{
+			name: "totally stubby area",
+			setup: func(i *Instance) {
+				a := i.getArea(ip("0.0.0.1"))
+				a.ExternalRoutingCapability, a.StubDefaultCost, a.importSummaries = false, 3, false
+			},
+			area: "0.0.0.1",
+			expected: []summaryRoute{
+				network("0.0.0.0", "0.0.0.0", 3),
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newTestABR()
+			if tt.setup != nil {
+				tt.setup(i)
+			}
+			rt, _ := i.calculateRoutingTable()
+			got := i.summaryRoutesForArea(rt, i.getArea(ip(tt.area)))
+			compare := func(x, y summaryRoute) int {
+				return cmp.Or(cmp.Compare(x.lsType, y.lsType), cmp.Compare(x.addr, y.addr), cmp.Compare(x.mask, y.mask))
+			}
+			slices.SortFunc(got, compare)
+			slices.SortFunc(tt.expected, compare)
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("expecting %v but got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCalculateInterAreaRoutesWithAreaRanges(t *testing.T) {
+	i := newTestABR()
+	i.getArea(ip("0.0.0.1")).Addresses = []*AreaAddress{
+		// contains the intra-area network 10.1.0.0/24.
+		testAreaAddress("10.1.0.0/16", false),
+		// contains no network of the area.
+		testAreaAddress("10.5.0.0/16", false),
+	}
+	// The area border router 2.2.2.2 advertises the same ranges into the backbone.
+	i.Backbone.testSummaryLSA(layers.SummaryLSANetworktypeV2, "10.1.0.0", "2.2.2.2", "255.255.0.0", 5)
+	i.Backbone.testSummaryLSA(layers.SummaryLSANetworktypeV2, "10.5.0.0", "2.2.2.2", "255.255.0.0", 5)
+	// Not equal to the range though contained in it.
+	i.Backbone.testSummaryLSA(layers.SummaryLSANetworktypeV2, "10.1.0.0", "2.2.2.2", "255.255.128.0", 5)
+	rt, _ := i.calculateRoutingTable()
+	checkRoutes(t, rt, []expectedRoute{
+		{prefix: "10.1.0.0/24", pathType: RoutingPathIntraArea, cost: 50, nextHops: []string{""}},
+		// The summary-LSA of the active range is ignored.
+		{prefix: "10.1.0.0/16", cost: -1},
+		{prefix: "10.5.0.0/16", pathType: RoutingPathInterArea, cost: 15, nextHops: []string{"10.0.0.2"}},
+		{prefix: "10.1.0.0/17", pathType: RoutingPathInterArea, cost: 15, nextHops: []string{"10.0.0.2"}},
+	})
+}
+
+func TestNewSummaryLSAs(t *testing.T) {
+	network := func(addr, mask string, cost int) summaryRoute {
+		return summaryRoute{layers.SummaryLSANetworktypeV2, ip(addr), ip(mask), cost}
+	}
+	type expectedLSA struct {
+		lsType uint16
+		id     string
+		mask   string
+		metric uint32
+	}
+	for _, tt := range []struct {
+		name     string
+		routes   []summaryRoute
+		expected []expectedLSA
+	}{
+		{
+			name: "network addresses",
+			routes: []summaryRoute{
+				network("10.1.0.0", "255.255.255.0", 10),
+				network("10.0.0.0", "255.255.0.0", 20),
+			},
+			expected: []expectedLSA{
+				{layers.SummaryLSANetworktypeV2, "10.0.0.0", "255.255.0.0", 20},
+				{layers.SummaryLSANetworktypeV2, "10.1.0.0", "255.255.255.0", 10},
+			},
+		},
+		{
+			name: "longer mask gets host bits set",
+			routes: []summaryRoute{
+				network("10.0.0.0", "255.255.255.0", 10),
+				network("10.0.0.0", "255.255.0.0", 20),
+			},
+			expected: []expectedLSA{
+				{layers.SummaryLSANetworktypeV2, "10.0.0.0", "255.255.0.0", 20},
+				{layers.SummaryLSANetworktypeV2, "10.0.0.255", "255.255.255.0", 10},
+			},
+		},
+		{
+			name: "conflicting network skipped",
+			routes: []summaryRoute{
+				network("10.0.0.255", "255.255.255.255", 5),
+				network("10.0.0.0", "255.255.255.0", 10),
+				network("10.0.0.0", "255.255.0.0", 20),
+			},
+			expected: []expectedLSA{
+				{layers.SummaryLSANetworktypeV2, "10.0.0.0", "255.255.0.0", 20},
+				{layers.SummaryLSANetworktypeV2, "10.0.0.255", "255.255.255.0", 10},
+			},
+		},
+		{
+			name: "cheapest of the same destination",
+			routes: []summaryRoute{
+				network("10.0.0.0", "255.255.255.0", 30),
+				network("10.0.0.0", "255.255.255.0", 10),
+			},
+			expected: []expectedLSA{
+				{layers.SummaryLSANetworktypeV2, "10.0.0.0", "255.255.255.0", 10},
+			},
+		},
+		{
+			name: "ASBR summary by Router ID",
+			routes: []summaryRoute{
+				network("10.0.0.0", "255.255.0.0", 20),
+				{layers.SummaryLSAASBRtypeV2, ip("10.0.0.0"), 0, 40},
+			},
+			expected: []expectedLSA{
+				{layers.SummaryLSANetworktypeV2, "10.0.0.0", "255.255.0.0", 20},
+				{layers.SummaryLSAASBRtypeV2, "10.0.0.0", "0.0.0.0", 40},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestInstance("1.1.1.1").Backbone
+			lsas := a.newSummaryLSAs(tt.routes)
+			if len(lsas) != len(tt.expected) {
+				t.Fatalf("expecting %d summary-LSAs but got %d", len(tt.expected), len(lsas))
+			}
+			for idx, exp := range tt.expected {
+				l := lsas[idx]
+				sm, ok := l.Content.(packet2.V2SummaryLSAImpl)
+				if !ok || l.LSType != exp.lsType || l.LinkStateID != ip(exp.id) || l.AdvRouter != ip("1.1.1.1") ||
+					sm.NetworkMask != ip(exp.mask) || sm.Metric != exp.metric {
+					t.Errorf("expecting type %d %s mask %s metric %d but got type %d %v %+v", exp.lsType, exp.id,
+						exp.mask, exp.metric, l.LSType, uint32ToIPv4(l.LinkStateID), l.Content)
+				}
+			}
+		})
+	}
+}
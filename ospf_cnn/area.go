@@ -2,8 +2,11 @@ package ospf_cnn
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
 	"net"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,30 +16,102 @@ type AreaConfig struct {
 	Instance *Instance
 	AreaId   uint32
 	Address  *AreaAddress
-	Options  packet2.BitOption
+	// The address ranges of the area, used to condense the routing
+	// information advertised into other areas when the router is an
+	// area border router.
+	Addresses []*AreaAddress
+	Options   packet2.BitOption
+	// Whether the area is configured as a stub area, into which
+	// AS-external-LSAs are not flooded. The backbone cannot be
+	// configured as a stub area.
+	Stub bool
+	// Whether summary-LSAs other than the default one are kept out of
+	// the stub area or NSSA as well, aka totally stubby area.
+	NoSummary bool
+	// The cost of the default summary-LSA advertised into the stub area
+	// when the router is an area border router. Defaults to 1.
+	StubDefaultCost int
+	// Whether the area is configured as a not-so-stubby area (NSSA) per
+	// RFC3101. AS-external-LSAs are not flooded into the NSSA, but
+	// external routes can be imported into it via Type-7 LSAs.
+	NSSA bool
+	// Whether the router unconditionally translates Type-7 LSAs of the
+	// NSSA into Type-5 LSAs when it is an NSSA border router, i.e. the
+	// NSSATranslatorRole is Always. Otherwise, it is Candidate and the
+	// translator is elected among the NSSA border routers.
+	NSSATranslatorAlways bool
+}
+
+func (c *AreaConfig) validate() error {
+	if c.Stub && c.AreaId == 0 {
+		return errors.New("the backbone cannot be configured as a stub area")
+	}
+	if c.NSSA && c.AreaId == 0 {
+		return errors.New("the backbone cannot be configured as an NSSA")
+	}
+	if c.Stub && c.NSSA {
+		return fmt.Errorf("area %v cannot be both a stub area and an NSSA", uint32ToIPv4(c.AreaId))
+	}
+	if c.NoSummary && !c.Stub && !c.NSSA {
+		return fmt.Errorf("area %v is neither a stub area nor an NSSA: NoSummary requires Stub or NSSA", uint32ToIPv4(c.AreaId))
+	}
+	if c.NSSATranslatorAlways && !c.NSSA {
+		return fmt.Errorf("area %v is not an NSSA: NSSATranslatorAlways requires NSSA", uint32ToIPv4(c.AreaId))
+	}
+	if c.StubDefaultCost < 0 || c.StubDefaultCost >= packet2.LSInfinity {
+		return fmt.Errorf("invalid StubDefaultCost %d of area %v", c.StubDefaultCost, uint32ToIPv4(c.AreaId))
+	}
+	return nil
 }
 
 func NewArea(ctx context.Context, c *AreaConfig) *Area {
+	addrs := slices.Clone(c.Addresses)
+	if c.Address != nil {
+		addrs = append(addrs, c.Address)
+	}
+	options := c.Options
+	if c.Stub || c.NSSA {
+		// The E-bit is reset in the Options field of all packets and
+		// LSAs sent into stub areas and NSSAs.
+		options = options.ClearBit(packet2.CapabilityEbit)
+	}
+	stubDefaultCost := c.StubDefaultCost
+	if stubDefaultCost == 0 {
+		stubDefaultCost = 1
+	}
 	a := &Area{
 		ctx:                       ctx,
 		ins:                       c.Instance,
 		AreaId:                    c.AreaId,
-		Addresses:                 []*AreaAddress{c.Address},
+		Addresses:                 addrs,
 		RouterLSAs:                make(map[packet2.LSAIdentity]*LSDBRouterItem),
 		NetworkLSAs:               make(map[packet2.LSAIdentity]*LSDBNetworkItem),
 		SummaryLSAs:               make(map[packet2.LSAIdentity]*LSDBSummaryItem),
-		Options:                   c.Options,
-		ExternalRoutingCapability: c.Options.IsBitSet(packet2.CapabilityEbit),
-		TransitCapability:         true,
+		NSSALSAs:                  make(map[packet2.LSAIdentity]*LSDBASExternalItem),
+		OpaqueLSAs:                make(map[packet2.LSAIdentity]*LSDBOpaqueItem),
+		Options:                   options,
+		ExternalRoutingCapability: options.IsBitSet(packet2.CapabilityEbit),
+		StubDefaultCost:           stubDefaultCost,
+		importSummaries:           !c.NoSummary,
+		NSSA:                      c.NSSA,
+		nssaTranslatorAlways:      c.NSSATranslatorAlways,
 	}
 	return a
 }
 
 func (a *Area) AddInterface(c *InterfaceConfig) {
+	i := a.attachInterface(c)
+	// The interface is operational once attached to the area. The
+	// router-LSA is updated with the link of it.
+	i.consumeEvent(IfEvInterfaceUp)
+}
+
+// attachInterface adds the interface into the area without bringing it up.
+func (a *Area) attachInterface(c *InterfaceConfig) *Interface {
 	i := NewInterface(context.Background(), c)
 	i.Area = a
 	a.Interfaces = append(a.Interfaces, i)
-	a.updateLSDBWhenInterfaceAdd(i)
+	return i
 }
 
 type Area struct {
@@ -88,6 +163,16 @@ type Area struct {
 	// destinations).
 	SummaryLSAs map[packet2.LSAIdentity]*LSDBSummaryItem
 
+	// Type-7 LSAs originate from the AS boundary routers within the
+	// NSSA. They are flooded only throughout the NSSA, and translated
+	// into AS-external-LSAs by the NSSA border router (see RFC3101).
+	NSSALSAs map[packet2.LSAIdentity]*LSDBASExternalItem
+
+	// Area-local opaque LSAs (LS type 10) are flooded throughout the area
+	// they are originated in, carrying application-specific information
+	// (see RFC5250).
+	OpaqueLSAs map[packet2.LSAIdentity]*LSDBOpaqueItem
+
 	pendingRemoveMaturedRw     sync.RWMutex
 	pendingRemoveMaturedLSAs   map[packet2.LSAIdentity]struct{}
 	pendingRemoveMaturedTicker *TickerFunc
@@ -125,6 +210,20 @@ type Area struct {
 	// should advertise into the area. See Section 12.4.3 for more
 	// information.
 	StubDefaultCost int
+	// Whether summary-LSAs are imported into the area. It is false for
+	// totally stubby areas, into which only the default summary-LSA is
+	// advertised.
+	importSummaries bool
+
+	// Whether the area is a not-so-stubby area. Like stub areas,
+	// AS-external-LSAs are not flooded into NSSAs, while external routes
+	// of the NSSA are described by Type-7 LSAs.
+	NSSA bool
+	// The NSSATranslatorRole of the router is Always.
+	nssaTranslatorAlways bool
+	// The NSSATranslatorState, true when the router translates Type-7 LSAs
+	// of the area, either unconditionally or being elected.
+	nssaTranslating atomic.Bool
 
 	// The shortest-path tree for the area, with this router itself as
 	// root.  Derived from the collected router-LSAs and network-LSAs
@@ -213,5 +312,20 @@ type AreaAddress struct {
 	DoNotAdvertise bool
 }
 
-type SPFTree struct {
+// contains checks whether the network addr/mask falls into the address range.
+func (r *AreaAddress) contains(addr, mask uint32) bool {
+	rangeMask := ipv4MaskToUint32(r.Address.Mask)
+	return rangeMask&mask == rangeMask && addr&rangeMask == ipv4BytesToUint32(r.Address.IP.To4())&rangeMask
+}
+
+// isActive checks whether the address range of area a is active, i.e. one or
+// more networks contained in it are reachable by intra-area paths.
+func (r *AreaAddress) isActive(rt *routingTableBuilder, a *Area) bool {
+	for _, e := range rt.networks {
+		if e.PathType == RoutingPathIntraArea && e.Area == a.AreaId &&
+			r.contains(e.DestinationId, ipv4MaskToUint32(e.AddressMask)) {
+			return true
+		}
+	}
+	return false
 }
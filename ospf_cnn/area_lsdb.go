@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/gopacket/gopacket/layers"
-	"golang.org/x/net/ipv4"
 )
 
 func (a *Area) lsDbInstallLSA(lsa packet2.LSAdvertisement, meta *lsaMeta) error {
@@ -59,6 +58,35 @@ func (a *Area) lsDbInstallLSA(lsa packet2.LSAdvertisement, meta *lsaMeta) error
 				h:       item.LSAheader, l: item.Content,
 			})
 		}
+	case layers.NSSALSAtypeV2:
+		var item packet2.LSAdv[packet2.V2NSSALSA]
+		item, err = lsa.AsV2NSSALSA()
+		if err == nil {
+			a.NSSALSAs[lsa.GetLSAIdentity()] = &LSDBASExternalItem{
+				lsaMeta: meta,
+				h:       item.LSAheader, l: item.Content.V2ASExternalLSA,
+			}
+		}
+	case packet2.AreaLocalOpaqueLSAtypeV2:
+		var item packet2.LSAdv[packet2.V2OpaqueLSA]
+		item, err = lsa.AsV2OpaqueLSA()
+		if err == nil {
+			l := &LSDBOpaqueItem{
+				lsaMeta: meta,
+				h:       item.LSAheader, l: item.Content,
+			}
+			a.OpaqueLSAs[lsa.GetLSAIdentity()] = l
+			a.ins.updateHostname(riLSAKey{areaId: a.AreaId, id: lsa.GetLSAIdentity()}, l)
+		}
+	case packet2.ASOpaqueLSAtypeV2:
+		var item packet2.LSAdv[packet2.V2OpaqueLSA]
+		item, err = lsa.AsV2OpaqueLSA()
+		if err == nil {
+			a.ins.lsDbSetASOpaqueLSA(lsa.GetLSAIdentity(), &LSDBOpaqueItem{
+				lsaMeta: meta,
+				h:       item.LSAheader, l: item.Content,
+			})
+		}
 	}
 	return err
 }
@@ -66,6 +94,8 @@ func (a *Area) lsDbInstallLSA(lsa packet2.LSAdvertisement, meta *lsaMeta) error
 func (a *Area) lsDbInstallReceivedLSA(lsa packet2.LSAdvertisement) {
 	if a.recalculateRoutingTableIfNecessary(lsa.LSAheader) {
 		defer a.ins.recalculateRoutes()
+		// Changes in content also end helping restarting neighbors.
+		defer a.exitHelperOnTopologyChange(lsa.LSAheader)
 	}
 	LogDebug("area %v installing received LSA: %+v", a.AreaId, lsa)
 	err := a.lsDbInstallLSA(lsa, &lsaMeta{
@@ -84,6 +114,8 @@ func (a *Area) lsDbInstallReceivedLSA(lsa packet2.LSAdvertisement) {
 func (a *Area) lsDbInstallNewLSA(lsa packet2.LSAdvertisement) bool {
 	if a.recalculateRoutingTableIfNecessary(lsa.LSAheader) {
 		defer a.ins.recalculateRoutes()
+		// Changes in content also end helping restarting neighbors.
+		defer a.exitHelperOnTopologyChange(lsa.LSAheader)
 	}
 	LogDebug("area %v installing new LSA: %+v", a.AreaId, lsa)
 	// install new LSA into DB
@@ -99,6 +131,10 @@ func (a *Area) lsDbInstallNewLSA(lsa packet2.LSAdvertisement) bool {
 }
 
 func (a *Area) recalculateRoutingTableIfNecessary(lh packet2.LSAheader) (shouldRecalculateRoute bool) {
+	// Opaque LSAs are not used in the routing table calculation (RFC5250 3).
+	if packet2.IsOpaqueLSType(lh.LSType) {
+		return false
+	}
 	// Installing a new LSA in the database, either as the result of
 	//        flooding or a newly self-originated LSA, may cause the OSPF
 	//        routing table structure to be recalculated.  The contents of the
@@ -132,7 +168,14 @@ func newLSAMeta() *lsaMeta {
 	}
 }
 
-func (a *Area) lsDbGetDatabaseSummary() (ret []packet2.LSAIdentity) {
+func (a *Area) lsDbGetDatabaseSummary(n *Neighbor) (ret []packet2.LSAIdentity) {
+	i := n.i
+	// Opaque LSAs are only described to opaque-capable neighbors, the ones
+	// setting the O-bit in their Database Description packets (RFC5250 3.1).
+	opaqueCapable := n.NeighborOptions.IsBitSet(packet2.CapabilityObit)
+	if opaqueCapable {
+		ret = append(ret, i.lsDbGetLinkLocalSummary()...)
+	}
 	a.lsDbRw.RLock()
 	defer a.lsDbRw.RUnlock()
 	for _, l := range a.RouterLSAs {
@@ -141,13 +184,31 @@ func (a *Area) lsDbGetDatabaseSummary() (ret []packet2.LSAIdentity) {
 	for _, l := range a.NetworkLSAs {
 		ret = append(ret, l.h.GetLSAIdentity())
 	}
-	a.ins.lsDbRangeExtLSA(func(id packet2.LSAIdentity, _ *LSDBASExternalItem) bool {
-		ret = append(ret, id)
-		return true
-	})
+	// AS-external-LSAs are omitted from a stub area's database summary,
+	// as well as from the ones sent over virtual links.
+	if a.ExternalRoutingCapability && i.Type != IfTypeVirtualLink {
+		a.ins.lsDbRangeExtLSA(func(id packet2.LSAIdentity, _ *LSDBASExternalItem) bool {
+			ret = append(ret, id)
+			return true
+		})
+		if opaqueCapable {
+			a.ins.lsDbRangeASOpaqueLSA(func(id packet2.LSAIdentity, _ *LSDBOpaqueItem) bool {
+				ret = append(ret, id)
+				return true
+			})
+		}
+	}
 	for _, l := range a.SummaryLSAs {
 		ret = append(ret, l.h.GetLSAIdentity())
 	}
+	for _, l := range a.NSSALSAs {
+		ret = append(ret, l.h.GetLSAIdentity())
+	}
+	if opaqueCapable {
+		for _, l := range a.OpaqueLSAs {
+			ret = append(ret, l.h.GetLSAIdentity())
+		}
+	}
 	return
 }
 
@@ -163,6 +224,13 @@ func (a *Area) lsDbDeleteLSAByIdentity(id packet2.LSAIdentity) {
 		delete(a.SummaryLSAs, id)
 	case layers.ASExternalLSAtypeV2:
 		a.ins.lsDbDeleteExtLSA(id)
+	case layers.NSSALSAtypeV2:
+		delete(a.NSSALSAs, id)
+	case packet2.AreaLocalOpaqueLSAtypeV2:
+		delete(a.OpaqueLSAs, id)
+		a.ins.updateHostname(riLSAKey{areaId: a.AreaId, id: id}, nil)
+	case packet2.ASOpaqueLSAtypeV2:
+		a.ins.lsDbDeleteASOpaqueLSA(id)
 	}
 }
 
@@ -209,6 +277,27 @@ func (a *Area) lsDbGetLSAByIdentity(id packet2.LSAIdentity, entireLSA bool) (lsa
 				fullLSA.LSAheader, fullLSA.Content = extLSA.h, extLSA.l
 			}
 		}
+	case layers.NSSALSAtypeV2:
+		if nssaLSA, ok := a.NSSALSAs[id]; ok {
+			lsaHdr, meta, exist = nssaLSA.h, nssaLSA.lsaMeta, true
+			if entireLSA {
+				fullLSA.LSAheader, fullLSA.Content = nssaLSA.h, nssaLSA.l
+			}
+		}
+	case packet2.AreaLocalOpaqueLSAtypeV2:
+		if opqLSA, ok := a.OpaqueLSAs[id]; ok {
+			lsaHdr, meta, exist = opqLSA.h, opqLSA.lsaMeta, true
+			if entireLSA {
+				fullLSA.LSAheader, fullLSA.Content = opqLSA.h, opqLSA.l
+			}
+		}
+	case packet2.ASOpaqueLSAtypeV2:
+		if opqLSA, ok := a.ins.lsDbGetASOpaqueLSA(id); ok {
+			lsaHdr, meta, exist = opqLSA.h, opqLSA.lsaMeta, true
+			if entireLSA {
+				fullLSA.LSAheader, fullLSA.Content = opqLSA.h, opqLSA.l
+			}
+		}
 	}
 	return
 }
@@ -235,7 +324,8 @@ func (lm *lsaMeta) premature() {
 	lm.rw.Lock()
 	defer lm.rw.Unlock()
 	lm.doNotRefresh = true
-	lm.ctime.Add(-packet2.MaxAge * time.Second)
+	// keep the LS age at MaxAge while aging.
+	lm.ctime = time.Now().Add(-packet2.MaxAge * time.Second)
 }
 
 func (lm *lsaMeta) isDoNotRefresh() bool {
@@ -244,9 +334,9 @@ func (lm *lsaMeta) isDoNotRefresh() bool {
 	return lm.doNotRefresh
 }
 
-func (a *Area) getLSReqListFromDD(dd *packet2.OSPFv2Packet[packet2.DbDescPayload]) (ret []packet2.LSAheader) {
+func (i *Interface) getLSReqListFromDD(dd *packet2.OSPFv2Packet[packet2.DbDescPayload]) (ret []packet2.LSAheader) {
 	for _, l := range dd.Content.LSAinfo {
-		if dbLSAh, _, _, exist := a.lsDbGetLSAByIdentity(l.GetLSAIdentity(), false); !exist {
+		if dbLSAh, _, _, exist := i.lsDbGetLSAByIdentity(l.GetLSAIdentity(), false); !exist {
 			// LSA not exist
 			ret = append(ret, l)
 		} else if l.IsMoreRecentThan(dbLSAh) {
@@ -265,7 +355,7 @@ func (a *Area) respondLSReqWithLSU(n *Neighbor, ifi *Interface, reqs []packet2.L
 	//        the neighbor.
 	lsas := make([]packet2.LSAdvertisement, 0, len(reqs))
 	for _, r := range reqs {
-		if _, lsa, _, exist := a.lsDbGetLSAByIdentity(r.GetLSAIdentity(), true); exist {
+		if _, lsa, _, exist := ifi.lsDbGetLSAByIdentity(r.GetLSAIdentity(), true); exist {
 			lsa.Ager(ifi.InfTransDelay)
 			lsas = append(lsas, lsa)
 		} else {
@@ -275,38 +365,34 @@ func (a *Area) respondLSReqWithLSU(n *Neighbor, ifi *Interface, reqs []packet2.L
 	if len(lsas) <= 0 {
 		return nil
 	}
-	a.splitSendLSAsByMtu(n.i, lsas, ipv4BytesToUint32(n.NeighborAddress.To4()))
+	a.splitSendLSAsByMtu(n.i, lsas, n.pktDst())
 	return
 }
 
 func (a *Area) splitSendLSAsByMtu(sendIf *Interface, lsas []packet2.LSAdvertisement, dst uint32) {
-	// send as many as LSUs per MTU limit
+	// send as many as LSUs per MTU limit. The LSU body starts with the 4 bytes
+	// # LSAs after the 24 bytes OSPF header. An LSA larger than the limit is
+	// sent alone, leaving it to the IP layer to fragment.
+	maxLSAsLen := sendIf.maxPktLen() - 24 - 4
 	lastIdx := 0
 	for lastIdx < len(lsas) {
 		var (
-			nextLSASize          = 0
-			isFirstPkt           = true
+			size                 = 0
 			singleFlightPayloads []packet2.LSAdvertisement
 		)
-		for remainBytes := int(sendIf.MTU) - ipv4.HeaderLen - 24; remainBytes > 0; remainBytes -= nextLSASize {
-			if lastIdx >= len(lsas) {
+		for lastIdx < len(lsas) {
+			next := lsas[lastIdx].Size()
+			if len(singleFlightPayloads) > 0 && size+next > maxLSAsLen {
 				break
 			}
-			thisLSA := lsas[lastIdx]
-			if isFirstPkt {
-				remainBytes -= thisLSA.Size()
-			}
-			singleFlightPayloads = append(singleFlightPayloads, thisLSA)
+			singleFlightPayloads = append(singleFlightPayloads, lsas[lastIdx])
+			size += next
 			lastIdx++
-			if lastIdx < len(lsas) {
-				nextLSASize = lsas[lastIdx].Size()
-			}
-			isFirstPkt = false
 		}
 		pkt := sendPkt{
 			dst: dst,
 			p: &packet2.OSPFv2Packet[packet2.LSUpdatePayload]{
-				OSPFv2: a.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
+				OSPFv2: sendIf.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
 					p.Type = layers.OSPFLinkStateUpdate
 				}),
 				Content: packet2.LSUpdatePayload{
@@ -350,13 +436,34 @@ func (a *Area) removeAllNeighborsLSRetransmission(lsa packet2.LSAIdentity) {
 	}
 }
 
+// floodingScopeInterfaces returns the interfaces the LSA of lsType is flooded out.
+// AS-external-LSAs and AS-scoped opaque LSAs are flooded throughout all non-stub
+// areas, while the others are specific to the area.
+func (a *Area) floodingScopeInterfaces(lsType uint16) []*Interface {
+	if lsType != layers.ASExternalLSAtypeV2 && lsType != packet2.ASOpaqueLSAtypeV2 {
+		return a.Interfaces
+	}
+	var ret []*Interface
+	for _, area := range a.ins.allAreas() {
+		if !area.ExternalRoutingCapability {
+			continue
+		}
+		for _, ifi := range area.Interfaces {
+			if ifi.Type != IfTypeVirtualLink {
+				ret = append(ret, ifi)
+			}
+		}
+	}
+	return ret
+}
+
 func (a *Area) isSelfOriginatedLSA(l packet2.LSAheader) bool {
 	if l.AdvRouter == a.ins.RouterId {
 		return true
 	}
 	if l.LSType == layers.NetworkLSAtypeV2 {
 		for _, ifi := range a.Interfaces {
-			if l.LinkStateID == ipv4BytesToUint32(ifi.Address.IP.To4()) {
+			if l.LinkStateID == ipv4BytesToUint32(ifi.getAddress().IP.To4()) {
 				return true
 			}
 		}
@@ -402,6 +509,24 @@ func (a *Area) agingIntraLSA() (maxAged []agedOutLSA) {
 			})
 		}
 	}
+	for id, l := range a.NSSALSAs {
+		isSelfOriginated := a.isSelfOriginatedLSA(l.h)
+		age := l.doAging()
+		if age >= packet2.MaxAge || (isSelfOriginated && age >= packet2.LSRefreshTime) {
+			maxAged = append(maxAged, agedOutLSA{
+				a, id, isSelfOriginated, l.isDoNotRefresh(),
+			})
+		}
+	}
+	for id, l := range a.OpaqueLSAs {
+		isSelfOriginated := a.isSelfOriginatedLSA(l.h)
+		age := l.doAging()
+		if age >= packet2.MaxAge || (isSelfOriginated && age >= packet2.LSRefreshTime) {
+			maxAged = append(maxAged, agedOutLSA{
+				a, id, isSelfOriginated, l.isDoNotRefresh(),
+			})
+		}
+	}
 
 	return
 }
@@ -415,7 +540,7 @@ func (a *Area) lsDbFlushMaxAgedLSA(id packet2.LSAIdentity) {
 		isInAnyNeighborsReTransmissionList = false
 		isAnyNeighobNotFullyAdjed          = false
 	)
-	for _, i := range a.Interfaces {
+	for _, i := range a.floodingScopeInterfaces(id.LSType) {
 		i.rangeOverNeighbors(func(nb *Neighbor) bool {
 			nbSt := nb.currState()
 			if nbSt == NeighborExchange || nbSt == NeighborLoading {
@@ -443,6 +568,9 @@ func (a *Area) lsDbFlushAllSelfOriginatedLSA() {
 				a.AreaId, len(selfOriginated))
 		}
 		a.prematureLSA(selfOriginated...)
+		for _, ifi := range a.Interfaces {
+			ifi.lsDbFlushLinkLocalLSA()
+		}
 		// TODO: refactor to event-driven.
 		closeCh := make(chan struct{})
 		go func() {
@@ -494,4 +622,14 @@ func (a *Area) lsDbFlushAllSelfOriginatedLSA() {
 			selfOriginated = append(selfOriginated, smLSA.h.GetLSAIdentity())
 		}
 	}
+	for _, nssaLSA := range a.NSSALSAs {
+		if a.isSelfOriginatedLSA(nssaLSA.h) {
+			selfOriginated = append(selfOriginated, nssaLSA.h.GetLSAIdentity())
+		}
+	}
+	for _, opqLSA := range a.OpaqueLSAs {
+		if a.isSelfOriginatedLSA(opqLSA.h) {
+			selfOriginated = append(selfOriginated, opqLSA.h.GetLSAIdentity())
+		}
+	}
 }
@@ -3,37 +3,37 @@ package ospf_cnn
 import (
 	"encoding/binary"
 	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"maps"
+	"net"
+	"slices"
 
 	"github.com/gopacket/gopacket"
 	"github.com/gopacket/gopacket/layers"
 )
 
-var decOpts = gopacket.DecodeOptions{
-	Lazy:                     false,
-	NoCopy:                   true,
-	SkipDecodeRecovery:       false,
-	DecodeStreamsAsDatagrams: false,
-}
-
 func (i *Interface) doReadDispatch(pkt recvPkt) {
-	//dst := pkt.h.Dst
-	//if dst.String() != AllSPFRouters && !dst.Equal(i.Address.IP) {
-	//	LogWarn("interface %s skipped 1 pkt processing causing its IPv4.Dst(%s)"+
-	//		" is neither AllSPFRouter(%s) nor interface addr(%s)", i.c.ifi.Name, dst.String(), AllSPFRouters, i.Address.IP.String())
-	//	return
-	//}
-	ps := gopacket.NewPacket(pkt.p, layers.LayerTypeOSPF, decOpts)
-	p := ps.Layer(layers.LayerTypeOSPF)
-	if p == nil {
-		LogErr("interface %s unexpected got nil OSPF layer parse result", i.c.ifi.Name)
+	// Left to the interfaces the packet is destined to.
+	if i.isReceivedOnOtherInterface(pkt) || i.rejectsVirtualLinkPkt(pkt) {
+		return
+	}
+	if reason, err := i.validateRecvPkt(pkt); err != nil {
+		i.rejectPkt(pkt, reason, err)
 		return
 	}
-	l, ok := p.(*layers.OSPFv2)
-	if !ok {
-		LogWarn("interface %s doReadDispatch expecting(*layers.OSPFv2) but got(%T)", i.c.ifi.Name, p)
+	data, accept, err := i.authenticatePkt(pkt.p)
+	if err != nil {
+		i.rejectPkt(pkt, RejectAuthentication, err)
+		return
+	}
+	l, err := packet2.DecodeOSPFv2(data)
+	if err != nil {
+		i.rejectPkt(pkt, RejectMalformed, err)
 		return
 	}
-	i.doParsedMsgProcessing(pkt.h, (*packet2.LayerOSPFv2)(l))
+	if accept != nil {
+		accept()
+	}
+	i.doParsedMsgProcessing(pkt, l)
 }
 
 func (i *Interface) queuePktForSend(pkt sendPkt) {
@@ -44,21 +44,26 @@ func (i *Interface) queuePktForSend(pkt sendPkt) {
 	}
 }
 
-func (i *Interface) doHello() (err error) {
+func (i *Interface) marshalHello() ([]byte, error) {
+	options := i.Area.Options
+	if i.Area.NSSA {
+		// The N-bit is only set in Hellos sent into the NSSA.
+		options = options.SetBit(packet2.CapabilityNPbit)
+	}
 	hello := &packet2.OSPFv2Packet[packet2.HelloPayloadV2]{
-		OSPFv2: i.Area.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
+		OSPFv2: i.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
 			p.Type = layers.OSPFHello
 		}),
 		Content: packet2.HelloPayloadV2{
 			HelloPkg: layers.HelloPkg{
 				RtrPriority:              i.RouterPriority,
-				Options:                  2,
+				Options:                  uint32(options),
 				HelloInterval:            i.HelloInterval,
 				RouterDeadInterval:       i.RouterDeadInterval,
 				DesignatedRouterID:       i.DR.Load(),
 				BackupDesignatedRouterID: i.BDR.Load(),
 			},
-			NetworkMask: binary.BigEndian.Uint32(i.Address.Mask),
+			NetworkMask: binary.BigEndian.Uint32(i.getAddress().Mask),
 		},
 	}
 	i.nbMu.RLock()
@@ -67,17 +72,53 @@ func (i *Interface) doHello() (err error) {
 	}
 	i.nbMu.RUnlock()
 	p := gopacket.NewSerializeBuffer()
-	err = gopacket.SerializeLayers(p, gopacket.SerializeOptions{
+	err := gopacket.SerializeLayers(p, gopacket.SerializeOptions{
 		FixLengths:       true,
 		ComputeChecksums: true,
 	}, hello)
 	if err != nil {
-		LogErr("interface %s err marshal %s->%s interval hello packet", i.c.ifi.Name, i.Address.IP.String(), AllSPFRouters)
+		return nil, err
+	}
+	if err = i.signPkt(p); err != nil {
+		return nil, err
+	}
+	return p.Bytes(), nil
+}
+
+func (i *Interface) doHello() (err error) {
+	switch {
+	case i.Type == IfTypeVirtualLink:
+		// Hellos are sent over virtual links as unicasts to the virtual
+		// neighbor's address in the Transit area.
+		if dst := i.vl.neighborAddress(); dst != 0 {
+			return i.doHelloTo(dst)
+		}
+		return nil
+	case i.Type == IfTypeNBMA:
+		// On NBMA networks a separate Hello packet is sent to each
+		// qualified neighbor.
+		i.doNBMAHellos()
+		return nil
+	case i.Type == IfTypePointToMultiPoint && len(i.nbmaNeighbors) > 0:
+		// On Point-to-MultiPoint networks without multicast capability,
+		// separate Hello Packets are sent to each attached neighbor
+		// every HelloInterval.
+		i.nbmaMu.Lock()
+		addrs := slices.Collect(maps.Keys(i.nbmaNeighbors))
+		i.nbmaMu.Unlock()
+		for _, addr := range addrs {
+			_ = i.doHelloTo(addr)
+		}
 		return nil
 	}
-	_, err = i.c.WriteMulticastAllSPF(p.Bytes())
+	p, err := i.marshalHello()
 	if err != nil {
-		LogErr("interface %s err send %s->%s interval hello packet", i.c.ifi.Name, i.Address.IP.String(), AllSPFRouters)
+		LogErr("interface %s err marshal %s->%s interval hello packet: %v", i.c.ifi.Name, i.getAddress().IP.String(), AllSPFRouters, err)
+		return nil
+	}
+	_, err = i.c.WriteMulticastAllSPF(p)
+	if err != nil {
+		LogErr("interface %s err send %s->%s interval hello packet", i.c.ifi.Name, i.getAddress().IP.String(), AllSPFRouters)
 	} else {
 		//LogDebug("Sent interval Hello Packet(%d) %s->%s via Interface %s:\n%+v", len(p.Bytes()),
 		//	i.Gateway.IP.String(), AllSPFRouters,
@@ -87,6 +128,21 @@ func (i *Interface) doHello() (err error) {
 	return err
 }
 
+// doHelloTo sends the Hello packet to dst as unicast.
+func (i *Interface) doHelloTo(dst uint32) (err error) {
+	dstIP := uint32ToIPv4(dst)
+	p, err := i.marshalHello()
+	if err != nil {
+		LogErr("interface %s err marshal %s->%s hello packet: %v", i.c.ifi.Name, i.getAddress().IP.String(), dstIP.String(), err)
+		return nil
+	}
+	_, err = i.c.WriteTo(p, &net.IPAddr{IP: dstIP})
+	if err != nil {
+		LogErr("interface %s err send %s->%s hello packet", i.c.ifi.Name, i.getAddress().IP.String(), dstIP.String())
+	}
+	return err
+}
+
 func (a *Area) ospfPktHeader(fn func(p *packet2.LayerOSPFv2)) layers.OSPFv2 {
 	ret := layers.OSPFv2{
 		OSPF: layers.OSPF{
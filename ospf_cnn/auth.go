@@ -0,0 +1,246 @@
+package ospf_cnn
+
+import (
+	"encoding/binary"
+	"fmt"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// InterfaceAuth configures the authentication of OSPF packets on an interface.
+// per RFC2328 D.
+type InterfaceAuth struct {
+	// Identifies the authentication procedure to be used on the attached
+	// network. One of packet.AuTypeNull, AuTypeSimplePassword or AuTypeCryptographic.
+	AuType uint16
+	// Simple password of up to 8 bytes. Used with AuTypeSimplePassword.
+	Password string
+	// Keys used with AuTypeCryptographic. Multiple keys with overlapping
+	// lifetimes allow keys to be changed without dropping adjacencies.
+	KeyChain []AuthKey
+}
+
+// AuthKey is a key of cryptographic authentication.
+// Zero value of lifetime boundaries means no limit.
+type AuthKey struct {
+	KeyId     uint8
+	Algorithm packet2.AuthAlgorithm
+	Key       string
+	// The time period during which the key is used to sign the sent packets.
+	SendStart, SendEnd time.Time
+	// The time period during which the key is accepted for received packets.
+	AcceptStart, AcceptEnd time.Time
+}
+
+func inLifetime(now, start, end time.Time) bool {
+	return (start.IsZero() || !now.Before(start)) && (end.IsZero() || now.Before(end))
+}
+
+func (k AuthKey) canSend(now time.Time) bool {
+	return inLifetime(now, k.SendStart, k.SendEnd)
+}
+
+func (k AuthKey) canAccept(now time.Time) bool {
+	return inLifetime(now, k.AcceptStart, k.AcceptEnd)
+}
+
+func (c InterfaceAuth) validate() error {
+	switch c.AuType {
+	case packet2.AuTypeNull:
+	case packet2.AuTypeSimplePassword:
+		if len(c.Password) > 8 {
+			return fmt.Errorf("simple password exceeds 8 bytes")
+		}
+	case packet2.AuTypeCryptographic:
+		if len(c.KeyChain) == 0 {
+			return fmt.Errorf("no key configured for cryptographic authentication")
+		}
+		seen := make(map[uint8]bool, len(c.KeyChain))
+		for _, k := range c.KeyChain {
+			if seen[k.KeyId] {
+				return fmt.Errorf("duplicated key ID %d", k.KeyId)
+			}
+			seen[k.KeyId] = true
+			if k.Algorithm.DigestLen() == 0 {
+				return fmt.Errorf("key ID %d: unsupported authentication algorithm %v", k.KeyId, k.Algorithm)
+			}
+			if l := k.Algorithm.MaxKeyLen(); l >= 0 && len(k.Key) > l {
+				return fmt.Errorf("key ID %d: %v key exceeds %d bytes", k.KeyId, k.Algorithm, l)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported AuType %d", c.AuType)
+	}
+	return nil
+}
+
+// sendKey selects the key to sign the sent packets.
+// Among the keys valid for sending, the most recently activated one is used.
+func (c InterfaceAuth) sendKey(now time.Time) (AuthKey, bool) {
+	var (
+		ret   AuthKey
+		found bool
+	)
+	for _, k := range c.KeyChain {
+		if k.canSend(now) && (!found || k.SendStart.After(ret.SendStart)) {
+			ret, found = k, true
+		}
+	}
+	return ret, found
+}
+
+// lastExpiredSendKey returns the key whose send lifetime ended most recently.
+func (c InterfaceAuth) lastExpiredSendKey(now time.Time) (AuthKey, bool) {
+	var (
+		ret   AuthKey
+		found bool
+	)
+	for _, k := range c.KeyChain {
+		if !k.SendEnd.IsZero() && !now.Before(k.SendEnd) && (!found || k.SendEnd.After(ret.SendEnd)) {
+			ret, found = k, true
+		}
+	}
+	return ret, found
+}
+
+// acceptKey looks up the key to verify the received packet.
+func (c InterfaceAuth) acceptKey(keyId uint8, now time.Time) (AuthKey, error) {
+	for _, k := range c.KeyChain {
+		if k.KeyId != keyId {
+			continue
+		}
+		if !k.canAccept(now) {
+			return k, fmt.Errorf("key ID %d is not acceptable now", keyId)
+		}
+		return k, nil
+	}
+	return AuthKey{}, fmt.Errorf("unknown key ID %d", keyId)
+}
+
+// SetAuth changes the authentication of packets sent and received on the interface.
+func (i *Interface) SetAuth(c InterfaceAuth) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	i.authMu.Lock()
+	defer i.authMu.Unlock()
+	i.auth = c
+	return nil
+}
+
+func (i *Interface) getAuth() InterfaceAuth {
+	i.authMu.RLock()
+	defer i.authMu.RUnlock()
+	return i.auth
+}
+
+// nextCryptoSeqNumber returns the non-decreasing cryptographic sequence number for
+// the packet to be sent. It starts from the current time, so it keeps increasing
+// across restarts.
+func (i *Interface) nextCryptoSeqNumber() uint32 {
+	for {
+		last := i.cryptoSeqNum.Load()
+		next := max(last+1, uint32(time.Now().Unix()))
+		if i.cryptoSeqNum.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
+
+// ospfPktHeader returns the OSPF packet header with the authentication type
+// and simple password of the interface set.
+func (i *Interface) ospfPktHeader(fn func(p *packet2.LayerOSPFv2)) layers.OSPFv2 {
+	auth := i.getAuth()
+	return i.Area.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
+		p.AuType = auth.AuType
+		if auth.AuType == packet2.AuTypeSimplePassword {
+			p.Authentication = packet2.SimplePasswordAuthentication(auth.Password)
+		}
+		fn(p)
+	})
+}
+
+// signPkt appends the authentication trailer to the serialized OSPF packet if needed.
+// The authentication field of simple password is set in the header before serializing.
+func (i *Interface) signPkt(b gopacket.SerializeBuffer) error {
+	auth := i.getAuth()
+	if auType := binary.BigEndian.Uint16(b.Bytes()[14:16]); auType != auth.AuType {
+		return fmt.Errorf("authentication changed from AuType %d to %d", auType, auth.AuType)
+	}
+	if auth.AuType != packet2.AuTypeCryptographic {
+		return nil
+	}
+	now := time.Now()
+	k, ok := auth.sendKey(now)
+	if !ok {
+		// In the event that the last key associated with an interface
+		// expires, it is unacceptable to revert to an unauthenticated
+		// condition. The key is treated as having an infinite lifetime
+		// until the lifetime is extended, deleted, or a new key configured.
+		if k, ok = auth.lastExpiredSendKey(now); !ok {
+			return fmt.Errorf("no key available for sending")
+		}
+		if !i.lastKeyExpiredWarned.Swap(true) {
+			LogWarn("interface %s last authentication key ID %d expired, keep using it until a new key configured",
+				i.c.ifi.Name, k.KeyId)
+		}
+	} else {
+		i.lastKeyExpiredWarned.Store(false)
+	}
+	return packet2.AppendAuthDigest(b, k.Algorithm, k.KeyId, i.nextCryptoSeqNumber(), []byte(k.Key))
+}
+
+// authenticatePkt checks the authentication of the received OSPF packet.
+// It returns the packet with the authentication trailer stripped. per RFC2328 D.
+// The cryptographic sequence number is not recorded until accept is called
+// once the packet has been accepted, so that a packet discarded afterwards
+// does not lock out the ones of lower sequence numbers. accept may be nil.
+func (i *Interface) authenticatePkt(data []byte) (_ []byte, accept func(), _ error) {
+	if len(data) < 24 {
+		return nil, nil, fmt.Errorf("packet too small for OSPF Version 2")
+	}
+	auth := i.getAuth()
+	auType := binary.BigEndian.Uint16(data[14:16])
+	if auType != auth.AuType {
+		return nil, nil, fmt.Errorf("mismatched AuType %d, expecting %d", auType, auth.AuType)
+	}
+	authentication := binary.BigEndian.Uint64(data[16:24])
+	switch auType {
+	case packet2.AuTypeSimplePassword:
+		if authentication != packet2.SimplePasswordAuthentication(auth.Password) {
+			return nil, nil, fmt.Errorf("mismatched simple password")
+		}
+	case packet2.AuTypeCryptographic:
+		c := packet2.ParseCryptographicAuthentication(authentication)
+		k, err := auth.acceptKey(c.KeyId, time.Now())
+		if err != nil {
+			return nil, nil, err
+		}
+		if int(c.AuthDataLen) != k.Algorithm.DigestLen() {
+			return nil, nil, fmt.Errorf("auth data length %d mismatched %v of key ID %d", c.AuthDataLen, k.Algorithm, k.KeyId)
+		}
+		if err = packet2.VerifyAuthDigest(data, k.Algorithm, []byte(k.Key)); err != nil {
+			return nil, nil, err
+		}
+		// If the cryptographic sequence number found in the OSPF header is
+		// less than the cryptographic sequence number recorded in the
+		// sending neighbor's data structure, the OSPF packet must be discarded.
+		if nb, ok := i.getNeighbor(binary.BigEndian.Uint32(data[4:8])); ok {
+			if last := nb.cryptoSeqNum.Load(); c.SeqNumber < last {
+				return nil, nil, fmt.Errorf("cryptographic sequence number %d less than last seen %d", c.SeqNumber, last)
+			}
+			accept = func() {
+				for last := nb.cryptoSeqNum.Load(); c.SeqNumber > last; last = nb.cryptoSeqNum.Load() {
+					if nb.cryptoSeqNum.CompareAndSwap(last, c.SeqNumber) {
+						return
+					}
+				}
+			}
+		}
+		data = data[:binary.BigEndian.Uint16(data[2:4])]
+	}
+	return data, accept, nil
+}
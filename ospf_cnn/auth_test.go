@@ -0,0 +1,78 @@
+package ospf_cnn
+
+import (
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"testing"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+func TestAuthenticatePktSequenceNumber(t *testing.T) {
+	const nbId = 0x02020202
+	nb := &Neighbor{NeighborId: nbId}
+	i := &Interface{Neighbors: map[uint32]*Neighbor{nbId: nb}}
+	if err := i.SetAuth(InterfaceAuth{
+		AuType:   packet2.AuTypeCryptographic,
+		KeyChain: []AuthKey{{KeyId: 7, Algorithm: packet2.AuthAlgorithmHMACSHA256, Key: "secret"}},
+	}); err != nil {
+		t.Fatalf("failed to set auth: %s", err)
+	}
+	signed := func(seq uint32) []byte {
+		hello := &packet2.OSPFv2Packet[packet2.HelloPayloadV2]{
+			OSPFv2: layers.OSPFv2{
+				OSPF:   layers.OSPF{Version: 2, Type: layers.OSPFHello, RouterID: nbId},
+				AuType: packet2.AuTypeCryptographic,
+			},
+			Content: packet2.HelloPayloadV2{
+				HelloPkg:    layers.HelloPkg{HelloInterval: 10, RouterDeadInterval: 40},
+				NetworkMask: 0xffffff00,
+			},
+		}
+		b := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(b, gopacket.SerializeOptions{FixLengths: true}, hello); err != nil {
+			t.Fatalf("failed to serialize hello: %s", err)
+		}
+		if err := packet2.AppendAuthDigest(b, packet2.AuthAlgorithmHMACSHA256, 7, seq, []byte("secret")); err != nil {
+			t.Fatalf("failed to sign hello: %s", err)
+		}
+		return b.Bytes()
+	}
+	authenticate := func(seq uint32) (func(), error) {
+		_, accept, err := i.authenticatePkt(signed(seq))
+		return accept, err
+	}
+
+	if _, err := authenticate(100); err != nil {
+		t.Fatalf("failed to authenticate: %s", err)
+	}
+	if seq := nb.cryptoSeqNum.Load(); seq != 0 {
+		t.Errorf("expecting sequence number not recorded before accepted but got %d", seq)
+	}
+	// The packet of sequence number 100 has been discarded, so the lower
+	// one is still acceptable.
+	accept, err := authenticate(50)
+	if err != nil {
+		t.Fatalf("failed to authenticate: %s", err)
+	}
+	accept()
+	if seq := nb.cryptoSeqNum.Load(); seq != 50 {
+		t.Errorf("expecting sequence number 50 but got %d", seq)
+	}
+	if _, err = authenticate(49); err == nil {
+		t.Errorf("expecting sequence number 49 rejected")
+	}
+	accept, err = authenticate(50)
+	if err != nil {
+		t.Fatalf("expecting the same sequence number accepted but got %s", err)
+	}
+	newer, err := authenticate(80)
+	if err != nil {
+		t.Fatalf("failed to authenticate: %s", err)
+	}
+	newer()
+	accept()
+	if seq := nb.cryptoSeqNum.Load(); seq != 80 {
+		t.Errorf("expecting sequence number 80 kept but got %d", seq)
+	}
+}
@@ -30,6 +30,9 @@ const (
 	IPPacketTos = 0b11000000 // 0xc0
 
 	MulticastTTL = 1
+	// VirtualLinkTTL is the TTL of packets sent over virtual links, which
+	// are routed across the transit area to the other endpoint.
+	VirtualLinkTTL = 64
 )
 
 type Conn struct {
@@ -37,6 +40,7 @@ type Conn struct {
 	ifi             *net.Interface
 	listenAddr      string
 	srcIP           net.IP
+	ttl             uint8
 	wMu             *sync.Mutex
 	rc              *ipv4.RawConn
 }
@@ -52,6 +56,7 @@ func ListenOSPFv2Multicast(ctx context.Context, ifi *net.Interface, addr string,
 	ospf = &Conn{
 		listenAddr: addr,
 		srcIP:      net.ParseIP(srcip),
+		ttl:        MulticastTTL,
 		wMu:        &sync.Mutex{},
 		ifi:        ifi,
 	}
@@ -103,10 +108,54 @@ func ListenOSPFv2Multicast(ctx context.Context, ifi *net.Interface, addr string,
 	return
 }
 
-func (o *Conn) Read(buf []byte) (int, *ipv4.Header, error) {
+// ListenOSPFv2Unicast listens OSPF packets without joining any multicast group.
+// It is used on NBMA networks, where all packets are sent as unicasts.
+func ListenOSPFv2Unicast(ctx context.Context, ifi *net.Interface, addr string, srcip string) (ospf *Conn, err error) {
+	ospf = &Conn{
+		listenAddr: addr,
+		srcIP:      net.ParseIP(srcip),
+		ttl:        MulticastTTL,
+		wMu:        &sync.Mutex{},
+		ifi:        ifi,
+	}
+	rc, err := iface.ListenIPv4ByProtocol(ctx, IPProtocolNum, addr,
+		func(rc *ipv4.RawConn) error {
+			// 路由协议包中的 IP 优先级被应该被设定为 Internetwork Control
+			if err := rc.SetTOS(IPPacketTos); err != nil {
+				return fmt.Errorf("err SetTOS: %w", err)
+			}
+			return nil
+		})
+	if err != nil {
+		return
+	}
+	ospf.rc = rc
+
+	return
+}
+
+// ListenOSPFv2VirtualLink listens OSPF packets sent over a virtual link named name.
+// The packets are routed to the other endpoint across the transit area, so the
+// source address is left to be filled in by the kernel per the route taken.
+func ListenOSPFv2VirtualLink(ctx context.Context, name string) (ospf *Conn, err error) {
+	ospf, err = ListenOSPFv2Unicast(ctx, &net.Interface{Name: name}, "0.0.0.0", "0.0.0.0")
+	if err != nil {
+		return
+	}
+	ospf.ttl = VirtualLinkTTL
+	return
+}
+
+// Read reads a packet along with the index of the interface it was received
+// on, which is 0 if unknown.
+func (o *Conn) Read(buf []byte) (int, *ipv4.Header, int, error) {
 	_ = o.rc.SetReadDeadline(time.Now().Add(1 * time.Second))
-	h, payload, _, err := o.rc.ReadFrom(buf)
-	return len(payload) + ipv4.HeaderLen, h, err
+	h, payload, cm, err := o.rc.ReadFrom(buf)
+	ifIndex := 0
+	if cm != nil {
+		ifIndex = cm.IfIndex
+	}
+	return len(payload) + ipv4.HeaderLen, h, ifIndex, err
 }
 
 func (o *Conn) fixIPv4HeaderForSend(b []byte) {
@@ -124,7 +173,7 @@ func (o *Conn) fixIPv4HeaderForSend(b []byte) {
 func (o *Conn) WriteTo(ospfMsg []byte, dst *net.IPAddr) (n int, err error) {
 	ip := &layers.IPv4{
 		Version:  ipv4.Version,
-		TTL:      MulticastTTL,
+		TTL:      o.ttl,
 		TOS:      IPPacketTos,
 		Protocol: IPProtocolNum,
 		SrcIP:    o.srcIP,
@@ -0,0 +1,86 @@
+package ospf_cnn
+
+import (
+	"fmt"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"net"
+)
+
+// DefaultExternalMetric is the metric used for announced external routes by default.
+const DefaultExternalMetric = 10000
+
+type ExternalMetricType uint8
+
+const (
+	// ExternalMetricType2 is considered larger than any link state path.
+	// This is the default type.
+	ExternalMetricType2 ExternalMetricType = iota
+	// ExternalMetricType1 is expressed in the same units as the link state metric.
+	ExternalMetricType1
+)
+
+func (t ExternalMetricType) String() string {
+	if t == ExternalMetricType1 {
+		return "E1"
+	}
+	return "E2"
+}
+
+// ExternalRoute is a route to a destination external to the AS, advertised
+// by the router via AS-external-LSA.
+type ExternalRoute struct {
+	Prefix net.IPNet
+	// The cost of this route. Only the lower 3 bytes are used, and it must
+	// be less than LSInfinity.
+	Metric uint32
+	// The type of external metric.
+	MetricType ExternalMetricType
+	// Data traffic for the advertised destination will be forwarded to
+	// this address. If nil or unspecified, data traffic will be forwarded
+	// instead to the LSA's originator.
+	ForwardingAddress net.IP
+	// A 32-bit field attached to each external route. This is not used
+	// by the OSPF protocol itself.
+	Tag uint32
+}
+
+// NewExternalRoute returns an external route with default attributes.
+func NewExternalRoute(prefix net.IPNet) ExternalRoute {
+	return ExternalRoute{
+		Prefix:     prefix,
+		Metric:     DefaultExternalMetric,
+		MetricType: ExternalMetricType2,
+	}
+}
+
+func (r ExternalRoute) validate() error {
+	if r.Prefix.IP.To4() == nil || len(r.Prefix.Mask) != net.IPv4len {
+		return fmt.Errorf("external route %v is not an IPv4 prefix", r.Prefix.String())
+	}
+	if r.Metric >= packet2.LSInfinity {
+		return fmt.Errorf("external route %v metric %d exceeds %d", r.Prefix.String(), r.Metric, packet2.LSInfinity-1)
+	}
+	if r.ForwardingAddress != nil && r.ForwardingAddress.To4() == nil {
+		return fmt.Errorf("external route %v forwarding address %v is not IPv4", r.Prefix.String(), r.ForwardingAddress)
+	}
+	return nil
+}
+
+func (r ExternalRoute) asV2ASExternalLSA() packet2.V2ASExternalLSA {
+	ret := packet2.V2ASExternalLSA{
+		NetworkMask:      ipv4MaskToUint32(r.Prefix.Mask),
+		Metric:           r.Metric,
+		ExternalRouteTag: r.Tag,
+	}
+	if r.MetricType == ExternalMetricType2 {
+		ret.ExternalBit = uint8(packet2.BitOption(0).SetBit(packet2.ASExternalLSAFlagEbit))
+	}
+	if fwd := r.ForwardingAddress.To4(); fwd != nil {
+		ret.ForwardingAddress = ipv4BytesToUint32(fwd)
+	}
+	return ret
+}
+
+func externalRouteKey(prefix net.IPNet) string {
+	return (&net.IPNet{IP: prefix.IP.Mask(prefix.Mask), Mask: prefix.Mask}).String()
+}
@@ -0,0 +1,137 @@
+package fib
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+)
+
+// NextHop is one of the equal-cost paths of a Route.
+type NextHop struct {
+	// Gateway is the address of the next router.
+	Gateway netip.Addr
+	// IfIndex is the index of the outgoing interface.
+	IfIndex int
+}
+
+// Route is a unicast route to be programmed into a FIB.
+type Route struct {
+	Dst      netip.Prefix
+	NextHops []NextHop
+}
+
+func (r Route) Equal(o Route) bool {
+	return r.Dst == o.Dst && slices.Equal(r.NextHops, o.NextHops)
+}
+
+func (r Route) String() string {
+	return fmt.Sprintf("%s nexthops %v", r.Dst, r.NextHops)
+}
+
+// ErrRouteNotFound is returned by FIB.Delete when the route does not exist,
+// e.g. removed by the administrator or along with its outgoing interface.
+var ErrRouteNotFound = errors.New("route not found")
+
+// FIB is a forwarding information base routes can be installed into.
+type FIB interface {
+	// Replace installs the route, replacing any existing route of the same destination.
+	Replace(r Route) error
+	// Delete removes the route of the destination.
+	Delete(r Route) error
+	Close() error
+}
+
+// Sink keeps a FIB in sync with the desired set of routes
+// by only applying the differences.
+type Sink struct {
+	mu        sync.Mutex
+	fib       FIB
+	installed map[netip.Prefix]Route
+}
+
+func NewSink(f FIB) *Sink {
+	return &Sink{
+		fib:       f,
+		installed: make(map[netip.Prefix]Route),
+	}
+}
+
+// Apply makes the FIB contain exactly the desired routes.
+// Routes failed to be applied are retried at the next Apply.
+func (s *Sink) Apply(desired []Route) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	want := make(map[netip.Prefix]Route, len(desired))
+	for _, r := range desired {
+		r.Dst = r.Dst.Masked()
+		want[r.Dst] = r
+	}
+	for dst, r := range s.installed {
+		if _, ok := want[dst]; ok {
+			continue
+		}
+		// A route gone already needs no more deleting.
+		if err := s.fib.Delete(r); err != nil && !errors.Is(err, ErrRouteNotFound) {
+			errs = append(errs, fmt.Errorf("err delete route %v: %w", r, err))
+			continue
+		}
+		delete(s.installed, dst)
+	}
+	for dst, r := range want {
+		if exist, ok := s.installed[dst]; ok && exist.Equal(r) {
+			continue
+		}
+		if err := s.fib.Replace(r); err != nil {
+			errs = append(errs, fmt.Errorf("err replace route %v: %w", r, err))
+			// the kernel may still hold the old route.
+			continue
+		}
+		s.installed[dst] = r
+	}
+	return errors.Join(errs...)
+}
+
+// Flush removes all routes installed by the sink.
+func (s *Sink) Flush() error {
+	return s.Apply(nil)
+}
+
+// Close flushes the installed routes and closes the underlying FIB.
+func (s *Sink) Close() error {
+	return errors.Join(s.Flush(), s.fib.Close())
+}
+
+// Detach closes the underlying FIB leaving the installed routes in place,
+// so packets keep being forwarded while the router restarts gracefully.
+func (s *Sink) Detach() error {
+	return s.fib.Close()
+}
+
+// Adopt takes over the routes installed before restarting. They are replaced
+// or removed by the next Apply like the ones installed by the sink itself.
+func (s *Sink) Adopt(routes []Route) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range routes {
+		r.Dst = r.Dst.Masked()
+		s.installed[r.Dst] = r
+	}
+}
+
+// Installed returns the routes currently installed by the sink.
+func (s *Sink) Installed() []Route {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]Route, 0, len(s.installed))
+	for _, r := range s.installed {
+		ret = append(ret, r)
+	}
+	slices.SortFunc(ret, func(a, b Route) int {
+		return cmp.Or(a.Dst.Addr().Compare(b.Dst.Addr()), cmp.Compare(a.Dst.Bits(), b.Dst.Bits()))
+	})
+	return ret
+}
@@ -0,0 +1,119 @@
+package fib
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func route(dst string, gws ...string) Route {
+	r := Route{Dst: netip.MustParsePrefix(dst)}
+	for _, gw := range gws {
+		r.NextHops = append(r.NextHops, NextHop{Gateway: netip.MustParseAddr(gw), IfIndex: 2})
+	}
+	return r
+}
+
+func TestSinkApplyDiff(t *testing.T) {
+	m := NewMemoryFIB()
+	s := NewSink(m)
+	if err := s.Apply([]Route{route("10.0.0.0/24", "192.168.1.2"), route("10.0.1.0/24", "192.168.1.3")}); err != nil {
+		t.Fatalf("apply failed: %s", err)
+	}
+	if m.Replaced != 2 || len(m.Routes) != 2 {
+		t.Fatalf("expecting 2 routes installed but got replaced(%d) routes(%d)", m.Replaced, len(m.Routes))
+	}
+
+	// unchanged routes must not be re-programmed.
+	if err := s.Apply([]Route{route("10.0.0.0/24", "192.168.1.2"), route("10.0.1.0/24", "192.168.1.3")}); err != nil {
+		t.Fatalf("apply failed: %s", err)
+	}
+	if m.Replaced != 2 || m.Deleted != 0 {
+		t.Fatalf("expecting no changes but got replaced(%d) deleted(%d)", m.Replaced, m.Deleted)
+	}
+
+	// one changed, one removed and one added.
+	if err := s.Apply([]Route{route("10.0.0.0/24", "192.168.1.2", "192.168.1.4"), route("10.0.2.0/24", "192.168.1.3")}); err != nil {
+		t.Fatalf("apply failed: %s", err)
+	}
+	if m.Replaced != 4 || m.Deleted != 1 {
+		t.Fatalf("expecting replaced(4) deleted(1) but got replaced(%d) deleted(%d)", m.Replaced, m.Deleted)
+	}
+	if r := m.Routes[netip.MustParsePrefix("10.0.0.0/24")]; len(r.NextHops) != 2 {
+		t.Errorf("expecting 2 nexthops but got %v", r)
+	}
+	if _, ok := m.Routes[netip.MustParsePrefix("10.0.1.0/24")]; ok {
+		t.Errorf("expecting 10.0.1.0/24 removed")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("close failed: %s", err)
+	}
+	if len(m.Routes) != 0 || len(s.Installed()) != 0 {
+		t.Errorf("expecting all routes removed on close but got %v", m.Routes)
+	}
+}
+
+func TestSinkApplyRetryFailed(t *testing.T) {
+	m := NewMemoryFIB()
+	s := NewSink(m)
+	dst := netip.MustParsePrefix("10.0.0.0/24")
+	m.FailOn[dst] = errors.New("boom")
+	if err := s.Apply([]Route{route("10.0.0.0/24", "192.168.1.2")}); err == nil {
+		t.Fatalf("expecting apply failed")
+	}
+	if len(s.Installed()) != 0 {
+		t.Fatalf("failed route should not be recorded as installed")
+	}
+	delete(m.FailOn, dst)
+	if err := s.Apply([]Route{route("10.0.0.0/24", "192.168.1.2")}); err != nil {
+		t.Fatalf("apply failed: %s", err)
+	}
+	if _, ok := m.Routes[dst]; !ok {
+		t.Errorf("expecting failed route retried")
+	}
+}
+
+func TestSinkAdoptAfterRestart(t *testing.T) {
+	m := NewMemoryFIB()
+	s := NewSink(m)
+	if err := s.Apply([]Route{route("10.0.0.0/24", "192.168.1.2"), route("10.0.1.0/24", "192.168.1.3")}); err != nil {
+		t.Fatalf("apply failed: %s", err)
+	}
+	installed := s.Installed()
+	if err := s.Detach(); err != nil {
+		t.Fatalf("detach failed: %s", err)
+	}
+	if len(m.Routes) != 2 {
+		t.Fatalf("expecting routes kept on detach but got %v", m.Routes)
+	}
+
+	// the restarted sink only re-programs the differences.
+	s = NewSink(m)
+	s.Adopt(installed)
+	if err := s.Apply([]Route{route("10.0.0.0/24", "192.168.1.2")}); err != nil {
+		t.Fatalf("apply failed: %s", err)
+	}
+	if m.Replaced != 2 || m.Deleted != 1 {
+		t.Errorf("expecting replaced(2) deleted(1) but got replaced(%d) deleted(%d)", m.Replaced, m.Deleted)
+	}
+}
+
+func TestSinkDeleteRouteGone(t *testing.T) {
+	m := NewMemoryFIB()
+	s := NewSink(m)
+	if err := s.Apply([]Route{route("10.0.0.0/24", "192.168.1.2"), route("10.0.0.0/16", "192.168.1.3")}); err != nil {
+		t.Fatalf("apply failed: %s", err)
+	}
+	if installed := s.Installed(); len(installed) != 2 || installed[0].Dst.Bits() != 16 || installed[1].Dst.Bits() != 24 {
+		t.Errorf("expecting routes sorted by prefix length but got %v", installed)
+	}
+	// removed behind the sink, e.g. along with the interface.
+	delete(m.Routes, netip.MustParsePrefix("10.0.0.0/24"))
+	if err := s.Apply([]Route{route("10.0.0.0/16", "192.168.1.3")}); err != nil {
+		t.Fatalf("expecting route gone treated as deleted but got %s", err)
+	}
+	if installed := s.Installed(); len(installed) != 1 {
+		t.Errorf("expecting route gone dropped from installed but got %v", installed)
+	}
+}
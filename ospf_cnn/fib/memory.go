@@ -0,0 +1,53 @@
+package fib
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+)
+
+// MemoryFIB is an in-memory FIB. It is useful for testing without privileges.
+type MemoryFIB struct {
+	mu     sync.Mutex
+	Routes map[netip.Prefix]Route
+	// Number of Replace and Delete calls succeeded.
+	Replaced, Deleted int
+	// FailOn makes Replace and Delete of the destination fail.
+	FailOn map[netip.Prefix]error
+}
+
+func NewMemoryFIB() *MemoryFIB {
+	return &MemoryFIB{
+		Routes: make(map[netip.Prefix]Route),
+		FailOn: make(map[netip.Prefix]error),
+	}
+}
+
+func (m *MemoryFIB) Replace(r Route) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.FailOn[r.Dst]; err != nil {
+		return err
+	}
+	m.Routes[r.Dst] = r
+	m.Replaced++
+	return nil
+}
+
+func (m *MemoryFIB) Delete(r Route) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.FailOn[r.Dst]; err != nil {
+		return err
+	}
+	if _, ok := m.Routes[r.Dst]; !ok {
+		return fmt.Errorf("%w: %v", ErrRouteNotFound, r.Dst)
+	}
+	delete(m.Routes, r.Dst)
+	m.Deleted++
+	return nil
+}
+
+func (m *MemoryFIB) Close() error {
+	return nil
+}
@@ -0,0 +1,185 @@
+//go:build linux
+
+package fib
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// netlinkAckTimeout is how long to wait for the kernel to acknowledge a request.
+const netlinkAckTimeout = 5 * time.Second
+
+type NetlinkConfig struct {
+	// Kernel routing table ID to install routes into. Defaults to main table.
+	Table uint32
+	// Route protocol number the routes are marked with. Defaults to RTPROT_OSPF.
+	Protocol uint8
+	// Route metric (priority) of installed routes.
+	Metric uint32
+}
+
+// NetlinkFIB programs routes into the Linux kernel over rtnetlink.
+type NetlinkFIB struct {
+	c   NetlinkConfig
+	mu  sync.Mutex
+	fd  int
+	seq atomic.Uint32
+}
+
+func NewNetlinkFIB(c NetlinkConfig) (FIB, error) {
+	if c.Table == 0 {
+		c.Table = unix.RT_TABLE_MAIN
+	}
+	if c.Protocol == 0 {
+		c.Protocol = unix.RTPROT_OSPF
+	}
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("err open netlink socket: %w", err)
+	}
+	if err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("err bind netlink socket: %w", err)
+	}
+	tv := unix.NsecToTimeval(netlinkAckTimeout.Nanoseconds())
+	if err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("err set netlink socket receive timeout: %w", err)
+	}
+	return &NetlinkFIB{c: c, fd: fd}, nil
+}
+
+func (n *NetlinkFIB) Replace(r Route) error {
+	return n.request(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, r)
+}
+
+func (n *NetlinkFIB) Delete(r Route) error {
+	err := n.request(unix.RTM_DELROUTE, 0, r)
+	if errors.Is(err, unix.ESRCH) {
+		return fmt.Errorf("%w: %w", ErrRouteNotFound, err)
+	}
+	return err
+}
+
+func (n *NetlinkFIB) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return unix.Close(n.fd)
+}
+
+func (n *NetlinkFIB) request(typ uint16, flags uint16, r Route) error {
+	if !r.Dst.Addr().Is4() {
+		return fmt.Errorf("only IPv4 routes are supported")
+	}
+	msg := n.marshalRoute(typ, flags, r)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := unix.Sendto(n.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("err send netlink request: %w", err)
+	}
+	return n.waitAck(binary.NativeEndian.Uint32(msg[8:12]))
+}
+
+func (n *NetlinkFIB) waitAck(seq uint32) error {
+	buf := make([]byte, unix.Getpagesize())
+	for {
+		// Acks of requests timed out earlier are skipped by their
+		// sequence numbers.
+		nr, _, err := unix.Recvfrom(n.fd, buf, 0)
+		if errors.Is(err, unix.EAGAIN) {
+			return fmt.Errorf("err receive netlink ack: timed out after %v", netlinkAckTimeout)
+		}
+		if err != nil {
+			return fmt.Errorf("err receive netlink ack: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:nr])
+		if err != nil {
+			return fmt.Errorf("err parse netlink ack: %w", err)
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != seq || m.Header.Type != unix.NLMSG_ERROR {
+				continue
+			}
+			if len(m.Data) < 4 {
+				return fmt.Errorf("netlink ack too short")
+			}
+			if errno := int32(binary.NativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+				return unix.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
+
+func (n *NetlinkFIB) marshalRoute(typ uint16, flags uint16, r Route) []byte {
+	table := uint8(unix.RT_TABLE_UNSPEC)
+	if n.c.Table < 256 {
+		table = uint8(n.c.Table)
+	}
+	// struct rtmsg
+	b := make([]byte, unix.SizeofNlMsghdr+unix.SizeofRtMsg)
+	rtm := b[unix.SizeofNlMsghdr:]
+	rtm[0] = unix.AF_INET
+	rtm[1] = uint8(r.Dst.Bits())
+	rtm[4] = table
+	rtm[5] = n.c.Protocol
+	rtm[6] = unix.RT_SCOPE_UNIVERSE
+	rtm[7] = unix.RTN_UNICAST
+
+	dst := r.Dst.Addr().As4()
+	b = appendRtAttr(b, unix.RTA_DST, dst[:])
+	b = appendRtAttr(b, unix.RTA_TABLE, binary.NativeEndian.AppendUint32(nil, n.c.Table))
+	b = appendRtAttr(b, unix.RTA_PRIORITY, binary.NativeEndian.AppendUint32(nil, n.c.Metric))
+	if typ == unix.RTM_NEWROUTE {
+		switch len(r.NextHops) {
+		case 0:
+		case 1:
+			nh := r.NextHops[0]
+			if nh.Gateway.IsValid() {
+				gw := nh.Gateway.As4()
+				b = appendRtAttr(b, unix.RTA_GATEWAY, gw[:])
+			}
+			b = appendRtAttr(b, unix.RTA_OIF, binary.NativeEndian.AppendUint32(nil, uint32(nh.IfIndex)))
+		default:
+			var mp []byte
+			for _, nh := range r.NextHops {
+				rtnh := make([]byte, unix.SizeofRtNexthop)
+				binary.NativeEndian.PutUint32(rtnh[4:8], uint32(nh.IfIndex))
+				if nh.Gateway.IsValid() {
+					gw := nh.Gateway.As4()
+					rtnh = appendRtAttr(rtnh, unix.RTA_GATEWAY, gw[:])
+				}
+				binary.NativeEndian.PutUint16(rtnh[0:2], uint16(len(rtnh)))
+				mp = append(mp, rtnh...)
+			}
+			b = appendRtAttr(b, unix.RTA_MULTIPATH, mp)
+		}
+	}
+
+	binary.NativeEndian.PutUint32(b[0:4], uint32(len(b)))
+	binary.NativeEndian.PutUint16(b[4:6], typ)
+	binary.NativeEndian.PutUint16(b[6:8], unix.NLM_F_REQUEST|unix.NLM_F_ACK|flags)
+	binary.NativeEndian.PutUint32(b[8:12], n.seq.Add(1))
+	return b
+}
+
+func appendRtAttr(b []byte, typ uint16, data []byte) []byte {
+	l := unix.SizeofRtAttr + len(data)
+	attr := make([]byte, rtaAlign(l))
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(l))
+	binary.NativeEndian.PutUint16(attr[2:4], typ)
+	copy(attr[unix.SizeofRtAttr:], data)
+	return append(b, attr...)
+}
+
+func rtaAlign(l int) int {
+	return (l + unix.RTA_ALIGNTO - 1) & ^(unix.RTA_ALIGNTO - 1)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package fib
+
+import (
+	"errors"
+)
+
+type NetlinkConfig struct {
+	// Kernel routing table ID to install routes into. Defaults to main table.
+	Table uint32
+	// Route protocol number the routes are marked with. Defaults to RTPROT_OSPF.
+	Protocol uint8
+	// Route metric (priority) of installed routes.
+	Metric uint32
+}
+
+func NewNetlinkFIB(c NetlinkConfig) (FIB, error) {
+	return nil, errors.New("netlink FIB is only supported on linux")
+}
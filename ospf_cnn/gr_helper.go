@@ -0,0 +1,231 @@
+package ospf_cnn
+
+import (
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"time"
+)
+
+// GRHelperExitReason tells why the router stopped helping a restarting neighbor.
+type GRHelperExitReason uint8
+
+const (
+	GRHelperExitNone GRHelperExitReason = iota
+	// GRHelperExitCompleted The neighbor flushed its Grace-LSA, i.e. it
+	//            has completed the graceful restart.
+	GRHelperExitCompleted
+	// GRHelperExitTimedOut The grace period expired before the neighbor
+	//            completed the graceful restart.
+	GRHelperExitTimedOut
+	// GRHelperExitTopologyChanged An LSA whose content changed would have
+	//            been flooded to the restarting neighbor.
+	GRHelperExitTopologyChanged
+	// GRHelperExitAdjacencyDown The adjacency was torn down, e.g. the
+	//            interface went down.
+	GRHelperExitAdjacencyDown
+)
+
+func (r GRHelperExitReason) String() string {
+	switch r {
+	case GRHelperExitCompleted:
+		return "Completed"
+	case GRHelperExitTimedOut:
+		return "TimedOut"
+	case GRHelperExitTopologyChanged:
+		return "TopologyChanged"
+	case GRHelperExitAdjacencyDown:
+		return "AdjacencyDown"
+	}
+	return "None"
+}
+
+// GRHelperState is the graceful restart helper state of a neighbor (RFC3623 3).
+type GRHelperState struct {
+	// Whether the router is helping the neighbor through its graceful restart.
+	InHelper bool
+	// The restart reason advertised in the Grace-LSA of the neighbor.
+	RestartReason uint8
+	// When the grace period ends. Valid if InHelper.
+	GracePeriodEnd time.Time
+	// Why the router last stopped helping the neighbor.
+	LastExitReason GRHelperExitReason
+}
+
+func (n *Neighbor) grHelperState() GRHelperState {
+	n.grHelperMu.RLock()
+	defer n.grHelperMu.RUnlock()
+	return n.GRHelper
+}
+
+func (n *Neighbor) isHelping() bool {
+	n.grHelperMu.RLock()
+	defer n.grHelperMu.RUnlock()
+	return n.GRHelper.InHelper
+}
+
+// isFullyAdjacent checks whether the neighbor is announced as fully adjacent
+// in LSAs. A restarting neighbor being helped stays so during the grace period
+// whatever the state of the adjacency is.
+func (n *Neighbor) isFullyAdjacent() bool {
+	return n.currState() == NeighborFull || n.isHelping()
+}
+
+// procReceivedGraceLSA enters, updates or exits helper mode upon the Grace-LSA
+// installed on the interface (RFC3623 3.1, 3.2).
+func (i *Interface) procReceivedGraceLSA(l packet2.LSAdvertisement) {
+	if opaqueType, _ := packet2.SplitOpaqueLinkStateID(l.LinkStateID); opaqueType != packet2.GraceOpaqueType {
+		return
+	}
+	opaque, err := l.AsV2OpaqueLSA()
+	if err != nil {
+		LogWarn("interface %s err decode Grace-LSA(%+v): %v", i.c.ifi.Name, l.GetLSAIdentity(), err)
+		return
+	}
+	grace, err := opaque.Content.AsGraceLSA()
+	if err != nil {
+		LogWarn("interface %s err decode Grace-LSA(%+v): %v", i.c.ifi.Name, l.GetLSAIdentity(), err)
+		return
+	}
+	nb := i.restartingNeighbor(l.AdvRouter, grace)
+	if nb == nil {
+		LogDebug("interface %s ignored Grace-LSA(%+v) from unknown neighbor", i.c.ifi.Name, l.GetLSAIdentity())
+		return
+	}
+	if l.LSAge >= packet2.MaxAge {
+		// The restarting router flushes its Grace-LSAs once it has
+		// completed the graceful restart.
+		nb.exitHelper(GRHelperExitCompleted)
+		return
+	}
+	nb.enterHelper(l.LSAge, grace)
+}
+
+// restartingNeighbor finds the neighbor originating the Grace-LSA. On broadcast,
+// NBMA and Point-to-MultiPoint networks it is identified by the IP interface
+// address in the Grace-LSA, while on the others by the Advertising Router.
+func (i *Interface) restartingNeighbor(advRouter uint32, g packet2.GraceLSA) (ret *Neighbor) {
+	i.rangeOverNeighbors(func(nb *Neighbor) bool {
+		if (g.InterfaceAddress != 0 && ipv4BytesToUint32(nb.NeighborAddress.To4()) == g.InterfaceAddress) ||
+			(g.InterfaceAddress == 0 && nb.NeighborId == advRouter) {
+			ret = nb
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// enterHelper enters helper mode for the neighbor, or updates the grace period
+// if already helping it.
+func (n *Neighbor) enterHelper(lsAge uint16, g packet2.GraceLSA) {
+	if n.i.Area.ins.grHelperDisabled {
+		LogDebug("neighbor %v restarting gracefully but helper mode is disabled", n.NeighborId)
+		return
+	}
+	// The router must not help while restarting itself.
+	if n.i.Area.ins.isGracefullyRestarting() {
+		LogInfo("neighbor %v restarting gracefully while the router is restarting as well. Refused to help",
+			n.i.Area.ins.routerName(n.NeighborId))
+		return
+	}
+	n.grHelperMu.Lock()
+	defer n.grHelperMu.Unlock()
+	if !n.GRHelper.InHelper {
+		// The router must be fully adjacent to the restarting router.
+		if n.currState() != NeighborFull {
+			LogInfo("neighbor %v restarting gracefully but not fully adjacent. Refused to help",
+				n.i.Area.ins.routerName(n.NeighborId))
+			return
+		}
+		// There must have been no changes in content to the link-state
+		// database since the beginning of the grace period, which is
+		// determined by checking whether any LSAs of types 1-5 or 7 are
+		// on the Link state retransmission list for the restarting router.
+		if n.hasTopologyLSAInRetransmissionList() {
+			LogInfo("neighbor %v restarting gracefully but the topology has changed. Refused to help",
+				n.i.Area.ins.routerName(n.NeighborId))
+			return
+		}
+	}
+	// The grace period must not have expired yet.
+	if uint32(lsAge) >= g.GracePeriod {
+		LogInfo("neighbor %v restarting gracefully but the grace period has expired",
+			n.i.Area.ins.routerName(n.NeighborId))
+		return
+	}
+	remaining := time.Duration(g.GracePeriod-uint32(lsAge)) * time.Second
+	if !n.GRHelper.InHelper {
+		LogInfo("neighbor %v restarting gracefully(reason %d). Entered helper mode for %v",
+			n.i.Area.ins.routerName(n.NeighborId), g.Reason, remaining)
+	}
+	n.GRHelper.InHelper = true
+	n.GRHelper.RestartReason = g.Reason
+	n.GRHelper.GracePeriodEnd = time.Now().Add(remaining)
+	if n.graceTimer == nil {
+		n.graceTimer = time.AfterFunc(remaining, func() { n.exitHelper(GRHelperExitTimedOut) })
+	} else {
+		n.graceTimer.Reset(remaining)
+	}
+}
+
+func (n *Neighbor) hasTopologyLSAInRetransmissionList() bool {
+	n.lsRtxmRw.RLock()
+	defer n.lsRtxmRw.RUnlock()
+	for id := range n.LSRetransmission {
+		if isTopologyLSType(id.LSType) {
+			return true
+		}
+	}
+	return false
+}
+
+// exitHelper exits helper mode for the neighbor. The router then recalculates
+// the Designated Router and re-originates its LSAs to describe the actual
+// state of the adjacency (RFC3623 3.2).
+func (n *Neighbor) exitHelper(reason GRHelperExitReason) {
+	n.grHelperMu.Lock()
+	if !n.GRHelper.InHelper {
+		n.grHelperMu.Unlock()
+		return
+	}
+	n.GRHelper.InHelper = false
+	n.GRHelper.LastExitReason = reason
+	if n.graceTimer != nil {
+		n.graceTimer.Stop()
+	}
+	n.grHelperMu.Unlock()
+	LogInfo("neighbor %v exited helper mode: %v", n.i.Area.ins.routerName(n.NeighborId), reason)
+
+	if reason == GRHelperExitAdjacencyDown {
+		return
+	}
+	if n.inactiveInHelper.Swap(false) {
+		// No Hello has been seen from the neighbor during the grace period.
+		n.consumeEvent(NbEvInactivityTimer)
+	} else {
+		n.i.consumeEvent(IfEvNeighborChange)
+	}
+	n.i.updateSelfOriginatedLSAWhenAdjacencyChanged()
+}
+
+// exitHelperOnTopologyChange exits helper mode for the restarting neighbors
+// the changed LSA would be flooded to. The LSAs originated by the restarting
+// router itself are never flooded back to it.
+func (a *Area) exitHelperOnTopologyChange(h packet2.LSAheader) {
+	if !isTopologyLSType(h.LSType) {
+		return
+	}
+	var helping []*Neighbor
+	for _, ifi := range a.floodingScopeInterfaces(h.LSType) {
+		ifi.rangeOverNeighbors(func(nb *Neighbor) bool {
+			if nb.NeighborId != h.AdvRouter && nb.isHelping() {
+				helping = append(helping, nb)
+			}
+			return true
+		})
+	}
+	for _, nb := range helping {
+		// Re-originating LSAs upon exiting may install LSAs as well, so
+		// do it outside the installation in progress.
+		go nb.exitHelper(GRHelperExitTopologyChanged)
+	}
+}
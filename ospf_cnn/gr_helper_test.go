@@ -0,0 +1,176 @@
+package ospf_cnn
+
+import (
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func testGraceLSA(advRouter, ifAddr string, gracePeriod uint32, age uint16) packet2.LSAdvertisement {
+	return packet2.LSAdvertisement{
+		LSAheader: packet2.LSAheader{
+			LSAge:       age,
+			LSType:      packet2.LinkLocalOpaqueLSAtypeV2,
+			LinkStateID: packet2.OpaqueLinkStateID(packet2.GraceOpaqueType, 0),
+			AdvRouter:   ip(advRouter),
+			LSSeqNumber: packet2.InitialSequenceNumber,
+		},
+		Content: packet2.NewV2OpaqueLSA(packet2.GraceLSA{
+			GracePeriod:      gracePeriod,
+			Reason:           packet2.GraceReasonSoftwareRestart,
+			InterfaceAddress: ip(ifAddr),
+		}.TLVs()...),
+	}
+}
+
+// newTestGRHelper returns the interface of the DR 1.1.1.1 of 10.0.0.0/24
+// fully adjacent to neighbor 2.2.2.2 at 10.0.0.2.
+func newTestGRHelper(t *testing.T) (*Interface, *Neighbor) {
+	i := newTestInstance("1.1.1.1")
+	ifi := i.Backbone.testInterface("10.0.0.1/24")
+	ifi.RouterPriority = 1
+	nb := ifi.testFullNeighbor(t, "2.2.2.2")
+	nb.NeighborAddress = net.ParseIP("10.0.0.2").To4()
+	ifi.transState(InterfaceDR)
+	ifi.DR.Store(ip("10.0.0.1"))
+	return ifi, nb
+}
+
+// waitHelperExited polls until the router stops helping the neighbor, as it
+// may be exited asynchronously.
+func waitHelperExited(nb *Neighbor, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if !nb.isHelping() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEnterHelper(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		setup    func(nb *Neighbor)
+		lsa      packet2.LSAdvertisement
+		expected bool
+	}{
+		{
+			name:     "fully adjacent",
+			lsa:      testGraceLSA("2.2.2.2", "10.0.0.2", 60, 10),
+			expected: true,
+		},
+		{
+			name:     "not fully adjacent",
+			setup:    func(nb *Neighbor) { nb.transState(NeighborLoading) },
+			lsa:      testGraceLSA("2.2.2.2", "10.0.0.2", 60, 10),
+			expected: false,
+		},
+		{
+			name: "topology LSA pending retransmission",
+			setup: func(nb *Neighbor) {
+				nb.LSRetransmission[testLSAHeader(layers.RouterLSAtypeV2, "3.3.3.3", "3.3.3.3").GetLSAIdentity()] = struct{}{}
+			},
+			lsa:      testGraceLSA("2.2.2.2", "10.0.0.2", 60, 10),
+			expected: false,
+		},
+		{
+			name: "opaque LSA pending retransmission",
+			setup: func(nb *Neighbor) {
+				nb.LSRetransmission[testLSAHeader(packet2.AreaLocalOpaqueLSAtypeV2, "4.0.0.0", "3.3.3.3").GetLSAIdentity()] = struct{}{}
+			},
+			lsa:      testGraceLSA("2.2.2.2", "10.0.0.2", 60, 10),
+			expected: true,
+		},
+		{
+			name:     "grace period expired",
+			lsa:      testGraceLSA("2.2.2.2", "10.0.0.2", 60, 60),
+			expected: false,
+		},
+		{
+			name:     "unknown neighbor",
+			lsa:      testGraceLSA("3.3.3.3", "10.0.0.3", 60, 10),
+			expected: false,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ifi, nb := newTestGRHelper(t)
+			if tt.setup != nil {
+				tt.setup(nb)
+			}
+			ifi.procReceivedGraceLSA(tt.lsa)
+			t.Cleanup(func() { nb.exitHelper(GRHelperExitAdjacencyDown) })
+			st := nb.grHelperState()
+			if st.InHelper != tt.expected {
+				t.Fatalf("expecting helping %v but got %v", tt.expected, st.InHelper)
+			}
+			if !st.InHelper {
+				return
+			}
+			if remaining := time.Until(st.GracePeriodEnd); remaining <= 49*time.Second || remaining > 50*time.Second {
+				t.Errorf("expecting grace period ending in 50s but got %v", remaining)
+			}
+			if st.RestartReason != packet2.GraceReasonSoftwareRestart {
+				t.Errorf("expecting restart reason %d but got %d", packet2.GraceReasonSoftwareRestart, st.RestartReason)
+			}
+		})
+	}
+}
+
+func TestExitHelper(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		period   uint32
+		exit     func(ifi *Interface)
+		expected GRHelperExitReason
+	}{
+		{
+			name:   "Grace-LSA flushed",
+			period: 60,
+			exit: func(ifi *Interface) {
+				ifi.procReceivedGraceLSA(testGraceLSA("2.2.2.2", "10.0.0.2", 60, packet2.MaxAge))
+			},
+			expected: GRHelperExitCompleted,
+		},
+		{
+			name:     "grace period expired",
+			period:   1,
+			exit:     func(ifi *Interface) {},
+			expected: GRHelperExitTimedOut,
+		},
+		{
+			name:   "topology changed",
+			period: 60,
+			exit: func(ifi *Interface) {
+				ifi.Area.exitHelperOnTopologyChange(testLSAHeader(layers.RouterLSAtypeV2, "3.3.3.3", "3.3.3.3"))
+			},
+			expected: GRHelperExitTopologyChanged,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ifi, nb := newTestGRHelper(t)
+			ifi.procReceivedGraceLSA(testGraceLSA("2.2.2.2", "10.0.0.2", tt.period, 0))
+			if !nb.isHelping() {
+				t.Fatalf("expecting helping but got not")
+			}
+			tt.exit(ifi)
+			if !waitHelperExited(nb, 3*time.Second) {
+				t.Fatalf("expecting helper mode exited but got still helping")
+			}
+			if got := nb.grHelperState().LastExitReason; got != tt.expected {
+				t.Errorf("expecting exit reason %v but got %v", tt.expected, got)
+			}
+		})
+	}
+
+	// The LSAs originated by the restarting neighbor itself change nothing.
+	ifi, nb := newTestGRHelper(t)
+	ifi.procReceivedGraceLSA(testGraceLSA("2.2.2.2", "10.0.0.2", 60, 0))
+	t.Cleanup(func() { nb.exitHelper(GRHelperExitAdjacencyDown) })
+	ifi.Area.exitHelperOnTopologyChange(testLSAHeader(layers.RouterLSAtypeV2, "2.2.2.2", "2.2.2.2"))
+	if waitHelperExited(nb, 100*time.Millisecond) {
+		t.Errorf("expecting still helping but got exited with %v", nb.grHelperState().LastExitReason)
+	}
+}
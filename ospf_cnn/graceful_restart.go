@@ -0,0 +1,554 @@
+package ospf_cnn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SvenShi/ospf-neighbor/ospf_cnn/fib"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// GracefulRestartConfig enables restarting the router gracefully (RFC3623 2),
+// i.e. keeping on forwarding packets while its neighbors keep advertising it
+// as fully adjacent.
+type GracefulRestartConfig struct {
+	// The number of seconds the neighbors are asked to help the router through
+	// the restart. 0 disables graceful restart.
+	GracePeriod uint32
+	// The file the state needed to restart gracefully is saved to before
+	// shutting down, and restored from when the router is created again.
+	StateFile string
+}
+
+func (c GracefulRestartConfig) validate() error {
+	if c.GracePeriod <= 0 {
+		return nil
+	}
+	// The grace period must not exceed LSRefreshTime, so the LSAs
+	// originated before restarting are not refreshed by then (RFC3623 B.1).
+	if c.GracePeriod > packet2.LSRefreshTime {
+		return fmt.Errorf("grace period %d exceeds %d seconds", c.GracePeriod, packet2.LSRefreshTime)
+	}
+	if c.StateFile == "" {
+		return errors.New("state file of graceful restart is not specified")
+	}
+	return nil
+}
+
+// graceLSAAckTimeout is how long to wait for the neighbors to acknowledge the
+// Grace-LSAs before shutting down.
+const graceLSAAckTimeout = 3 * time.Second
+
+// gracefulRestartState is the state saved before restarting gracefully.
+type gracefulRestartState struct {
+	RouterId uint32
+	// The beginning of the grace period, when the Grace-LSAs were originated.
+	Start       time.Time
+	GracePeriod uint32
+	// The LS sequence numbers of the self-originated LSAs, which new
+	// instances of the LSAs continue from after restarting.
+	SeqNumbers []savedSeqNumber
+	// The fully adjacent neighbors, which must be re-established for the
+	// graceful restart to complete.
+	Neighbors []savedNeighbor
+	// The routes installed into the FIB. They are left in place while restarting.
+	FIBRoutes []fib.Route
+}
+
+type savedSeqNumber struct {
+	packet2.LSAIdentity
+	SeqNumber uint32
+}
+
+type savedNeighbor struct {
+	IfName   string
+	RouterId uint32
+}
+
+// gracefulRestart is the graceful restart in progress after restarting.
+type gracefulRestart struct {
+	neighbors []savedNeighbor
+	timer     *time.Timer
+	exiting   bool
+	// The LSAs of LS types 1-5 and 7 the router wishes to originate. They are
+	// collected while restarting, and only originated once the restart is over
+	// if differing from the ones originated before restarting.
+	wished map[*Area]map[packet2.LSAIdentity]packet2.LSAdvertisement
+}
+
+// isTopologyLSType checks whether LSAs of lsType describe the topology, i.e.
+// are of LS types 1-5 or 7, whose changes end graceful restart (RFC3623).
+func isTopologyLSType(lsType uint16) bool {
+	return lsType <= layers.ASExternalLSAtypeV2 || lsType == layers.NSSALSAtypeV2
+}
+
+// GracefulShutdown shuts the router down for restarting gracefully. Grace-LSAs
+// are originated to ask the neighbors for help, and the state is saved to
+// be restored by the next router. Unlike Close, the self-originated LSAs
+// are not flushed and the routes installed into the FIB are left in place.
+func (r *Router) GracefulShutdown() (err error) {
+	if r.ins.grConfig.GracePeriod <= 0 {
+		return errors.New("graceful restart is not enabled")
+	}
+	r.closeOnce.Do(func() {
+		if err = r.ins.prepareGracefulRestart(); err != nil {
+			// Still shutting down rather than leaving the router half closed.
+			LogErr("err prepare graceful restart: %v", err)
+		}
+		if r.cancel != nil {
+			r.cancel()
+		}
+		r.ins.shutdownForRestart()
+	})
+	return
+}
+
+// prepareGracefulRestart originates Grace-LSAs out of every interface having
+// fully adjacent neighbors, waits for them to be acknowledged and saves the
+// restart state (RFC3623 2.1).
+func (i *Instance) prepareGracefulRestart() error {
+	state := gracefulRestartState{
+		RouterId:    i.RouterId,
+		Start:       time.Now(),
+		GracePeriod: i.grConfig.GracePeriod,
+	}
+	var graceIfis []*Interface
+	for _, a := range i.attachedAreas() {
+		for _, ifi := range a.Interfaces {
+			nbIds := ifi.fullyAdjacentNeighbors()
+			if len(nbIds) <= 0 {
+				continue
+			}
+			for _, nbId := range nbIds {
+				state.Neighbors = append(state.Neighbors, savedNeighbor{IfName: ifi.c.ifi.Name, RouterId: nbId})
+			}
+			grace := packet2.GraceLSA{
+				GracePeriod: i.grConfig.GracePeriod,
+				Reason:      packet2.GraceReasonSoftwareRestart,
+			}
+			// The IP interface address identifies the restarting router on
+			// broadcast, NBMA and Point-to-MultiPoint networks.
+			if ifi.Type != IfTypePointToPoint && ifi.Type != IfTypeVirtualLink {
+				grace.InterfaceAddress = ipv4BytesToUint32(ifi.getAddress().IP.To4())
+			}
+			ifi.originateLinkLocalLSA(OpaqueLSA{
+				LSType:     packet2.LinkLocalOpaqueLSAtypeV2,
+				OpaqueType: packet2.GraceOpaqueType,
+				IfName:     ifi.c.ifi.Name,
+				TLVs:       grace.TLVs(),
+			}.newLSA(i.RouterId, a.Options))
+			graceIfis = append(graceIfis, ifi)
+		}
+	}
+	if !i.waitGraceLSAsAcked(graceIfis) {
+		LogWarn("Grace-LSAs not acknowledged by all neighbors within %v", graceLSAAckTimeout)
+	}
+	// The Grace-LSAs are saved as well, so they are not mistaken for
+	// stale ones when received back after restarting.
+	state.SeqNumbers = i.selfOriginatedSeqNumbers()
+	if i.fibSink != nil {
+		state.FIBRoutes = i.fibSink.Installed()
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(i.grConfig.StateFile), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(i.grConfig.StateFile, data, 0600)
+}
+
+func (i *Instance) waitGraceLSAsAcked(ifis []*Interface) bool {
+	graceId := OpaqueLSA{
+		LSType:     packet2.LinkLocalOpaqueLSAtypeV2,
+		OpaqueType: packet2.GraceOpaqueType,
+	}.identity(i.RouterId)
+	deadline := time.Now().Add(graceLSAAckTimeout)
+	for time.Now().Before(deadline) {
+		acked := true
+		for _, ifi := range ifis {
+			ifi.rangeOverNeighbors(func(nb *Neighbor) bool {
+				if nb.isInLSRetransmissionList(graceId) {
+					acked = false
+				}
+				return acked
+			})
+		}
+		if acked {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
+
+// selfOriginatedSeqNumbers collects the LS sequence numbers of all the
+// self-originated LSAs. Those of link-local LSAs with the same identity on
+// multiple interfaces are merged to the greatest.
+func (i *Instance) selfOriginatedSeqNumbers() (ret []savedSeqNumber) {
+	seqNums := make(map[packet2.LSAIdentity]uint32)
+	collect := func(h packet2.LSAheader) {
+		if h.AdvRouter != i.RouterId {
+			return
+		}
+		id := h.GetLSAIdentity()
+		if exist, ok := seqNums[id]; !ok || int32(h.LSSeqNumber) > int32(exist) {
+			seqNums[id] = h.LSSeqNumber
+		}
+	}
+	for _, a := range i.attachedAreas() {
+		a.lsDbRw.RLock()
+		for _, l := range a.RouterLSAs {
+			collect(l.h)
+		}
+		for _, l := range a.NetworkLSAs {
+			collect(l.h)
+		}
+		for _, l := range a.SummaryLSAs {
+			collect(l.h)
+		}
+		for _, l := range a.NSSALSAs {
+			collect(l.h)
+		}
+		for _, l := range a.OpaqueLSAs {
+			collect(l.h)
+		}
+		a.lsDbRw.RUnlock()
+		for _, ifi := range a.Interfaces {
+			ifi.opaqueRw.RLock()
+			for _, l := range ifi.LinkLocalOpaqueLSAs {
+				collect(l.h)
+			}
+			ifi.opaqueRw.RUnlock()
+		}
+	}
+	i.lsDbRangeExtLSA(func(_ packet2.LSAIdentity, l *LSDBASExternalItem) bool {
+		collect(l.h)
+		return true
+	})
+	i.lsDbRangeASOpaqueLSA(func(_ packet2.LSAIdentity, l *LSDBOpaqueItem) bool {
+		collect(l.h)
+		return true
+	})
+	for id, seqNum := range seqNums {
+		ret = append(ret, savedSeqNumber{LSAIdentity: id, SeqNumber: seqNum})
+	}
+	return
+}
+
+// shutdownForRestart shuts down like shutdown, except for leaving the
+// self-originated LSAs and the FIB untouched.
+func (i *Instance) shutdownForRestart() {
+	for _, a := range i.allAreas() {
+		a.shuttingDown.Store(true)
+		for _, ifi := range a.Interfaces {
+			if err := ifi.close(); err != nil {
+				LogErr("interface %v close failed", ifi.c.ifi.Name)
+			}
+		}
+		a.wg.Wait()
+	}
+	if i.fibSink != nil {
+		i.spfMu.Lock()
+		defer i.spfMu.Unlock()
+		if err := i.fibSink.Detach(); err != nil {
+			LogErr("err detach FIB: %v", err)
+		}
+	}
+}
+
+// restoreGracefulRestartState restores the state saved before restarting. The
+// router restarts gracefully if still within the grace period. The state file
+// is removed as it is only valid for a single restart.
+func (i *Instance) restoreGracefulRestartState() {
+	if i.grConfig.GracePeriod <= 0 {
+		return
+	}
+	data, err := os.ReadFile(i.grConfig.StateFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			LogErr("err read graceful restart state: %v", err)
+		}
+		return
+	}
+	if err = os.Remove(i.grConfig.StateFile); err != nil {
+		LogErr("err remove graceful restart state: %v", err)
+	}
+	var state gracefulRestartState
+	if err = json.Unmarshal(data, &state); err != nil {
+		LogErr("err parse graceful restart state: %v", err)
+		return
+	}
+	if state.RouterId != i.RouterId {
+		LogWarn("ignored graceful restart state of router %v", uint32ToIPv4(state.RouterId))
+		return
+	}
+	// The sequence numbers are useful even if the grace period has expired,
+	// so the LSAs originated before restarting are not considered newer.
+	i.restoredSeqNumbers = make(map[packet2.LSAIdentity]uint32, len(state.SeqNumbers))
+	for _, s := range state.SeqNumbers {
+		i.restoredSeqNumbers[s.LSAIdentity] = s.SeqNumber
+	}
+	if i.fibSink != nil {
+		i.fibSink.Adopt(state.FIBRoutes)
+	}
+	remaining := time.Until(state.Start.Add(time.Duration(state.GracePeriod) * time.Second))
+	if remaining <= 0 {
+		LogInfo("grace period expired %v ago. Restarting normally", -remaining)
+		return
+	}
+	i.gr = &gracefulRestart{
+		neighbors: state.Neighbors,
+		timer: time.AfterFunc(remaining, func() {
+			i.exitGracefulRestart("grace period expired")
+		}),
+		wished: make(map[*Area]map[packet2.LSAIdentity]packet2.LSAdvertisement),
+	}
+	LogInfo("restarting gracefully with %d neighbors to re-establish within %v", len(state.Neighbors), remaining)
+}
+
+// abandonGracefulRestart stops the graceful restart in progress without
+// originating anything, e.g. when the router is closed.
+func (i *Instance) abandonGracefulRestart() {
+	i.grMu.Lock()
+	defer i.grMu.Unlock()
+	if i.gr != nil {
+		i.gr.timer.Stop()
+		i.gr = nil
+	}
+}
+
+func (i *Instance) isGracefullyRestarting() bool {
+	i.grMu.Lock()
+	defer i.grMu.Unlock()
+	return i.gr != nil
+}
+
+// restoreSeqNumber makes the first instance of the LSA originated after
+// restarting continue from the sequence number of the one originated before.
+func (i *Instance) restoreSeqNumber(lsa *packet2.LSAdvertisement) {
+	i.grMu.Lock()
+	defer i.grMu.Unlock()
+	seqNum, ok := i.restoredSeqNumbers[lsa.GetLSAIdentity()]
+	if !ok {
+		return
+	}
+	delete(i.restoredSeqNumbers, lsa.GetLSAIdentity())
+	if int32(seqNum) >= int32(lsa.LSSeqNumber) && int32(seqNum) < packet2.MaxSequenceNumber {
+		lsa.LSSeqNumber = seqNum + 1
+	}
+}
+
+// deferOriginationInGR collects the LSAs of LS types 1-5 and 7 the router
+// wishes to originate while restarting gracefully, which must not be
+// originated until the restart is over (RFC3623 2.2). The other LSAs are
+// returned to be originated as usual.
+func (i *Instance) deferOriginationInGR(a *Area, lsas ...packet2.LSAdvertisement) (remaining []packet2.LSAdvertisement) {
+	i.grMu.Lock()
+	defer i.grMu.Unlock()
+	if i.gr == nil {
+		return lsas
+	}
+	for _, l := range lsas {
+		if !isTopologyLSType(l.LSType) {
+			remaining = append(remaining, l)
+			continue
+		}
+		if i.gr.wished[a] == nil {
+			i.gr.wished[a] = make(map[packet2.LSAIdentity]packet2.LSAdvertisement)
+		}
+		i.gr.wished[a][l.GetLSAIdentity()] = l
+	}
+	return
+}
+
+// deferFlushInGR is like deferOriginationInGR but for the LSAs the router no
+// longer wishes to originate. They are flushed once the restart is over.
+func (i *Instance) deferFlushInGR(a *Area, ids ...packet2.LSAIdentity) (remaining []packet2.LSAIdentity) {
+	i.grMu.Lock()
+	defer i.grMu.Unlock()
+	if i.gr == nil {
+		return ids
+	}
+	for _, id := range ids {
+		if !isTopologyLSType(id.LSType) {
+			remaining = append(remaining, id)
+			continue
+		}
+		delete(i.gr.wished[a], id)
+	}
+	return
+}
+
+// checkGracefulRestartDone exits graceful restart once all the adjacencies
+// existing before restarting have been re-established (RFC3623 2.3).
+func (i *Instance) checkGracefulRestartDone() {
+	i.grMu.Lock()
+	if i.gr == nil {
+		i.grMu.Unlock()
+		return
+	}
+	neighbors := slices.Clone(i.gr.neighbors)
+	i.grMu.Unlock()
+	for _, sn := range neighbors {
+		ifi := i.getInterfaceByName(sn.IfName)
+		if ifi == nil {
+			continue
+		}
+		if nb, ok := ifi.getNeighbor(sn.RouterId); !ok || nb.currState() != NeighborFull {
+			return
+		}
+	}
+	// Exiting re-originates LSAs and recalculates routes, which must not
+	// block the neighbor event in progress.
+	go i.exitGracefulRestart("all adjacencies re-established")
+}
+
+// checkGracefulRestartConsistency exits graceful restart when a neighbor the
+// router was fully adjacent to before restarting originates a router-LSA no
+// longer describing the adjacency, which means the neighbor has stopped
+// helping (RFC3623 2.2).
+func (a *Area) checkGracefulRestartConsistency(l packet2.LSAdvertisement) {
+	if l.LSType != layers.RouterLSAtypeV2 || l.LSAge >= packet2.MaxAge {
+		return
+	}
+	ins := a.ins
+	ins.grMu.Lock()
+	isRestartingNeighbor := ins.gr != nil && slices.ContainsFunc(ins.gr.neighbors, func(sn savedNeighbor) bool {
+		return sn.RouterId == l.AdvRouter
+	})
+	ins.grMu.Unlock()
+	if !isRestartingNeighbor {
+		return
+	}
+	rtLSA, err := l.AsV2RouterLSA()
+	if err != nil {
+		return
+	}
+	for _, rl := range rtLSA.Content.Routers {
+		switch rl.Type {
+		case 1, 4:
+			if rl.LinkID == ins.RouterId {
+				return
+			}
+		case 2:
+			// The routers attached to the transit network are listed in
+			// the network-LSA originated by its Designated Router.
+			consistent := true
+			a.lsDbRw.RLock()
+			for _, nt := range a.NetworkLSAs {
+				if nt.h.LinkStateID == rl.LinkID && nt.h.LSAge < packet2.MaxAge {
+					consistent = slices.Contains(nt.l.AttachedRouter, ins.RouterId)
+					break
+				}
+			}
+			a.lsDbRw.RUnlock()
+			if consistent {
+				return
+			}
+		}
+	}
+	go ins.exitGracefulRestart(fmt.Sprintf("router-LSA of neighbor %v no longer describes the adjacency",
+		ins.routerName(l.AdvRouter)))
+}
+
+// exitGracefulRestart exits graceful restart (RFC3623 2.3). The router
+// re-originates the LSAs differing from the ones originated before restarting,
+// flushes those no longer wished and the Grace-LSAs, and recalculates routes,
+// which are installed into the FIB from then on.
+func (i *Instance) exitGracefulRestart(reason string) {
+	i.grMu.Lock()
+	gr := i.gr
+	if gr == nil || gr.exiting || i.ctx.Err() != nil {
+		i.grMu.Unlock()
+		return
+	}
+	gr.exiting = true
+	gr.timer.Stop()
+	i.grMu.Unlock()
+	LogInfo("exiting graceful restart: %s", reason)
+
+	// Recalculate the LSAs wished per the re-established adjacencies and
+	// routes. They are still collected rather than originated.
+	for _, a := range i.attachedAreas() {
+		for _, ifi := range a.Interfaces {
+			ifi.updateSelfOriginatedLSAWhenAdjacencyChanged()
+		}
+	}
+	i.doRecalculateRoutes()
+
+	i.grMu.Lock()
+	i.gr = nil
+	i.grMu.Unlock()
+
+	for _, a := range i.allAreas() {
+		wished := gr.wished[a]
+		var stale []packet2.LSAIdentity
+		for _, id := range a.selfOriginatedTopologyLSAs() {
+			if _, ok := wished[id]; !ok {
+				stale = append(stale, id)
+			}
+		}
+		lsas := a.skipUnchangedSelfOriginatedLSAs(slices.Collect(maps.Values(wished)))
+		if nonExistLSAs := a.batchTryUpdatingExistingLSAs(lsas, nil, func(idx int, lsa *packet2.LSAdvertisement) {
+			lsa.LSOptions = lsas[idx].LSOptions
+			lsa.Content = lsas[idx].Content
+		}); len(nonExistLSAs) > 0 {
+			a.batchOriginatingNewLSAs(nonExistLSAs)
+		}
+		if len(stale) > 0 {
+			LogDebug("area %v flushing %d self-originated LSAs no longer wished after restart", a.AreaId, len(stale))
+			a.prematureLSA(stale...)
+		}
+		graceId := OpaqueLSA{
+			LSType:     packet2.LinkLocalOpaqueLSAtypeV2,
+			OpaqueType: packet2.GraceOpaqueType,
+		}.identity(i.RouterId)
+		for _, ifi := range a.Interfaces {
+			if h, _, _, ok := ifi.lsDbGetLSAByIdentity(graceId, false); ok && h.LSAge < packet2.MaxAge {
+				ifi.prematureLinkLocalLSA(graceId)
+			}
+		}
+	}
+	i.recalculateRoutes()
+}
+
+// selfOriginatedTopologyLSAs returns the live self-originated LSAs of LS types
+// 1-5 and 7 in the link state database. AS-external-LSAs are returned by the
+// backbone only, since they are originated via the backbone.
+func (a *Area) selfOriginatedTopologyLSAs() (ret []packet2.LSAIdentity) {
+	collect := func(h packet2.LSAheader) {
+		if h.AdvRouter == a.ins.RouterId && h.LSAge < packet2.MaxAge {
+			ret = append(ret, h.GetLSAIdentity())
+		}
+	}
+	a.lsDbRw.RLock()
+	for _, l := range a.RouterLSAs {
+		collect(l.h)
+	}
+	for _, l := range a.NetworkLSAs {
+		collect(l.h)
+	}
+	for _, l := range a.SummaryLSAs {
+		collect(l.h)
+	}
+	for _, l := range a.NSSALSAs {
+		collect(l.h)
+	}
+	a.lsDbRw.RUnlock()
+	if a == a.ins.Backbone {
+		a.ins.lsDbRangeExtLSA(func(_ packet2.LSAIdentity, l *LSDBASExternalItem) bool {
+			collect(l.h)
+			return true
+		})
+	}
+	return
+}
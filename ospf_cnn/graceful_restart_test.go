@@ -0,0 +1,219 @@
+package ospf_cnn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// newTestRestartingInstance returns a started-like instance restarting
+// gracefully, with the given neighbors to re-establish within gracePeriod.
+func newTestRestartingInstance(t *testing.T, gracePeriod time.Duration, neighbors ...savedNeighbor) *Instance {
+	t.Helper()
+	i := newTestInstance("1.1.1.1")
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	i.ctx = ctx
+	i.lsDbAgingTicker = TimeTickerFunc(ctx, time.Hour, func() {}, true)
+	i.testGracefulRestart(gracePeriod, neighbors...)
+	t.Cleanup(i.abandonGracefulRestart)
+	return i
+}
+
+func (i *Instance) testGracefulRestart(gracePeriod time.Duration, neighbors ...savedNeighbor) {
+	i.grMu.Lock()
+	defer i.grMu.Unlock()
+	i.gr = &gracefulRestart{
+		neighbors: neighbors,
+		timer: time.AfterFunc(gracePeriod, func() {
+			i.exitGracefulRestart("grace period expired")
+		}),
+		wished: make(map[*Area]map[packet2.LSAIdentity]packet2.LSAdvertisement),
+	}
+}
+
+func testSelfSummaryLSA(id, advRouter string, metric uint32) packet2.LSAdvertisement {
+	return packet2.LSAdvertisement{
+		LSAheader: testLSAHeader(layers.SummaryLSANetworktypeV2, id, advRouter),
+		Content:   packet2.V2SummaryLSAImpl{NetworkMask: ip("255.255.255.0"), Metric: metric},
+	}
+}
+
+// waitGracefulRestartExited polls until the graceful restart is over, as it
+// is exited asynchronously.
+func waitGracefulRestartExited(i *Instance) bool {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if !i.isGracefullyRestarting() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRestoreGracefulRestartState(t *testing.T) {
+	id := packet2.LSAIdentity{LSType: layers.RouterLSAtypeV2, LinkStateId: ip("1.1.1.1"), AdvRouter: ip("1.1.1.1")}
+	for _, tt := range []struct {
+		name        string
+		state       *gracefulRestartState
+		restarting  bool
+		seqRestored bool
+	}{
+		{
+			name: "within grace period",
+			state: &gracefulRestartState{
+				RouterId: ip("1.1.1.1"), Start: time.Now(), GracePeriod: 60,
+				SeqNumbers: []savedSeqNumber{{LSAIdentity: id, SeqNumber: 0x80000010}},
+				Neighbors:  []savedNeighbor{{IfName: "eth0", RouterId: ip("2.2.2.2")}},
+			},
+			restarting:  true,
+			seqRestored: true,
+		},
+		{
+			name: "grace period expired",
+			state: &gracefulRestartState{
+				RouterId: ip("1.1.1.1"), Start: time.Now().Add(-time.Minute), GracePeriod: 30,
+				SeqNumbers: []savedSeqNumber{{LSAIdentity: id, SeqNumber: 0x80000010}},
+			},
+			restarting:  false,
+			seqRestored: true,
+		},
+		{
+			name: "state of other router",
+			state: &gracefulRestartState{
+				RouterId: ip("9.9.9.9"), Start: time.Now(), GracePeriod: 60,
+				SeqNumbers: []savedSeqNumber{{LSAIdentity: id, SeqNumber: 0x80000010}},
+			},
+			restarting:  false,
+			seqRestored: false,
+		},
+		{
+			name:        "no state file",
+			restarting:  false,
+			seqRestored: false,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newTestInstance("1.1.1.1")
+			i.grConfig = GracefulRestartConfig{GracePeriod: 60, StateFile: filepath.Join(t.TempDir(), "gr.json")}
+			if tt.state != nil {
+				data, err := json.Marshal(tt.state)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = os.WriteFile(i.grConfig.StateFile, data, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			i.restoreGracefulRestartState()
+			defer i.abandonGracefulRestart()
+			if got := i.isGracefullyRestarting(); got != tt.restarting {
+				t.Errorf("expecting restarting %v but got %v", tt.restarting, got)
+			}
+			if _, got := i.restoredSeqNumbers[id]; got != tt.seqRestored {
+				t.Errorf("expecting sequence number restored %v but got %v", tt.seqRestored, got)
+			}
+			if _, err := os.Stat(i.grConfig.StateFile); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expecting state file removed but got %v", err)
+			}
+		})
+	}
+}
+
+func TestDeferOriginationInGR(t *testing.T) {
+	summary := testSelfSummaryLSA("10.0.0.0", "1.1.1.1", 10)
+	opaque := packet2.LSAdvertisement{LSAheader: testLSAHeader(packet2.AreaLocalOpaqueLSAtypeV2, "4.0.0.0", "1.1.1.1")}
+
+	i := newTestInstance("1.1.1.1")
+	if remaining := i.deferOriginationInGR(i.Backbone, summary, opaque); len(remaining) != 2 {
+		t.Errorf("expecting 2 LSAs originated when not restarting but got %d", len(remaining))
+	}
+
+	i = newTestRestartingInstance(t, time.Hour)
+	remaining := i.deferOriginationInGR(i.Backbone, summary, opaque)
+	if len(remaining) != 1 || remaining[0].LSType != packet2.AreaLocalOpaqueLSAtypeV2 {
+		t.Errorf("expecting only the opaque LSA originated but got %+v", remaining)
+	}
+	if _, ok := i.gr.wished[i.Backbone][summary.GetLSAIdentity()]; !ok {
+		t.Errorf("expecting summary-LSA wished but got %+v", i.gr.wished[i.Backbone])
+	}
+
+	ids := i.deferFlushInGR(i.Backbone, summary.GetLSAIdentity(), opaque.GetLSAIdentity())
+	if len(ids) != 1 || ids[0] != opaque.GetLSAIdentity() {
+		t.Errorf("expecting only the opaque LSA flushed but got %+v", ids)
+	}
+	if _, ok := i.gr.wished[i.Backbone][summary.GetLSAIdentity()]; ok {
+		t.Errorf("expecting summary-LSA no longer wished but got %+v", i.gr.wished[i.Backbone])
+	}
+}
+
+func TestExitGracefulRestart(t *testing.T) {
+	t.Run("grace period expired", func(t *testing.T) {
+		i := newTestRestartingInstance(t, 50*time.Millisecond)
+		if !waitGracefulRestartExited(i) {
+			t.Errorf("expecting graceful restart exited but got still restarting")
+		}
+	})
+
+	t.Run("neighbor router-LSA consistent", func(t *testing.T) {
+		i := newTestRestartingInstance(t, time.Hour, savedNeighbor{IfName: "eth0", RouterId: ip("2.2.2.2")})
+		i.Backbone.checkGracefulRestartConsistency(packet2.LSAdvertisement{
+			LSAheader: testLSAHeader(layers.RouterLSAtypeV2, "2.2.2.2", "2.2.2.2"),
+			Content: packet2.V2RouterLSA{Routers: []packet2.RouterV2{
+				testLink(1, "1.1.1.1", "10.0.0.2", 10),
+			}},
+		})
+		time.Sleep(50 * time.Millisecond)
+		if !i.isGracefullyRestarting() {
+			t.Errorf("expecting still restarting but got exited")
+		}
+	})
+
+	t.Run("neighbor router-LSA inconsistent", func(t *testing.T) {
+		i := newTestRestartingInstance(t, time.Hour, savedNeighbor{IfName: "eth0", RouterId: ip("2.2.2.2")})
+		i.Backbone.checkGracefulRestartConsistency(packet2.LSAdvertisement{
+			LSAheader: testLSAHeader(layers.RouterLSAtypeV2, "2.2.2.2", "2.2.2.2"),
+			Content: packet2.V2RouterLSA{Routers: []packet2.RouterV2{
+				testLink(3, "10.0.0.0", "255.255.255.0", 10),
+			}},
+		})
+		if !waitGracefulRestartExited(i) {
+			t.Errorf("expecting graceful restart exited but got still restarting")
+		}
+	})
+
+	t.Run("stale LSAs flushed", func(t *testing.T) {
+		i := newTestRestartingInstance(t, time.Hour)
+		a := i.Backbone
+		kept := testSelfSummaryLSA("10.0.0.0", "1.1.1.1", 10)
+		stale := testSelfSummaryLSA("10.1.0.0", "1.1.1.1", 10)
+		// The LSAs originated before restarting.
+		i.abandonGracefulRestart()
+		a.syncSummaryLSAs([]packet2.LSAdvertisement{kept, stale})
+		i.testGracefulRestart(time.Hour)
+
+		// Only the unchanged summary-LSA is wished while restarting, and
+		// neither is flushed until the restart is over.
+		a.syncSummaryLSAs([]packet2.LSAdvertisement{kept})
+		for _, l := range []packet2.LSAdvertisement{kept, stale} {
+			if h, _, _, ok := a.lsDbGetLSAByIdentity(l.GetLSAIdentity(), false); !ok || h.LSAge >= packet2.MaxAge {
+				t.Errorf("expecting LSA(%+v) live while restarting but got %v", l.GetLSAIdentity(), ok)
+			}
+		}
+
+		i.exitGracefulRestart("test")
+		h, _, _, ok := a.lsDbGetLSAByIdentity(kept.GetLSAIdentity(), false)
+		if !ok || h.LSAge >= packet2.MaxAge || h.LSSeqNumber != packet2.InitialSequenceNumber {
+			t.Errorf("expecting LSA(%+v) unchanged but got %+v", kept.GetLSAIdentity(), h)
+		}
+		if h, _, _, ok = a.lsDbGetLSAByIdentity(stale.GetLSAIdentity(), false); !ok || h.LSAge < packet2.MaxAge {
+			t.Errorf("expecting LSA(%+v) flushed but got %+v", stale.GetLSAIdentity(), h)
+		}
+	})
+}
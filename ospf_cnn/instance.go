@@ -1,10 +1,15 @@
 package ospf_cnn
 
 import (
+	"cmp"
 	"context"
+	"github.com/SvenShi/ospf-neighbor/ospf_cnn/fib"
 	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"math/bits"
 	"net"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gopacket/gopacket/layers"
@@ -17,6 +22,46 @@ type InstanceConfig struct {
 	Network            *net.IPNet
 	IfName             string
 	ASBR               bool
+	// Router Priority advertised on the interface. A router whose
+	// priority is 0 is ineligible to become (Backup) Designated Router.
+	RouterPriority uint8
+	// Controls the preference rules used in Section 16.4 when choosing
+	// among multiple AS-external-LSAs advertising the same destination.
+	RFC1583Compatibility bool
+	// Optional FIB the calculated routes are installed into.
+	FIB fib.FIB
+	// Authentication of packets on the interface.
+	Auth InterfaceAuth
+	// The OSPF interface type. Defaults to broadcast.
+	IfType InterfaceType
+	// The neighbors configured when the interface type is NBMA, or
+	// Point-to-MultiPoint without multicast capability.
+	NBMANeighbors []NBMANeighbor
+	// Accept Database Description packets whose Interface MTU exceeds the
+	// MTU of the interfaces.
+	MTUIgnore bool
+	// The area the interface IfName attaches to. Defaults to the backbone.
+	AreaId uint32
+	// Additional interfaces attaching to arbitrary areas, identified by
+	// their AreaId. The router becomes an area border router when it
+	// attaches to the backbone and at least one other area.
+	Interfaces []*InterfaceConfig
+	// Per-area parameters such as the address ranges. Instance is ignored.
+	Areas []*AreaConfig
+	// Virtual links to other area border routers through non-backbone areas.
+	VirtualLinks []*VirtualLinkConfig
+	// The symbolic name of the router advertised in Router Information LSAs
+	// (RFC5642). Not advertised if empty.
+	Hostname string
+	// Refuse to help neighbors restarting gracefully (RFC3623).
+	DisableGRHelper bool
+	// Restarting the router itself gracefully. Disabled by default.
+	GracefulRestart GracefulRestartConfig
+	// Advertising the router as stub router (RFC6987).
+	StubRouter StubRouterConfig
+	// Re-originates the LSAs of the announced external routes. Set by the
+	// Router owning the instance.
+	reannounceExternalRoutes func()
 }
 
 func NewInstance(ctx context.Context, c *InstanceConfig) *Instance {
@@ -25,25 +70,121 @@ func NewInstance(ctx context.Context, c *InstanceConfig) *Instance {
 		RouterId:       c.RouterId,
 		ASBR:           c.ASBR,
 		ASExternalLSAs: make(map[packet2.LSAIdentity]*LSDBASExternalItem),
+		ASOpaqueLSAs:   make(map[packet2.LSAIdentity]*LSDBOpaqueItem),
+		RoutingTable:   &RoutingTable{},
+
+		rfc1583Compatibility: c.RFC1583Compatibility,
+		hostname:             c.Hostname,
+		grHelperDisabled:     c.DisableGRHelper,
+		grConfig:             c.GracefulRestart,
+
+		reannounceExternalRoutes: c.reannounceExternalRoutes,
 	}
-	ins.Backbone = NewArea(ctx, &AreaConfig{
-		Instance: ins,
-		AreaId:   0,
-		Address: &AreaAddress{
-			Address: c.Network,
-		},
-		Options: packet2.BitOption(0).SetBit(packet2.CapabilityEbit),
-	})
-	ins.Backbone.AddInterface(&InterfaceConfig{
+	ins.stub.MaxMetricExternal = c.StubRouter.MaxMetricExternal
+	if c.FIB != nil {
+		ins.fibSink = fib.NewSink(c.FIB)
+	}
+	ins.Backbone = ins.addArea(c, 0)
+	ifcs := append([]*InterfaceConfig{{
 		IfName:             c.IfName,
+		AreaId:             c.AreaId,
 		Address:            c.Network,
-		RouterPriority:     0,
+		RouterPriority:     c.RouterPriority,
 		HelloInterval:      c.HelloInterval,
 		RouterDeadInterval: c.RouterDeadInterval,
-	})
+		Auth:               c.Auth,
+		Type:               c.IfType,
+		NBMANeighbors:      c.NBMANeighbors,
+		MTUIgnore:          c.MTUIgnore,
+	}}, c.Interfaces...)
+	var ifis []*Interface
+	for _, ifc := range ifcs {
+		if ifc.HelloInterval == 0 {
+			ifc.HelloInterval = c.HelloInterval
+		}
+		if ifc.RouterDeadInterval == 0 {
+			ifc.RouterDeadInterval = c.RouterDeadInterval
+		}
+		a := ins.getArea(ifc.AreaId)
+		if a == nil {
+			a = ins.addArea(c, ifc.AreaId)
+			ins.Areas = append(ins.Areas, a)
+		}
+		ifis = append(ifis, a.attachInterface(ifc))
+	}
+	// Virtual links belong to the backbone. They are brought up once the
+	// virtual neighbors are found reachable through the Transit areas.
+	for _, vc := range c.VirtualLinks {
+		if vc.HelloInterval == 0 {
+			vc.HelloInterval = c.HelloInterval
+		}
+		if vc.RouterDeadInterval == 0 {
+			vc.RouterDeadInterval = c.RouterDeadInterval
+		}
+		ins.Backbone.attachVirtualLink(vc, ins.getArea(vc.TransitAreaId))
+	}
+	// Restored before any LSA is originated.
+	ins.restoreGracefulRestartState()
+	// A router restarting gracefully stays on the forwarding path, and its
+	// router-LSAs must not change while restarting.
+	if c.StubRouter.OnStartup > 0 && !ins.isGracefullyRestarting() {
+		ins.stub.OnStartup = true
+		ins.stub.onStartupPeriod = time.Duration(c.StubRouter.OnStartup) * time.Second
+	}
+	// The interfaces are brought up after all of them have been attached,
+	// so that the first router-LSAs originated already tell whether the
+	// router is an area border router.
+	for _, ifi := range ifis {
+		ifi.consumeEvent(IfEvInterfaceUp)
+	}
 	return ins
 }
 
+func (i *Instance) addArea(c *InstanceConfig, areaId uint32) *Area {
+	ac := &AreaConfig{
+		AreaId:  areaId,
+		Options: packet2.BitOption(0).SetBit(packet2.CapabilityEbit),
+	}
+	for _, cfg := range c.Areas {
+		if cfg.AreaId == areaId {
+			cp := *cfg
+			ac = &cp
+		}
+	}
+	ac.Instance = i
+	if !ac.Stub {
+		ac.Options = ac.Options.SetBit(packet2.CapabilityEbit)
+	}
+	return NewArea(i.ctx, ac)
+}
+
+func (i *Instance) getArea(areaId uint32) *Area {
+	for _, a := range i.allAreas() {
+		if a.AreaId == areaId {
+			return a
+		}
+	}
+	return nil
+}
+
+// attachedAreas returns the areas having at least one interface attached.
+func (i *Instance) attachedAreas() (ret []*Area) {
+	for _, a := range i.allAreas() {
+		if len(a.Interfaces) > 0 {
+			ret = append(ret, a)
+		}
+	}
+	return
+}
+
+// isABR checks whether the router is an area border router. A router
+// attaching to multiple areas is only able to pass inter-area routing
+// information when one of them is the backbone, possibly through virtual links.
+func (i *Instance) isABR() bool {
+	areas := i.attachedAreas()
+	return len(areas) > 1 && len(i.Backbone.Interfaces) > 0
+}
+
 type Instance struct {
 	ctx context.Context
 
@@ -56,7 +197,7 @@ type Instance struct {
 	//        own data structure.  This data structure describes the working
 	//        of the basic OSPF algorithm.  Remember that each area runs a
 	//        separate copy of the basic OSPF algorithm.
-	// Not used yet
+	// The backbone is not included.
 	Areas []*Area
 
 	// These are routes to destinations external to the Autonomous
@@ -76,13 +217,64 @@ type Instance struct {
 	//        itself an AS boundary router, some of these AS-external-LSAs
 	//        have been self-originated.
 	ASExternalLSAs map[packet2.LSAIdentity]*LSDBASExternalItem
-	extRw          sync.RWMutex
+	// AS-scoped opaque LSAs (LS type 11) share the flooding scope of
+	// AS-external-LSAs (see RFC5250).
+	ASOpaqueLSAs map[packet2.LSAIdentity]*LSDBOpaqueItem
+	extRw        sync.RWMutex
+	// The self-originated AS-external-LSAs translated from Type-7 LSAs
+	// when the router is the translator of NSSAs.
+	nssaTranslated map[packet2.LSAIdentity]struct{}
+	// The Link State IDs of the AS-external-LSAs of the announced external
+	// routes, keyed by externalRouteKey. The announced routes take
+	// precedence over the translated ones of the same destinations.
+	announcedExtIds  map[string]uint32
+	nssaTranslatedMu sync.Mutex
 	// Derived from the link-state database.  Each entry in the routing
 	//        table is indexed by a destination, and contains the
 	//        destination's cost and a set of paths to use in forwarding
 	//        packets to the destination. A path is described by its type and
 	//        next hop.  For more information, see Section 11.
 	RoutingTable *RoutingTable
+	rtMu         sync.RWMutex
+	spfMu        sync.Mutex
+	spfScheduled atomic.Bool
+	fibSink      *fib.Sink
+
+	rfc1583Compatibility bool
+	// The dynamic hostname of the router.
+	hostname string
+	// The dynamic hostnames learned from the Router Information LSAs,
+	// keyed by Router ID, and per LSA carrying them, since the LSAs of
+	// different flooding scopes may come and go independently.
+	hostnamesMu       sync.RWMutex
+	hostnamesByRouter map[uint32]string
+	hostnamesByLSA    map[riLSAKey]string
+	// Whether helper mode for graceful restart is disabled.
+	grHelperDisabled bool
+	grConfig         GracefulRestartConfig
+	// The graceful restart in progress, and the LS sequence numbers
+	// restored from the state saved before restarting.
+	gr                 *gracefulRestart
+	restoredSeqNumbers map[packet2.LSAIdentity]uint32
+	grMu               sync.Mutex
+	// Whether and why the router is advertised as stub router.
+	stub   stubRouter
+	stubMu sync.Mutex
+	// Re-originates the LSAs of the announced external routes.
+	reannounceExternalRoutes func()
+}
+
+// getRoutingTable returns the latest calculated routing table.
+func (i *Instance) getRoutingTable() *RoutingTable {
+	i.rtMu.RLock()
+	defer i.rtMu.RUnlock()
+	return i.RoutingTable
+}
+
+// allAreas returns the configured areas with the backbone last. The returned
+// slice is newly allocated, so it may be modified by the caller.
+func (i *Instance) allAreas() []*Area {
+	return slices.Concat(i.Areas, []*Area{i.Backbone})
 }
 
 type LSDBASExternalItem struct {
@@ -141,6 +333,11 @@ func (i *Instance) lsDbFlushExtLSA(a *Area) {
 			selfOriginated = append(selfOriginated, extLSA.h.GetLSAIdentity())
 		}
 	}
+	for _, opqLSA := range i.ASOpaqueLSAs {
+		if a.isSelfOriginatedLSA(opqLSA.h) {
+			selfOriginated = append(selfOriginated, opqLSA.h.GetLSAIdentity())
+		}
+	}
 }
 
 func (i *Instance) agingExternalLSA() (maxAged []agedOutLSA) {
@@ -155,6 +352,15 @@ func (i *Instance) agingExternalLSA() (maxAged []agedOutLSA) {
 			})
 		}
 	}
+	for id, l := range i.ASOpaqueLSAs {
+		isSelfOriginated := l.h.AdvRouter == i.RouterId
+		age := l.doAging()
+		if age >= packet2.MaxAge || (isSelfOriginated && age >= packet2.LSRefreshTime) {
+			maxAged = append(maxAged, agedOutLSA{
+				i.Backbone, id, isSelfOriginated, l.isDoNotRefresh(),
+			})
+		}
+	}
 	return
 }
 
@@ -163,7 +369,12 @@ func (i *Instance) start() {
 	i.lsDbAgingTicker = TimeTickerFunc(i.ctx, 3*time.Second, func() {
 		lastTotalMaxAged = i.agingLSDB(lastTotalMaxAged)
 	})
-	i.Backbone.start()
+	for _, a := range i.allAreas() {
+		a.start()
+	}
+	i.originateRouterInfoLSA()
+	// Nothing to wait for if no neighbor was fully adjacent.
+	i.checkGracefulRestartDone()
 }
 
 // this is needed when some LSA need to premature.
@@ -177,18 +388,25 @@ func (i *Instance) continueAgingLSDB() {
 }
 
 func (i *Instance) agingLSDB(lastTotalMaxAged int) int {
-	var totalMaxAged []agedOutLSA
+	var (
+		totalMaxAged     []agedOutLSA
+		linkLocalMaxAged int
+	)
 	totalMaxAged = append(totalMaxAged, i.agingExternalLSA()...)
-	for _, a := range append(i.Areas, i.Backbone) {
+	for _, a := range i.allAreas() {
 		totalMaxAged = append(totalMaxAged, a.agingIntraLSA()...)
+		// Link-local opaque LSAs are flushed or refreshed by their interfaces.
+		for _, ifi := range a.Interfaces {
+			linkLocalMaxAged += ifi.agingLinkLocalLSA()
+		}
 	}
 	if len(totalMaxAged) > 0 {
 		i.flushOrRefreshAgedOutLSAs(totalMaxAged)
 	}
-	if lastTotalMaxAged != len(totalMaxAged) {
-		LogDebug("aging LSDB done. %v max aged LSA found", len(totalMaxAged))
+	if total := len(totalMaxAged) + linkLocalMaxAged; lastTotalMaxAged != total {
+		LogDebug("aging LSDB done. %v max aged LSA found", total)
 	}
-	return len(totalMaxAged)
+	return len(totalMaxAged) + linkLocalMaxAged
 }
 
 func (i *Instance) flushOrRefreshAgedOutLSAs(all []agedOutLSA) {
@@ -204,10 +422,15 @@ func (i *Instance) flushOrRefreshAgedOutLSAs(all []agedOutLSA) {
 }
 
 func (i *Instance) shutdown() {
-	for _, a := range append(i.Areas, i.Backbone) {
-		i.lsDbFlushExtLSA(a)
+	i.abandonGracefulRestart()
+	i.stopStubRouterOnStartup()
+	// AS-external-LSAs are flooded into all areas. They only need to be
+	// flushed once.
+	i.lsDbFlushExtLSA(i.Backbone)
+	for _, a := range i.allAreas() {
 		a.shutdown()
 	}
+	i.closeFIB()
 }
 
 func (i *Instance) floodLSA(fromArea *Area, fromIfi *Interface, fromRtId uint32, lsas ...packet2.LSAheader) {
@@ -218,12 +441,12 @@ func (i *Instance) floodLSA(fromArea *Area, fromIfi *Interface, fromRtId uint32,
 	//        following, are called the eligible interfaces:
 	for _, l := range lsas {
 		switch l.LSType {
-		case layers.ASExternalLSAtypeV2:
+		case layers.ASExternalLSAtypeV2, packet2.ASOpaqueLSAtypeV2:
 			// AS-external-LSAs are flooded throughout the entire AS, with
 			//            the exception of stub areas (see Section 3.6).  The eligible
 			//            interfaces are all the router's interfaces, excluding
 			//            virtual links and those interfaces attaching to stub areas.
-			for _, a := range append(i.Areas, i.Backbone) {
+			for _, a := range i.allAreas() {
 				if !a.ExternalRoutingCapability {
 					continue
 				}
@@ -236,6 +459,13 @@ func (i *Instance) floodLSA(fromArea *Area, fromIfi *Interface, fromRtId uint32,
 					eligibleIfaceLSAs[ifi] = LSAs
 				}
 			}
+		case packet2.LinkLocalOpaqueLSAtypeV2:
+			// Link-local opaque LSAs are not flooded beyond the local
+			//            (sub)network (RFC5250 3). The only eligible interface
+			//            is the one the LSA is received on or originated for.
+			if fromIfi != nil {
+				eligibleIfaceLSAs[fromIfi] = append(eligibleIfaceLSAs[fromIfi], l)
+			}
 		default:
 			// All other types are specific to a single area (Area A).  The
 			//            eligible interfaces are all those interfaces attaching to
@@ -278,6 +508,10 @@ func (i *Instance) floodLSA(fromArea *Area, fromIfi *Interface, fromRtId uint32,
 				if nbSt < NeighborExchange {
 					return true
 				}
+				// Opaque LSAs are only flooded to opaque-capable neighbors (RFC5250 3.1).
+				if packet2.IsOpaqueLSType(l.LSType) && !nb.NeighborOptions.IsBitSet(packet2.CapabilityObit) {
+					return true
+				}
 				// Else, if the adjacency is not yet full (neighbor state
 				//                is Exchange or Loading), examine the Link state request
 				//                list associated with this adjacency.  If there is an
@@ -339,7 +573,7 @@ func (i *Instance) floodLSA(fromArea *Area, fromIfi *Interface, fromRtId uint32,
 			//            Designated Router, chances are that all the neighbors have
 			//            received the LSA already.  Therefore, examine the next
 			//            interface.
-			if fromIfi == ifi && fromRtId == ifi.DR.Load() || fromRtId == ifi.BDR.Load() {
+			if fromIfi == ifi && ifi.isNeighborDROrBDR(fromRtId) {
 				continue
 			}
 
@@ -350,7 +584,8 @@ func (i *Instance) floodLSA(fromArea *Area, fromIfi *Interface, fromRtId uint32,
 			//            However, if the Designated Router fails the router (i.e.,
 			//            the Backup Designated Router) will end up retransmitting the
 			//            updates.
-			if fromIfi == ifi && ifiSt == InterfaceBackup {
+			// Self-originated LSAs are not received on any interface.
+			if fromIfi == ifi && fromRtId != i.RouterId && ifiSt == InterfaceBackup {
 				continue
 			}
 
@@ -377,36 +612,101 @@ func (i *Instance) floodLSA(fromArea *Area, fromIfi *Interface, fromRtId uint32,
 				dst = allSPFRouters
 			}
 			ifi.sendLSUFlood(dst, pendingFloodLSAs...)
+		case IfTypePointToPoint:
+			// On physical point-to-point networks, the Link State Update
+			//            packets are always sent to AllSPFRouters.
+			ifi.sendLSUFlood(allSPFRouters, pendingFloodLSAs...)
 		default:
 			// On non-broadcast networks, separate Link State Update
 			//            packets must be sent, as unicasts, to each adjacent neighbor
 			//            (i.e., those in state Exchange or greater).  The destination
 			//            IP addresses for these packets are the neighbors' IP
 			//            addresses.
-			ifi.immediateTickNeighborsRetransmissionList()
+			ifi.sendLSUFloodToNeighbors(pendingFloodLSAs...)
+		}
+	}
+}
+
+// linkStateIdAssigner assigns the Link State IDs of LSAs describing networks
+// per RFC2328 Appendix E. The networks must be assigned in the order of
+// compareNetworksByMaskLength, so that the Link State ID of a network is its
+// address, unless a network of the same address but a shorter mask has taken
+// it, in which case it is the address with all the host bits set.
+type linkStateIdAssigner map[uint32]struct{}
+
+// compareNetworksByMaskLength orders networks by increasing mask length and
+// then by address.
+func compareNetworksByMaskLength(xAddr, xMask, yAddr, yMask uint32) int {
+	return cmp.Or(cmp.Compare(bits.OnesCount32(xMask), bits.OnesCount32(yMask)), cmp.Compare(xAddr, yAddr))
+}
+
+// assign returns the Link State ID of the network addr/mask. It fails if both
+// the candidates have been taken, e.g. by a host route.
+func (u linkStateIdAssigner) assign(addr, mask uint32) (uint32, bool) {
+	for _, id := range []uint32{addr & mask, addr | ^mask} {
+		if _, ok := u[id]; !ok {
+			u[id] = struct{}{}
+			return id, true
 		}
 	}
+	return 0, false
 }
 
-func (i *Instance) addASBRLSA(ips ...net.IPNet) {
-	lsas := make([]packet2.LSAdvertisement, 0, len(ips))
-	for _, ip := range ips {
+// externalLinkStateIds assigns the Link State IDs of the LSAs describing the
+// external routes per RFC2328 Appendix E, keyed by externalRouteKey. Both
+// 10.0.0.0/16 and 10.0.0.0/24, for example, are announced, the latter with
+// Link State ID 10.0.0.255. Routes whose Link State IDs conflict are left out.
+func externalLinkStateIds(routes []ExternalRoute) map[string]uint32 {
+	prefixes := make([]net.IPNet, 0, len(routes))
+	for _, r := range routes {
+		prefixes = append(prefixes, r.Prefix)
+	}
+	slices.SortFunc(prefixes, func(x, y net.IPNet) int {
+		return compareNetworksByMaskLength(ipv4BytesToUint32(x.IP.To4()), ipv4MaskToUint32(x.Mask),
+			ipv4BytesToUint32(y.IP.To4()), ipv4MaskToUint32(y.Mask))
+	})
+	ret := make(map[string]uint32, len(prefixes))
+	used := make(linkStateIdAssigner, len(prefixes))
+	for _, p := range prefixes {
+		id, ok := used.assign(ipv4BytesToUint32(p.IP.To4()), ipv4MaskToUint32(p.Mask))
+		if !ok {
+			LogWarn("skipped external route %v: Link State ID conflicted", externalRouteKey(p))
+			continue
+		}
+		ret[externalRouteKey(p)] = id
+	}
+	return ret
+}
+
+// addASBRLSA originates the LSAs of the external routes, whose Link State IDs
+// have been assigned by externalLinkStateIds.
+func (i *Instance) addASBRLSA(ids map[string]uint32, routes ...ExternalRoute) {
+	// The routes are imported into the attached NSSAs via Type-7 LSAs.
+	for _, a := range i.nssaAreas() {
+		a.addNSSALSAs(ids, routes...)
+	}
+	if !i.originatesASExternalLSAs() {
+		return
+	}
+	lsas := make([]packet2.LSAdvertisement, 0, len(routes))
+	for _, r := range routes {
+		id, ok := ids[externalRouteKey(r.Prefix)]
+		if !ok {
+			continue
+		}
 		l := packet2.LSAdvertisement{
 			LSAheader: packet2.LSAheader{
 				LSType:      layers.ASExternalLSAtypeV2,
-				LinkStateID: ipv4BytesToUint32(ip.IP.To4()),
+				LinkStateID: id,
 				AdvRouter:   i.RouterId,
 				LSSeqNumber: packet2.InitialSequenceNumber,
 				LSOptions:   uint8(packet2.BitOption(0).SetBit(packet2.CapabilityEbit)),
 			},
-			Content: packet2.V2ASExternalLSA{
-				NetworkMask: ipv4MaskToUint32(ip.Mask),
-				ExternalBit: uint8(packet2.BitOption(0).SetBit(packet2.ASExternalLSAFlagEbit)),
-				Metric:      10000,
-			},
+			Content: i.externalLSAContent(r),
 		}
 		lsas = append(lsas, l)
 	}
+	lsas = i.Backbone.skipUnchangedSelfOriginatedLSAs(lsas)
 	if nonExistLSAs := i.Backbone.batchTryUpdatingExistingLSAs(lsas, nil, func(idx int, lsa *packet2.LSAdvertisement) {
 		lsa.Content = lsas[idx].Content
 	}); len(nonExistLSAs) > 0 {
@@ -414,20 +714,66 @@ func (i *Instance) addASBRLSA(ips ...net.IPNet) {
 	}
 }
 
-func (i *Instance) delASBRLSA(ips ...net.IPNet) {
-	var lsas []packet2.LSAIdentity
-	for _, ip := range ips {
-		lsas = append(lsas, packet2.LSAIdentity{
-			LSType:      layers.ASExternalLSAtypeV2,
-			LinkStateId: ipv4BytesToUint32(ip.IP.To4()),
-			AdvRouter:   i.RouterId,
-		})
+// announcedLSAIdentity returns the identity of the LSA of Link State ID id
+// describing an announced external route. It is a Type-7 LSA if the router
+// only attaches to NSSAs besides stub areas.
+func (i *Instance) announcedLSAIdentity(id uint32) packet2.LSAIdentity {
+	ret := packet2.LSAIdentity{
+		LSType:      layers.ASExternalLSAtypeV2,
+		LinkStateId: id,
+		AdvRouter:   i.RouterId,
 	}
-	if len(lsas) > 0 {
-		i.Backbone.prematureLSA(lsas...)
+	if len(i.nssaAreas()) > 0 && !i.originatesASExternalLSAs() {
+		ret.LSType = layers.NSSALSAtypeV2
 	}
+	return ret
 }
 
-func (i *Instance) recalculateRoutes() {
-	// TODO: recalculate route
+// syncASBRLSA makes the self-originated AS-external-LSAs and Type-7 LSAs match
+// the routes exactly. New routes are originated, changed ones re-originated and
+// those no longer desired are prematurely aged. Unchanged LSAs are left
+// untouched. As the Link State IDs depend on each other, the routes must be all
+// the announced ones.
+func (i *Instance) syncASBRLSA(routes ...ExternalRoute) {
+	ids := externalLinkStateIds(routes)
+	i.nssaTranslatedMu.Lock()
+	i.announcedExtIds = ids
+	i.nssaTranslatedMu.Unlock()
+	desired := make(map[uint32]struct{}, len(ids))
+	for _, id := range ids {
+		desired[id] = struct{}{}
+	}
+	// The ones translated from Type-7 LSAs are not announced routes.
+	translated := i.translatedExtLSAs()
+	var stale []packet2.LSAIdentity
+	i.lsDbRangeExtLSA(func(id packet2.LSAIdentity, item *LSDBASExternalItem) bool {
+		if id.AdvRouter != i.RouterId || item.h.LSAge >= packet2.MaxAge {
+			// not ours or already being flushed.
+			return true
+		}
+		if _, ok := translated[id]; ok {
+			return true
+		}
+		if _, ok := desired[id.LinkStateId]; !ok {
+			stale = append(stale, id)
+		}
+		return true
+	})
+	if len(routes) > 0 {
+		i.addASBRLSA(ids, routes...)
+	}
+	if len(stale) > 0 {
+		LogDebug("flushing %d self-originated external LSAs no longer desired", len(stale))
+		i.Backbone.prematureLSA(stale...)
+	}
+	for _, a := range i.nssaAreas() {
+		nssaStale := a.selfOriginatedNSSALSAs(func(id packet2.LSAIdentity) bool {
+			_, ok := desired[id.LinkStateId]
+			return !ok
+		})
+		if len(nssaStale) > 0 {
+			LogDebug("area %v flushing %d self-originated Type-7 LSAs no longer desired", a.AreaId, len(nssaStale))
+			a.prematureLSA(nssaStale...)
+		}
+	}
 }
@@ -0,0 +1,49 @@
+package ospf_cnn
+
+import (
+	"net"
+	"testing"
+)
+
+func TestExternalLinkStateIds(t *testing.T) {
+	route := func(s string) ExternalRoute {
+		_, p, _ := net.ParseCIDR(s)
+		return ExternalRoute{Prefix: *p}
+	}
+	for _, tt := range []struct {
+		name     string
+		routes   []ExternalRoute
+		expected map[string]string
+	}{
+		{
+			name:     "network address",
+			routes:   []ExternalRoute{route("10.0.0.0/24"), route("10.1.0.0/16")},
+			expected: map[string]string{"10.0.0.0/24": "10.0.0.0", "10.1.0.0/16": "10.1.0.0"},
+		},
+		{
+			name:     "longer mask gets host bits set",
+			routes:   []ExternalRoute{route("10.0.0.0/24"), route("10.0.0.0/16")},
+			expected: map[string]string{"10.0.0.0/16": "10.0.0.0", "10.0.0.0/24": "10.0.0.255"},
+		},
+		{
+			name:     "conflicting route left out",
+			routes:   []ExternalRoute{route("10.0.0.0/16"), route("10.0.0.255/32"), route("10.0.0.0/24")},
+			expected: map[string]string{"10.0.0.0/16": "10.0.0.0", "10.0.0.0/24": "10.0.0.255"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ids := externalLinkStateIds(tt.routes)
+			if len(ids) != len(tt.expected) {
+				t.Errorf("expecting %d Link State IDs but got %d", len(tt.expected), len(ids))
+			}
+			for key, expected := range tt.expected {
+				id, ok := ids[key]
+				if !ok {
+					t.Errorf("expecting %v assigned %v but got none", key, expected)
+				} else if id != ip(expected) {
+					t.Errorf("expecting %v assigned %v but got %v", key, expected, uint32ToIPv4(id))
+				}
+			}
+		})
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"math"
 	"net"
 	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,12 +18,31 @@ import (
 	"golang.org/x/net/ipv4"
 )
 
+// defaultMTU is the MTU assumed when the MTU of the network interface is
+// unknown, which is the one of Ethernet.
+const defaultMTU = 1500
+
 type InterfaceConfig struct {
 	IfName             string
 	Address            *net.IPNet
 	RouterPriority     uint8
 	HelloInterval      uint16
 	RouterDeadInterval uint32
+	Auth               InterfaceAuth
+	// The OSPF interface type. Defaults to broadcast.
+	Type InterfaceType
+	// The neighbors configured on NBMA networks, or on Point-to-MultiPoint
+	// networks without multicast capability.
+	NBMANeighbors []NBMANeighbor
+	// The interval in seconds between Hellos sent to inactive NBMA neighbors.
+	// Defaults to 120.
+	PollInterval uint16
+	// The area the interface attaches to. Only used by NewInstance.
+	AreaId uint32
+	// Accept Database Description packets whose Interface MTU exceeds the
+	// MTU of the interface, for neighbors misconfigured or advertising
+	// wrong values.
+	MTUIgnore bool
 }
 
 func NewInterface(ctx context.Context, c *InterfaceConfig) *Interface {
@@ -29,19 +50,39 @@ func NewInterface(ctx context.Context, c *InterfaceConfig) *Interface {
 	if err != nil {
 		panic(fmt.Errorf("can not find InterfaceByName: %w", err))
 	}
-	conn, err := ListenOSPFv2Multicast(ctx, ifi, "0.0.0.0", c.Address.IP.String())
+	ifType := c.Type
+	if ifType == 0 {
+		ifType = IfTypeBroadcast
+	}
+	var conn *Conn
+	if ifType == IfTypeNBMA || (ifType == IfTypePointToMultiPoint && len(c.NBMANeighbors) > 0) {
+		// NBMA networks, and Point-to-MultiPoint networks with configured
+		// neighbors, do not support multicast. All packets are sent as unicasts.
+		conn, err = ListenOSPFv2Unicast(ctx, ifi, "0.0.0.0", c.Address.IP.String())
+	} else {
+		conn, err = ListenOSPFv2Multicast(ctx, ifi, "0.0.0.0", c.Address.IP.String())
+	}
 	if err != nil {
-		panic(fmt.Errorf("can not bind OSPFv2 multicast conn: %w", err))
+		panic(fmt.Errorf("can not bind OSPFv2 conn: %w", err))
 	}
 	ctx, cancel := context.WithCancel(ctx)
+	mtu := ifi.MTU
+	if mtu <= 0 {
+		mtu = defaultMTU
+	}
+	pollInterval := c.PollInterval
+	if pollInterval == 0 {
+		pollInterval = 120
+	}
 	ret := &Interface{
 		ctx:                ctx,
 		cancel:             cancel,
 		c:                  conn,
 		pendingProcessPkt:  make(chan recvPkt, 100),
 		pendingSendPkt:     make(chan sendPkt, 100),
-		Type:               IfTypeBroadcast,
-		MTU:                1500,
+		Type:               ifType,
+		MTU:                uint16(min(mtu, math.MaxUint16)),
+		mtuIgnore:          c.MTUIgnore,
 		Address:            c.Address,
 		RouterPriority:     c.RouterPriority,
 		HelloInterval:      c.HelloInterval,
@@ -50,14 +91,18 @@ func NewInterface(ctx context.Context, c *InterfaceConfig) *Interface {
 		OutputCost:         10,
 		RxmtInterval:       5,
 		InfTransDelay:      1,
+		auth:               c.Auth,
+		PollInterval:       pollInterval,
 	}
-	ret.consumeEvent(IfEvInterfaceUp)
+	ret.initNBMANeighbors(c.NBMANeighbors)
 	return ret
 }
 
 type recvPkt struct {
 	h *ipv4.Header
 	p []byte
+	// The index of the network interface the packet was received on.
+	ifIndex int
 }
 
 type sendPkt struct {
@@ -130,6 +175,23 @@ const (
 	InterfaceDR
 )
 
+var ifsName = map[InterfaceState]string{
+	InterfaceDown:         "Down",
+	InterfaceLoopBack:     "Loopback",
+	InterfaceWaiting:      "Waiting",
+	InterfacePointToPoint: "Point-to-point",
+	InterfaceDROther:      "DR Other",
+	InterfaceBackup:       "Backup",
+	InterfaceDR:           "DR",
+}
+
+func (is InterfaceState) String() string {
+	if name, ok := ifsName[is]; ok {
+		return name
+	}
+	return strconv.FormatInt(int64(is), 10)
+}
+
 type InterfaceStateChangingEvent int
 
 const (
@@ -212,6 +274,31 @@ const (
 	IfTypeVirtualLink
 )
 
+var iftName = map[InterfaceType]string{
+	IfTypePointToPoint:      "point-to-point",
+	IfTypeBroadcast:         "broadcast",
+	IfTypeNBMA:              "nbma",
+	IfTypePointToMultiPoint: "point-to-multipoint",
+	IfTypeVirtualLink:       "virtual-link",
+}
+
+func (it InterfaceType) String() string {
+	if name, ok := iftName[it]; ok {
+		return name
+	}
+	return strconv.FormatInt(int64(it), 10)
+}
+
+// ParseInterfaceType parses the interface type from its name.
+func ParseInterfaceType(s string) (InterfaceType, error) {
+	for it, name := range iftName {
+		if name == s {
+			return it, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown interface type %q", s)
+}
+
 type Interface struct {
 	// internal use
 
@@ -222,16 +309,28 @@ type Interface struct {
 
 	pendingProcessPkt chan recvPkt
 	pendingSendPkt    chan sendPkt
+	// Reassembles the fragmented IPv4 packets received.
+	reassembly fragmentReassembler
+	// The received packets and LSAs rejected, per reason.
+	rejected recvRejectCounters
 
 	// The OSPF interface type is either point-to-point, broadcast,
 	//        NBMA, Point-to-MultiPoint or virtual link.
-	// Not actually used yet.
 	Type InterfaceType
-	MTU  uint16
+	// The size in bytes of the largest IP datagram that can be sent out
+	//        the interface without fragmentation, as found on the network
+	//        interface.
+	MTU uint16
+	// Whether to accept Database Description packets advertising an
+	// Interface MTU larger than MTU.
+	mtuIgnore bool
 	// The functional level of an interface.  State determines whether
 	//        or not full adjacencies are allowed to form over the interface.
 	//        State is also reflected in the router's LSAs.
 	State InterfaceState
+	stMu  sync.RWMutex
+	// serializes the interface state machine, guarding WaitTimer as well
+	evMu sync.Mutex
 	// The IP address associated with the interface.  This appears as
 	//        the IP source address in all routing protocol packets originated
 	//        over this interface.  Interfaces to unnumbered point-to-point
@@ -246,6 +345,9 @@ type Interface struct {
 	//        of the link are assigned independently, if they are assigned at
 	//        all.
 	Address *net.IPNet
+	// guards Address and OutputCost, which change on virtual links as the
+	// path through the Transit area changes.
+	addrMu sync.RWMutex
 	// The Area ID of the area to which the attached network belongs.
 	//        All routing protocol packets originating from the interface are
 	//        labelled with this Area ID.
@@ -272,6 +374,17 @@ type Interface struct {
 	//        it down, when they stop hearing the router's Hello Packets.
 	//        Advertised in Hello packets sent out this interface.
 	RouterDeadInterval uint32
+	// If a neighboring router has become inactive (Hello Packets have
+	//        not been seen for RouterDeadInterval seconds), it may still be
+	//        necessary to send Hello Packets to the dead neighbor.  These
+	//        Hello Packets will be sent at the reduced rate PollInterval,
+	//        which should be much larger than HelloInterval.  Used on NBMA
+	//        networks only.
+	PollInterval uint16
+	// The configured neighbors on NBMA networks along with their eligibility
+	//        to become Designated Router, keyed by IP address.
+	nbmaNeighbors map[uint32]*nbmaNeighbor
+	nbmaMu        sync.Mutex
 	// A single shot timer that causes the interface to exit the
 	//        Waiting state, and as a consequence select a Designated Router
 	//        on the network.  The length of the timer is RouterDeadInterval
@@ -329,38 +442,99 @@ type Interface struct {
 	//            local area network: 1 second.
 	InfTransDelay uint16
 
-	// TODO
-	AuType string
-	// TODO
-	Authentication string
+	// The type of authentication used on the attached network/subnet
+	//        and the authentication key (simple password or key chain). All
+	//        OSPF packet exchanges are authenticated. Different
+	//        authentication procedures are used on different interfaces.
+	auth   InterfaceAuth
+	authMu sync.RWMutex
+	// The cryptographic sequence number of the last packet sent.
+	cryptoSeqNum atomic.Uint32
+	// Whether the expiration of the last send key has been logged.
+	lastKeyExpiredWarned atomic.Bool
+	// The Transit area and the virtual neighbor of the virtual link.
+	// Nil unless Type is virtual link.
+	vl *virtualLink
+	// The link-local opaque LSAs (LS type 9) of the attached network. Their
+	// flooding scope is the interface, so they are kept apart from the
+	// link state database of the area.
+	LinkLocalOpaqueLSAs map[packet2.LSAIdentity]*LSDBOpaqueItem
+	opaqueRw            sync.RWMutex
 }
 
 func (i *Interface) shouldCheckNeighborNetworkMask() bool {
 	return i.Type != IfTypePointToPoint && i.Type != IfTypeVirtualLink
 }
 
+// ddInterfaceMTU returns the Interface MTU advertised in Database Description
+// packets. It is set to 0x0000 when the packet is sent over a virtual link.
+func (i *Interface) ddInterfaceMTU() uint16 {
+	if i.Type == IfTypeVirtualLink {
+		return 0
+	}
+	return i.MTU
+}
+
+// maxPktLen returns the maximum length of OSPF packets sent out the interface
+// without fragmentation, excluding the authentication trailer if any.
+func (i *Interface) maxPktLen() int {
+	ret := int(i.MTU) - ipv4.HeaderLen
+	if auth := i.getAuth(); auth.AuType == packet2.AuTypeCryptographic {
+		// The longest digest if no key is available for now.
+		digestLen := packet2.AuthAlgorithmHMACSHA512.DigestLen()
+		if k, ok := auth.sendKey(time.Now()); ok {
+			digestLen = k.Algorithm.DigestLen()
+		}
+		ret -= digestLen
+	}
+	return ret
+}
+
+// ddOptions returns the Options field of Database Description packets. The
+// O-bit is set to tell the neighbor the router is opaque-capable (RFC5250 3.1).
+func (i *Interface) ddOptions() uint32 {
+	return uint32(i.Area.Options.SetBit(packet2.CapabilityObit))
+}
+
 func (i *Interface) shouldHaveDR() bool {
 	return i.Type == IfTypeBroadcast || i.Type == IfTypeNBMA
 }
 
 func (i *Interface) changeDRAndBDR(dr, bdr uint32) (changed bool) {
-	oldDR := i.DR.Load()
-	oldBDR := i.BDR.Load()
-	if dr == oldDR && bdr == oldBDR {
+	oldDR := i.DR.Swap(dr)
+	oldBDR := i.BDR.Swap(bdr)
+	return oldDR != dr || oldBDR != bdr
+}
+
+// isNeighborDROrBDR checks whether the neighbor identified by rtId is
+// the (Backup) Designated Router of the attached network.
+func (i *Interface) isNeighborDROrBDR(rtId uint32) bool {
+	nb, ok := i.getNeighbor(rtId)
+	if !ok {
 		return false
 	}
-	return i.DR.CompareAndSwap(oldDR, dr) || i.BDR.CompareAndSwap(oldBDR, bdr)
+	nbAddr := ipv4BytesToUint32(nb.NeighborAddress.To4())
+	return nbAddr == i.DR.Load() || nbAddr == i.BDR.Load()
 }
 
 func (i *Interface) currState() InterfaceState {
+	i.stMu.RLock()
+	defer i.stMu.RUnlock()
 	return i.State
 }
 
 func (i *Interface) transState(target InterfaceState) {
+	i.stMu.Lock()
+	defer i.stMu.Unlock()
+	if i.State != target {
+		LogInfo("interface %s state change: %v -> %v", i.c.ifi.Name, i.State, target)
+	}
 	i.State = target
 }
 
 func (i *Interface) consumeEvent(e InterfaceStateChangingEvent) {
+	i.evMu.Lock()
+	defer i.evMu.Unlock()
 	switch e {
 	case IfEvInterfaceUp:
 		if i.currState() == InterfaceDown {
@@ -390,8 +564,13 @@ func (i *Interface) consumeEvent(e InterfaceStateChangingEvent) {
 					// list of neighbors for this interface and generate
 					// the neighbor event Start for each neighbor that is
 					// also eligible to become Designated Router.
+					i.transState(InterfaceWaiting)
+					i.startWaitTimer()
+					i.startNBMANeighbors(true)
 				}
 			}
+			// The interface now contributes a link to the router-LSA.
+			i.Area.updateSelfOriginatedRouterLSA(i)
 		}
 	case IfEvBackupSeen:
 		if i.currState() == InterfaceWaiting {
@@ -399,6 +578,8 @@ func (i *Interface) consumeEvent(e InterfaceStateChangingEvent) {
 			// Router and Designated Router, as shown in Section
 			// 9.4.  As a result of this calculation, the new state
 			// of the interface will be either DR Other, Backup or DR.
+			i.stopWaitTimer()
+			i.calculateDRAndBDR()
 		}
 	case IfEvWaitTimer:
 		if i.currState() == InterfaceWaiting {
@@ -406,6 +587,7 @@ func (i *Interface) consumeEvent(e InterfaceStateChangingEvent) {
 			// Router and Designated Router, as shown in Section
 			// 9.4.  As a result of this calculation, the new state
 			// of the interface will be either DR Other, Backup or DR.
+			i.calculateDRAndBDR()
 		}
 	case IfEvNeighborChange:
 		switch i.currState() {
@@ -414,6 +596,7 @@ func (i *Interface) consumeEvent(e InterfaceStateChangingEvent) {
 			// Router and Designated Router, as shown in Section
 			// 9.4.  As a result of this calculation, the new state
 			// of the interface will be either DR Other, Backup or DR.
+			i.calculateDRAndBDR()
 		}
 	case IfEvInterfaceDown:
 		// All interface variables are reset, and interface
@@ -423,6 +606,8 @@ func (i *Interface) consumeEvent(e InterfaceStateChangingEvent) {
 		// associated neighbors (see Section 10.2).
 		i.transState(InterfaceDown)
 		i.HelloTicker.Terminate()
+		i.stopWaitTimer()
+		i.changeDRAndBDR(0, 0)
 		i.killAllNeighbor()
 	case IfEvLoopInd:
 		// Since this interface is no longer connected to the
@@ -430,6 +615,8 @@ func (i *Interface) consumeEvent(e InterfaceStateChangingEvent) {
 		// above InterfaceDown event are executed.
 		i.transState(InterfaceLoopBack)
 		i.HelloTicker.Terminate()
+		i.stopWaitTimer()
+		i.changeDRAndBDR(0, 0)
 		i.killAllNeighbor()
 	case IfEvUnLoopInd:
 		if i.currState() == InterfaceLoopBack {
@@ -451,6 +638,9 @@ func (i *Interface) start() {
 
 func (i *Interface) close() error {
 	i.cancel()
+	i.evMu.Lock()
+	i.stopWaitTimer()
+	i.evMu.Unlock()
 	i.wg.Wait()
 	return i.c.Close()
 }
@@ -476,10 +666,11 @@ func (i *Interface) runReadLoop() {
 	go func() {
 		const recvBufLen = 64 << 10
 		var (
-			buf = make([]byte, recvBufLen)
-			n   int
-			h   *ipv4.Header
-			err error
+			buf     = make([]byte, recvBufLen)
+			n       int
+			h       *ipv4.Header
+			ifIndex int
+			err     error
 		)
 		for {
 			select {
@@ -488,17 +679,14 @@ func (i *Interface) runReadLoop() {
 				i.wg.Done()
 				return
 			default:
-				n, h, err = i.c.Read(buf)
+				n, h, ifIndex, err = i.c.Read(buf)
 				if err != nil {
 					if !errors.Is(err, os.ErrDeadlineExceeded) {
 						LogErr("interface %s read err", i.c.ifi.Name)
 					}
-					continue
-				}
-				if h.Flags&ipv4.MoreFragments == 1 || h.FragOff != 0 {
-					// TODO: deal with ipv4 fragment
-					LogWarn("interface %s received fragmented IPv4 packet %s->%s and discarded",
-						i.c.ifi.Name, h.Src.String(), h.Dst.String())
+					// Reading times out every second, which drives the
+					// expiry of incomplete datagrams as well.
+					i.reassembly.expire(time.Now())
 					continue
 				}
 				payloadLen := n - ipv4.HeaderLen
@@ -507,8 +695,17 @@ func (i *Interface) runReadLoop() {
 				//}
 				payload := make([]byte, payloadLen)
 				copy(payload, buf[ipv4.HeaderLen:n])
+				if isFragment(h) {
+					var ok bool
+					if h, payload, ok = i.reassembly.add(h, payload, time.Now()); !ok {
+						continue
+					}
+					LogDebug("interface %s reassembled fragmented IPv4 packet %s->%s payloadSize(%d)",
+						i.c.ifi.Name, h.Src.String(), h.Dst.String(), len(payload))
+					payloadLen = len(payload)
+				}
 				select {
-				case i.pendingProcessPkt <- recvPkt{h: h, p: payload}:
+				case i.pendingProcessPkt <- recvPkt{h: h, p: payload, ifIndex: ifIndex}:
 				default:
 					LogWarn("interface %s pendingProcPkt full. Discarding 1 pkt(%d)", i.c.ifi.Name, payloadLen)
 				}
@@ -545,7 +742,11 @@ func (i *Interface) doSendPkt(pkt sendPkt) (err error) {
 		ComputeChecksums: true,
 	}, pkt.p)
 	if err != nil {
-		LogErr("interface %s err marshal pending send %s->%s %v packet", i.c.ifi.Name, i.Address.IP.String(), dstIP.String(), pkt.p.GetType())
+		LogErr("interface %s err marshal pending send %s->%s %v packet", i.c.ifi.Name, i.getAddress().IP.String(), dstIP.String(), pkt.p.GetType())
+		return
+	}
+	if err = i.signPkt(p); err != nil {
+		LogErr("interface %s err sign pending send %s->%s %v packet: %v", i.c.ifi.Name, i.getAddress().IP.String(), dstIP.String(), pkt.p.GetType(), err)
 		return
 	}
 
@@ -553,11 +754,11 @@ func (i *Interface) doSendPkt(pkt sendPkt) (err error) {
 		IP: dstIP,
 	})
 	if err != nil {
-		LogErr("interface %s err send %s->%s %v packet", i.c.ifi.Name, i.Address.IP.String(), dstIP.String(), pkt.p.GetType())
+		LogErr("interface %s err send %s->%s %v packet", i.c.ifi.Name, i.getAddress().IP.String(), dstIP.String(), pkt.p.GetType())
 	} else {
 		if pkt.p.GetType() != layers.OSPFHello {
 			LogDebug("sent via interface %s %s->%s %+v", i.c.ifi.Name,
-				i.Address.IP.String(), dstIP.String(),
+				i.getAddress().IP.String(), dstIP.String(),
 				pkt.p)
 		}
 	}
@@ -575,6 +776,21 @@ func (i *Interface) runHelloTicker() {
 		})
 }
 
+// startWaitTimer and stopWaitTimer must be called with evMu held.
+func (i *Interface) startWaitTimer() {
+	waitDur := time.Duration(i.RouterDeadInterval) * time.Second
+	i.stopWaitTimer()
+	i.WaitTimer = time.AfterFunc(waitDur, func() {
+		i.consumeEvent(IfEvWaitTimer)
+	})
+}
+
+func (i *Interface) stopWaitTimer() {
+	if i.WaitTimer != nil {
+		i.WaitTimer.Stop()
+	}
+}
+
 func (i *Interface) rangeOverNeighbors(fn func(nb *Neighbor) bool) {
 	i.nbMu.RLock()
 	defer i.nbMu.RUnlock()
@@ -585,6 +801,18 @@ func (i *Interface) rangeOverNeighbors(fn func(nb *Neighbor) bool) {
 	}
 }
 
+func (i *Interface) getAddress() *net.IPNet {
+	i.addrMu.RLock()
+	defer i.addrMu.RUnlock()
+	return i.Address
+}
+
+func (i *Interface) getOutputCost() int {
+	i.addrMu.RLock()
+	defer i.addrMu.RUnlock()
+	return i.OutputCost
+}
+
 func (i *Interface) getNeighbor(rtId uint32) (nb *Neighbor, ok bool) {
 	i.nbMu.RLock()
 	defer i.nbMu.RUnlock()
@@ -596,38 +824,50 @@ func (i *Interface) removeNeighbor(nb *Neighbor) {
 	i.nbMu.Lock()
 	defer i.nbMu.Unlock()
 	nb.terminate()
-	delete(i.Neighbors, nb.NeighborId)
-	// we are not DR or BDR because of zero priority.
-	// so remember to reset DR or BDR when neighbor disappear
-	nbAddr := ipv4BytesToUint32(nb.NeighborAddress.To4())
-	if nbAddr == i.DR.Load() || nbAddr == i.BDR.Load() {
-		i.changeDRAndBDR(0, 0)
+	if i.Neighbors[nb.NeighborId] == nb {
+		delete(i.Neighbors, nb.NeighborId)
 	}
 }
 
 func (i *Interface) killAllNeighbor() {
 	i.nbMu.Lock()
-	defer i.nbMu.Unlock()
+	nbs := make([]*Neighbor, 0, len(i.Neighbors))
 	for _, nb := range i.Neighbors {
+		nbs = append(nbs, nb)
+	}
+	clear(i.Neighbors)
+	i.nbMu.Unlock()
+	for _, nb := range nbs {
 		nb.consumeEvent(NbEvKillNbr)
 		nb.terminate()
 	}
-	clear(i.Neighbors)
+	// The interface no longer contributes a transit link, and is no
+	// longer DR of the attached network.
+	i.updateSelfOriginatedLSAWhenAdjacencyChanged()
 }
 
 func (i *Interface) addNeighbor(h *ipv4.Header, hello *packet2.OSPFv2Packet[packet2.HelloPayloadV2]) *Neighbor {
-	ctx, cancel := context.WithCancel(i.ctx)
-	nb := &Neighbor{
-		ctx:              ctx,
-		cancel:           cancel,
-		i:                i,
-		State:            NeighborDown,
-		NeighborId:       hello.RouterID,
-		NeighborPriority: hello.Content.RtrPriority,
-		NeighborAddress:  h.Src,
-		NeighborsDR:      hello.Content.DesignatedRouterID,
-		NeighborsBDR:     hello.Content.BackupDesignatedRouterID,
-		LSRetransmission: make(map[packet2.LSAIdentity]struct{}),
+	nb := i.takeNBMAAttemptNeighbor(h.Src)
+	if nb != nil {
+		// The configured NBMA neighbor in Attempt state is heard from.
+		nb.NeighborId = hello.RouterID
+		nb.NeighborPriority = hello.Content.RtrPriority
+		nb.NeighborsDR = hello.Content.DesignatedRouterID
+		nb.NeighborsBDR = hello.Content.BackupDesignatedRouterID
+	} else {
+		ctx, cancel := context.WithCancel(i.ctx)
+		nb = &Neighbor{
+			ctx:              ctx,
+			cancel:           cancel,
+			i:                i,
+			State:            NeighborDown,
+			NeighborId:       hello.RouterID,
+			NeighborPriority: hello.Content.RtrPriority,
+			NeighborAddress:  h.Src,
+			NeighborsDR:      hello.Content.DesignatedRouterID,
+			NeighborsBDR:     hello.Content.BackupDesignatedRouterID,
+			LSRetransmission: make(map[packet2.LSAIdentity]struct{}),
+		}
 	}
 	i.nbMu.Lock()
 	defer i.nbMu.Unlock()
@@ -637,7 +877,7 @@ func (i *Interface) addNeighbor(h *ipv4.Header, hello *packet2.OSPFv2Packet[pack
 
 func (i *Interface) sendDelayedLSAcks(lsacks []packet2.LSAheader, dst uint32) {
 	p := &packet2.OSPFv2Packet[packet2.LSAcknowledgementPayload]{
-		OSPFv2: i.Area.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
+		OSPFv2: i.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
 			p.Type = layers.OSPFLinkStateAcknowledgment
 		}),
 		Content: packet2.LSAcknowledgementPayload(lsacks),
@@ -654,7 +894,7 @@ func (i *Interface) sendLSUFlood(dst uint32, lids ...packet2.LSAIdentity) {
 		allLSAs []packet2.LSAdvertisement
 	)
 	for _, lid := range lids {
-		_, lsa, meta, ok := i.Area.lsDbGetLSAByIdentity(lid, true)
+		_, lsa, meta, ok := i.lsDbGetLSAByIdentity(lid, true)
 		if !ok {
 			continue
 		}
@@ -665,9 +905,196 @@ func (i *Interface) sendLSUFlood(dst uint32, lids ...packet2.LSAIdentity) {
 	i.Area.splitSendLSAsByMtu(i, allLSAs, dst)
 }
 
-func (i *Interface) immediateTickNeighborsRetransmissionList() {
+// sendLSUFloodToNeighbors sends separate Link State Update packets as unicasts
+// to each adjacent neighbor, containing the LSAs on its Link state retransmission list.
+func (i *Interface) sendLSUFloodToNeighbors(lids ...packet2.LSAIdentity) {
+	var nbs []*Neighbor
+	i.rangeOverNeighbors(func(nb *Neighbor) bool {
+		if nb.currState() >= NeighborExchange {
+			nbs = append(nbs, nb)
+		}
+		return true
+	})
+	for _, nb := range nbs {
+		var pending []packet2.LSAIdentity
+		for _, lid := range lids {
+			if nb.isInLSRetransmissionList(lid) {
+				pending = append(pending, lid)
+			}
+		}
+		if len(pending) > 0 {
+			i.sendLSUFlood(nb.pktDst(), pending...)
+		}
+	}
+}
+
+// drCandidate is a router taking part in the (Backup) Designated Router
+// election on the attached network.
+type drCandidate struct {
+	routerId uint32
+	priority uint8
+	// IP interface address on the attached network
+	addr uint32
+	// the candidate's idea of the (Backup) Designated Router
+	dr  uint32
+	bdr uint32
+}
+
+func (c drCandidate) declaresDR() bool {
+	return c.dr == c.addr
+}
+
+func (c drCandidate) declaresBDR() bool {
+	return c.bdr == c.addr
+}
+
+func (c drCandidate) isPreferredTo(o drCandidate) bool {
+	// the one with the highest Router Priority is chosen.
+	// In case of a tie, the one having the highest Router ID is chosen.
+	if c.priority != o.priority {
+		return c.priority > o.priority
+	}
+	return c.routerId > o.routerId
+}
+
+func calculateBDR(candidates []drCandidate) uint32 {
+	var best, bestDeclared *drCandidate
+	for idx := range candidates {
+		c := &candidates[idx]
+		// Routers that have declared themselves to be Designated
+		// Router are not eligible for the Backup Designated Router.
+		if c.priority <= 0 || c.declaresDR() {
+			continue
+		}
+		if c.declaresBDR() && (bestDeclared == nil || c.isPreferredTo(*bestDeclared)) {
+			bestDeclared = c
+		}
+		if best == nil || c.isPreferredTo(*best) {
+			best = c
+		}
+	}
+	// If one or more of these routers have declared themselves Backup
+	// Designated Router, the one having highest Router Priority is declared
+	// to be Backup Designated Router. If no routers have declared themselves
+	// Backup Designated Router, choose the router having highest Router
+	// Priority, (again excluding those routers who have declared themselves
+	// Designated Router).
+	if bestDeclared != nil {
+		return bestDeclared.addr
+	}
+	if best != nil {
+		return best.addr
+	}
+	return 0
+}
+
+func calculateDR(candidates []drCandidate, bdr uint32) uint32 {
+	var best *drCandidate
+	for idx := range candidates {
+		c := &candidates[idx]
+		if c.priority <= 0 || !c.declaresDR() {
+			continue
+		}
+		if best == nil || c.isPreferredTo(*best) {
+			best = c
+		}
+	}
+	// If one or more of the routers have declared themselves Designated
+	// Router, the one having highest Router Priority is declared to be
+	// Designated Router.  If no routers have declared themselves
+	// Designated Router, assign the Designated Router to be the same as
+	// the newly elected Backup Designated Router.
+	if best != nil {
+		return best.addr
+	}
+	return bdr
+}
+
+// electDRAndBDR runs steps 2 to 4 of RFC2328 9.4.
+// The first candidate must be the calculating router itself.
+// Returns the IP interface addresses of the elected (Backup) Designated Router.
+func electDRAndBDR(candidates []drCandidate) (dr, bdr uint32) {
+	self := &candidates[0]
+	wasDR, wasBDR := self.declaresDR(), self.declaresBDR()
+	bdr = calculateBDR(candidates)
+	dr = calculateDR(candidates, bdr)
+	// If Router X is now newly the Designated Router or newly the Backup
+	// Designated Router, or is now no longer the Designated Router or no
+	// longer the Backup Designated Router, repeat steps 2 and 3.
+	if isDR, isBDR := dr == self.addr, bdr == self.addr; isDR != wasDR || isBDR != wasBDR {
+		self.dr, self.bdr = dr, bdr
+		bdr = calculateBDR(candidates)
+		dr = calculateDR(candidates, bdr)
+	}
+	return
+}
+
+// calculateDRAndBDR calculates the attached network's Backup Designated Router
+// and Designated Router, as shown in RFC2328 9.4.
+// Must be called from the interface state machine.
+func (i *Interface) calculateDRAndBDR() {
+	selfAddr := ipv4BytesToUint32(i.getAddress().IP.To4())
+	// Only those neighbors with whom the router has established
+	// bidirectional communication are considered.
+	candidates := []drCandidate{{
+		routerId: i.Area.ins.RouterId,
+		priority: i.RouterPriority,
+		addr:     selfAddr,
+		dr:       i.DR.Load(),
+		bdr:      i.BDR.Load(),
+	}}
 	i.rangeOverNeighbors(func(nb *Neighbor) bool {
-		nb.lsRtxmTicker.DoFnNow()
+		if nb.currState() >= Neighbor2Way {
+			candidates = append(candidates, drCandidate{
+				routerId: nb.NeighborId,
+				priority: nb.NeighborPriority,
+				addr:     ipv4BytesToUint32(nb.NeighborAddress.To4()),
+				dr:       nb.NeighborsDR,
+				bdr:      nb.NeighborsBDR,
+			})
+		}
 		return true
 	})
+	dr, bdr := electDRAndBDR(candidates)
+
+	// As a result of these calculations, the router itself may now be
+	// Designated Router or Backup Designated Router.  The router's
+	// interface state should be set accordingly.
+	switch selfAddr {
+	case dr:
+		i.transState(InterfaceDR)
+	case bdr:
+		i.transState(InterfaceBackup)
+	default:
+		i.transState(InterfaceDROther)
+	}
+	// If the router has just become either Designated Router or Backup
+	// Designated Router on an NBMA network, it must invoke the neighbor
+	// event Start for each neighbor that is not eligible to become
+	// Designated Router.
+	if selfAddr == dr || selfAddr == bdr {
+		i.startNBMANeighbors(false)
+	}
+
+	if i.changeDRAndBDR(dr, bdr) {
+		LogInfo("interface %s elected DR(%v) BDR(%v)", i.c.ifi.Name,
+			uint32ToIPv4(dr).String(), uint32ToIPv4(bdr).String())
+		i.updateSelfOriginatedLSAWhenAdjacencyChanged()
+		// If the above calculations have caused the identity of either the
+		// Designated Router or Backup Designated Router to change, the set
+		// of adjacencies associated with this interface will need to be
+		// modified.  Some adjacencies may need to be formed, and others may
+		// need to be broken.  To accomplish this, invoke the event AdjOK?
+		// on all neighbors whose state is at least 2-Way.
+		var nbs []*Neighbor
+		i.rangeOverNeighbors(func(nb *Neighbor) bool {
+			if nb.currState() >= Neighbor2Way {
+				nbs = append(nbs, nb)
+			}
+			return true
+		})
+		for _, nb := range nbs {
+			nb.consumeEvent(NbEvIsAdjOK)
+		}
+	}
 }
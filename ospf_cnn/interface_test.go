@@ -0,0 +1,97 @@
+package ospf_cnn
+
+import "testing"
+
+func TestElectDRAndBDR(t *testing.T) {
+	const (
+		self = 0x0a000001
+		nb1  = 0x0a000002
+		nb2  = 0x0a000003
+	)
+	for _, tt := range []struct {
+		name       string
+		candidates []drCandidate
+		dr, bdr    uint32
+	}{
+		{
+			name: "alone on the network declares self",
+			candidates: []drCandidate{
+				{routerId: 1, priority: 1, addr: self},
+			},
+			dr: self, bdr: 0,
+		},
+		{
+			// self is newly elected BDR and then DR, so the election is
+			// repeated to elect another BDR.
+			name: "highest on a new network becomes DR",
+			candidates: []drCandidate{
+				{routerId: 3, priority: 1, addr: self},
+				{routerId: 2, priority: 1, addr: nb1},
+				{routerId: 1, priority: 1, addr: nb2},
+			},
+			dr: self, bdr: nb1,
+		},
+		{
+			name: "priority tie broken by Router ID",
+			candidates: []drCandidate{
+				{routerId: 1, priority: 1, addr: self, dr: nb2},
+				{routerId: 2, priority: 5, addr: nb1, dr: nb2, bdr: nb1},
+				{routerId: 3, priority: 5, addr: nb2, dr: nb2},
+			},
+			dr: nb2, bdr: nb1,
+		},
+		{
+			name: "higher priority preferred to higher Router ID",
+			candidates: []drCandidate{
+				{routerId: 1, priority: 1, addr: self, dr: nb1},
+				{routerId: 2, priority: 5, addr: nb1, dr: nb1},
+				{routerId: 3, priority: 2, addr: nb2, dr: nb1},
+			},
+			dr: nb1, bdr: nb2,
+		},
+		{
+			name: "declared DR kept despite higher priority",
+			candidates: []drCandidate{
+				{routerId: 9, priority: 100, addr: self},
+				{routerId: 2, priority: 1, addr: nb1, dr: nb1},
+			},
+			dr: nb1, bdr: self,
+		},
+		{
+			name: "declared BDR kept despite higher priority",
+			candidates: []drCandidate{
+				{routerId: 1, priority: 1, addr: self, dr: nb1, bdr: self},
+				{routerId: 2, priority: 1, addr: nb1, dr: nb1, bdr: self},
+				{routerId: 3, priority: 100, addr: nb2, dr: nb1, bdr: self},
+			},
+			dr: nb1, bdr: self,
+		},
+		{
+			// The DR is gone: self, the BDR, is promoted and the
+			// election is repeated to elect a new BDR.
+			name: "BDR promoted to DR",
+			candidates: []drCandidate{
+				{routerId: 1, priority: 1, addr: self, dr: nb2, bdr: self},
+				{routerId: 2, priority: 1, addr: nb1, dr: nb2, bdr: self},
+			},
+			dr: self, bdr: nb1,
+		},
+		{
+			name: "priority 0 never elected",
+			candidates: []drCandidate{
+				{routerId: 9, priority: 0, addr: self},
+				{routerId: 2, priority: 1, addr: nb1, dr: nb1},
+				{routerId: 3, priority: 0, addr: nb2, dr: nb1},
+			},
+			dr: nb1, bdr: 0,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dr, bdr := electDRAndBDR(tt.candidates)
+			if dr != tt.dr || bdr != tt.bdr {
+				t.Errorf("expecting DR(%v) BDR(%v) but got DR(%v) BDR(%v)",
+					uint32ToIPv4(tt.dr), uint32ToIPv4(tt.bdr), uint32ToIPv4(dr), uint32ToIPv4(bdr))
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package ospf_cnn
+
+import (
+	"context"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"net"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// NBMANeighbor is a neighbor statically configured on NBMA networks.
+// per RFC2328 9.5.1 and C.6
+type NBMANeighbor struct {
+	// The IP address of the neighbor's interface to the attached network.
+	Address net.IP
+	// Whether the neighbor is eligible to become Designated Router.
+	Eligible bool
+}
+
+type nbmaNeighbor struct {
+	NBMANeighbor
+	// The neighbor in Attempt state that has not been heard from yet.
+	attempt *Neighbor
+	// When the last Hello was sent to the neighbor in Down state.
+	lastPoll time.Time
+}
+
+func (i *Interface) initNBMANeighbors(nbs []NBMANeighbor) {
+	i.nbmaNeighbors = make(map[uint32]*nbmaNeighbor, len(nbs))
+	for _, nb := range nbs {
+		addr := nb.Address.To4()
+		if addr == nil {
+			LogWarn("interface %s ignored configured NBMA neighbor %v: not an IPv4 address", i.c.ifi.Name, nb.Address)
+			continue
+		}
+		nb.Address = addr
+		i.nbmaNeighbors[ipv4BytesToUint32(addr)] = &nbmaNeighbor{NBMANeighbor: nb}
+	}
+}
+
+// isNBMANeighborActive checks whether the configured neighbor is in state Attempt or higher.
+// Must be called with nbmaMu held.
+func (i *Interface) isNBMANeighborActive(addr uint32, nb *nbmaNeighbor) (active bool) {
+	if nb.attempt != nil {
+		if nb.attempt.currState() != NeighborDown {
+			return true
+		}
+		// inactivity timer fired before the neighbor was heard.
+		nb.attempt = nil
+	}
+	i.rangeOverNeighbors(func(n *Neighbor) bool {
+		if ipv4BytesToUint32(n.NeighborAddress.To4()) == addr && n.currState() > NeighborDown {
+			active = true
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// startNBMANeighbors generates the neighbor event Start for the configured neighbors
+// which are still Down. If eligibleOnly is set, only those neighbors eligible to
+// become Designated Router are started.
+func (i *Interface) startNBMANeighbors(eligibleOnly bool) {
+	if i.Type != IfTypeNBMA {
+		return
+	}
+	var started []*Neighbor
+	i.nbmaMu.Lock()
+	for addr, nb := range i.nbmaNeighbors {
+		if (eligibleOnly && !nb.Eligible) || i.isNBMANeighborActive(addr, nb) {
+			continue
+		}
+		ctx, cancel := context.WithCancel(i.ctx)
+		nb.attempt = &Neighbor{
+			ctx:              ctx,
+			cancel:           cancel,
+			i:                i,
+			State:            NeighborDown,
+			NeighborAddress:  nb.Address,
+			LSRetransmission: make(map[packet2.LSAIdentity]struct{}),
+		}
+		nb.lastPoll = time.Now()
+		started = append(started, nb.attempt)
+	}
+	i.nbmaMu.Unlock()
+	for _, nb := range started {
+		nb.consumeEvent(NbEvStart)
+	}
+}
+
+// takeNBMAAttemptNeighbor returns the neighbor in Attempt state at address src, so
+// the Hello received from it continues its neighbor state machine.
+func (i *Interface) takeNBMAAttemptNeighbor(src net.IP) *Neighbor {
+	if i.Type != IfTypeNBMA {
+		return nil
+	}
+	i.nbmaMu.Lock()
+	defer i.nbmaMu.Unlock()
+	nb, ok := i.nbmaNeighbors[ipv4BytesToUint32(src.To4())]
+	if !ok || nb.attempt == nil {
+		return nil
+	}
+	ret := nb.attempt
+	nb.attempt = nil
+	if ret.currState() == NeighborDown {
+		return nil
+	}
+	return ret
+}
+
+// doNBMAHellos sends Hellos to the configured neighbors as unicasts per RFC2328 9.5.1.
+func (i *Interface) doNBMAHellos() {
+	for _, dst := range i.nbmaHelloDsts(time.Now()) {
+		_ = i.doHelloTo(dst)
+	}
+}
+
+// nbmaHelloDsts returns the addresses of the configured neighbors to send Hellos to at now.
+func (i *Interface) nbmaHelloDsts(now time.Time) (dsts []uint32) {
+	var (
+		st       = i.currState()
+		dr       = i.DR.Load()
+		bdr      = i.BDR.Load()
+		eligible = i.RouterPriority > 0
+		poll     = time.Duration(i.PollInterval) * time.Second
+	)
+	i.nbmaMu.Lock()
+	for addr, nb := range i.nbmaNeighbors {
+		// If the router is eligible to become Designated Router, it must
+		// periodically send Hello Packets to all neighbors that are also
+		// eligible. In addition, if the router is itself the Designated
+		// Router or Backup Designated Router, it must also send periodic
+		// Hello Packets to all other neighbors. If the router is not
+		// eligible to become Designated Router, it must periodically send
+		// Hello Packets to both the Designated Router and the Backup
+		// Designated Router (if they exist).
+		if !(st == InterfaceDR || st == InterfaceBackup || (eligible && nb.Eligible) || addr == dr || addr == bdr) {
+			continue
+		}
+		// When a neighbor is in state Down, Hellos are sent at the
+		// reduced rate of PollInterval instead of HelloInterval.
+		if !i.isNBMANeighborActive(addr, nb) {
+			if now.Sub(nb.lastPoll) < poll {
+				continue
+			}
+			nb.lastPoll = now
+		}
+		dsts = append(dsts, addr)
+	}
+	i.nbmaMu.Unlock()
+	return
+}
+
+// replyNBMAHello answers the Hello from an eligible neighbor when the router itself
+// is not eligible to become Designated Router. per RFC2328 9.5.1
+func (i *Interface) replyNBMAHello(h *ipv4.Header, nb *Neighbor, hello *packet2.OSPFv2Packet[packet2.HelloPayloadV2]) {
+	if i.Type != IfTypeNBMA || i.RouterPriority > 0 || hello.Content.RtrPriority <= 0 {
+		return
+	}
+	nbAddr := ipv4BytesToUint32(nb.NeighborAddress.To4())
+	if nbAddr == i.DR.Load() || nbAddr == i.BDR.Load() {
+		// periodic Hellos are sent to them anyway.
+		return
+	}
+	_ = i.doHelloTo(ipv4BytesToUint32(h.Src.To4()))
+}
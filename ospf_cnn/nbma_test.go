@@ -0,0 +1,119 @@
+package ospf_cnn
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testNBMAInterface configures neighbors 10.0.0.2 and 10.0.0.3 eligible to become
+// Designated Router and 10.0.0.4 and 10.0.0.5 not eligible on the NBMA interface.
+// The neighbors at the active addresses have been heard from.
+func testNBMAInterface(t *testing.T, active ...string) *Interface {
+	ifi := newTestInstance("1.1.1.1").Backbone.testInterface("10.0.0.1/24")
+	ifi.Type = IfTypeNBMA
+	ifi.PollInterval = 120
+	ifi.initNBMANeighbors([]NBMANeighbor{
+		{Address: net.ParseIP("10.0.0.2"), Eligible: true},
+		{Address: net.ParseIP("10.0.0.3"), Eligible: true},
+		{Address: net.ParseIP("10.0.0.4")},
+		{Address: net.ParseIP("10.0.0.5")},
+	})
+	for n, addr := range active {
+		nb := ifi.testFullNeighbor(t, uint32ToIPv4(uint32(n+2)).String())
+		nb.NeighborAddress = net.ParseIP(addr).To4()
+	}
+	return ifi
+}
+
+func checkNBMAHelloDsts(t *testing.T, ifi *Interface, now time.Time, expected ...string) {
+	t.Helper()
+	var got []string
+	for _, dst := range ifi.nbmaHelloDsts(now) {
+		got = append(got, uint32ToIPv4(dst).String())
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, expected) {
+		t.Errorf("expecting Hellos to %v but got %v", expected, got)
+	}
+}
+
+func TestNBMAHelloDsts(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		priority uint8
+		state    InterfaceState
+		dr, bdr  string
+		expected []string
+	}{
+		{
+			name:     "eligible",
+			priority: 1,
+			state:    InterfaceDROther,
+			dr:       "10.0.0.2",
+			bdr:      "0.0.0.0",
+			expected: []string{"10.0.0.2", "10.0.0.3"},
+		},
+		{
+			name:     "eligible DR",
+			priority: 1,
+			state:    InterfaceDR,
+			dr:       "10.0.0.1",
+			bdr:      "10.0.0.2",
+			expected: []string{"10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"},
+		},
+		{
+			name:     "eligible Backup",
+			priority: 1,
+			state:    InterfaceBackup,
+			dr:       "10.0.0.2",
+			bdr:      "10.0.0.1",
+			expected: []string{"10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"},
+		},
+		{
+			name:     "not eligible",
+			priority: 0,
+			state:    InterfaceDROther,
+			dr:       "10.0.0.2",
+			bdr:      "10.0.0.3",
+			expected: []string{"10.0.0.2", "10.0.0.3"},
+		},
+		{
+			name:     "not eligible without DR",
+			priority: 0,
+			state:    InterfaceDROther,
+			dr:       "0.0.0.0",
+			bdr:      "10.0.0.3",
+			expected: []string{"10.0.0.3"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ifi := testNBMAInterface(t, "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5")
+			ifi.RouterPriority = tt.priority
+			ifi.State = tt.state
+			ifi.DR.Store(ip(tt.dr))
+			ifi.BDR.Store(ip(tt.bdr))
+			checkNBMAHelloDsts(t, ifi, time.Now(), tt.expected...)
+		})
+	}
+}
+
+func TestNBMAHelloPollInterval(t *testing.T) {
+	// 10.0.0.3 is Down and 10.0.0.2 heard from.
+	ifi := testNBMAInterface(t, "10.0.0.2")
+	ifi.RouterPriority = 1
+	ifi.State = InterfaceDROther
+	now := time.Now()
+	for _, nb := range ifi.nbmaNeighbors {
+		nb.lastPoll = now
+	}
+
+	// Hellos are sent every HelloInterval to the neighbor heard from.
+	checkNBMAHelloDsts(t, ifi, now.Add(10*time.Second), "10.0.0.2")
+	// and every PollInterval to the neighbor Down.
+	now = now.Add(120 * time.Second)
+	checkNBMAHelloDsts(t, ifi, now, "10.0.0.2", "10.0.0.3")
+	checkNBMAHelloDsts(t, ifi, now.Add(10*time.Second), "10.0.0.2")
+	checkNBMAHelloDsts(t, ifi, now.Add(120*time.Second), "10.0.0.2", "10.0.0.3")
+}
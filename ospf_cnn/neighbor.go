@@ -12,7 +12,6 @@ import (
 	"time"
 
 	"github.com/gopacket/gopacket/layers"
-	"golang.org/x/net/ipv4"
 )
 
 type NeighborState int
@@ -116,6 +115,10 @@ type Neighbor struct {
 	// The DD Sequence number of the Database Description packet that
 	//        is currently being sent to the neighbor.
 	DDSeqNumber atomic.Uint32
+	// The cryptographic sequence number of the last authenticated packet
+	//        received from the neighbor. Packets with smaller number are
+	//        discarded to prevent replay attacks. per RFC2328 D.3
+	cryptoSeqNum atomic.Uint32
 
 	// The initialize(I), more (M) and master(MS) bits, Options field,
 	//        and DD sequence number contained in the last Database
@@ -190,6 +193,16 @@ type Neighbor struct {
 	LSRequest           []packet2.LSAheader
 	lsReqListRw         sync.RWMutex
 	lsReqListRtxmTicker *TickerFunc
+
+	// The graceful restart helper state of the neighbor (RFC3623 3).
+	//        While the router is helping the restarting neighbor, the
+	//        neighbor is announced as fully adjacent until the grace period
+	//        ends, the restart completes or the topology changes.
+	GRHelper   GRHelperState
+	grHelperMu sync.RWMutex
+	graceTimer *time.Timer
+	// Whether the Inactivity Timer fired while helping the neighbor.
+	inactiveInHelper atomic.Bool
 }
 
 func (n *Neighbor) terminate() {
@@ -200,6 +213,7 @@ func (n *Neighbor) terminate() {
 	if n.InactivityTimer != nil {
 		n.InactivityTimer.Stop()
 	}
+	n.exitHelper(GRHelperExitAdjacencyDown)
 }
 
 func (n *Neighbor) currState() NeighborState {
@@ -240,17 +254,41 @@ func (n *Neighbor) transState(target NeighborState) {
 			n.lastReceivedDDInvalidTimer.Stop()
 		}
 	}
-	LogInfo("neighbor %s state change: %v -> %v", uint32ToIPv4(n.NeighborId).String(), currState, target)
+	LogInfo("neighbor %s state change: %v -> %v", n.i.Area.ins.routerName(n.NeighborId), currState, target)
 	n.State = target
 }
 
+// pktDst returns the IP destination of packets sent to the neighbor.
+// On physical point-to-point networks, the IP destination address must
+// always be set to the address AllSPFRouters. per RFC2328 8.1
+func (n *Neighbor) pktDst() uint32 {
+	if n.i.Type == IfTypePointToPoint {
+		return allSPFRouters
+	}
+	return ipv4BytesToUint32(n.NeighborAddress.To4())
+}
+
 func (n *Neighbor) shouldFormAdjacency() bool {
-	// for now we form adj only if the neighbor is DR or BDR
-	nbAddr := ipv4BytesToUint32(n.NeighborAddress.To4())
-	if nbAddr == n.i.DR.Load() || nbAddr == n.i.BDR.Load() {
+	// An adjacency should be established with a bidirectional neighbor
+	// when at least one of the following conditions holds:
+	switch n.i.Type {
+	case IfTypePointToPoint, IfTypePointToMultiPoint, IfTypeVirtualLink:
+		// o   The underlying network type is point-to-point
+		// o   The underlying network type is Point-to-MultiPoint
+		// o   The underlying network type is virtual link
 		return true
 	}
-	return false
+	var (
+		dr     = n.i.DR.Load()
+		bdr    = n.i.BDR.Load()
+		myAddr = ipv4BytesToUint32(n.i.getAddress().IP.To4())
+		nbAddr = ipv4BytesToUint32(n.NeighborAddress.To4())
+	)
+	// o   The router itself is the Designated Router
+	// o   The router itself is the Backup Designated Router
+	// o   The neighboring router is the Designated Router
+	// o   The neighboring router is the Backup Designated Router
+	return myAddr == dr || myAddr == bdr || nbAddr == dr || nbAddr == bdr
 }
 
 type NeighborStateChangingEvent int
@@ -326,6 +364,30 @@ const (
 )
 
 func (n *Neighbor) consumeEvent(e NeighborStateChangingEvent) {
+	prevSt := n.currState()
+	defer func() {
+		// A neighbor transitioning to 2-Way or higher, or falling back
+		// to Init or lower, changes the set of bidirectional neighbors
+		// associated with the interface.
+		// KillNbr is only generated while the interface itself is going
+		// down, so there is nothing to recalculate.
+		if e == NbEvKillNbr {
+			return
+		}
+		currSt := n.currState()
+		if (prevSt >= Neighbor2Way) != (currSt >= Neighbor2Way) {
+			n.i.consumeEvent(IfEvNeighborChange)
+		}
+		// Becoming or no longer being fully adjacent changes both the
+		// router-LSA and, when acting as DR, the network-LSA.
+		if (prevSt == NeighborFull) != (currSt == NeighborFull) {
+			n.i.updateSelfOriginatedLSAWhenAdjacencyChanged()
+			if currSt == NeighborFull {
+				n.i.Area.ins.checkGracefulRestartDone()
+				n.i.Area.ins.scheduleStubRouterOnStartupEnd()
+			}
+		}
+	}()
 	switch e {
 	case NbEvStart: // NBMA networks only
 		if n.currState() == NeighborDown {
@@ -335,6 +397,8 @@ func (n *Neighbor) consumeEvent(e NeighborStateChangingEvent) {
 			//                    the Inactivity Timer for the neighbor.  The timer's
 			//                    later firing would indicate that communication with
 			//                    the neighbor was not attained.
+			_ = n.i.doHelloTo(ipv4BytesToUint32(n.NeighborAddress.To4()))
+			n.startInactivityTimer()
 		}
 	case NbEvHelloReceived:
 		if n.currState() == NeighborAttempt {
@@ -453,6 +517,7 @@ func (n *Neighbor) consumeEvent(e NeighborStateChangingEvent) {
 			n.startMasterNegotiation()
 		}
 	case NbEvKillNbr:
+		n.exitHelper(GRHelperExitAdjacencyDown)
 		n.clearLSRetransmissionList()
 		n.clearLSReqList()
 		clear(n.DatabaseSummary)
@@ -461,6 +526,7 @@ func (n *Neighbor) consumeEvent(e NeighborStateChangingEvent) {
 		//                    list and Link state request list are cleared of
 		//                    LSAs.  Also, the Inactivity Timer is disabled.
 	case NbEvLLDown:
+		n.exitHelper(GRHelperExitAdjacencyDown)
 		n.clearLSRetransmissionList()
 		n.clearLSReqList()
 		clear(n.DatabaseSummary)
@@ -469,6 +535,12 @@ func (n *Neighbor) consumeEvent(e NeighborStateChangingEvent) {
 		//                    list and Link state request list are cleared of
 		//                    LSAs.  Also, the Inactivity Timer is disabled.
 	case NbEvInactivityTimer:
+		// The adjacency with a restarting neighbor being helped is kept
+		// until the router exits helper mode (RFC3623 3).
+		if n.isHelping() {
+			n.inactiveInHelper.Store(true)
+			return
+		}
 		n.clearLSRetransmissionList()
 		n.clearLSReqList()
 		clear(n.DatabaseSummary)
@@ -478,7 +550,10 @@ func (n *Neighbor) consumeEvent(e NeighborStateChangingEvent) {
 		//                    list and Link state request list are cleared of
 		//                    LSAs.
 	case NbEv1Way:
-		if n.currState() >= Neighbor2Way {
+		// The restarting neighbor does not list the router in its Hellos
+		// until it relearns its neighbors. Keep the conversation while
+		// helping it so the Designated Router does not change.
+		if n.currState() >= Neighbor2Way && !n.isHelping() {
 			n.clearLSRetransmissionList()
 			n.clearLSReqList()
 			clear(n.DatabaseSummary)
@@ -491,6 +566,7 @@ func (n *Neighbor) consumeEvent(e NeighborStateChangingEvent) {
 }
 
 func (n *Neighbor) startInactivityTimer() {
+	n.inactiveInHelper.Store(false)
 	inactiveDur := time.Duration(n.i.RouterDeadInterval) * time.Second
 	if n.InactivityTimer == nil {
 		n.InactivityTimer = time.AfterFunc(inactiveDur,
@@ -513,13 +589,13 @@ func (n *Neighbor) startMasterNegotiation() {
 		n.DDSeqNumber.Store(ddSeqNum)
 	}
 	dd := &packet2.OSPFv2Packet[packet2.DbDescPayload]{
-		OSPFv2: n.i.Area.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
+		OSPFv2: n.i.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
 			p.Type = layers.OSPFDatabaseDescription
 		}),
 		Content: packet2.DbDescPayload{
 			DbDescPkg: layers.DbDescPkg{
-				Options:      uint32(n.i.Area.Options),
-				InterfaceMTU: n.i.MTU,
+				Options:      n.i.ddOptions(),
+				InterfaceMTU: n.i.ddInterfaceMTU(),
 				Flags: uint16(packet2.BitOption(0).SetBit(packet2.DDFlagMSbit,
 					packet2.DDFlagIbit, packet2.DDFlagMbit)),
 				DDSeqNumber: ddSeqNum,
@@ -528,7 +604,7 @@ func (n *Neighbor) startMasterNegotiation() {
 	}
 
 	pkt := sendPkt{
-		dst: ipv4BytesToUint32(n.NeighborAddress.To4()),
+		dst: n.pktDst(),
 		p:   dd,
 	}
 
@@ -562,17 +638,34 @@ func (n *Neighbor) isDuplicatedDD(dd *packet2.OSPFv2Packet[packet2.DbDescPayload
 	return nil, false
 }
 
-func (n *Neighbor) parseDD(dd *packet2.OSPFv2Packet[packet2.DbDescPayload]) {
+// parseDD adds the LSAs of the DD packet newer than ours into the Link state
+// request list. It returns false if the packet has been rejected with the
+// neighbor event SeqNumberMismatch.
+func (n *Neighbor) parseDD(dd *packet2.OSPFv2Packet[packet2.DbDescPayload]) bool {
 	if len(dd.Content.LSAinfo) <= 0 {
-		return
+		return true
+	}
+	for _, l := range dd.Content.LSAinfo {
+		// If the LSA's LS type is AS-external-LSA and the neighbor is
+		// associated with a stub area, generate the neighbor event
+		// SeqNumberMismatch and stop processing the packet.
+		// So are AS-scoped opaque LSAs (RFC5250 3).
+		if (l.LSType == layers.ASExternalLSAtypeV2 || l.LSType == packet2.ASOpaqueLSAtypeV2) &&
+			!n.i.Area.ExternalRoutingCapability {
+			LogWarn("rejected DatabaseDesc from NeighborId(%v): AS-external-LSA described in stub area %v",
+				n.NeighborId, n.i.Area.AreaId)
+			n.consumeEvent(NbEvSeqNumberMismatch)
+			return false
+		}
 	}
-	lsReq := n.i.Area.getLSReqListFromDD(dd)
+	lsReq := n.i.getLSReqListFromDD(dd)
 	n.appendLSReqList(lsReq...)
+	return true
 }
 
 func (n *Neighbor) echoDDWithPossibleRetransmission(dd *packet2.OSPFv2Packet[packet2.DbDescPayload]) {
 	echoDD := &packet2.OSPFv2Packet[packet2.DbDescPayload]{
-		OSPFv2: n.i.Area.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
+		OSPFv2: n.i.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
 			p.Type = layers.OSPFDatabaseDescription
 		}),
 	}
@@ -581,8 +674,8 @@ func (n *Neighbor) echoDDWithPossibleRetransmission(dd *packet2.OSPFv2Packet[pac
 	} else {
 		echoDD.Content = packet2.DbDescPayload{
 			DbDescPkg: layers.DbDescPkg{
-				Options:      uint32(n.i.Area.Options),
-				InterfaceMTU: n.i.MTU,
+				Options:      n.i.ddOptions(),
+				InterfaceMTU: n.i.ddInterfaceMTU(),
 				Flags: func() uint16 {
 					retFlag := packet2.BitOption(0)
 					if len(n.DatabaseSummary) > 0 {
@@ -595,7 +688,7 @@ func (n *Neighbor) echoDDWithPossibleRetransmission(dd *packet2.OSPFv2Packet[pac
 		}
 	}
 	n.i.queuePktForSend(sendPkt{
-		dst: ipv4BytesToUint32(n.NeighborAddress.To4()),
+		dst: n.pktDst(),
 		p:   echoDD,
 	})
 }
@@ -617,20 +710,20 @@ func (n *Neighbor) sendDDExchange() {
 	)
 	// simply one LSA per DD to avoid potential MTU issue.
 	if len(n.DatabaseSummary) >= 1 {
-		toSendLSAs = append(toSendLSAs, n.i.Area.lsDbGetLSAheaderByIdentity(n.DatabaseSummary[0])...)
+		toSendLSAs = append(toSendLSAs, n.i.lsDbGetLSAheaderByIdentity(n.DatabaseSummary[0])...)
 		n.DatabaseSummary = n.DatabaseSummary[1:]
 		if len(n.DatabaseSummary) > 0 {
 			moreBit = true
 		}
 	}
 	dd := &packet2.OSPFv2Packet[packet2.DbDescPayload]{
-		OSPFv2: n.i.Area.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
+		OSPFv2: n.i.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
 			p.Type = layers.OSPFDatabaseDescription
 		}),
 		Content: packet2.DbDescPayload{
 			DbDescPkg: layers.DbDescPkg{
-				Options:      uint32(n.i.Area.Options),
-				InterfaceMTU: n.i.MTU,
+				Options:      n.i.ddOptions(),
+				InterfaceMTU: n.i.ddInterfaceMTU(),
 				Flags: func() uint16 {
 					ret := packet2.BitOption(0).SetBit(packet2.DDFlagMSbit)
 					if moreBit {
@@ -645,7 +738,7 @@ func (n *Neighbor) sendDDExchange() {
 	}
 
 	pkt := sendPkt{
-		dst: ipv4BytesToUint32(n.NeighborAddress.To4()),
+		dst: n.pktDst(),
 		p:   dd,
 	}
 	// Database Description packets are sent when either
@@ -659,14 +752,16 @@ func (n *Neighbor) sendDDExchange() {
 }
 
 func (n *Neighbor) fillDatabaseSummary() {
-	n.DatabaseSummary = n.i.Area.lsDbGetDatabaseSummary()
+	n.DatabaseSummary = n.i.Area.lsDbGetDatabaseSummary(n)
 }
 
 func (n *Neighbor) masterStartDDExchange(dd *packet2.OSPFv2Packet[packet2.DbDescPayload]) {
 	n.fillDatabaseSummary()
 	// for some reason, ROS will send LSA in the slave negotiation result ack packet.
 	// so we must parse those DD payload here
-	n.parseDD(dd)
+	if !n.parseDD(dd) {
+		return
+	}
 	n.sendDDExchange()
 }
 
@@ -690,13 +785,13 @@ func (n *Neighbor) slavePrepareDDExchange() {
 func (n *Neighbor) slaveDDEchoAndExchange(dd *packet2.OSPFv2Packet[packet2.DbDescPayload]) (allDDSent bool) {
 	if len(n.DatabaseSummary) >= 1 {
 		// simply one LSA per DD to avoid potential MTU issue.
-		toSendLSA := n.i.Area.lsDbGetLSAheaderByIdentity(n.DatabaseSummary[0])
+		toSendLSA := n.i.lsDbGetLSAheaderByIdentity(n.DatabaseSummary[0])
 		n.DatabaseSummary = n.DatabaseSummary[1:]
 		allDDSent = len(n.DatabaseSummary) <= 0
 		n.lastSlaveDDSent.Set(&packet2.DbDescPayload{
 			DbDescPkg: layers.DbDescPkg{
-				Options:      uint32(n.i.Area.Options),
-				InterfaceMTU: n.i.MTU,
+				Options:      n.i.ddOptions(),
+				InterfaceMTU: n.i.ddInterfaceMTU(),
 				Flags: func() uint16 {
 					retFlag := packet2.BitOption(0)
 					if !allDDSent {
@@ -787,20 +882,20 @@ func (n *Neighbor) sendOutTopLSR() int {
 		return 0
 	}
 	// calculate max cnt by MTU
-	maxCnt := (int(n.i.MTU) - ipv4.HeaderLen - 24) / packet2.LSReq{}.Size()
+	maxCnt := max(1, (n.i.maxPktLen()-24)/packet2.LSReq{}.Size())
 	singleFlightMax := min(maxCnt, len(n.LSRequest))
 	payloads := make([]packet2.LSReq, 0, singleFlightMax)
 	for i := 0; i < singleFlightMax; i++ {
 		payloads = append(payloads, n.LSRequest[i].GetLSReq())
 	}
 	lsr := &packet2.OSPFv2Packet[packet2.LSRequestPayload]{
-		OSPFv2: n.i.Area.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
+		OSPFv2: n.i.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
 			p.Type = layers.OSPFLinkStateRequest
 		}),
 		Content: packet2.LSRequestPayload(payloads),
 	}
 	n.i.queuePktForSend(sendPkt{
-		dst: ipv4BytesToUint32(n.NeighborAddress.To4()),
+		dst: n.pktDst(),
 		p:   lsr,
 	})
 	return singleFlightMax
@@ -836,7 +931,7 @@ func (n *Neighbor) tryEmptyLSRetransmissionListByAck(lsAcks *packet2.OSPFv2Packe
 		}
 	}
 	// validate it with LSDB
-	lsaHdrs := n.i.Area.lsDbGetLSAheaderByIdentity(validAcks...)
+	lsaHdrs := n.i.lsDbGetLSAheaderByIdentity(validAcks...)
 	lsaHdrsIdLUT := make(map[packet2.LSAIdentity]packet2.LSAheader, len(lsaHdrs))
 	for _, lsaH := range lsaHdrs {
 		lsaHdrsIdLUT[lsaH.GetLSAIdentity()] = lsaH
@@ -880,7 +975,7 @@ func (n *Neighbor) doLSRetransmission() {
 	defer n.lsRtxmRw.RUnlock()
 	lsas := make([]packet2.LSAdvertisement, 0, len(n.LSRetransmission))
 	for l := range n.LSRetransmission {
-		_, lsa, meta, ok := n.i.Area.lsDbGetLSAByIdentity(l, true)
+		_, lsa, meta, ok := n.i.lsDbGetLSAByIdentity(l, true)
 		if !ok {
 			continue
 		}
@@ -888,7 +983,7 @@ func (n *Neighbor) doLSRetransmission() {
 		meta.updateLastFloodTime()
 		lsas = append(lsas, lsa)
 	}
-	n.i.Area.splitSendLSAsByMtu(n.i, lsas, ipv4BytesToUint32(n.NeighborAddress.To4()))
+	n.i.Area.splitSendLSAsByMtu(n.i, lsas, n.pktDst())
 }
 
 func (n *Neighbor) removeFromLSRetransmissionList(lsa packet2.LSAIdentity) {
@@ -912,7 +1007,7 @@ func (n *Neighbor) isInLSRetransmissionList(l packet2.LSAIdentity) bool {
 
 func (n *Neighbor) directSendLSAck(ack packet2.LSAheader) {
 	p := &packet2.OSPFv2Packet[packet2.LSAcknowledgementPayload]{
-		OSPFv2: n.i.Area.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
+		OSPFv2: n.i.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
 			p.Type = layers.OSPFLinkStateAcknowledgment
 		}),
 		Content: packet2.LSAcknowledgementPayload{
@@ -920,20 +1015,20 @@ func (n *Neighbor) directSendLSAck(ack packet2.LSAheader) {
 		},
 	}
 	pkt := sendPkt{
-		dst: ipv4BytesToUint32(n.NeighborAddress.To4()),
+		dst: n.pktDst(),
 		p:   p,
 	}
 	n.i.queuePktForSend(pkt)
 }
 
 func (n *Neighbor) directSendLSU(id packet2.LSAIdentity) {
-	_, lsa, meta, ok := n.i.Area.lsDbGetLSAByIdentity(id, true)
+	_, lsa, meta, ok := n.i.lsDbGetLSAByIdentity(id, true)
 	if !ok {
 		return
 	}
 	defer meta.updateLastFloodTime()
 	p := &packet2.OSPFv2Packet[packet2.LSUpdatePayload]{
-		OSPFv2: n.i.Area.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
+		OSPFv2: n.i.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
 			p.Type = layers.OSPFLinkStateUpdate
 		}),
 		Content: packet2.LSUpdatePayload{
@@ -946,7 +1041,7 @@ func (n *Neighbor) directSendLSU(id packet2.LSAIdentity) {
 		},
 	}
 	pkt := sendPkt{
-		dst: ipv4BytesToUint32(n.NeighborAddress.To4()),
+		dst: n.pktDst(),
 		p:   p,
 	}
 	n.i.queuePktForSend(pkt)
@@ -954,13 +1049,13 @@ func (n *Neighbor) directSendLSU(id packet2.LSAIdentity) {
 
 func (n *Neighbor) directSendDelayedLSAcks(acks []packet2.LSAheader) {
 	p := &packet2.OSPFv2Packet[packet2.LSAcknowledgementPayload]{
-		OSPFv2: n.i.Area.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
+		OSPFv2: n.i.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
 			p.Type = layers.OSPFLinkStateAcknowledgment
 		}),
 		Content: packet2.LSAcknowledgementPayload(acks),
 	}
 	pkt := sendPkt{
-		dst: ipv4BytesToUint32(n.NeighborAddress.To4()),
+		dst: n.pktDst(),
 		p:   p,
 	}
 	n.i.queuePktForSend(pkt)
@@ -0,0 +1,294 @@
+package ospf_cnn
+
+import (
+	"cmp"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"maps"
+	"net"
+	"slices"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// nssaAreas returns the attached not-so-stubby areas.
+func (i *Instance) nssaAreas() (ret []*Area) {
+	for _, a := range i.attachedAreas() {
+		if a.NSSA {
+			ret = append(ret, a)
+		}
+	}
+	return
+}
+
+// originatesASExternalLSAs checks whether the router attaches to any area
+// AS-external-LSAs are flooded into. Routers attached only to stub areas
+// and NSSAs do not originate AS-external-LSAs.
+func (i *Instance) originatesASExternalLSAs() bool {
+	for _, a := range i.attachedAreas() {
+		if a.ExternalRoutingCapability {
+			return true
+		}
+	}
+	return false
+}
+
+// translatedExtLSAs returns the identities of the AS-external-LSAs currently
+// translated from Type-7 LSAs.
+func (i *Instance) translatedExtLSAs() map[packet2.LSAIdentity]struct{} {
+	i.nssaTranslatedMu.Lock()
+	defer i.nssaTranslatedMu.Unlock()
+	ret := make(map[packet2.LSAIdentity]struct{}, len(i.nssaTranslated))
+	for id := range i.nssaTranslated {
+		ret[id] = struct{}{}
+	}
+	return ret
+}
+
+// announcedExtLinkStateIds returns the Link State IDs of the AS-external-LSAs
+// of the announced external routes, keyed by externalRouteKey. The returned
+// map must not be modified.
+func (i *Instance) announcedExtLinkStateIds() map[string]uint32 {
+	i.nssaTranslatedMu.Lock()
+	defer i.nssaTranslatedMu.Unlock()
+	return i.announcedExtIds
+}
+
+// nssaForwardingAddress selects one of the router's interface addresses in the
+// NSSA as the forwarding address of Type-7 LSAs, preferring the operational ones.
+func (a *Area) nssaForwardingAddress() (ret uint32) {
+	for _, ifi := range a.Interfaces {
+		addr := ipv4BytesToUint32(ifi.getAddress().IP.To4())
+		if ifi.currState() > InterfaceDown {
+			return addr
+		}
+		if ret == 0 {
+			ret = addr
+		}
+	}
+	return
+}
+
+// newNSSALSA builds the Type-7 LSA of Link State ID id importing the external
+// route into the NSSA.
+func (a *Area) newNSSALSA(r ExternalRoute, id uint32) packet2.LSAdvertisement {
+	content := a.ins.externalLSAContent(r)
+	options := packet2.BitOption(0)
+	// When the router also originates an AS-external-LSA for the same
+	// network, the P-bit must be clear so that the Type-7 LSA is not
+	// translated by another NSSA border router. Otherwise, it is set and
+	// the forwarding address must be non-zero (RFC3101 2.3).
+	if !a.ins.originatesASExternalLSAs() {
+		options = options.SetBit(packet2.CapabilityNPbit)
+		if content.ForwardingAddress == 0 {
+			content.ForwardingAddress = a.nssaForwardingAddress()
+		}
+	}
+	return packet2.LSAdvertisement{
+		LSAheader: packet2.LSAheader{
+			LSType:      layers.NSSALSAtypeV2,
+			LinkStateID: id,
+			AdvRouter:   a.ins.RouterId,
+			LSSeqNumber: packet2.InitialSequenceNumber,
+			LSOptions:   uint8(options),
+		},
+		Content: content,
+	}
+}
+
+// addNSSALSAs originates Type-7 LSAs of the routes into the NSSA, with the same
+// Link State IDs as the AS-external-LSAs. Already originated ones with changed
+// attributes are re-originated.
+func (a *Area) addNSSALSAs(ids map[string]uint32, routes ...ExternalRoute) {
+	lsas := make([]packet2.LSAdvertisement, 0, len(routes))
+	for _, r := range routes {
+		if id, ok := ids[externalRouteKey(r.Prefix)]; ok {
+			lsas = append(lsas, a.newNSSALSA(r, id))
+		}
+	}
+	lsas = a.skipUnchangedSelfOriginatedLSAs(lsas)
+	if nonExistLSAs := a.batchTryUpdatingExistingLSAs(lsas, nil, func(idx int, lsa *packet2.LSAdvertisement) {
+		lsa.LSOptions = lsas[idx].LSOptions
+		lsa.Content = lsas[idx].Content
+	}); len(nonExistLSAs) > 0 {
+		a.batchOriginatingNewLSAs(nonExistLSAs)
+	}
+}
+
+// selfOriginatedNSSALSAs returns the identities of the live self-originated
+// Type-7 LSAs of the NSSA for which keep returns true.
+func (a *Area) selfOriginatedNSSALSAs(keep func(id packet2.LSAIdentity) bool) (ret []packet2.LSAIdentity) {
+	a.lsDbRw.RLock()
+	defer a.lsDbRw.RUnlock()
+	for id, l := range a.NSSALSAs {
+		if id.AdvRouter == a.ins.RouterId && l.h.LSAge < packet2.MaxAge && keep(id) {
+			ret = append(ret, id)
+		}
+	}
+	return
+}
+
+// isNSSATranslator runs the translator election of the NSSA per RFC3101 3.1.
+// The router translates Type-7 LSAs if its NSSATranslatorRole is Always.
+// Otherwise, it translates unless another reachable NSSA border router has
+// the Nt bit set in its router-LSA or has a higher Router ID.
+func (a *Area) isNSSATranslator(rt *routingTableBuilder) bool {
+	if !a.ins.isABR() {
+		return false
+	}
+	if a.nssaTranslatorAlways {
+		return true
+	}
+	a.lsDbRw.RLock()
+	defer a.lsDbRw.RUnlock()
+	for _, l := range a.RouterLSAs {
+		rtId := l.h.AdvRouter
+		flags := packet2.BitOption(l.l.Flags)
+		if rtId == a.ins.RouterId || l.h.LSAge >= packet2.MaxAge || !flags.IsBitSet(packet2.RouterLSAFlagBbit) {
+			continue
+		}
+		if br, ok := rt.routers[routingRouterKey{area: a.AreaId, rtId: rtId}]; !ok || br.PathType != RoutingPathIntraArea {
+			continue
+		}
+		if flags.IsBitSet(packet2.RouterLSAFlagNtbit) || rtId > a.ins.RouterId {
+			return false
+		}
+	}
+	return true
+}
+
+// translateNSSALSAs translates the Type-7 LSAs of the NSSAs the router is
+// elected as translator for into AS-external-LSAs per RFC3101 3.2. Only
+// Type-7 LSAs with the P-bit set and a non-zero forwarding address, whose
+// originators are reachable through the NSSA, are translated. The
+// AS-external-LSAs no longer translated are flushed.
+//
+// The external routes announced by the router itself take precedence: the
+// Type-7 LSAs of the same destinations are not translated, and the Link
+// State IDs of the translated ones avoid theirs per RFC2328 Appendix E.
+func (i *Instance) translateNSSALSAs(rt *routingTableBuilder) {
+	prev := i.translatedExtLSAs()
+	announced := i.announcedExtLinkStateIds()
+	// The Type-7 LSAs to translate, keyed by destination.
+	candidates := make(map[routingNetworkKey]*LSDBASExternalItem)
+	for _, a := range i.nssaAreas() {
+		translating := a.isNSSATranslator(rt)
+		if a.nssaTranslating.Swap(translating) != translating {
+			LogInfo("area %v NSSA translator state changed: translating(%v)", a.AreaId, translating)
+		}
+		if !translating {
+			continue
+		}
+		var nssaLSAs []*LSDBASExternalItem
+		a.lsDbRw.RLock()
+		for _, l := range a.NSSALSAs {
+			if l.h.LSAge >= packet2.MaxAge || l.h.AdvRouter == i.RouterId || l.l.Metric >= packet2.LSInfinity ||
+				l.l.ForwardingAddress == 0 || !packet2.BitOption(l.h.LSOptions).IsBitSet(packet2.CapabilityNPbit) {
+				continue
+			}
+			nssaLSAs = append(nssaLSAs, &LSDBASExternalItem{h: l.h, l: l.l})
+		}
+		a.lsDbRw.RUnlock()
+		for _, l := range nssaLSAs {
+			asbr, ok := rt.routers[routingRouterKey{area: a.AreaId, rtId: l.h.AdvRouter}]
+			if !ok || asbr.PathType != RoutingPathIntraArea || !asbr.IsASBR {
+				continue
+			}
+			k := routingNetworkKey{addr: l.h.LinkStateID & l.l.NetworkMask, mask: l.l.NetworkMask}
+			if _, ok = announced[externalRouteKey(net.IPNet{IP: uint32ToIPv4(k.addr), Mask: uint32ToIPv4Mask(k.mask)})]; ok {
+				continue
+			}
+			// Of multiple Type-7 LSAs for the same destination, the one with
+			// type 1 metric, then the least metric and then the higher
+			// Router ID is translated.
+			if exist, ok := candidates[k]; ok && compareTranslatedNSSALSAs(exist, l) <= 0 {
+				continue
+			}
+			candidates[k] = l
+		}
+	}
+
+	keys := slices.SortedFunc(maps.Keys(candidates), func(x, y routingNetworkKey) int {
+		return compareNetworksByMaskLength(x.addr, x.mask, y.addr, y.mask)
+	})
+	used := make(linkStateIdAssigner, len(announced)+len(keys))
+	for _, id := range announced {
+		used[id] = struct{}{}
+	}
+	desired := make(map[packet2.LSAIdentity]packet2.LSAdvertisement, len(keys))
+	for _, k := range keys {
+		lsId, ok := used.assign(k.addr, k.mask)
+		if !ok {
+			LogWarn("skipped translating Type-7 LSA of %v/%v: Link State ID conflicted",
+				uint32ToIPv4(k.addr), uint32ToIPv4(k.mask))
+			continue
+		}
+		id := packet2.LSAIdentity{
+			LSType:      layers.ASExternalLSAtypeV2,
+			LinkStateId: lsId,
+			AdvRouter:   i.RouterId,
+		}
+		desired[id] = packet2.LSAdvertisement{
+			LSAheader: packet2.LSAheader{
+				LSType:      id.LSType,
+				LinkStateID: id.LinkStateId,
+				AdvRouter:   id.AdvRouter,
+				LSSeqNumber: packet2.InitialSequenceNumber,
+				LSOptions:   uint8(packet2.BitOption(0).SetBit(packet2.CapabilityEbit)),
+			},
+			Content: candidates[k].l,
+		}
+	}
+
+	translated := make(map[packet2.LSAIdentity]struct{}, len(desired))
+	lsas := make([]packet2.LSAdvertisement, 0, len(desired))
+	for id, l := range desired {
+		translated[id] = struct{}{}
+		lsas = append(lsas, l)
+	}
+	i.nssaTranslatedMu.Lock()
+	i.nssaTranslated = translated
+	i.nssaTranslatedMu.Unlock()
+
+	lsas = i.Backbone.skipUnchangedSelfOriginatedLSAs(lsas)
+	if nonExistLSAs := i.Backbone.batchTryUpdatingExistingLSAs(lsas, nil, func(idx int, lsa *packet2.LSAdvertisement) {
+		lsa.LSOptions = lsas[idx].LSOptions
+		lsa.Content = lsas[idx].Content
+	}); len(nonExistLSAs) > 0 {
+		i.Backbone.batchOriginatingNewLSAs(nonExistLSAs)
+	}
+	announcedIds := make(map[uint32]struct{}, len(announced))
+	for _, id := range announced {
+		announcedIds[id] = struct{}{}
+	}
+	var stale []packet2.LSAIdentity
+	for id := range prev {
+		if _, ok := desired[id]; ok {
+			continue
+		}
+		// Taken over by an announced route.
+		if _, ok := announcedIds[id.LinkStateId]; ok {
+			continue
+		}
+		if ext, ok := i.lsDbGetExtLSA(id); ok && ext.h.LSAge < packet2.MaxAge {
+			stale = append(stale, id)
+		}
+	}
+	if len(stale) > 0 {
+		LogDebug("flushing %d AS-external-LSAs no longer translated from Type-7 LSAs", len(stale))
+		i.Backbone.prematureLSA(stale...)
+	}
+}
+
+// compareTranslatedNSSALSAs compares Type-7 LSAs of the same destination to
+// translate. A negative result means x is preferred.
+func compareTranslatedNSSALSAs(x, y *LSDBASExternalItem) int {
+	xE := packet2.BitOption(x.l.ExternalBit).IsBitSet(packet2.ASExternalLSAFlagEbit)
+	yE := packet2.BitOption(y.l.ExternalBit).IsBitSet(packet2.ASExternalLSAFlagEbit)
+	if xE != yE {
+		if xE {
+			return 1
+		}
+		return -1
+	}
+	return cmp.Or(cmp.Compare(x.l.Metric, y.l.Metric), cmp.Compare(y.h.AdvRouter, x.h.AdvRouter))
+}
@@ -0,0 +1,162 @@
+package ospf_cnn
+
+import (
+	"context"
+	"fmt"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"maps"
+	"testing"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func (i *Instance) testNSSA(areaId string) *Area {
+	a := NewArea(context.Background(), &AreaConfig{
+		Instance: i,
+		AreaId:   ip(areaId),
+		NSSA:     true,
+	})
+	i.Areas = append(i.Areas, a)
+	return a
+}
+
+func (a *Area) testNSSALSA(id, advRouter, mask string, metric uint32, fwd string, propagate bool) {
+	h := testLSAHeader(layers.NSSALSAtypeV2, id, advRouter)
+	if propagate {
+		h.LSOptions = uint8(packet2.BitOption(0).SetBit(packet2.CapabilityNPbit))
+	}
+	l := packet2.V2ASExternalLSA{NetworkMask: ip(mask), Metric: metric, ForwardingAddress: ip(fwd),
+		ExternalBit: uint8(packet2.BitOption(0).SetBit(packet2.ASExternalLSAFlagEbit))}
+	a.NSSALSAs[h.GetLSAIdentity()] = &LSDBASExternalItem{h: h, l: l}
+}
+
+// newTestNSSABorder adds NSSA 0.0.0.2 to the backbone of newTestBackbone, in
+// which AS boundary routers 6.6.6.6 and 7.7.7.7 are attached to 10.3.0.0/24.
+func newTestNSSABorder() *Instance {
+	i := newTestBackbone()
+	i.Backbone.testRouterLSA("1.1.1.1", flagB, testLink(2, "10.0.0.1", "10.0.0.1", 10))
+	a := i.testNSSA("0.0.0.2")
+	a.testInterface("10.3.0.1/24")
+	a.testRouterLSA("1.1.1.1", flagB, testLink(2, "10.3.0.1", "10.3.0.1", 10))
+	a.testRouterLSA("6.6.6.6", flagE, testLink(2, "10.3.0.1", "10.3.0.6", 10))
+	a.testRouterLSA("7.7.7.7", flagE, testLink(2, "10.3.0.1", "10.3.0.7", 10))
+	a.testNetworkLSA("10.3.0.1", "1.1.1.1", "255.255.255.0", "1.1.1.1", "6.6.6.6", "7.7.7.7")
+	return i
+}
+
+func TestFunctionallySameExternalRoutes(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		setup    func(i *Instance, nssa *Area)
+		expected string
+	}{
+		{
+			name: "Type-5 preferred to Type-7 without P-bit",
+			setup: func(i *Instance, nssa *Area) {
+				i.testExternalLSA("8.8.0.0", "5.5.5.5", "255.255.0.0", ExternalMetricType2, 20, "10.3.0.9")
+				nssa.testNSSALSA("8.8.0.0", "7.7.7.7", "255.255.0.0", 20, "10.3.0.9", false)
+			},
+			expected: "5.5.5.5",
+		},
+		{
+			name: "Type-7 with P-bit preferred to Type-5",
+			setup: func(i *Instance, nssa *Area) {
+				i.testExternalLSA("8.8.0.0", "5.5.5.5", "255.255.0.0", ExternalMetricType2, 20, "10.3.0.9")
+				nssa.testNSSALSA("8.8.0.0", "6.6.6.6", "255.255.0.0", 20, "10.3.0.9", true)
+			},
+			expected: "6.6.6.6",
+		},
+		{
+			name: "higher Router ID preferred",
+			setup: func(i *Instance, nssa *Area) {
+				nssa.testNSSALSA("8.8.0.0", "7.7.7.7", "255.255.0.0", 20, "10.3.0.9", true)
+				nssa.testNSSALSA("8.8.0.0", "6.6.6.6", "255.255.0.0", 20, "10.3.0.9", true)
+			},
+			expected: "7.7.7.7",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newTestNSSABorder()
+			tt.setup(i, i.getArea(ip("0.0.0.2")))
+			// Regardless of the order of the LSAs examined.
+			for range 10 {
+				rt, _ := i.calculateRoutingTable()
+				e, ok := rt.networks[routingNetworkKey{addr: ip("8.8.0.0"), mask: ip("255.255.0.0")}]
+				if !ok {
+					t.Fatalf("expecting route to 8.8.0.0/16 but got none")
+				}
+				if e.AdvertisingRouter != ip(tt.expected) {
+					t.Fatalf("expecting the LSA of %s preferred but got %v", tt.expected, uint32ToIPv4(e.AdvertisingRouter))
+				}
+			}
+		})
+	}
+}
+
+func TestTranslateNSSALSAs(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		announced map[string]uint32
+		setup     func(nssa *Area)
+		// the translated masks and metrics keyed by Link State ID
+		expected map[string]string
+	}{
+		{
+			name: "translated",
+			setup: func(nssa *Area) {
+				nssa.testNSSALSA("8.8.0.0", "6.6.6.6", "255.255.0.0", 20, "10.3.0.6", true)
+				nssa.testNSSALSA("8.9.0.0", "6.6.6.6", "255.255.0.0", 20, "10.3.0.6", false)
+			},
+			expected: map[string]string{"8.8.0.0": "255.255.0.0 20"},
+		},
+		{
+			name: "least metric translated",
+			setup: func(nssa *Area) {
+				nssa.testNSSALSA("8.8.0.0", "6.6.6.6", "255.255.0.0", 30, "10.3.0.6", true)
+				nssa.testNSSALSA("8.8.0.0", "7.7.7.7", "255.255.0.0", 20, "10.3.0.7", true)
+			},
+			expected: map[string]string{"8.8.0.0": "255.255.0.0 20"},
+		},
+		{
+			name: "Link State IDs per Appendix E",
+			setup: func(nssa *Area) {
+				nssa.testNSSALSA("8.8.0.0", "6.6.6.6", "255.255.255.0", 20, "10.3.0.6", true)
+				nssa.testNSSALSA("8.8.0.0", "7.7.7.7", "255.255.0.0", 20, "10.3.0.7", true)
+			},
+			expected: map[string]string{"8.8.0.0": "255.255.0.0 20", "8.8.0.255": "255.255.255.0 20"},
+		},
+		{
+			name:      "announced route not translated",
+			announced: map[string]uint32{"8.8.0.0/16": ip("8.8.0.0")},
+			setup: func(nssa *Area) {
+				nssa.testNSSALSA("8.8.0.0", "6.6.6.6", "255.255.0.0", 20, "10.3.0.6", true)
+			},
+			expected: map[string]string{},
+		},
+		{
+			name:      "Link State ID of announced route avoided",
+			announced: map[string]uint32{"8.8.0.0/24": ip("8.8.0.0")},
+			setup: func(nssa *Area) {
+				nssa.testNSSALSA("8.8.0.0", "6.6.6.6", "255.255.0.0", 20, "10.3.0.6", true)
+			},
+			expected: map[string]string{"8.8.255.255": "255.255.0.0 20"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newTestNSSABorder()
+			i.announcedExtIds = tt.announced
+			tt.setup(i.getArea(ip("0.0.0.2")))
+			rt, _ := i.calculateRoutingTable()
+			i.translateNSSALSAs(rt)
+			got := make(map[string]string)
+			for id, l := range i.ASExternalLSAs {
+				if id.AdvRouter == i.RouterId {
+					got[uint32ToIPv4(id.LinkStateId).String()] = fmt.Sprintf("%v %d", uint32ToIPv4(l.l.NetworkMask), l.l.Metric)
+				}
+			}
+			if !maps.Equal(got, tt.expected) {
+				t.Errorf("expecting %v translated but got %v", tt.expected, got)
+			}
+		})
+	}
+}
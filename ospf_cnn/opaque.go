@@ -0,0 +1,421 @@
+package ospf_cnn
+
+import (
+	"errors"
+	"fmt"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"time"
+)
+
+// OpaqueLSA is an opaque LSA originated by the router, carrying
+// application-specific information as TLVs (see RFC5250).
+type OpaqueLSA struct {
+	// The LS type tells the flooding scope of the opaque LSA: link-local
+	// (type 9), area-local (type 10) or the entire AS (type 11).
+	LSType uint16
+	// The Opaque Type and Opaque ID make up the Link State ID.
+	// The Opaque ID is only 24 bits long.
+	OpaqueType uint8
+	OpaqueId   uint32
+	// The area the area-local opaque LSA is flooded throughout.
+	AreaId uint32
+	// The interface the link-local opaque LSA is flooded out.
+	IfName string
+	// The payload of the opaque LSA.
+	TLVs []packet2.OpaqueTLV
+}
+
+func (o OpaqueLSA) validate() error {
+	if !packet2.IsOpaqueLSType(o.LSType) {
+		return fmt.Errorf("invalid LS type %d of opaque LSA", o.LSType)
+	}
+	if o.OpaqueId >= 1<<24 {
+		return fmt.Errorf("opaque ID %d of opaque LSA exceeds 24 bits", o.OpaqueId)
+	}
+	if o.LSType == packet2.LinkLocalOpaqueLSAtypeV2 && o.IfName == "" {
+		return errors.New("interface of link-local opaque LSA is not specified")
+	}
+	return nil
+}
+
+func (o OpaqueLSA) identity(rtId uint32) packet2.LSAIdentity {
+	return packet2.LSAIdentity{
+		LSType:      o.LSType,
+		LinkStateId: packet2.OpaqueLinkStateID(o.OpaqueType, o.OpaqueId),
+		AdvRouter:   rtId,
+	}
+}
+
+func (o OpaqueLSA) newLSA(rtId uint32, options packet2.BitOption) packet2.LSAdvertisement {
+	id := o.identity(rtId)
+	return packet2.LSAdvertisement{
+		LSAheader: packet2.LSAheader{
+			LSType:      id.LSType,
+			LinkStateID: id.LinkStateId,
+			AdvRouter:   id.AdvRouter,
+			LSSeqNumber: packet2.InitialSequenceNumber,
+			LSOptions:   uint8(options),
+		},
+		Content: packet2.NewV2OpaqueLSA(o.TLVs...),
+	}
+}
+
+// OriginateOpaqueLSA originates the opaque LSA, or updates the one with the
+// same LS type and Link State ID previously originated.
+func (r *Router) OriginateOpaqueLSA(o OpaqueLSA) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	return r.ins.originateOpaqueLSA(o)
+}
+
+// WithdrawOpaqueLSA flushes the opaque LSA previously originated from the
+// routing domain. The TLVs are ignored.
+func (r *Router) WithdrawOpaqueLSA(o OpaqueLSA) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	return r.ins.withdrawOpaqueLSA(o)
+}
+
+// getInterfaceByName returns the interface ifName, including virtual links.
+func (i *Instance) getInterfaceByName(ifName string) *Interface {
+	for _, a := range i.allAreas() {
+		for _, ifi := range a.Interfaces {
+			if ifi.c.ifi.Name == ifName {
+				return ifi
+			}
+		}
+	}
+	return nil
+}
+
+// opaqueLSAOwner returns the interface or the area whose link state database
+// the opaque LSA is installed into. AS-scoped opaque LSAs are installed
+// via the backbone like AS-external-LSAs.
+func (i *Instance) opaqueLSAOwner(o OpaqueLSA) (*Interface, *Area, error) {
+	switch o.LSType {
+	case packet2.LinkLocalOpaqueLSAtypeV2:
+		ifi := i.getInterfaceByName(o.IfName)
+		if ifi == nil {
+			return nil, nil, fmt.Errorf("interface %s not found", o.IfName)
+		}
+		return ifi, ifi.Area, nil
+	case packet2.AreaLocalOpaqueLSAtypeV2:
+		a := i.getArea(o.AreaId)
+		if a == nil {
+			return nil, nil, fmt.Errorf("area %v not attached", uint32ToIPv4(o.AreaId))
+		}
+		return nil, a, nil
+	default:
+		return nil, i.Backbone, nil
+	}
+}
+
+func (i *Instance) originateOpaqueLSA(o OpaqueLSA) error {
+	ifi, a, err := i.opaqueLSAOwner(o)
+	if err != nil {
+		return err
+	}
+	lsa := o.newLSA(i.RouterId, a.Options)
+	if ifi != nil {
+		ifi.originateLinkLocalLSA(lsa)
+		return nil
+	}
+	if !a.tryUpdatingExistingLSA(lsa.GetLSAIdentity(), nil, func(l *packet2.LSAdvertisement) {
+		l.LSOptions, l.Content = lsa.LSOptions, lsa.Content
+	}) {
+		a.originatingNewLSA(lsa)
+	}
+	return nil
+}
+
+func (i *Instance) withdrawOpaqueLSA(o OpaqueLSA) error {
+	ifi, a, err := i.opaqueLSAOwner(o)
+	if err != nil {
+		return err
+	}
+	id := o.identity(i.RouterId)
+	if ifi != nil {
+		if h, _, _, ok := ifi.lsDbGetLSAByIdentity(id, false); ok && h.LSAge < packet2.MaxAge {
+			ifi.prematureLinkLocalLSA(id)
+		}
+		return nil
+	}
+	if h, _, _, ok := a.lsDbGetLSAByIdentity(id, false); ok && h.LSAge < packet2.MaxAge {
+		a.prematureLSA(id)
+	}
+	return nil
+}
+
+type LSDBOpaqueItem struct {
+	*lsaMeta
+	h packet2.LSAheader
+	l packet2.V2OpaqueLSA
+}
+
+func (l *LSDBOpaqueItem) doAging() uint16 {
+	l.h.LSAge = l.age()
+	return l.h.LSAge
+}
+
+func (i *Instance) lsDbGetASOpaqueLSA(id packet2.LSAIdentity) (*LSDBOpaqueItem, bool) {
+	i.extRw.RLock()
+	defer i.extRw.RUnlock()
+	item, ok := i.ASOpaqueLSAs[id]
+	return item, ok
+}
+
+func (i *Instance) lsDbSetASOpaqueLSA(id packet2.LSAIdentity, item *LSDBOpaqueItem) {
+	i.extRw.Lock()
+	defer i.extRw.Unlock()
+	i.ASOpaqueLSAs[id] = item
+	i.updateHostname(riLSAKey{id: id}, item)
+}
+
+func (i *Instance) lsDbRangeASOpaqueLSA(next func(id packet2.LSAIdentity, item *LSDBOpaqueItem) bool) {
+	i.extRw.RLock()
+	defer i.extRw.RUnlock()
+	for id, item := range i.ASOpaqueLSAs {
+		if !next(id, item) {
+			return
+		}
+	}
+}
+
+func (i *Instance) lsDbDeleteASOpaqueLSA(id packet2.LSAIdentity) {
+	i.extRw.Lock()
+	defer i.extRw.Unlock()
+	delete(i.ASOpaqueLSAs, id)
+	i.updateHostname(riLSAKey{id: id}, nil)
+}
+
+// lsDbGetLSAByIdentity looks up the LSA in the link state database the
+// interface is attached to. Link-local opaque LSAs are kept by the
+// interface itself, the others by its area.
+func (i *Interface) lsDbGetLSAByIdentity(id packet2.LSAIdentity, entireLSA bool) (lsaHdr packet2.LSAheader,
+	fullLSA packet2.LSAdvertisement, meta *lsaMeta, exist bool) {
+	if id.LSType != packet2.LinkLocalOpaqueLSAtypeV2 {
+		return i.Area.lsDbGetLSAByIdentity(id, entireLSA)
+	}
+	i.opaqueRw.RLock()
+	defer i.opaqueRw.RUnlock()
+	if opqLSA, ok := i.LinkLocalOpaqueLSAs[id]; ok {
+		lsaHdr, meta, exist = opqLSA.h, opqLSA.lsaMeta, true
+		if entireLSA {
+			fullLSA.LSAheader, fullLSA.Content = opqLSA.h, opqLSA.l
+		}
+	}
+	return
+}
+
+func (i *Interface) lsDbGetLSAheaderByIdentity(ids ...packet2.LSAIdentity) (ret []packet2.LSAheader) {
+	for _, id := range ids {
+		if lsaH, _, _, ok := i.lsDbGetLSAByIdentity(id, false); ok {
+			ret = append(ret, lsaH)
+		}
+	}
+	return
+}
+
+func (i *Interface) lsDbInstallLinkLocalLSA(lsa packet2.LSAdvertisement, meta *lsaMeta) error {
+	item, err := lsa.AsV2OpaqueLSA()
+	if err != nil {
+		return err
+	}
+	i.opaqueRw.Lock()
+	defer i.opaqueRw.Unlock()
+	if i.LinkLocalOpaqueLSAs == nil {
+		i.LinkLocalOpaqueLSAs = make(map[packet2.LSAIdentity]*LSDBOpaqueItem)
+	}
+	l := &LSDBOpaqueItem{
+		lsaMeta: meta,
+		h:       item.LSAheader, l: item.Content,
+	}
+	i.LinkLocalOpaqueLSAs[lsa.GetLSAIdentity()] = l
+	i.Area.ins.updateHostname(riLSAKey{ifi: i, id: lsa.GetLSAIdentity()}, l)
+	return nil
+}
+
+func (i *Interface) lsDbInstallReceivedLSA(lsa packet2.LSAdvertisement) {
+	if lsa.LSType != packet2.LinkLocalOpaqueLSAtypeV2 {
+		i.Area.lsDbInstallReceivedLSA(lsa)
+		return
+	}
+	LogDebug("interface %s installing received LSA: %+v", i.c.ifi.Name, lsa)
+	err := i.lsDbInstallLinkLocalLSA(lsa, &lsaMeta{
+		ctime:    time.Now().Add(-time.Duration(lsa.LSAge) * time.Second),
+		recvTime: time.Now(),
+	})
+	if err != nil {
+		LogErr("interface %s err install received LSA", i.c.ifi.Name)
+	}
+}
+
+func (i *Interface) lsDbDeleteLinkLocalLSA(id packet2.LSAIdentity) {
+	i.opaqueRw.Lock()
+	defer i.opaqueRw.Unlock()
+	delete(i.LinkLocalOpaqueLSAs, id)
+	i.Area.ins.updateHostname(riLSAKey{ifi: i, id: id}, nil)
+}
+
+func (i *Interface) lsDbGetLinkLocalSummary() (ret []packet2.LSAIdentity) {
+	i.opaqueRw.RLock()
+	defer i.opaqueRw.RUnlock()
+	for id := range i.LinkLocalOpaqueLSAs {
+		ret = append(ret, id)
+	}
+	return
+}
+
+// removeAllNeighborsLSRetransmission removes the LSA from the Link state
+// retransmission lists of the neighbors it is flooded to.
+func (i *Interface) removeAllNeighborsLSRetransmission(id packet2.LSAIdentity) {
+	if id.LSType != packet2.LinkLocalOpaqueLSAtypeV2 {
+		i.Area.removeAllNeighborsLSRetransmission(id)
+		return
+	}
+	i.rangeOverNeighbors(func(nb *Neighbor) bool {
+		nb.removeFromLSRetransmissionList(id)
+		return true
+	})
+}
+
+// agingLinkLocalLSA ages the link-local opaque LSAs of the interface.
+// Self-originated ones are refreshed every LSRefreshTime, and MaxAge ones
+// are flushed once acknowledged by all neighbors on the link.
+func (i *Interface) agingLinkLocalLSA() (maxAgedCnt int) {
+	var refresh, flush []packet2.LSAIdentity
+	i.opaqueRw.Lock()
+	for id, l := range i.LinkLocalOpaqueLSAs {
+		isSelfOriginated := l.h.AdvRouter == i.Area.ins.RouterId
+		age := l.doAging()
+		if age < packet2.MaxAge && (!isSelfOriginated || age < packet2.LSRefreshTime) {
+			continue
+		}
+		maxAgedCnt++
+		if isSelfOriginated && !l.isDoNotRefresh() {
+			refresh = append(refresh, id)
+		} else if age >= packet2.MaxAge {
+			flush = append(flush, id)
+		}
+	}
+	i.opaqueRw.Unlock()
+
+	for _, id := range refresh {
+		if _, lsa, _, ok := i.lsDbGetLSAByIdentity(id, true); ok {
+			LogDebug("interface %s refreshing self-originated LSA(%+v)", i.c.ifi.Name, id)
+			i.originateLinkLocalLSA(lsa)
+		}
+	}
+	for _, id := range flush {
+		i.lsDbFlushMaxAgedLinkLocalLSA(id)
+	}
+	return
+}
+
+func (i *Interface) lsDbFlushMaxAgedLinkLocalLSA(id packet2.LSAIdentity) {
+	// A MaxAge LSA must be removed immediately from the router's link
+	//    state database as soon as both a) it is no longer contained on any
+	//    neighbor Link state retransmission lists and b) none of the router's
+	//    neighbors are in states Exchange or Loading.
+	canFlush := true
+	i.rangeOverNeighbors(func(nb *Neighbor) bool {
+		nbSt := nb.currState()
+		if nbSt == NeighborExchange || nbSt == NeighborLoading || nb.isInLSRetransmissionList(id) {
+			canFlush = false
+			return false
+		}
+		return true
+	})
+	if canFlush {
+		i.lsDbDeleteLinkLocalLSA(id)
+		LogDebug("interface %s successfully flushed MaxAged LSA: %+v", i.c.ifi.Name, id)
+	}
+}
+
+// originateLinkLocalLSA installs the self-originated link-local opaque LSA
+// and floods it out the interface. It supersedes any existing instance.
+func (i *Interface) originateLinkLocalLSA(lsa packet2.LSAdvertisement) {
+	if h, _, _, ok := i.lsDbGetLSAByIdentity(lsa.GetLSAIdentity(), false); ok {
+		lsa.LSSeqNumber = h.LSSeqNumber
+		if !lsa.PrepareReOriginating(true) {
+			// The existing instance must be flushed before a new one with
+			// InitialSequenceNumber can be originated.
+			LogWarn("interface %s LSA(%+v) reached MaxSequenceNumber. Flushing it",
+				i.c.ifi.Name, lsa.GetLSAIdentity())
+			i.prematureLinkLocalLSA(lsa.GetLSAIdentity())
+			return
+		}
+	} else {
+		i.Area.ins.restoreSeqNumber(&lsa)
+	}
+	lsa.LSAge = 0
+	if err := lsa.FixLengthAndChkSum(); err != nil {
+		LogErr("interface %s err fix chkSum while originating new LSA(%+v)", i.c.ifi.Name, lsa.GetLSAIdentity())
+		return
+	}
+	if err := i.lsDbInstallLinkLocalLSA(lsa, newLSAMeta()); err != nil {
+		LogErr("interface %s err install new LSA(%+v)", i.c.ifi.Name, lsa.GetLSAIdentity())
+		return
+	}
+	LogDebug("interface %s successfully originated new LSA(%+v)", i.c.ifi.Name, lsa.GetLSAIdentity())
+	i.Area.ins.floodLSA(i.Area, i, i.Area.ins.RouterId, lsa.LSAheader)
+}
+
+// prematureLinkLocalLSA flushes the self-originated link-local opaque LSAs
+// by setting their LS age to MaxAge and reflooding them.
+func (i *Interface) prematureLinkLocalLSA(ids ...packet2.LSAIdentity) {
+	var allLSAh []packet2.LSAheader
+	i.Area.ins.suspendAgingLSDB()
+	for _, id := range ids {
+		_, lsa, meta, ok := i.lsDbGetLSAByIdentity(id, true)
+		if !ok {
+			LogWarn("interface %s err premature LSA(%+v): LSA not found in LSDB", i.c.ifi.Name, id)
+			continue
+		}
+		meta.premature()
+		lsa.LSAge = packet2.MaxAge
+		if err := i.lsDbInstallLinkLocalLSA(lsa, meta); err != nil {
+			LogErr("interface %s err premature LSA(%+v)", i.c.ifi.Name, id)
+			continue
+		}
+		LogDebug("interface %s is pre-maturing LSA(%+v)", i.c.ifi.Name, id)
+		allLSAh = append(allLSAh, lsa.LSAheader)
+	}
+	i.Area.ins.continueAgingLSDB()
+	if len(allLSAh) > 0 {
+		i.Area.ins.floodLSA(i.Area, i, i.Area.ins.RouterId, allLSAh...)
+	}
+}
+
+// lsDbFlushLinkLocalLSA flushes all self-originated link-local opaque LSAs
+// of the interface before shutting down.
+func (i *Interface) lsDbFlushLinkLocalLSA() {
+	var selfOriginated []packet2.LSAIdentity
+	i.opaqueRw.RLock()
+	for id, l := range i.LinkLocalOpaqueLSAs {
+		if l.h.AdvRouter == i.Area.ins.RouterId && l.h.LSAge < packet2.MaxAge {
+			selfOriginated = append(selfOriginated, id)
+		}
+	}
+	i.opaqueRw.RUnlock()
+	if len(selfOriginated) > 0 {
+		LogDebug("interface %s flushing %d self-originated link-local LSAs before shutting down",
+			i.c.ifi.Name, len(selfOriginated))
+		i.prematureLinkLocalLSA(selfOriginated...)
+	}
+}
+
+// dealWithReceivedSelfOriginatedLinkLocalLSA handles the received link-local
+// opaque LSA claiming to be originated by the router per RFC2328 13.4.
+func (i *Interface) dealWithReceivedSelfOriginatedLinkLocalLSA(l packet2.LSAdvertisement, existInLSDB bool) {
+	if !existInLSDB {
+		// Originated before the router restarted and no longer wished.
+		i.prematureLinkLocalLSA(l.GetLSAIdentity())
+		return
+	}
+	LogDebug("interface %s adapted newer self-originated LSA(%v). Trying incr its SeqNum and re-flood it out",
+		i.c.ifi.Name, l.GetLSAIdentity())
+	i.originateLinkLocalLSA(l)
+}
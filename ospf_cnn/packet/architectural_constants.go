@@ -58,6 +58,11 @@ const (
 	//        defined to be the 24-bit binary value of all ones: 0xffffff.
 	LSInfinity = 0xffffff
 
+	// MaxLinkMetric The maximum 16-bit metric of router-LSA links. A stub
+	//        router advertises its non-stub links with this metric so that
+	//        other routers avoid transiting it (see RFC6987 2).
+	MaxLinkMetric = 0xffff
+
 	// DefaultDestination The Destination ID that indicates the default route.  This route
 	//        is used when no other matching routing table entry can be found.
 	//        The default destination can only be advertised in AS-external-
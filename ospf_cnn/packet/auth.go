@@ -0,0 +1,210 @@
+package packet
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+
+	"github.com/gopacket/gopacket"
+)
+
+// OSPF authentication types. per RFC2328 D.
+const (
+	AuTypeNull           uint16 = 0
+	AuTypeSimplePassword uint16 = 1
+	AuTypeCryptographic  uint16 = 2
+)
+
+// AuthAlgorithm is the algorithm generating the message digest of
+// cryptographic authentication.
+type AuthAlgorithm uint8
+
+const (
+	// AuthAlgorithmMD5 is the keyed MD5 per RFC2328 D.3.
+	AuthAlgorithmMD5 AuthAlgorithm = iota
+	// HMAC-SHA algorithms per RFC5709.
+	AuthAlgorithmHMACSHA1
+	AuthAlgorithmHMACSHA256
+	AuthAlgorithmHMACSHA384
+	AuthAlgorithmHMACSHA512
+)
+
+func (a AuthAlgorithm) String() string {
+	switch a {
+	case AuthAlgorithmMD5:
+		return "MD5"
+	case AuthAlgorithmHMACSHA1:
+		return "HMAC-SHA-1"
+	case AuthAlgorithmHMACSHA256:
+		return "HMAC-SHA-256"
+	case AuthAlgorithmHMACSHA384:
+		return "HMAC-SHA-384"
+	case AuthAlgorithmHMACSHA512:
+		return "HMAC-SHA-512"
+	}
+	return fmt.Sprintf("AuthAlgorithm(%d)", uint8(a))
+}
+
+func (a AuthAlgorithm) hash() (func() hash.Hash, error) {
+	switch a {
+	case AuthAlgorithmMD5:
+		return md5.New, nil
+	case AuthAlgorithmHMACSHA1:
+		return sha1.New, nil
+	case AuthAlgorithmHMACSHA256:
+		return sha256.New, nil
+	case AuthAlgorithmHMACSHA384:
+		return sha512.New384, nil
+	case AuthAlgorithmHMACSHA512:
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported authentication algorithm %v", a)
+}
+
+// DigestLen returns the length of the message digest appended to the packet.
+// It is also the Auth Data Len of the authentication field.
+func (a AuthAlgorithm) DigestLen() int {
+	h, err := a.hash()
+	if err != nil {
+		return 0
+	}
+	return h().Size()
+}
+
+// MaxKeyLen returns the max length of the secret key. Keyed MD5 key is
+// padded to 16 bytes, while HMAC-SHA key of any length is accepted.
+func (a AuthAlgorithm) MaxKeyLen() int {
+	if a == AuthAlgorithmMD5 {
+		return md5.Size
+	}
+	return -1
+}
+
+// SimplePasswordAuthentication returns the 64-bit authentication field carrying
+// the password. Passwords shorter than 8 bytes are padded with zeros.
+func SimplePasswordAuthentication(password string) uint64 {
+	var buf [8]byte
+	copy(buf[:], password)
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+// CryptographicAuthentication is the authentication field of OSPF header
+// when cryptographic authentication is used. per RFC2328 D.3
+//
+//	0                   1                   2                   3
+//	0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|              0                |    Key ID     | Auth Data Len |
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|                 Cryptographic sequence number                 |
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type CryptographicAuthentication struct {
+	KeyId       uint8
+	AuthDataLen uint8
+	SeqNumber   uint32
+}
+
+func ParseCryptographicAuthentication(auth uint64) CryptographicAuthentication {
+	return CryptographicAuthentication{
+		KeyId:       uint8(auth >> 40),
+		AuthDataLen: uint8(auth >> 32),
+		SeqNumber:   uint32(auth),
+	}
+}
+
+func (c CryptographicAuthentication) Uint64() uint64 {
+	return uint64(c.KeyId)<<40 | uint64(c.AuthDataLen)<<32 | uint64(c.SeqNumber)
+}
+
+// hmacApad is the value of Apad per RFC5709 3.3.
+const hmacApad = 0x878FE1F3
+
+func calculateDigest(alg AuthAlgorithm, pkt []byte, key []byte) ([]byte, error) {
+	newHash, err := alg.hash()
+	if err != nil {
+		return nil, err
+	}
+	l := alg.DigestLen()
+	if alg == AuthAlgorithmMD5 {
+		// The 16 byte MD5 key is appended to the OSPF packet. per RFC2328 D.4.3
+		var secret [md5.Size]byte
+		copy(secret[:], key)
+		h := newHash()
+		h.Write(pkt)
+		h.Write(secret[:])
+		return h.Sum(nil), nil
+	}
+	// per RFC5709 3.3
+	// If the Authentication Key is L octets long, Ks is equal to K. If
+	// the Authentication Key is more than L octets long, Ks is set to
+	// H(K). If the Authentication Key is less than L octets long, Ks is
+	// set to the Authentication Key with zeros appended to it.
+	var ks []byte
+	if len(key) > l {
+		h := newHash()
+		h.Write(key)
+		ks = h.Sum(nil)
+	} else {
+		ks = make([]byte, l)
+		copy(ks, key)
+	}
+	// The Apad is appended to the OSPF packet while calculating the digest.
+	apad := make([]byte, l)
+	for i := 0; i < l; i += 4 {
+		binary.BigEndian.PutUint32(apad[i:], hmacApad)
+	}
+	h := hmac.New(newHash, ks)
+	h.Write(pkt)
+	h.Write(apad)
+	return h.Sum(nil), nil
+}
+
+// AppendAuthDigest sets the authentication field of the serialized OSPF packet
+// and appends the message digest to it. per RFC2328 D.4.3 and RFC5709 3.3
+// The packet must have been serialized with AuTypeCryptographic so the checksum is 0.
+func AppendAuthDigest(b gopacket.SerializeBuffer, alg AuthAlgorithm, keyId uint8, seq uint32, key []byte) error {
+	pkt := b.Bytes()
+	if len(pkt) < 24 {
+		return fmt.Errorf("packet too small for OSPF Version 2")
+	}
+	binary.BigEndian.PutUint64(pkt[16:24], CryptographicAuthentication{
+		KeyId:       keyId,
+		AuthDataLen: uint8(alg.DigestLen()),
+		SeqNumber:   seq,
+	}.Uint64())
+	digest, err := calculateDigest(alg, pkt, key)
+	if err != nil {
+		return err
+	}
+	trailer, err := b.AppendBytes(len(digest))
+	if err != nil {
+		return err
+	}
+	copy(trailer, digest)
+	return nil
+}
+
+// VerifyAuthDigest checks the message digest appended to the received OSPF packet.
+func VerifyAuthDigest(data []byte, alg AuthAlgorithm, key []byte) error {
+	if len(data) < 24 {
+		return fmt.Errorf("packet too small for OSPF Version 2")
+	}
+	pktLen := int(binary.BigEndian.Uint16(data[2:4]))
+	l := alg.DigestLen()
+	if pktLen < 24 || len(data) < pktLen+l {
+		return fmt.Errorf("message digest truncated")
+	}
+	digest, err := calculateDigest(alg, data[:pktLen], key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(digest, data[pktLen:pktLen+l]) {
+		return fmt.Errorf("%v message digest mismatched", alg)
+	}
+	return nil
+}
@@ -49,7 +49,7 @@ const (
 	//	   capability and process the packet/LSA normally.
 	//
 	//	                      +------------------------------------+
-	//	                      | * | * | DC | EA | N/P | MC | E | * |
+	//	                      | * | O | DC | EA | N/P | MC | E | * |
 	//	                      +------------------------------------+
 	//
 	//	                            The Options field
@@ -61,7 +61,9 @@ const (
 	//        according to the specifications in [Ref18].
 	CapabilityMCbit = 2
 	// CapabilityNPbit This bit describes the handling of Type-7 LSAs, as specified in
-	//        [Ref19].
+	//        [Ref19]. In Hello packets it is the N-bit, set when the area is an
+	//        NSSA. In Type-7 LSAs it is the P-bit, telling the NSSA border router
+	//        to translate the LSA into a Type-5 LSA (RFC3101 2.3).
 	CapabilityNPbit = 3
 	// CapabilityEAbit This bit describes the router's willingness to receive and
 	//        forward External-Attributes-LSAs, as specified in [Ref20].
@@ -69,6 +71,9 @@ const (
 	// CapabilityDCbit This bit describes the router's handling of demand circuits, as
 	//        specified in [Ref21].
 	CapabilityDCbit = 5
+	// CapabilityObit This bit describes the router's willingness to receive and
+	//        forward Opaque-LSAs as specified in RFC5250.
+	CapabilityObit = 6
 )
 
 const (
@@ -101,6 +106,9 @@ const (
 	//        adjacent virtual links having the described area as Transit area
 	//        (V is for virtual link endpoint).
 	RouterLSAFlagVbit = 2
+	// RouterLSAFlagNtbit When set, the router is an NSSA border router that
+	//        unconditionally translates Type-7 LSAs into Type-5 LSAs (RFC3101 2.1).
+	RouterLSAFlagNtbit = 4
 )
 
 const (
@@ -1,10 +1,12 @@
 package packet
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"unsafe"
 
+	"github.com/gopacket/gopacket"
 	"github.com/gopacket/gopacket/layers"
 )
 
@@ -14,6 +16,133 @@ var (
 
 type LayerOSPFv2 layers.OSPFv2
 
+// DecodeOSPFv2 decodes an OSPFv2 packet from wire format.
+// gopacket fails the whole Link State Update if any LSA inside is of a type
+// it does not know (e.g. summary-LSAs), so LSUs are decoded here instead
+// and unknown LSAs are kept raw to be discarded one by one later.
+func DecodeOSPFv2(data []byte) (*LayerOSPFv2, error) {
+	if len(data) < 24 {
+		return nil, fmt.Errorf("packet too small for OSPF Version 2")
+	}
+	if layers.OSPFType(data[1]) != layers.OSPFLinkStateUpdate {
+		l := &layers.OSPFv2{}
+		if err := l.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+			return nil, err
+		}
+		return (*LayerOSPFv2)(l), nil
+	}
+	l := &LayerOSPFv2{
+		BaseLayer: layers.BaseLayer{Contents: data},
+		OSPF: layers.OSPF{
+			Version:      data[0],
+			Type:         layers.OSPFType(data[1]),
+			PacketLength: binary.BigEndian.Uint16(data[2:4]),
+			RouterID:     binary.BigEndian.Uint32(data[4:8]),
+			AreaID:       binary.BigEndian.Uint32(data[8:12]),
+			Checksum:     binary.BigEndian.Uint16(data[12:14]),
+		},
+		AuType:         binary.BigEndian.Uint16(data[14:16]),
+		Authentication: binary.BigEndian.Uint64(data[16:24]),
+	}
+	if int(l.PacketLength) < 28 || int(l.PacketLength) > len(data) {
+		return nil, fmt.Errorf("invalid LSUpdate packet length %d", l.PacketLength)
+	}
+	data = data[:l.PacketLength]
+	lsu := layers.LSUpdate{NumOfLSAs: binary.BigEndian.Uint32(data[24:28])}
+	offset := 28
+	for idx := uint32(0); idx < lsu.NumOfLSAs; idx++ {
+		if len(data) < offset+20 {
+			return nil, fmt.Errorf("LSA header of #%d LSA truncated", idx)
+		}
+		lsa, err := decodeLSAV2(data[offset:])
+		if err != nil {
+			return nil, fmt.Errorf("err decode #%d LSA: %w", idx, err)
+		}
+		lsu.LSAs = append(lsu.LSAs, lsa)
+		offset += int(lsa.Length)
+	}
+	l.Content = lsu
+	return l, nil
+}
+
+func decodeLSAV2(data []byte) (ret layers.LSA, err error) {
+	ret.LSAheader = layers.LSAheader{
+		LSAge:       binary.BigEndian.Uint16(data[0:2]),
+		LSOptions:   data[2],
+		LSType:      uint16(data[3]),
+		LinkStateID: binary.BigEndian.Uint32(data[4:8]),
+		AdvRouter:   binary.BigEndian.Uint32(data[8:12]),
+		LSSeqNumber: binary.BigEndian.Uint32(data[12:16]),
+		LSChecksum:  binary.BigEndian.Uint16(data[16:18]),
+		Length:      binary.BigEndian.Uint16(data[18:20]),
+	}
+	if ret.Length < 20 || int(ret.Length) > len(data) {
+		return ret, fmt.Errorf("invalid LSA length %d", ret.Length)
+	}
+	data = data[:ret.Length]
+	if !IsLSAChecksumValid(data) {
+		ret.Content = corruptedLSA{rawLSA(append([]byte(nil), data[20:]...))}
+		return
+	}
+	switch ret.LSType {
+	case layers.RouterLSAtypeV2:
+		if len(data) < 24 {
+			return ret, errors.New("router-LSA too small")
+		}
+		rt := layers.RouterLSAV2{
+			Flags: data[20],
+			Links: binary.BigEndian.Uint16(data[22:24]),
+		}
+		for off := 24; off < len(data); {
+			if len(data) < off+12 {
+				return ret, errors.New("router-LSA link truncated")
+			}
+			rt.Routers = append(rt.Routers, layers.RouterV2{
+				LinkID:   binary.BigEndian.Uint32(data[off : off+4]),
+				LinkData: binary.BigEndian.Uint32(data[off+4 : off+8]),
+				Type:     data[off+8],
+				Metric:   binary.BigEndian.Uint16(data[off+10 : off+12]),
+			})
+			// skip legacy TOS metrics
+			off += 12 + 4*int(data[off+9])
+		}
+		ret.Content = rt
+	case layers.NetworkLSAtypeV2:
+		if len(data) < 24 {
+			return ret, errors.New("network-LSA too small")
+		}
+		nt := layers.NetworkLSAV2{NetworkMask: binary.BigEndian.Uint32(data[20:24])}
+		for off := 24; off+4 <= len(data); off += 4 {
+			nt.AttachedRouter = append(nt.AttachedRouter, binary.BigEndian.Uint32(data[off:off+4]))
+		}
+		ret.Content = nt
+	case layers.SummaryLSANetworktypeV2, layers.SummaryLSAASBRtypeV2:
+		if len(data) < 28 {
+			return ret, errors.New("summary-LSA too small")
+		}
+		ret.Content = V2SummaryLSAImpl{
+			NetworkMask: binary.BigEndian.Uint32(data[20:24]),
+			Metric:      binary.BigEndian.Uint32(data[24:28]) & 0x00FFFFFF,
+		}
+	case layers.ASExternalLSAtypeV2, layers.NSSALSAtypeV2:
+		if len(data) < 36 {
+			return ret, errors.New("AS-external-LSA too small")
+		}
+		ret.Content = layers.ASExternalLSAV2{
+			NetworkMask:       binary.BigEndian.Uint32(data[20:24]),
+			ExternalBit:       data[24] & 0x80,
+			Metric:            binary.BigEndian.Uint32(data[24:28]) & 0x00FFFFFF,
+			ForwardingAddress: binary.BigEndian.Uint32(data[28:32]),
+			ExternalRouteTag:  binary.BigEndian.Uint32(data[32:36]),
+		}
+	case LinkLocalOpaqueLSAtypeV2, AreaLocalOpaqueLSAtypeV2, ASOpaqueLSAtypeV2:
+		ret.Content = V2OpaqueLSA{Data: append([]byte(nil), data[20:]...)}
+	default:
+		ret.Content = rawLSA(append([]byte(nil), data[20:]...))
+	}
+	return
+}
+
 func (l *LayerOSPFv2) AsHello() (*OSPFv2Packet[HelloPayloadV2], error) {
 	if hello, ok := l.Content.(layers.HelloPkgV2); ok {
 		return &OSPFv2Packet[HelloPayloadV2]{
@@ -129,8 +258,20 @@ func (p LSAdvertisement) AsV2SummaryLSAType3() (ret LSAdv[V2SummaryLSAType3], er
 				Content:         V2SummaryLSAType3{abrSm},
 			}, nil
 		}
+		if sm, ok := p.Content.(V2SummaryLSAType3); ok {
+			return LSAdv[V2SummaryLSAType3]{
+				LSAdvertisement: p,
+				Content:         sm,
+			}, nil
+		}
 	}
-	err = fmt.Errorf("err V2SummaryLSAType3 %w", ErrNotImplemented)
+	if abrSm, ok := p.LSA.Content.(V2SummaryLSAImpl); ok {
+		return LSAdv[V2SummaryLSAType3]{
+			LSAdvertisement: p,
+			Content:         V2SummaryLSAType3{abrSm},
+		}, nil
+	}
+	err = fmt.Errorf("expecting V2SummaryLSAImpl but got %T", p.LSA.Content)
 	return
 }
 
@@ -142,8 +283,20 @@ func (p LSAdvertisement) AsV2SummaryLSAType4() (ret LSAdv[V2SummaryLSAType4], er
 				Content:         V2SummaryLSAType4{asbrSm},
 			}, nil
 		}
+		if sm, ok := p.Content.(V2SummaryLSAType4); ok {
+			return LSAdv[V2SummaryLSAType4]{
+				LSAdvertisement: p,
+				Content:         sm,
+			}, nil
+		}
 	}
-	err = fmt.Errorf("err V2SummaryLSAType4 %w", ErrNotImplemented)
+	if asbrSm, ok := p.LSA.Content.(V2SummaryLSAImpl); ok {
+		return LSAdv[V2SummaryLSAType4]{
+			LSAdvertisement: p,
+			Content:         V2SummaryLSAType4{asbrSm},
+		}, nil
+	}
+	err = fmt.Errorf("expecting V2SummaryLSAImpl but got %T", p.LSA.Content)
 	return
 }
 
@@ -165,3 +318,47 @@ func (p LSAdvertisement) AsV2ASExternalLSA() (ret LSAdv[V2ASExternalLSA], err er
 	err = fmt.Errorf("expecting layers.ASExternalLSAV2 but got %T", p.LSA.Content)
 	return
 }
+
+func (p LSAdvertisement) AsV2NSSALSA() (ret LSAdv[V2NSSALSA], err error) {
+	if p.LSA.Content == nil {
+		if extLSA, ok := p.Content.(V2ASExternalLSA); ok {
+			return LSAdv[V2NSSALSA]{
+				LSAdvertisement: p,
+				Content:         V2NSSALSA{extLSA},
+			}, nil
+		}
+		if nssaLSA, ok := p.Content.(V2NSSALSA); ok {
+			return LSAdv[V2NSSALSA]{
+				LSAdvertisement: p,
+				Content:         nssaLSA,
+			}, nil
+		}
+	}
+	if lsAdv, ok := p.LSA.Content.(layers.ASExternalLSAV2); ok {
+		return LSAdv[V2NSSALSA]{
+			LSAdvertisement: p,
+			Content:         V2NSSALSA{V2ASExternalLSA(lsAdv)},
+		}, nil
+	}
+	err = fmt.Errorf("expecting layers.ASExternalLSAV2 but got %T", p.LSA.Content)
+	return
+}
+
+func (p LSAdvertisement) AsV2OpaqueLSA() (ret LSAdv[V2OpaqueLSA], err error) {
+	if p.LSA.Content == nil {
+		if opLSA, ok := p.Content.(V2OpaqueLSA); ok {
+			return LSAdv[V2OpaqueLSA]{
+				LSAdvertisement: p,
+				Content:         opLSA,
+			}, nil
+		}
+	}
+	if opLSA, ok := p.LSA.Content.(V2OpaqueLSA); ok {
+		return LSAdv[V2OpaqueLSA]{
+			LSAdvertisement: p,
+			Content:         opLSA,
+		}, nil
+	}
+	err = fmt.Errorf("expecting V2OpaqueLSA but got %T", p.LSA.Content)
+	return
+}
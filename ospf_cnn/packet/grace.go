@@ -0,0 +1,90 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// GraceOpaqueType is the Opaque Type of Grace-LSAs, which are link-local
+// opaque LSAs originated by a router restarting gracefully (RFC3623 A).
+const GraceOpaqueType = 3
+
+// TLVs of Grace-LSAs.
+const (
+	// GraceTLVGracePeriod is the number of seconds that the router's
+	// neighbors should continue to advertise the router as fully adjacent.
+	GraceTLVGracePeriod = 1
+	// GraceTLVRestartReason encodes the reason for the router restart.
+	GraceTLVRestartReason = 2
+	// GraceTLVInterfaceAddress is the IP address of the restarting router's
+	// interface. Required on broadcast, NBMA and Point-to-MultiPoint networks.
+	GraceTLVInterfaceAddress = 3
+)
+
+// Restart reasons of the Graceful Restart Reason TLV.
+const (
+	GraceReasonUnknown uint8 = iota
+	GraceReasonSoftwareRestart
+	GraceReasonSoftwareUpgrade
+	GraceReasonSwitchToRedundantCP
+)
+
+// GraceLSA is the payload of Grace-LSAs.
+type GraceLSA struct {
+	// The grace period in seconds, counted from the LS age of the Grace-LSA.
+	GracePeriod uint32
+	// One of the GraceReason values.
+	Reason uint8
+	// The interface address of the restarting router. 0 if not advertised.
+	InterfaceAddress uint32
+	// Unrecognized TLVs are kept and encoded back as is.
+	Unknown []OpaqueTLV
+}
+
+// AsGraceLSA decodes the Grace-LSA from the opaque information. The Grace
+// Period and Graceful Restart Reason TLVs are mandatory.
+func (p V2OpaqueLSA) AsGraceLSA() (GraceLSA, error) {
+	var ret GraceLSA
+	tlvs, err := p.TLVs()
+	if err != nil {
+		return ret, err
+	}
+	var hasPeriod, hasReason bool
+	for _, t := range tlvs {
+		switch t.Type {
+		case GraceTLVGracePeriod:
+			if len(t.Value) != 4 {
+				return ret, errors.New("invalid length of Grace Period TLV")
+			}
+			ret.GracePeriod, hasPeriod = binary.BigEndian.Uint32(t.Value), true
+		case GraceTLVRestartReason:
+			if len(t.Value) != 1 {
+				return ret, errors.New("invalid length of Graceful Restart Reason TLV")
+			}
+			ret.Reason, hasReason = t.Value[0], true
+		case GraceTLVInterfaceAddress:
+			if len(t.Value) != 4 {
+				return ret, errors.New("invalid length of IP Interface Address TLV")
+			}
+			ret.InterfaceAddress = binary.BigEndian.Uint32(t.Value)
+		default:
+			ret.Unknown = append(ret.Unknown, t)
+		}
+	}
+	if !hasPeriod || !hasReason {
+		return ret, errors.New("mandatory Grace Period or Graceful Restart Reason TLV missing in Grace-LSA")
+	}
+	return ret, nil
+}
+
+// TLVs encodes the Grace-LSA into the opaque information.
+func (g GraceLSA) TLVs() []OpaqueTLV {
+	ret := []OpaqueTLV{
+		{Type: GraceTLVGracePeriod, Value: binary.BigEndian.AppendUint32(nil, g.GracePeriod)},
+		{Type: GraceTLVRestartReason, Value: []byte{g.Reason}},
+	}
+	if g.InterfaceAddress != 0 {
+		ret = append(ret, OpaqueTLV{Type: GraceTLVInterfaceAddress, Value: binary.BigEndian.AppendUint32(nil, g.InterfaceAddress)})
+	}
+	return append(ret, g.Unknown...)
+}
@@ -2,6 +2,8 @@ package packet
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 
 	"github.com/gopacket/gopacket/layers"
 )
@@ -21,7 +23,7 @@ var InvalidLSAIdentity = LSAIdentity{}
 type LSAdvPayload interface {
 	V2RouterLSA | V2NetworkLSA |
 		V2SummaryLSAType3 | V2SummaryLSAType4 |
-		V2ASExternalLSA
+		V2ASExternalLSA | V2NSSALSA | V2OpaqueLSA
 	marshalable
 }
 
@@ -83,6 +85,13 @@ type V2SummaryLSAType4 struct {
 
 type V2ASExternalLSA layers.ASExternalLSAV2
 
+// V2NSSALSA is the Type-7 LSA originated within a not-so-stubby area (NSSA)
+// per RFC3101. Its format is identical to the AS-external-LSA, except that
+// the forwarding address must be non-zero when the P-bit is set.
+type V2NSSALSA struct {
+	V2ASExternalLSA
+}
+
 func (p V2RouterLSA) isLSAContent() {}
 
 func (p V2RouterLSA) Size() int {
@@ -181,3 +190,122 @@ func (p V2ASExternalLSA) SerializeToSizedBuffer(b []byte) (err error) {
 	binary.BigEndian.PutUint32(b[12:16], p.ExternalRouteTag)
 	return
 }
+
+const (
+	// LinkLocalOpaqueLSAtypeV2 Opaque LSAs of type 9 are not flooded beyond the
+	// local (sub)network (RFC5250 3).
+	LinkLocalOpaqueLSAtypeV2 = 0x9
+	// AreaLocalOpaqueLSAtypeV2 Opaque LSAs of type 10 are not flooded beyond the
+	// borders of their associated area.
+	AreaLocalOpaqueLSAtypeV2 = 0xa
+	// ASOpaqueLSAtypeV2 Opaque LSAs of type 11 are flooded throughout all transit
+	// areas, the same as AS-external-LSAs.
+	ASOpaqueLSAtypeV2 = 0xb
+)
+
+// IsOpaqueLSType checks whether the LS type is one of the opaque LSA types.
+func IsOpaqueLSType(lsType uint16) bool {
+	return lsType >= LinkLocalOpaqueLSAtypeV2 && lsType <= ASOpaqueLSAtypeV2
+}
+
+// OpaqueLinkStateID composes the Link State ID of opaque LSAs, which is
+// divided into an 8-bit Opaque Type field and a 24-bit Opaque ID field.
+func OpaqueLinkStateID(opaqueType uint8, opaqueId uint32) uint32 {
+	return uint32(opaqueType)<<24 | opaqueId&0x00FFFFFF
+}
+
+// SplitOpaqueLinkStateID returns the Opaque Type and the Opaque ID of the
+// Link State ID of opaque LSAs.
+func SplitOpaqueLinkStateID(lsId uint32) (opaqueType uint8, opaqueId uint32) {
+	return uint8(lsId >> 24), lsId & 0x00FFFFFF
+}
+
+// V2OpaqueLSA is the opaque LSA per RFC5250. The opaque information is
+// application-specific, and is usually encoded in TLVs.
+// It is kept as is, so that the LSA is reflooded unchanged.
+type V2OpaqueLSA struct {
+	Data []byte
+}
+
+// NewV2OpaqueLSA builds the opaque LSA carrying the TLVs.
+func NewV2OpaqueLSA(tlvs ...OpaqueTLV) V2OpaqueLSA {
+	size := 0
+	for _, t := range tlvs {
+		size += t.Size()
+	}
+	ret := V2OpaqueLSA{Data: make([]byte, size)}
+	offset := 0
+	for _, t := range tlvs {
+		_ = t.SerializeToSizedBuffer(ret.Data[offset : offset+t.Size()])
+		offset += t.Size()
+	}
+	return ret
+}
+
+// TLVs parses the opaque information as TLVs.
+func (p V2OpaqueLSA) TLVs() ([]OpaqueTLV, error) {
+	return ParseOpaqueTLVs(p.Data)
+}
+
+func (p V2OpaqueLSA) isLSAContent() {}
+
+func (p V2OpaqueLSA) Size() int {
+	return len(p.Data)
+}
+
+func (p V2OpaqueLSA) SerializeToSizedBuffer(b []byte) (err error) {
+	if len(b) < p.Size() {
+		return ErrBufferLengthTooShort
+	}
+	copy(b, p.Data)
+	return
+}
+
+// OpaqueTLV is the Type/Length/Value triplet the opaque information is
+// made up of. The Value is padded to 4-octet alignment, which is not
+// included in the Length field.
+//
+//	 0                   1                   2                   3
+//	 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|              Type             |             Length            |
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|                            Value...                           |
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type OpaqueTLV struct {
+	Type  uint16
+	Value []byte
+}
+
+func (t OpaqueTLV) Size() int {
+	return 4 + (len(t.Value)+3)&^3
+}
+
+func (t OpaqueTLV) SerializeToSizedBuffer(b []byte) error {
+	if len(b) < t.Size() {
+		return ErrBufferLengthTooShort
+	}
+	binary.BigEndian.PutUint16(b[0:2], t.Type)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(t.Value)))
+	copy(b[4:], t.Value)
+	clear(b[4+len(t.Value) : t.Size()])
+	return nil
+}
+
+// ParseOpaqueTLVs parses the TLVs, or the sub-TLVs nested in the Value of a TLV.
+func ParseOpaqueTLVs(b []byte) (ret []OpaqueTLV, err error) {
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, errors.New("TLV header truncated")
+		}
+		t := OpaqueTLV{Type: binary.BigEndian.Uint16(b[0:2])}
+		l := int(binary.BigEndian.Uint16(b[2:4]))
+		if len(b) < 4+l {
+			return nil, fmt.Errorf("TLV(%d) of length %d truncated", t.Type, l)
+		}
+		t.Value = b[4 : 4+l]
+		ret = append(ret, t)
+		b = b[min(len(b), t.Size()):]
+	}
+	return
+}
@@ -31,6 +31,10 @@ type OSPFPayloadV2 interface {
 
 var (
 	ErrBufferLengthTooShort = errors.New("err buffer length too short")
+	// ErrLSAChecksum is returned by ValidateLSA if the LS checksum is invalid.
+	ErrLSAChecksum = errors.New("invalid LS checksum")
+	// ErrUnknownLSType is returned by ValidateLSA if the LS type is unknown.
+	ErrUnknownLSType = errors.New("unknown LS type")
 )
 
 type HelloPayloadV2 layers.HelloPkgV2
@@ -212,18 +216,24 @@ func (p LSAdvertisement) ValidateLSA() error {
 	// (1) Validate the LSA's LS checksum.  If the checksum turns out to be
 	//        invalid, discard the LSA and get the next one from the Link
 	//        State Update packet.
-	// TODO: validate LSA chksum
+	// It is verified against the received bytes while decoding.
+	if _, ok := p.Content.(corruptedLSA); ok {
+		return fmt.Errorf("%w 0x%04x of LSA type %d", ErrLSAChecksum, p.LSChecksum, p.LSType)
+	}
 
 	// Examine the LSA's LS type.  If the LS type is unknown, discard
 	//        the LSA and get the next one from the Link State Update Packet.
 	//        This specification defines LS types 1-5 (see Section 4.3).
+	//        LS type 7 is defined by RFC3101 for NSSAs, and LS types 9-11
+	//        by RFC5250 for opaque LSAs.
 	switch p.LSType {
 	case layers.RouterLSAtypeV2, layers.NetworkLSAtypeV2,
 		layers.SummaryLSANetworktypeV2, layers.SummaryLSAASBRtypeV2,
-		layers.ASExternalLSAtypeV2:
+		layers.ASExternalLSAtypeV2, layers.NSSALSAtypeV2,
+		LinkLocalOpaqueLSAtypeV2, AreaLocalOpaqueLSAtypeV2, ASOpaqueLSAtypeV2:
 		return nil
 	}
-	return fmt.Errorf("unknown LSA type %d", p.LSType)
+	return fmt.Errorf("%w %d", ErrUnknownLSType, p.LSType)
 }
 
 func (pt *LSAdvertisement) parse() error {
@@ -231,6 +241,11 @@ func (pt *LSAdvertisement) parse() error {
 	if int(pt.Length) < pt.LSAheader.Size() {
 		return fmt.Errorf("LSA too short")
 	}
+	// keep corrupted LSAs raw, they are discarded by ValidateLSA.
+	if corrupted, ok := pt.LSA.Content.(corruptedLSA); ok {
+		pt.Content = corrupted
+		return nil
+	}
 	switch pt.LSType {
 	case layers.RouterLSAtypeV2:
 		lsa, err := pt.AsV2RouterLSA()
@@ -262,14 +277,33 @@ func (pt *LSAdvertisement) parse() error {
 			return err
 		}
 		pt.Content = lsa.Content
+	case layers.NSSALSAtypeV2:
+		lsa, err := pt.AsV2NSSALSA()
+		if err != nil {
+			return err
+		}
+		pt.Content = lsa.Content
+	case LinkLocalOpaqueLSAtypeV2, AreaLocalOpaqueLSAtypeV2, ASOpaqueLSAtypeV2:
+		lsa, err := pt.AsV2OpaqueLSA()
+		if err != nil {
+			return err
+		}
+		pt.Content = lsa.Content
 	default:
+		// keep unknown LSAs raw, they are discarded by ValidateLSA.
+		if raw, ok := pt.LSA.Content.(rawLSA); ok {
+			pt.Content = raw
+			return nil
+		}
 		return fmt.Errorf("LSA.LSType(%x) not implemented", pt.LSType)
 	}
 	return nil
 }
 
 func (p *LSAdvertisement) FixLengthAndChkSum() error {
-	buf := make([]byte, p.Size())
+	// The length is recalculated from the content, which may have been
+	// modified since last serialized.
+	buf := make([]byte, p.LSAheader.Size()+p.Content.Size())
 	return p.SerializeToSizedBuffer(buf)
 }
 
@@ -467,6 +501,42 @@ func (p rawLSA) SerializeToSizedBuffer(b []byte) error {
 	return nil
 }
 
+// corruptedLSA is the content of an LSA whose LS checksum turns out to be
+// invalid, kept raw as it cannot be trusted.
+type corruptedLSA struct {
+	rawLSA
+}
+
+// IsLSAChecksumValid verifies the LS checksum of the LSA in wire format.
+func IsLSAChecksumValid(b []byte) bool {
+	if len(b) < 20 {
+		return false
+	}
+	buf := append([]byte(nil), b...)
+	clear(buf[16:18])
+	return lsaChecksum(buf[2:], 14) == binary.BigEndian.Uint16(b[16:18])
+}
+
+// VerifyOSPFv2Checksum verifies the checksum of the OSPF packet in wire format,
+// which is the standard IP checksum of the entire contents of the packet
+// excluding the 64-bit authentication field. It is not calculated when using
+// cryptographic authentication (RFC2328 D.4).
+func VerifyOSPFv2Checksum(data []byte) error {
+	if len(data) < 24 {
+		return fmt.Errorf("packet too small for OSPF Version 2")
+	}
+	if binary.BigEndian.Uint16(data[14:16]) == AuTypeCryptographic {
+		return nil
+	}
+	buf := append([]byte(nil), data...)
+	clear(buf[12:14])
+	clear(buf[16:24])
+	if sum, want := ipPacketChecksum(buf), binary.BigEndian.Uint16(data[12:14]); sum != want {
+		return fmt.Errorf("checksum 0x%04x mismatched, expecting 0x%04x", want, sum)
+	}
+	return nil
+}
+
 func (v2 *OSPFv2Packet[T]) packetErr(format string, args ...interface{}) error {
 	return fmt.Errorf("malformed ospfv2 %s packet: "+format, append([]interface{}{v2.Type}, args...)...)
 }
@@ -476,7 +546,10 @@ func ipPacketChecksum(bytes []byte) uint16 {
 	var csum uint32
 	for i := 0; i < len(bytes); i += 2 {
 		csum += uint32(bytes[i]) << 8
-		csum += uint32(bytes[i+1])
+		// an odd length is padded with a zero octet.
+		if i+1 < len(bytes) {
+			csum += uint32(bytes[i+1])
+		}
 	}
 	for {
 		// Break when sum is less or equals to 0xFFFF
@@ -523,7 +596,14 @@ func (v2 *OSPFv2Packet[T]) SerializeTo(b gopacket.SerializeBuffer, opts gopacket
 			clear(h[12:14])
 			// clear authentication bytes
 			clear(h[16:24])
-			v2.Checksum = ipPacketChecksum(b.Bytes())
+			if v2.AuType == AuTypeCryptographic {
+				// When using cryptographic authentication, the checksum field
+				// in the standard OSPF packet header is not calculated, but
+				// is instead set to 0. per RFC2328 D.4.3
+				v2.Checksum = 0
+			} else {
+				v2.Checksum = ipPacketChecksum(b.Bytes())
+			}
 		}
 		binary.BigEndian.PutUint16(h[12:14], v2.Checksum)
 		// ipPacketChecksum calculation must exclude 64bit authentication bytes.
@@ -134,3 +134,15 @@ func (p V2ASExternalLSA) String() string {
 	return fmt.Sprintf("{NetworkMask:%d  ExternalBit:%d Metric:%d ForwardingAddress:%d ExternalRouteTag: %d}",
 		p.NetworkMask, p.ExternalBit, p.Metric, p.ForwardingAddress, p.ExternalRouteTag)
 }
+
+func (p V2NSSALSA) String() string {
+	return p.V2ASExternalLSA.String()
+}
+
+func (p V2OpaqueLSA) String() string {
+	return fmt.Sprintf("{Data:%x}", p.Data)
+}
+
+func (p rawLSA) String() string {
+	return fmt.Sprintf("{Raw:%x}", []byte(p))
+}
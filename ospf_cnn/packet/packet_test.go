@@ -1,8 +1,15 @@
 package packet
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math"
 	"testing"
 
+	"github.com/gopacket/gopacket"
+
 	"github.com/gopacket/gopacket/layers"
 )
 
@@ -63,3 +70,428 @@ func TestLSAChkSum(t *testing.T) {
 	}
 
 }
+
+// lsuPacket builds a Link State Update packet carrying the LSAs, without
+// authentication and checksum.
+func lsuPacket(t *testing.T, lsas ...LSAdvertisement) []byte {
+	t.Helper()
+	size := 28
+	for _, lsa := range lsas {
+		size += lsa.Size()
+	}
+	buf := make([]byte, size)
+	buf[0], buf[1] = 2, byte(layers.OSPFLinkStateUpdate)
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)))
+	binary.BigEndian.PutUint32(buf[24:28], uint32(len(lsas)))
+	offset := 28
+	for _, lsa := range lsas {
+		if err := lsa.SerializeToSizedBuffer(buf[offset:]); err != nil {
+			t.Fatalf("failed to serialize LSA(%+v): %s", lsa.LSAheader, err)
+		}
+		offset += lsa.Size()
+	}
+	return buf
+}
+
+// testHello returns a Hello packet of router 1.1.1.1 without authentication.
+func testHello() *OSPFv2Packet[HelloPayloadV2] {
+	return &OSPFv2Packet[HelloPayloadV2]{
+		OSPFv2: layers.OSPFv2{
+			OSPF: layers.OSPF{
+				Version:  2,
+				Type:     layers.OSPFHello,
+				RouterID: 0x01010101,
+			},
+		},
+		Content: HelloPayloadV2{
+			HelloPkg: layers.HelloPkg{
+				HelloInterval:      10,
+				RouterDeadInterval: 40,
+			},
+			NetworkMask: 0xffffff00,
+		},
+	}
+}
+
+func serializeHello(t *testing.T, hello *OSPFv2Packet[HelloPayloadV2]) gopacket.SerializeBuffer {
+	t.Helper()
+	b := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(b, gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}, hello); err != nil {
+		t.Fatalf("failed to serialize hello: %s", err)
+	}
+	return b
+}
+
+func TestDecodeLSUWithSummaryLSA(t *testing.T) {
+	lsa := LSAdvertisement{
+		LSAheader: LSAheader{
+			LSAge:       1,
+			LSType:      layers.SummaryLSANetworktypeV2,
+			LinkStateID: 0x0a000000,
+			AdvRouter:   0x01010101,
+			LSSeqNumber: InitialSequenceNumber,
+			LSOptions:   2,
+		},
+		Content: V2SummaryLSAImpl{
+			NetworkMask: 0xffffff00,
+			Metric:      20,
+		},
+	}
+	if err := lsa.FixLengthAndChkSum(); err != nil {
+		t.Fatalf("failed to fix summary LSA: %s", err)
+	}
+	l, err := DecodeOSPFv2(lsuPacket(t, lsa))
+	if err != nil {
+		t.Fatalf("failed to decode LSU: %s", err)
+	}
+	lsu, err := l.AsLSUpdate()
+	if err != nil {
+		t.Fatalf("failed to parse LSU: %s", err)
+	}
+	if len(lsu.Content.LSAs) != 1 {
+		t.Fatalf("expecting 1 LSA but got %d", len(lsu.Content.LSAs))
+	}
+	sm, err := lsu.Content.LSAs[0].AsV2SummaryLSAType3()
+	if err != nil {
+		t.Fatalf("failed to get summary LSA: %s", err)
+	}
+	if sm.Content.NetworkMask != 0xffffff00 || sm.Content.Metric != 20 {
+		t.Errorf("unexpected summary LSA content %+v", sm.Content)
+	}
+}
+
+func TestDecodeLSUWithNSSALSA(t *testing.T) {
+	lsa := LSAdvertisement{
+		LSAheader: LSAheader{
+			LSAge:       1,
+			LSType:      layers.NSSALSAtypeV2,
+			LinkStateID: 0xc0a80100,
+			AdvRouter:   0x01010101,
+			LSSeqNumber: InitialSequenceNumber,
+			LSOptions:   uint8(BitOption(0).SetBit(CapabilityNPbit)),
+		},
+		Content: V2ASExternalLSA{
+			NetworkMask:       0xffffff00,
+			ExternalBit:       0x80,
+			Metric:            20,
+			ForwardingAddress: 0x0a000001,
+			ExternalRouteTag:  100,
+		},
+	}
+	if err := lsa.FixLengthAndChkSum(); err != nil {
+		t.Fatalf("failed to fix NSSA LSA: %s", err)
+	}
+	l, err := DecodeOSPFv2(lsuPacket(t, lsa))
+	if err != nil {
+		t.Fatalf("failed to decode LSU: %s", err)
+	}
+	lsu, err := l.AsLSUpdate()
+	if err != nil {
+		t.Fatalf("failed to parse LSU: %s", err)
+	}
+	if len(lsu.Content.LSAs) != 1 {
+		t.Fatalf("expecting 1 LSA but got %d", len(lsu.Content.LSAs))
+	}
+	if err = lsu.Content.LSAs[0].ValidateLSA(); err != nil {
+		t.Errorf("expecting NSSA LSA valid but got %s", err)
+	}
+	nssa, err := lsu.Content.LSAs[0].AsV2NSSALSA()
+	if err != nil {
+		t.Fatalf("failed to get NSSA LSA: %s", err)
+	}
+	if !BitOption(nssa.LSOptions).IsBitSet(CapabilityNPbit) {
+		t.Errorf("expecting P-bit set in options %x", nssa.LSOptions)
+	}
+	if nssa.Content.NetworkMask != 0xffffff00 || nssa.Content.ExternalBit != 0x80 || nssa.Content.Metric != 20 ||
+		nssa.Content.ForwardingAddress != 0x0a000001 || nssa.Content.ExternalRouteTag != 100 {
+		t.Errorf("unexpected NSSA LSA content %+v", nssa.Content)
+	}
+}
+
+func TestDecodeLSUWithOpaqueLSA(t *testing.T) {
+	tlvs := []OpaqueTLV{
+		{Type: 1, Value: []byte{1, 2, 3, 4, 5}},
+		{Type: 2, Value: []byte{6, 7, 8, 9}},
+	}
+	lsa := LSAdvertisement{
+		LSAheader: LSAheader{
+			LSAge:       1,
+			LSType:      AreaLocalOpaqueLSAtypeV2,
+			LinkStateID: OpaqueLinkStateID(1, 0x123456),
+			AdvRouter:   0x01010101,
+			LSSeqNumber: InitialSequenceNumber,
+			LSOptions:   uint8(BitOption(0).SetBit(CapabilityObit)),
+		},
+		Content: NewV2OpaqueLSA(tlvs...),
+	}
+	if err := lsa.FixLengthAndChkSum(); err != nil {
+		t.Fatalf("failed to fix opaque LSA: %s", err)
+	}
+	if lsa.Length != 20+8+4+8 {
+		t.Fatalf("unexpected padded opaque LSA length %d", lsa.Length)
+	}
+	l, err := DecodeOSPFv2(lsuPacket(t, lsa))
+	if err != nil {
+		t.Fatalf("failed to decode LSU: %s", err)
+	}
+	lsu, err := l.AsLSUpdate()
+	if err != nil {
+		t.Fatalf("failed to parse LSU: %s", err)
+	}
+	if err = lsu.Content.LSAs[0].ValidateLSA(); err != nil {
+		t.Errorf("expecting opaque LSA valid but got %s", err)
+	}
+	if opType, opId := SplitOpaqueLinkStateID(lsu.Content.LSAs[0].LinkStateID); opType != 1 || opId != 0x123456 {
+		t.Errorf("unexpected opaque type %d and ID %x", opType, opId)
+	}
+	op, err := lsu.Content.LSAs[0].AsV2OpaqueLSA()
+	if err != nil {
+		t.Fatalf("failed to get opaque LSA: %s", err)
+	}
+	got, err := op.Content.TLVs()
+	if err != nil {
+		t.Fatalf("failed to parse TLVs: %s", err)
+	}
+	if len(got) != len(tlvs) {
+		t.Fatalf("expecting %d TLVs but got %d", len(tlvs), len(got))
+	}
+	for idx := range tlvs {
+		if got[idx].Type != tlvs[idx].Type || !bytes.Equal(got[idx].Value, tlvs[idx].Value) {
+			t.Errorf("expecting TLV %+v but got %+v", tlvs[idx], got[idx])
+		}
+	}
+}
+
+func TestTELSA(t *testing.T) {
+	var (
+		metric  uint32  = 100
+		maxBw   float32 = 125000000
+		unresBw         = [8]float32{1, 2, 3, 4, 5, 6, 7, 8}
+	)
+	te := TELSA{Link: &TELinkTLV{
+		LinkType:            TELinkTypePointToPoint,
+		LinkID:              0x02020202,
+		LocalAddresses:      []uint32{0x0a000001},
+		RemoteAddresses:     []uint32{0x0a000002},
+		TEMetric:            &metric,
+		MaxBandwidth:        &maxBw,
+		UnreservedBandwidth: &unresBw,
+		Unknown:             []OpaqueTLV{{Type: 32768, Value: []byte{1, 2}}},
+	}}
+	got, err := NewV2OpaqueLSA(te.TLVs()...).AsTELSA()
+	if err != nil {
+		t.Fatalf("failed to decode TE LSA: %s", err)
+	}
+	l := got.Link
+	if l == nil || l.LinkType != TELinkTypePointToPoint || l.LinkID != 0x02020202 ||
+		len(l.LocalAddresses) != 1 || l.LocalAddresses[0] != 0x0a000001 ||
+		len(l.RemoteAddresses) != 1 || l.RemoteAddresses[0] != 0x0a000002 {
+		t.Fatalf("unexpected TE link %+v", l)
+	}
+	if l.TEMetric == nil || *l.TEMetric != metric || l.MaxBandwidth == nil || *l.MaxBandwidth != maxBw ||
+		l.UnreservedBandwidth == nil || *l.UnreservedBandwidth != unresBw ||
+		l.MaxReservableBandwidth != nil || l.AdminGroup != nil {
+		t.Errorf("unexpected TE link attributes %+v", l)
+	}
+	if len(l.Unknown) != 1 || l.Unknown[0].Type != 32768 || !bytes.Equal(l.Unknown[0].Value, []byte{1, 2}) {
+		t.Errorf("unexpected unknown sub-TLVs %+v", l.Unknown)
+	}
+
+	got, err = NewV2OpaqueLSA(TELSA{RouterAddress: 0x01010101}.TLVs()...).AsTELSA()
+	if err != nil || got.Link != nil || got.RouterAddress != 0x01010101 {
+		t.Errorf("unexpected router address TE LSA %+v: %v", got, err)
+	}
+	if _, err = NewV2OpaqueLSA(OpaqueTLV{Type: TETLVLink, Value: []byte{0, 1, 0, 1, 1, 0, 0, 0}}).AsTELSA(); err == nil {
+		t.Errorf("expecting Link TLV without Link ID invalid")
+	}
+
+	for _, bw := range []float32{float32(math.NaN()), float32(math.Inf(1)), float32(math.Inf(-1))} {
+		invalid := *te.Link
+		invalid.MaxBandwidth = &bw
+		if _, err = NewV2OpaqueLSA(TELSA{Link: &invalid}.TLVs()...).AsTELSA(); err == nil {
+			t.Errorf("expecting Maximum Bandwidth %v invalid", bw)
+		}
+		invalid = *te.Link
+		invalidBws := unresBw
+		invalidBws[3] = bw
+		invalid.UnreservedBandwidth = &invalidBws
+		if _, err = NewV2OpaqueLSA(TELSA{Link: &invalid}.TLVs()...).AsTELSA(); err == nil {
+			t.Errorf("expecting Unreserved Bandwidth %v invalid", bw)
+		}
+	}
+}
+
+func TestRouterInfoLSA(t *testing.T) {
+	ri := RouterInfoLSA{
+		Capabilities: RICapabilityGracefulRestartHelper | RICapabilityStubRouter,
+		Hostname:     "core-router-1",
+	}
+	p := NewV2OpaqueLSA(ri.TLVs()...)
+	// capabilities TLV of 8 bytes and 13 bytes hostname padded to 16.
+	if p.Size() != 8+4+16 {
+		t.Fatalf("unexpected Router Information LSA size %d", p.Size())
+	}
+	got, err := p.AsRouterInfoLSA()
+	if err != nil {
+		t.Fatalf("failed to decode Router Information LSA: %s", err)
+	}
+	if got.Capabilities != 0x60000000 || got.Hostname != ri.Hostname || len(got.Unknown) != 0 {
+		t.Errorf("unexpected Router Information LSA %+v", got)
+	}
+}
+
+func TestGraceLSA(t *testing.T) {
+	g := GraceLSA{
+		GracePeriod:      120,
+		Reason:           GraceReasonSoftwareUpgrade,
+		InterfaceAddress: 0xc0a80101,
+	}
+	p := NewV2OpaqueLSA(g.TLVs()...)
+	// 8 bytes period, 1 byte reason padded to 8 and 8 bytes address.
+	if p.Size() != 24 {
+		t.Fatalf("unexpected Grace-LSA size %d", p.Size())
+	}
+	got, err := p.AsGraceLSA()
+	if err != nil {
+		t.Fatalf("failed to decode Grace-LSA: %s", err)
+	}
+	if got.GracePeriod != g.GracePeriod || got.Reason != g.Reason || got.InterfaceAddress != g.InterfaceAddress {
+		t.Errorf("unexpected Grace-LSA %+v", got)
+	}
+	if _, err = NewV2OpaqueLSA(g.TLVs()[:1]...).AsGraceLSA(); err == nil {
+		t.Errorf("expected error decoding Grace-LSA without restart reason")
+	}
+}
+
+func TestCryptographicAuthentication(t *testing.T) {
+	for _, alg := range []AuthAlgorithm{
+		AuthAlgorithmMD5, AuthAlgorithmHMACSHA1, AuthAlgorithmHMACSHA256,
+		AuthAlgorithmHMACSHA384, AuthAlgorithmHMACSHA512,
+	} {
+		hello := testHello()
+		hello.AuType = AuTypeCryptographic
+		b := serializeHello(t, hello)
+		if err := AppendAuthDigest(b, alg, 7, 100, []byte("secret")); err != nil {
+			t.Fatalf("failed to sign hello with %v: %s", alg, err)
+		}
+		data := b.Bytes()
+		if len(data) != int(hello.PacketLength)+alg.DigestLen() {
+			t.Fatalf("expecting %v digest appended after %d bytes packet but got %d bytes", alg, hello.PacketLength, len(data))
+		}
+		if data[12] != 0 || data[13] != 0 {
+			t.Errorf("expecting checksum 0 with cryptographic authentication")
+		}
+		l, err := DecodeOSPFv2(data)
+		if err != nil {
+			t.Fatalf("failed to decode hello: %s", err)
+		}
+		c := ParseCryptographicAuthentication(l.Authentication)
+		if c.KeyId != 7 || int(c.AuthDataLen) != alg.DigestLen() || c.SeqNumber != 100 {
+			t.Errorf("unexpected %v cryptographic authentication %+v", alg, c)
+		}
+		if err = VerifyAuthDigest(data, alg, []byte("secret")); err != nil {
+			t.Errorf("failed to verify %v digest: %s", alg, err)
+		}
+		if err = VerifyAuthDigest(data, alg, []byte("wrong")); err == nil {
+			t.Errorf("expecting %v digest mismatched with wrong key", alg)
+		}
+	}
+}
+
+// TestAuthDigestKnownAnswers checks the digests of a Hello packet against the
+// ones calculated independently per RFC2328 D.4.3 and RFC5709 3.3.
+func TestAuthDigestKnownAnswers(t *testing.T) {
+	pkt, _ := hex.DecodeString("0201002c0101010100000000000000020000000000000000" +
+		"ffffff00000a0201000000280000000000000000")
+	for _, tt := range []struct {
+		alg    AuthAlgorithm
+		key    string
+		digest string
+	}{
+		{AuthAlgorithmMD5, "secret", "389eae384af8343a288e30c52bc50ae0"},
+		{AuthAlgorithmHMACSHA1, "secret", "4b4f6aecedf34d06ea37c56b998748667db28dc7"},
+		{AuthAlgorithmHMACSHA256, "secret", "69c2d488f10f438dae4a5a7cc74b2a5295bfb1db739453665b396120fd740049"},
+		{AuthAlgorithmHMACSHA384, "secret", "33bd1bff4277fa1b4cc0f2318af85b4c68f0e27a9e4fcbc4d783aadd2e79d40df9d8bdafd4591ad3ed5bc8308222bb1f"},
+		{AuthAlgorithmHMACSHA512, "secret", "4164ace59b74d6bb7a20884450055acdfc38dc6fa02a9613f79ade81d393d1ac36be12041583e242547d7de81a106d9734ea489430f7ff37eb9feaf252e0c752"},
+		// The key longer than the digest is hashed first.
+		{AuthAlgorithmHMACSHA256, string(bytes.Repeat([]byte("k"), 40)), "87a32cd840acffb19130e40fd3be95babe2f76e798a51af3d9282564193b2da4"},
+	} {
+		t.Run(tt.alg.String(), func(t *testing.T) {
+			b := gopacket.NewSerializeBuffer()
+			buf, _ := b.PrependBytes(len(pkt))
+			copy(buf, pkt)
+			if err := AppendAuthDigest(b, tt.alg, 7, 100, []byte(tt.key)); err != nil {
+				t.Fatalf("failed to sign packet: %s", err)
+			}
+			if digest := hex.EncodeToString(b.Bytes()[len(pkt):]); digest != tt.digest {
+				t.Errorf("expecting digest %s but got %s", tt.digest, digest)
+			}
+			if err := VerifyAuthDigest(b.Bytes(), tt.alg, []byte(tt.key)); err != nil {
+				t.Errorf("failed to verify digest: %s", err)
+			}
+		})
+	}
+}
+
+func TestDecodeLSUWithCorruptedLSA(t *testing.T) {
+	var lsas []LSAdvertisement
+	for _, id := range []uint32{0x0a000000, 0x0a000100} {
+		lsa := LSAdvertisement{
+			LSAheader: LSAheader{
+				LSAge:       1,
+				LSType:      layers.SummaryLSANetworktypeV2,
+				LinkStateID: id,
+				AdvRouter:   0x01010101,
+				LSSeqNumber: InitialSequenceNumber,
+				LSOptions:   2,
+			},
+			Content: V2SummaryLSAImpl{
+				NetworkMask: 0xffffff00,
+				Metric:      20,
+			},
+		}
+		if err := lsa.FixLengthAndChkSum(); err != nil {
+			t.Fatalf("failed to fix summary LSA: %s", err)
+		}
+		lsas = append(lsas, lsa)
+	}
+	buf := lsuPacket(t, lsas...)
+	// corrupt the metric of the first LSA.
+	buf[28+27]++
+	l, err := DecodeOSPFv2(buf)
+	if err != nil {
+		t.Fatalf("failed to decode LSU: %s", err)
+	}
+	lsu, err := l.AsLSUpdate()
+	if err != nil {
+		t.Fatalf("failed to parse LSU: %s", err)
+	}
+	if len(lsu.Content.LSAs) != 2 {
+		t.Fatalf("expecting 2 LSAs but got %d", len(lsu.Content.LSAs))
+	}
+	if err = lsu.Content.LSAs[0].ValidateLSA(); !errors.Is(err, ErrLSAChecksum) {
+		t.Errorf("expecting corrupted LSA invalid but got %v", err)
+	}
+	if err = lsu.Content.LSAs[1].ValidateLSA(); err != nil {
+		t.Errorf("expecting summary LSA valid but got %s", err)
+	}
+}
+
+func TestVerifyOSPFv2Checksum(t *testing.T) {
+	hello := testHello()
+	hello.AuType = AuTypeSimplePassword
+	hello.Authentication = SimplePasswordAuthentication("secret")
+	hello.Content.NeighborID = []uint32{0x02020202}
+	b := serializeHello(t, hello)
+	data := b.Bytes()
+	if err := VerifyOSPFv2Checksum(data); err != nil {
+		t.Errorf("expecting checksum valid but got %s", err)
+	}
+	data[len(data)-1]++
+	if err := VerifyOSPFv2Checksum(data); err == nil {
+		t.Errorf("expecting checksum mismatched with corrupted packet")
+	}
+}
@@ -0,0 +1,79 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// RouterInfoOpaqueType is the Opaque Type of Router Information LSAs, which
+// advertise optional capabilities of the router (RFC7770 2).
+const RouterInfoOpaqueType = 4
+
+// TLVs of Router Information LSAs.
+const (
+	// RITLVCapabilities is the Router Informational Capabilities TLV. It is
+	// the first TLV in the body of Router Information LSAs.
+	RITLVCapabilities = 1
+	// RITLVHostname is the Dynamic Hostname TLV (RFC5642 3.1).
+	RITLVHostname = 7
+)
+
+// Bits of the Router Informational Capabilities TLV, numbered from the
+// most significant bit (RFC7770 2.4).
+const (
+	RICapabilityGracefulRestart       uint32 = 1 << (31 - 0)
+	RICapabilityGracefulRestartHelper uint32 = 1 << (31 - 1)
+	RICapabilityStubRouter            uint32 = 1 << (31 - 2)
+	RICapabilityTE                    uint32 = 1 << (31 - 3)
+	RICapabilityPointToPointOverLAN   uint32 = 1 << (31 - 4)
+	RICapabilityExperimentalTE        uint32 = 1 << (31 - 5)
+)
+
+// MaxHostnameLength is the longest hostname of the Dynamic Hostname TLV.
+const MaxHostnameLength = 255
+
+// RouterInfoLSA is the payload of Router Information LSAs.
+type RouterInfoLSA struct {
+	// The Router Informational Capabilities bits.
+	Capabilities uint32
+	// The symbolic name of the router. Empty if not advertised.
+	Hostname string
+	// Unrecognized TLVs are kept and encoded back as is.
+	Unknown []OpaqueTLV
+}
+
+// AsRouterInfoLSA decodes the Router Information LSA from the opaque information.
+func (p V2OpaqueLSA) AsRouterInfoLSA() (RouterInfoLSA, error) {
+	var ret RouterInfoLSA
+	tlvs, err := p.TLVs()
+	if err != nil {
+		return ret, err
+	}
+	for _, t := range tlvs {
+		switch t.Type {
+		case RITLVCapabilities:
+			// The TLV may be longer to hold more capabilities in the future.
+			if len(t.Value) < 4 {
+				return ret, errors.New("invalid length of Router Informational Capabilities TLV")
+			}
+			ret.Capabilities = binary.BigEndian.Uint32(t.Value)
+		case RITLVHostname:
+			if len(t.Value) <= 0 {
+				return ret, errors.New("empty Dynamic Hostname TLV")
+			}
+			ret.Hostname = string(t.Value)
+		default:
+			ret.Unknown = append(ret.Unknown, t)
+		}
+	}
+	return ret, nil
+}
+
+// TLVs encodes the Router Information LSA into the opaque information.
+func (r RouterInfoLSA) TLVs() []OpaqueTLV {
+	ret := []OpaqueTLV{{Type: RITLVCapabilities, Value: binary.BigEndian.AppendUint32(nil, r.Capabilities)}}
+	if r.Hostname != "" {
+		ret = append(ret, OpaqueTLV{Type: RITLVHostname, Value: []byte(r.Hostname)})
+	}
+	return append(ret, r.Unknown...)
+}
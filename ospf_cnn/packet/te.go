@@ -0,0 +1,240 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// TEOpaqueType is the Opaque Type of Traffic Engineering LSAs, which are
+// area-local opaque LSAs (RFC3630 2.2).
+const TEOpaqueType = 1
+
+// Top level TLVs of TE LSAs. An LSA contains exactly one top level TLV.
+const (
+	TETLVRouterAddress = 1
+	TETLVLink          = 2
+)
+
+// Sub-TLVs of the Link TLV (RFC3630 2.5).
+const (
+	TELinkSubTLVLinkType               = 1
+	TELinkSubTLVLinkID                 = 2
+	TELinkSubTLVLocalAddress           = 3
+	TELinkSubTLVRemoteAddress          = 4
+	TELinkSubTLVTEMetric               = 5
+	TELinkSubTLVMaxBandwidth           = 6
+	TELinkSubTLVMaxReservableBandwidth = 7
+	TELinkSubTLVUnreservedBandwidth    = 8
+	TELinkSubTLVAdminGroup             = 9
+)
+
+// Link types of the Link Type sub-TLV.
+const (
+	TELinkTypePointToPoint = 1
+	TELinkTypeMultiAccess  = 2
+)
+
+// TELSA is the payload of Traffic Engineering LSAs per RFC3630. It carries
+// either the Router Address TLV or a Link TLV.
+type TELSA struct {
+	// A stable IP address of the advertising router that is always
+	// reachable if there is any connectivity to it. Valid when Link is nil.
+	RouterAddress uint32
+	// Describes a single link.
+	Link *TELinkTLV
+}
+
+// TELinkTLV describes a single link per RFC3630 2.4.2. Link Type and
+// Link ID are mandatory, while the others are optional and nil if absent.
+// The bandwidths are in bytes per second.
+type TELinkTLV struct {
+	// Either point-to-point or multi-access.
+	LinkType uint8
+	// The Router ID of the neighbor for point-to-point links, or the
+	// interface address of the designated router for multi-access links.
+	LinkID uint32
+	// The IP addresses of the interface corresponding to this link.
+	LocalAddresses []uint32
+	// The IP addresses of the neighbor's interface corresponding to this link.
+	RemoteAddresses []uint32
+	// The link metric for traffic engineering purposes.
+	TEMetric *uint32
+	// The maximum bandwidth that can be used on this link in this direction.
+	MaxBandwidth *float32
+	// The maximum bandwidth that may be reserved on this link in this direction.
+	MaxReservableBandwidth *float32
+	// The amount of bandwidth not yet reserved at each of the eight
+	// priority levels, in ascending order of the priority value.
+	UnreservedBandwidth *[8]float32
+	// The administrative group membership of this link, aka color.
+	AdminGroup *uint32
+	// Unrecognized sub-TLVs are kept and encoded back as is.
+	Unknown []OpaqueTLV
+}
+
+// AsTELSA decodes the TE LSA from the opaque information.
+func (p V2OpaqueLSA) AsTELSA() (TELSA, error) {
+	var ret TELSA
+	tlvs, err := p.TLVs()
+	if err != nil {
+		return ret, err
+	}
+	if len(tlvs) != 1 {
+		return ret, fmt.Errorf("TE LSA contains %d top level TLVs", len(tlvs))
+	}
+	switch tlvs[0].Type {
+	case TETLVRouterAddress:
+		if len(tlvs[0].Value) != 4 {
+			return ret, errors.New("invalid length of Router Address TLV")
+		}
+		ret.RouterAddress = binary.BigEndian.Uint32(tlvs[0].Value)
+	case TETLVLink:
+		ret.Link, err = parseTELinkTLV(tlvs[0].Value)
+	default:
+		err = fmt.Errorf("unknown top level TLV(%d) of TE LSA", tlvs[0].Type)
+	}
+	return ret, err
+}
+
+func parseTELinkTLV(b []byte) (*TELinkTLV, error) {
+	subTLVs, err := ParseOpaqueTLVs(b)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		ret                  = &TELinkTLV{}
+		hasLinkType, hasLink bool
+	)
+	for _, t := range subTLVs {
+		v := t.Value
+		switch t.Type {
+		case TELinkSubTLVLinkType:
+			if len(v) != 1 {
+				return nil, errors.New("invalid length of Link Type sub-TLV")
+			}
+			ret.LinkType, hasLinkType = v[0], true
+		case TELinkSubTLVLinkID:
+			if len(v) != 4 {
+				return nil, errors.New("invalid length of Link ID sub-TLV")
+			}
+			ret.LinkID, hasLink = binary.BigEndian.Uint32(v), true
+		case TELinkSubTLVLocalAddress, TELinkSubTLVRemoteAddress:
+			if len(v) == 0 || len(v)%4 != 0 {
+				return nil, fmt.Errorf("invalid length of interface address sub-TLV(%d)", t.Type)
+			}
+			var addrs []uint32
+			for i := 0; i < len(v); i += 4 {
+				addrs = append(addrs, binary.BigEndian.Uint32(v[i:i+4]))
+			}
+			if t.Type == TELinkSubTLVLocalAddress {
+				ret.LocalAddresses = addrs
+			} else {
+				ret.RemoteAddresses = addrs
+			}
+		case TELinkSubTLVTEMetric, TELinkSubTLVAdminGroup:
+			if len(v) != 4 {
+				return nil, fmt.Errorf("invalid length of sub-TLV(%d)", t.Type)
+			}
+			val := binary.BigEndian.Uint32(v)
+			if t.Type == TELinkSubTLVTEMetric {
+				ret.TEMetric = &val
+			} else {
+				ret.AdminGroup = &val
+			}
+		case TELinkSubTLVMaxBandwidth, TELinkSubTLVMaxReservableBandwidth:
+			if len(v) != 4 {
+				return nil, fmt.Errorf("invalid length of sub-TLV(%d)", t.Type)
+			}
+			bw, err := decodeBandwidth(v)
+			if err != nil {
+				return nil, fmt.Errorf("sub-TLV(%d): %w", t.Type, err)
+			}
+			if t.Type == TELinkSubTLVMaxBandwidth {
+				ret.MaxBandwidth = &bw
+			} else {
+				ret.MaxReservableBandwidth = &bw
+			}
+		case TELinkSubTLVUnreservedBandwidth:
+			if len(v) != 32 {
+				return nil, errors.New("invalid length of Unreserved Bandwidth sub-TLV")
+			}
+			var bws [8]float32
+			for i := range bws {
+				bw, err := decodeBandwidth(v[i*4 : i*4+4])
+				if err != nil {
+					return nil, fmt.Errorf("priority %d of Unreserved Bandwidth sub-TLV: %w", i, err)
+				}
+				bws[i] = bw
+			}
+			ret.UnreservedBandwidth = &bws
+		default:
+			ret.Unknown = append(ret.Unknown, t)
+		}
+	}
+	if !hasLinkType || !hasLink {
+		return nil, errors.New("mandatory Link Type or Link ID sub-TLV missing in Link TLV")
+	}
+	return ret, nil
+}
+
+// decodeBandwidth decodes the bandwidth in IEEE floating point format.
+// NaN and infinities are not valid bandwidths.
+func decodeBandwidth(v []byte) (float32, error) {
+	bw := math.Float32frombits(binary.BigEndian.Uint32(v))
+	if math.IsNaN(float64(bw)) || math.IsInf(float64(bw), 0) {
+		return 0, fmt.Errorf("invalid bandwidth %v", bw)
+	}
+	return bw, nil
+}
+
+// TLVs encodes the TE LSA into the opaque information.
+func (t TELSA) TLVs() []OpaqueTLV {
+	if t.Link == nil {
+		return []OpaqueTLV{{Type: TETLVRouterAddress, Value: binary.BigEndian.AppendUint32(nil, t.RouterAddress)}}
+	}
+	var (
+		l       = t.Link
+		subTLVs = []OpaqueTLV{
+			{Type: TELinkSubTLVLinkType, Value: []byte{l.LinkType}},
+			{Type: TELinkSubTLVLinkID, Value: binary.BigEndian.AppendUint32(nil, l.LinkID)},
+		}
+	)
+	appendAddrs := func(typ uint16, addrs []uint32) {
+		if len(addrs) <= 0 {
+			return
+		}
+		var v []byte
+		for _, addr := range addrs {
+			v = binary.BigEndian.AppendUint32(v, addr)
+		}
+		subTLVs = append(subTLVs, OpaqueTLV{Type: typ, Value: v})
+	}
+	appendUint32 := func(typ uint16, val *uint32) {
+		if val != nil {
+			subTLVs = append(subTLVs, OpaqueTLV{Type: typ, Value: binary.BigEndian.AppendUint32(nil, *val)})
+		}
+	}
+	appendBandwidth := func(typ uint16, bw *float32) {
+		if bw != nil {
+			subTLVs = append(subTLVs, OpaqueTLV{Type: typ, Value: binary.BigEndian.AppendUint32(nil, math.Float32bits(*bw))})
+		}
+	}
+	appendAddrs(TELinkSubTLVLocalAddress, l.LocalAddresses)
+	appendAddrs(TELinkSubTLVRemoteAddress, l.RemoteAddresses)
+	appendUint32(TELinkSubTLVTEMetric, l.TEMetric)
+	appendBandwidth(TELinkSubTLVMaxBandwidth, l.MaxBandwidth)
+	appendBandwidth(TELinkSubTLVMaxReservableBandwidth, l.MaxReservableBandwidth)
+	if l.UnreservedBandwidth != nil {
+		var v []byte
+		for _, bw := range l.UnreservedBandwidth {
+			v = binary.BigEndian.AppendUint32(v, math.Float32bits(bw))
+		}
+		subTLVs = append(subTLVs, OpaqueTLV{Type: TELinkSubTLVUnreservedBandwidth, Value: v})
+	}
+	appendUint32(TELinkSubTLVAdminGroup, l.AdminGroup)
+	subTLVs = append(subTLVs, l.Unknown...)
+
+	return []OpaqueTLV{{Type: TETLVLink, Value: NewV2OpaqueLSA(subTLVs...).Data}}
+}
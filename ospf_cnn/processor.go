@@ -1,50 +1,52 @@
 package ospf_cnn
 
 import (
+	"fmt"
 	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
 	"github.com/gopacket/gopacket/layers"
 	"golang.org/x/net/ipv4"
 )
 
-func (i *Interface) doParsedMsgProcessing(h *ipv4.Header, op *packet2.LayerOSPFv2) {
+func (i *Interface) doParsedMsgProcessing(pkt recvPkt, op *packet2.LayerOSPFv2) {
+	h := pkt.h
 	switch op.Type {
 	case layers.OSPFHello:
 		hello, err := op.AsHello()
 		if err != nil {
-			LogErr("invalid OSPF Hello pkt")
+			i.rejectPkt(pkt, RejectMalformed, err)
 			return
 		}
 		i.Area.procHello(i, h, hello)
 	case layers.OSPFDatabaseDescription:
 		dbd, err := op.AsDbDescription()
 		if err != nil {
-			LogErr("invalid OSPF DatabaseDesc pkt")
+			i.rejectPkt(pkt, RejectMalformed, err)
 			return
 		}
 		i.Area.procDatabaseDesc(i, h, dbd)
 	case layers.OSPFLinkStateRequest:
 		lsr, err := op.AsLSRequest()
 		if err != nil {
-			LogErr("invalid OSPF LSR pkt")
+			i.rejectPkt(pkt, RejectMalformed, err)
 			return
 		}
 		i.Area.procLSR(i, h, lsr)
 	case layers.OSPFLinkStateUpdate:
 		lsu, err := op.AsLSUpdate()
 		if err != nil {
-			LogErr("invalid OSPF LSU pkt")
+			i.rejectPkt(pkt, RejectMalformed, err)
 			return
 		}
 		i.Area.procLSU(i, h, lsu)
 	case layers.OSPFLinkStateAcknowledgment:
 		lsack, err := op.AsLSAcknowledgment()
 		if err != nil {
-			LogErr("invalid OSPF LSAck pkt")
+			i.rejectPkt(pkt, RejectMalformed, err)
 			return
 		}
 		i.Area.procLSAck(i, h, lsack)
 	default:
-		LogWarn("discarded unknown OSPF packet type: %v", op.Type)
+		i.rejectPkt(pkt, RejectUnknownPacketType, fmt.Errorf("packet type %v", op.Type))
 	}
 }
 
@@ -53,93 +55,116 @@ func (a *Area) procHello(i *Interface, h *ipv4.Header, hello *packet2.OSPFv2Pack
 
 	// pre-checks
 	if hello.Content.HelloInterval != i.HelloInterval || hello.Content.RouterDeadInterval != i.RouterDeadInterval ||
-		(i.shouldCheckNeighborNetworkMask() && ipv4MaskToUint32(i.Address.Mask) != hello.Content.NetworkMask) {
+		(i.shouldCheckNeighborNetworkMask() && ipv4MaskToUint32(i.getAddress().Mask) != hello.Content.NetworkMask) {
 		LogWarn("rejected Hello from RouterId(%v) AreaId(%v): pre-check failure", hello.RouterID, hello.AreaID)
 		return
 	}
+	// The setting of the E-bit found in the Hello Packet's Options field
+	// must match this area's ExternalRoutingCapability.  If AS-external-LSAs
+	// are not flooded into/throughout the area (i.e, the area is a "stub")
+	// the E-bit must be clear in received Hello Packets, otherwise the
+	// E-bit must be set.  A mismatch causes processing to stop and the
+	// packet to be dropped.
+	if packet2.BitOption(hello.Content.Options).IsBitSet(packet2.CapabilityEbit) != a.ExternalRoutingCapability {
+		LogWarn("rejected Hello from RouterId(%v) AreaId(%v): E-bit mismatch with ExternalRoutingCapability(%v)",
+			hello.RouterID, hello.AreaID, a.ExternalRoutingCapability)
+		return
+	}
+	// Likewise, the N-bit must be set in Hellos received in an NSSA and
+	// clear otherwise, so that all routers of the area agree on whether
+	// it is an NSSA (RFC3101 2.1).
+	if packet2.BitOption(hello.Content.Options).IsBitSet(packet2.CapabilityNPbit) != a.NSSA {
+		LogWarn("rejected Hello from RouterId(%v) AreaId(%v): N-bit mismatch with NSSA(%v)",
+			hello.RouterID, hello.AreaID, a.NSSA)
+		return
+	}
 
 	neighborId := hello.RouterID
 	neighbor, ok := i.getNeighbor(neighborId)
 	if !ok {
 		neighbor = i.addNeighbor(h, hello)
-	} else {
-		defer func() {
-			// update RtrPriority / DR / BDR
-			neighbor.NeighborsDR = hello.Content.DesignatedRouterID
-			neighbor.NeighborsBDR = hello.Content.BackupDesignatedRouterID
-			neighbor.NeighborPriority = hello.Content.RtrPriority
-		}()
 	}
+	// Remember the neighbor's previous view of the network before
+	// updating the neighbor structure with the received Hello.
+	var (
+		prevPriority = neighbor.NeighborPriority
+		prevDR       = neighbor.NeighborsDR
+		prevBDR      = neighbor.NeighborsBDR
+	)
+	neighbor.NeighborPriority = hello.Content.RtrPriority
+	neighbor.NeighborsDR = hello.Content.DesignatedRouterID
+	neighbor.NeighborsBDR = hello.Content.BackupDesignatedRouterID
+
 	// Each Hello Packet causes the neighbor state machine to be
 	// executed with the event HelloReceived.
 	neighbor.consumeEvent(NbEvHelloReceived)
+	i.replyNBMAHello(h, neighbor, hello)
 
-	if i.shouldHaveDR() {
-		// Then the list of neighbors contained in the Hello Packet is examined.
-		isMySelfSeen := false
-		for _, seenNbs := range hello.Content.NeighborID {
-			if seenNbs == a.ins.RouterId {
-				isMySelfSeen = true
-				break
-			}
-		}
-		if isMySelfSeen {
-			// If the router itself appears in this list, the
-			// neighbor state machine should be executed with the event 2-WayReceived.
-			neighbor.consumeEvent(NbEv2WayReceived)
-			// for the reason that rt priority is always 0.
-			// just some handy addon
-			if i.changeDRAndBDR(neighbor.NeighborsDR, neighbor.NeighborsBDR) {
-				a.updateSelfOriginatedLSAWhenDRorBDRChanged(i)
-				neighbor.consumeEvent(NbEvIsAdjOK)
-			}
-		} else {
-			// Otherwise, the neighbor state machine should
-			// be executed with the event 1-WayReceived, and the processing of the packet stops.
-			neighbor.consumeEvent(NbEv1Way)
-			return
-		}
-		// Next, if a change in the neighbor's Router Priority field
-		// was noted, the receiving interface's state machine is
-		// scheduled with the event NeighborChange.
-		if neighbor.NeighborPriority != hello.Content.RtrPriority {
-			i.consumeEvent(IfEvNeighborChange)
-		}
-		// If the neighbor is both declaring itself to be Designated
-		// Router (Hello Packet's Designated Router field = Neighbor IP
-		// address) and the Backup Designated Router field in the
-		// packet is equal to 0.0.0.0 and the receiving interface is in
-		// state Waiting, the receiving interface's state machine is
-		// scheduled with the event BackupSeen.
-		nbAddr := ipv4BytesToUint32(neighbor.NeighborAddress.To4())
-		if i.currState() == InterfaceWaiting && nbAddr == hello.Content.DesignatedRouterID &&
-			hello.Content.BackupDesignatedRouterID == 0 {
-			i.consumeEvent(IfEvBackupSeen)
-		} else if nbAddr == hello.Content.DesignatedRouterID &&
-			neighbor.NeighborsDR != nbAddr {
-			// Otherwise, if the neighbor is declaring itself to be Designated Router and it
-			// had not previously, or the neighbor is not declaring itself
-			// Designated Router where it had previously, the receiving
-			// interface's state machine is scheduled with the event NeighborChange.
-			i.consumeEvent(IfEvNeighborChange)
-		}
-		// If the neighbor is declaring itself to be Backup Designated
-		// Router (Hello Packet's Backup Designated Router field =
-		// Neighbor IP address) and the receiving interface is in state
-		// Waiting, the receiving interface's state machine is
-		// scheduled with the event BackupSeen.
-		if i.currState() == InterfaceWaiting && nbAddr == hello.Content.BackupDesignatedRouterID {
-			i.consumeEvent(IfEvBackupSeen)
-		} else if nbAddr == hello.Content.BackupDesignatedRouterID &&
-			neighbor.NeighborsBDR != nbAddr {
-			// Otherwise, if the neighbor is declaring itself to be Backup Designated Router
-			// and it had not previously, or the neighbor is not declaring
-			// itself Backup Designated Router where it had previously, the
-			// receiving interface's state machine is scheduled with the
-			// event NeighborChange.
-			i.consumeEvent(IfEvNeighborChange)
+	// Then the list of neighbors contained in the Hello Packet is examined.
+	isMySelfSeen := false
+	for _, seenNbs := range hello.Content.NeighborID {
+		if seenNbs == a.ins.RouterId {
+			isMySelfSeen = true
+			break
 		}
 	}
+	if !isMySelfSeen {
+		// Otherwise, the neighbor state machine should
+		// be executed with the event 1-WayReceived, and the processing of the packet stops.
+		neighbor.consumeEvent(NbEv1Way)
+		return
+	}
+	// If the router itself appears in this list, the
+	// neighbor state machine should be executed with the event 2-WayReceived.
+	neighbor.consumeEvent(NbEv2WayReceived)
+
+	if !i.shouldHaveDR() {
+		return
+	}
+	// Next, if a change in the neighbor's Router Priority field
+	// was noted, the receiving interface's state machine is
+	// scheduled with the event NeighborChange.
+	if prevPriority != hello.Content.RtrPriority {
+		i.consumeEvent(IfEvNeighborChange)
+	}
+	var (
+		nbAddr           = ipv4BytesToUint32(neighbor.NeighborAddress.To4())
+		declaresDR       = nbAddr == hello.Content.DesignatedRouterID
+		declaresBDR      = nbAddr == hello.Content.BackupDesignatedRouterID
+		previouslyDR     = nbAddr == prevDR
+		previouslyBDR    = nbAddr == prevBDR
+		isWaitingOnHello = i.currState() == InterfaceWaiting
+	)
+	// If the neighbor is both declaring itself to be Designated
+	// Router (Hello Packet's Designated Router field = Neighbor IP
+	// address) and the Backup Designated Router field in the
+	// packet is equal to 0.0.0.0 and the receiving interface is in
+	// state Waiting, the receiving interface's state machine is
+	// scheduled with the event BackupSeen.
+	if isWaitingOnHello && declaresDR && hello.Content.BackupDesignatedRouterID == 0 {
+		i.consumeEvent(IfEvBackupSeen)
+	} else if declaresDR != previouslyDR {
+		// Otherwise, if the neighbor is declaring itself to be Designated Router and it
+		// had not previously, or the neighbor is not declaring itself
+		// Designated Router where it had previously, the receiving
+		// interface's state machine is scheduled with the event NeighborChange.
+		i.consumeEvent(IfEvNeighborChange)
+	}
+	// If the neighbor is declaring itself to be Backup Designated
+	// Router (Hello Packet's Backup Designated Router field =
+	// Neighbor IP address) and the receiving interface is in state
+	// Waiting, the receiving interface's state machine is
+	// scheduled with the event BackupSeen.
+	if isWaitingOnHello && declaresBDR {
+		i.consumeEvent(IfEvBackupSeen)
+	} else if declaresBDR != previouslyBDR {
+		// Otherwise, if the neighbor is declaring itself to be Backup Designated Router
+		// and it had not previously, or the neighbor is not declaring
+		// itself Backup Designated Router where it had previously, the
+		// receiving interface's state machine is scheduled with the
+		// event NeighborChange.
+		i.consumeEvent(IfEvNeighborChange)
+	}
 }
 
 func (a *Area) procDatabaseDesc(i *Interface, h *ipv4.Header, dd *packet2.OSPFv2Packet[packet2.DbDescPayload]) {
@@ -154,11 +179,17 @@ func (a *Area) procDatabaseDesc(i *Interface, h *ipv4.Header, dd *packet2.OSPFv2
 	// If the Interface MTU field in the Database Description packet
 	// indicates an IP datagram size that is larger than the router can
 	// accept on the receiving interface without fragmentation, the
-	// Database Description packet is rejected.
+	// Database Description packet is rejected. The check is skipped if
+	// configured to ignore the MTU mismatch.
 	if dd.Content.InterfaceMTU > i.MTU {
-		LogWarn("rejected DatabaseDesc from NeighborId(%v) AreaId(%v): neighbor MTU(%d) > InterfaceMTU(%d)",
+		if !i.mtuIgnore {
+			i.rejected[RejectMTUMismatch].Add(1)
+			LogWarn("rejected DatabaseDesc from NeighborId(%v) AreaId(%v): neighbor MTU(%d) > InterfaceMTU(%d)",
+				dd.RouterID, dd.AreaID, dd.Content.InterfaceMTU, i.MTU)
+			return
+		}
+		LogDebug("ignored MTU mismatch of DatabaseDesc from NeighborId(%v) AreaId(%v): neighbor MTU(%d) > InterfaceMTU(%d)",
 			dd.RouterID, dd.AreaID, dd.Content.InterfaceMTU, i.MTU)
-		return
 	}
 	switch nbSt := neighbor.currState(); nbSt {
 	case Neighbor2Way:
@@ -281,10 +312,13 @@ func (a *Area) procDatabaseDesc(i *Interface, h *ipv4.Header, dd *packet2.OSPFv2
 		if neighbor.IsMaster {
 			// im slave. save the dd seq number offered by master
 			neighbor.DDSeqNumber.Store(dd.Content.DDSeqNumber)
+			// process the dd from master first, so nothing is sent
+			// for the dd rejected with SeqNumberMismatch.
+			if !neighbor.parseDD(dd) {
+				return
+			}
 			// echo the dd from master and send dd of my own.
-			// then process the dd from master
 			allDDSent := neighbor.slaveDDEchoAndExchange(dd)
-			neighbor.parseDD(dd)
 			if !packet2.BitOption(dd.Content.Flags).IsBitSet(packet2.DDFlagMbit) && allDDSent {
 				// no more DD packets from master.
 				// and all local dd has been sent.
@@ -294,7 +328,9 @@ func (a *Area) procDatabaseDesc(i *Interface, h *ipv4.Header, dd *packet2.OSPFv2
 		} else {
 			// im master. this is a dd echo packet with summary.
 			// parse it and try continue sending next dd
-			neighbor.parseDD(dd)
+			if !neighbor.parseDD(dd) {
+				return
+			}
 			if needWaitForAck := neighbor.masterContinueDDExchange(packet2.BitOption(dd.Content.Flags).
 				IsBitSet(packet2.DDFlagMbit)); !needWaitForAck {
 				// no dd echo need(no dd packet has been sent or slave has finished DD, too).
@@ -383,18 +419,25 @@ func (a *Area) procLSU(i *Interface, h *ipv4.Header, lsu *packet2.OSPFv2Packet[p
 	for _, l := range lsu.Content.LSAs {
 		err := l.ValidateLSA()
 		if err != nil {
-			LogErr("wrong LSA from NeighborId: %v AreaId: %v", neighbor.NeighborId, a.AreaId)
+			i.rejectLSA(neighbor, l, err)
 			continue
 		}
 		// if this is an AS-external-LSA (LS type = 5), and the area
 		//        has been configured as a stub area, discard the LSA and get the
 		//        next one from the Link State Update Packet.  AS-external-LSAs
 		//        are not flooded into/throughout stub areas
-		if !a.ExternalRoutingCapability && l.LSType == layers.ASExternalLSAtypeV2 {
+		// The same applies to AS-scoped opaque LSAs (RFC5250 3).
+		if !a.ExternalRoutingCapability &&
+			(l.LSType == layers.ASExternalLSAtypeV2 || l.LSType == packet2.ASOpaqueLSAtypeV2) {
+			continue
+		}
+		// Type-7 LSAs are only flooded throughout the NSSA they are
+		//        originated in (RFC3101 2.4).
+		if !a.NSSA && l.LSType == layers.NSSALSAtypeV2 {
 			continue
 		}
 
-		lsaHdrFromLSDB, _, lsaMetaFromLSDB, existInLSDB := a.lsDbGetLSAByIdentity(l.GetLSAIdentity(), false)
+		lsaHdrFromLSDB, _, lsaMetaFromLSDB, existInLSDB := i.lsDbGetLSAByIdentity(l.GetLSAIdentity(), false)
 		// if the LSA's LS age is equal to MaxAge, and there is
 		//        currently no instance of the LSA in the router's link state
 		//        database, and none of router's neighbors are in states Exchange
@@ -430,7 +473,7 @@ func (a *Area) procLSU(i *Interface, h *ipv4.Header, lsu *packet2.OSPFv2Packet[p
 			// (c) Remove the current database copy from all neighbors' Link
 			//            state retransmission lists.
 			if existInLSDB {
-				a.removeAllNeighborsLSRetransmission(lsaHdrFromLSDB.GetLSAIdentity())
+				i.removeAllNeighborsLSRetransmission(lsaHdrFromLSDB.GetLSAIdentity())
 			}
 
 			// (d) Install the new LSA in the link state database (replacing
@@ -441,7 +484,12 @@ func (a *Area) procLSU(i *Interface, h *ipv4.Header, lsu *packet2.OSPFv2Packet[p
 			//            newly installed LSA until MinLSArrival seconds have elapsed.
 			//            The LSA installation process is discussed further in Section
 			//            13.2.
-			a.lsDbInstallReceivedLSA(l)
+			i.lsDbInstallReceivedLSA(l)
+			// A Grace-LSA asks the router to help the neighbor through
+			// its graceful restart (RFC3623 3.1).
+			if l.LSType == packet2.LinkLocalOpaqueLSAtypeV2 && l.AdvRouter != a.ins.RouterId {
+				i.procReceivedGraceLSA(l)
+			}
 
 			// (b) Otherwise immediately flood the new LSA out some subset of
 			//            the router's interfaces (see Section 13.3).  In some cases
@@ -459,7 +507,7 @@ func (a *Area) procLSU(i *Interface, h *ipv4.Header, lsu *packet2.OSPFv2Packet[p
 			if i.currState() == InterfaceBackup {
 				// Delayed ack should be sent if this LSA is received from DR,
 				// otherwise do nothing
-				if i.DR.Load() == neighbor.NeighborId {
+				if i.DR.Load() == ipv4BytesToUint32(neighbor.NeighborAddress.To4()) {
 					delayedAcks = append(delayedAcks, l.GetLSAck())
 				}
 			} else {
@@ -475,7 +523,15 @@ func (a *Area) procLSU(i *Interface, h *ipv4.Header, lsu *packet2.OSPFv2Packet[p
 			//            routing domain. For a description of how self-originated
 			//            LSAs are detected and subsequently handled, see Section
 			//            13.4.
-			if a.isSelfOriginatedLSA(l.LSAheader) {
+			if a.ins.isGracefullyRestarting() {
+				// While restarting gracefully, the LSAs originated before
+				// restarting are accepted as valid rather than updated or
+				// flushed (RFC3623 2.2). It is done once the restart is over.
+				a.checkGracefulRestartConsistency(l)
+			} else if l.LSType == packet2.LinkLocalOpaqueLSAtypeV2 && l.AdvRouter == a.ins.RouterId {
+				// Link-local opaque LSAs are kept by the interface.
+				i.dealWithReceivedSelfOriginatedLinkLocalLSA(l, existInLSDB)
+			} else if a.isSelfOriginatedLSA(l.LSAheader) {
 				// if the received self-originated LSA is newer than the
 				//        last instance that the router actually originated, the router
 				//        must take special action.  The reception of such an LSA
@@ -523,7 +579,7 @@ func (a *Area) procLSU(i *Interface, h *ipv4.Header, lsu *packet2.OSPFv2Packet[p
 				if i.currState() == InterfaceBackup {
 					// Delayed ack should be sent if received from DR.
 					// otherwise do nothing.
-					if i.DR.Load() == neighbor.NeighborId {
+					if i.DR.Load() == ipv4BytesToUint32(neighbor.NeighborAddress.To4()) {
 						delayedAcks = append(delayedAcks, l.GetLSAck())
 					}
 				}
@@ -573,13 +629,17 @@ func (a *Area) procLSU(i *Interface, h *ipv4.Header, lsu *packet2.OSPFv2Packet[p
 				dst = allSPFRouters
 			}
 			i.sendDelayedLSAcks(delayedAcks, dst)
+		case IfTypePointToPoint:
+			// On physical point-to-point networks, the destination
+			//        is always AllSPFRouters.
+			i.sendDelayedLSAcks(delayedAcks, allSPFRouters)
 		default:
 			// On non-broadcast networks, delayed Link State Acknowledgment packets must be
 			// unicast separately over each adjacency (i.e., neighbor whose
 			// state is >= Exchange).
 			i.rangeOverNeighbors(func(nb *Neighbor) bool {
 				if nb.currState() >= NeighborExchange {
-					neighbor.directSendDelayedLSAcks(delayedAcks)
+					nb.directSendDelayedLSAcks(delayedAcks)
 				}
 				return true
 			})
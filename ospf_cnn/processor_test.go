@@ -0,0 +1,57 @@
+package ospf_cnn
+
+import (
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"net"
+	"testing"
+
+	"github.com/gopacket/gopacket/layers"
+	"golang.org/x/net/ipv4"
+)
+
+func TestProcDatabaseDescRejectedInStubArea(t *testing.T) {
+	i := newTestABR()
+	a := i.getArea(ip("0.0.0.1"))
+	a.ExternalRoutingCapability = false
+	ifi := a.Interfaces[0]
+	ifi.pendingSendPkt = make(chan sendPkt, 10)
+	// Slave to the master 4.4.4.4 with an LSA yet to be described.
+	nb := ifi.testFullNeighbor(t, "4.4.4.4")
+	nb.NeighborAddress = net.ParseIP("10.1.0.4").To4()
+	nb.State = NeighborExchange
+	nb.IsMaster = true
+	nb.NeighborOptions = packet2.BitOption(ifi.ddOptions())
+	nb.DDSeqNumber.Store(100)
+	nb.DatabaseSummary = []packet2.LSAIdentity{{LSType: layers.RouterLSAtypeV2, LinkStateId: ip("1.1.1.1"), AdvRouter: ip("1.1.1.1")}}
+
+	dd := &packet2.OSPFv2Packet[packet2.DbDescPayload]{
+		OSPFv2: ifi.ospfPktHeader(func(p *packet2.LayerOSPFv2) {
+			p.Type = layers.OSPFDatabaseDescription
+			p.RouterID = ip("4.4.4.4")
+		}),
+		Content: packet2.DbDescPayload{
+			DbDescPkg: layers.DbDescPkg{
+				Options:     ifi.ddOptions(),
+				Flags:       uint16(packet2.BitOption(0).SetBit(packet2.DDFlagMSbit)),
+				DDSeqNumber: 101,
+			},
+			LSAinfo: []packet2.LSAheader{testLSAHeader(layers.ASExternalLSAtypeV2, "192.168.0.0", "5.5.5.5")},
+		},
+	}
+	a.procDatabaseDesc(ifi, &ipv4.Header{Src: nb.NeighborAddress, Dst: net.ParseIP("10.1.0.1")}, dd)
+
+	if st := nb.currState(); st != NeighborExStart {
+		t.Errorf("expecting neighbor %v but got %v", NeighborExStart, st)
+	}
+	if echo := nb.lastSlaveDDSent.Get(); echo != nil {
+		t.Errorf("expecting no DD echoed but got %+v", echo)
+	}
+	// Only the DD starting the master negotiation is sent.
+	for len(ifi.pendingSendPkt) > 0 {
+		pkt := <-ifi.pendingSendPkt
+		sent, ok := pkt.p.(*packet2.OSPFv2Packet[packet2.DbDescPayload])
+		if !ok || !packet2.BitOption(sent.Content.Flags).IsBitSet(packet2.DDFlagIbit) {
+			t.Errorf("expecting only the initial DD sent but got %+v", pkt.p)
+		}
+	}
+}
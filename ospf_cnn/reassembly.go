@@ -0,0 +1,199 @@
+package ospf_cnn
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// Limits of the IPv4 fragment reassembly of an interface. OSPF packets larger
+// than the MTU, e.g. Link State Update packets sent by neighbors with larger
+// MTUs or behind tunnels, are fragmented by the IP layer (RFC2328 A.1).
+const (
+	// How long the fragments of a datagram are kept waiting for the rest.
+	reassemblyTimeout = 30 * time.Second
+	// The maximum number of datagrams being reassembled at the same time.
+	reassemblyMaxDatagrams = 64
+	// The maximum number of payload bytes buffered for reassembly.
+	reassemblyMaxBytes = 1 << 20
+	// The maximum payload length of an IPv4 datagram.
+	reassemblyMaxPayloadLen = 0xffff - ipv4.HeaderLen
+)
+
+// ReassemblyStats counts the IPv4 fragments received on an interface.
+type ReassemblyStats struct {
+	// Datagrams completely reassembled from their fragments.
+	Reassembled uint64
+	// Datagrams discarded as not all of their fragments were received
+	// in time.
+	Expired uint64
+	// Fragments discarded as the reassembled datagram would exceed the
+	// maximum IPv4 datagram length.
+	Oversized uint64
+	// Fragments discarded as malformed, e.g. overlapping each other, or the
+	// buffer being full.
+	Discarded uint64
+}
+
+// fragmentKey identifies the datagram a fragment belongs to (RFC791 3.2).
+type fragmentKey struct {
+	src, dst uint32
+	proto    int
+	id       int
+}
+
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+// partialDatagram is a datagram whose fragments are being received.
+type partialDatagram struct {
+	// The header of the first fragment, i.e. whose offset is 0.
+	h         *ipv4.Header
+	fragments []fragment
+	// The total payload length, known once the last fragment is received.
+	totalLen int
+	size     int
+	expiry   time.Time
+}
+
+// fragmentReassembler reassembles IPv4 datagrams from fragments keyed by
+// their source and destination addresses, protocols and identifications. The zero value is ready to use.
+type fragmentReassembler struct {
+	mu      sync.Mutex
+	pending map[fragmentKey]*partialDatagram
+	bytes   int
+	stats   ReassemblyStats
+}
+
+func (r *fragmentReassembler) getStats() ReassemblyStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+func isFragment(h *ipv4.Header) bool {
+	return h.Flags&ipv4.MoreFragments != 0 || h.FragOff != 0
+}
+
+// add buffers the fragment. It returns the header and payload of the datagram
+// once all of its fragments have been received.
+func (r *fragmentReassembler) add(h *ipv4.Header, payload []byte, now time.Time) (*ipv4.Header, []byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireLocked(now)
+
+	key := fragmentKey{
+		src:   ipv4BytesToUint32(h.Src.To4()),
+		dst:   ipv4BytesToUint32(h.Dst.To4()),
+		proto: h.Protocol,
+		id:    h.ID,
+	}
+	offset := h.FragOff * 8
+	end := offset + len(payload)
+	more := h.Flags&ipv4.MoreFragments != 0
+	if end > reassemblyMaxPayloadLen {
+		r.stats.Oversized++
+		r.dropLocked(key)
+		return nil, nil, false
+	}
+	// All fragments but the last carry a multiple of 8 octets of data.
+	if len(payload) == 0 || (more && len(payload)%8 != 0) {
+		r.stats.Discarded++
+		r.dropLocked(key)
+		return nil, nil, false
+	}
+	d, ok := r.pending[key]
+	if !ok {
+		if len(r.pending) >= reassemblyMaxDatagrams || r.bytes+len(payload) > reassemblyMaxBytes {
+			r.stats.Discarded++
+			return nil, nil, false
+		}
+		if r.pending == nil {
+			r.pending = make(map[fragmentKey]*partialDatagram)
+		}
+		d = &partialDatagram{totalLen: -1, expiry: now.Add(reassemblyTimeout)}
+		r.pending[key] = d
+	} else if r.bytes+len(payload) > reassemblyMaxBytes {
+		r.stats.Discarded++
+		r.dropLocked(key)
+		return nil, nil, false
+	}
+	if !more {
+		if (d.totalLen >= 0 && d.totalLen != end) || (d.size > 0 && d.fragments[len(d.fragments)-1].offset+
+			len(d.fragments[len(d.fragments)-1].data) > end) {
+			// Conflicting last fragments, or data beyond the end.
+			r.stats.Discarded++
+			r.dropLocked(key)
+			return nil, nil, false
+		}
+		d.totalLen = end
+	} else if d.totalLen >= 0 && end > d.totalLen {
+		r.stats.Discarded++
+		r.dropLocked(key)
+		return nil, nil, false
+	}
+	idx, found := slices.BinarySearchFunc(d.fragments, offset, func(f fragment, offset int) int {
+		return f.offset - offset
+	})
+	if found && len(d.fragments[idx].data) == len(payload) {
+		// A duplicate, e.g. retransmitted.
+		return nil, nil, false
+	}
+	// Overlapping fragments are never sent by legitimate senders, so the
+	// whole datagram is discarded rather than resolving the overlap.
+	if found || (idx > 0 && d.fragments[idx-1].offset+len(d.fragments[idx-1].data) > offset) ||
+		(idx < len(d.fragments) && d.fragments[idx].offset < end) {
+		r.stats.Discarded++
+		r.dropLocked(key)
+		return nil, nil, false
+	}
+	d.fragments = slices.Insert(d.fragments, idx, fragment{offset: offset, data: payload})
+	d.size += len(payload)
+	r.bytes += len(payload)
+	if offset == 0 {
+		d.h = h
+	}
+	if d.totalLen < 0 || d.size != d.totalLen {
+		return nil, nil, false
+	}
+	// Non-overlapping fragments adding up to the total length leave no holes.
+	ret := make([]byte, 0, d.totalLen)
+	for _, f := range d.fragments {
+		ret = append(ret, f.data...)
+	}
+	rh := *d.h
+	rh.Flags &^= ipv4.MoreFragments
+	rh.FragOff = 0
+	rh.TotalLen = rh.Len + d.totalLen
+	r.dropLocked(key)
+	r.stats.Reassembled++
+	return &rh, ret, true
+}
+
+func (r *fragmentReassembler) dropLocked(key fragmentKey) {
+	if d, ok := r.pending[key]; ok {
+		r.bytes -= d.size
+		delete(r.pending, key)
+	}
+}
+
+// expire discards the datagrams whose fragments have not all been received
+// within reassemblyTimeout.
+func (r *fragmentReassembler) expire(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireLocked(now)
+}
+
+func (r *fragmentReassembler) expireLocked(now time.Time) {
+	for key, d := range r.pending {
+		if now.After(d.expiry) {
+			r.dropLocked(key)
+			r.stats.Expired++
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package ospf_cnn
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+type testFragment struct {
+	src, dst string
+	proto    int
+	id       int
+	// offset in octets, a multiple of 8.
+	offset int
+	more   bool
+	data   []byte
+}
+
+func (f testFragment) header() *ipv4.Header {
+	h := &ipv4.Header{
+		Version:  ipv4.Version,
+		Len:      ipv4.HeaderLen,
+		TotalLen: ipv4.HeaderLen + len(f.data),
+		ID:       f.id,
+		FragOff:  f.offset / 8,
+		TTL:      1,
+		Protocol: f.proto,
+		Src:      net.ParseIP(f.src),
+		Dst:      net.ParseIP(f.dst),
+	}
+	if h.Protocol == 0 {
+		h.Protocol = 89
+	}
+	if f.more {
+		h.Flags = ipv4.MoreFragments
+	}
+	return h
+}
+
+// testFragments splits data into fragments of size octets from 10.0.0.2 to
+// 224.0.0.5.
+func testFragments(id int, data []byte, size int) (ret []testFragment) {
+	for offset := 0; offset < len(data); offset += size {
+		end := min(offset+size, len(data))
+		ret = append(ret, testFragment{
+			src: "10.0.0.2", dst: "224.0.0.5", id: id,
+			offset: offset, more: end < len(data), data: data[offset:end],
+		})
+	}
+	return
+}
+
+func TestFragmentReassembler(t *testing.T) {
+	data := make([]byte, 50)
+	for idx := range data {
+		data[idx] = byte(idx)
+	}
+	frags := testFragments(1, data, 16)
+	other := testFragments(1, data, 16)
+	for idx := range other {
+		other[idx].dst = "224.0.0.6"
+	}
+	overlapping := frags[1]
+	overlapping.offset = 8
+	for _, tt := range []struct {
+		name       string
+		fragments  []testFragment
+		advance    time.Duration
+		expected   []byte
+		stats      ReassemblyStats
+		numPending int
+	}{
+		{
+			name:      "in order",
+			fragments: frags,
+			expected:  data,
+			stats:     ReassemblyStats{Reassembled: 1},
+		},
+		{
+			name:      "out of order",
+			fragments: []testFragment{frags[3], frags[1], frags[0], frags[2]},
+			expected:  data,
+			stats:     ReassemblyStats{Reassembled: 1},
+		},
+		{
+			name:      "duplicate fragment",
+			fragments: []testFragment{frags[0], frags[1], frags[1], frags[2], frags[3]},
+			expected:  data,
+			stats:     ReassemblyStats{Reassembled: 1},
+		},
+		{
+			name:      "overlapping fragments",
+			fragments: []testFragment{frags[0], overlapping, frags[2], frags[3]},
+			stats:     ReassemblyStats{Discarded: 1},
+			// The remaining fragments start a new datagram.
+			numPending: 1,
+		},
+		{
+			name:       "missing fragment",
+			fragments:  []testFragment{frags[0], frags[1], frags[3]},
+			numPending: 1,
+		},
+		{
+			name:      "missing fragment timed out",
+			fragments: []testFragment{frags[0], frags[1], frags[3]},
+			advance:   reassemblyTimeout + time.Second,
+			stats:     ReassemblyStats{Expired: 1},
+		},
+		{
+			// Fragments with the same source and identification are
+			// still distinct datagrams when sent to other destinations.
+			name:       "different destinations",
+			fragments:  []testFragment{frags[0], other[1], frags[2], other[3]},
+			numPending: 2,
+		},
+		{
+			name:       "different protocols",
+			fragments:  []testFragment{frags[0], frags[1], frags[2], {src: "10.0.0.2", dst: "224.0.0.5", proto: 17, id: 1, offset: 48, data: data[48:]}},
+			numPending: 2,
+		},
+		{
+			name:      "misaligned fragment",
+			fragments: []testFragment{frags[0], {src: "10.0.0.2", dst: "224.0.0.5", id: 1, offset: 16, more: true, data: data[16:30]}},
+			stats:     ReassemblyStats{Discarded: 1},
+		},
+		{
+			name:      "oversized datagram",
+			fragments: []testFragment{{src: "10.0.0.2", dst: "224.0.0.5", id: 1, offset: reassemblyMaxPayloadLen &^ 7, data: data[:16]}},
+			stats:     ReassemblyStats{Oversized: 1},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				r   fragmentReassembler
+				got []byte
+				now = time.Now()
+			)
+			for _, f := range tt.fragments {
+				h, payload, ok := r.add(f.header(), f.data, now)
+				if !ok {
+					continue
+				}
+				if got != nil {
+					t.Fatalf("expecting a single datagram but got another %v", payload)
+				}
+				if h.FragOff != 0 || h.Flags&ipv4.MoreFragments != 0 || h.TotalLen != h.Len+len(payload) {
+					t.Errorf("expecting unfragmented header but got %+v", h)
+				}
+				got = payload
+			}
+			r.expire(now.Add(tt.advance))
+			if !bytes.Equal(got, tt.expected) {
+				t.Errorf("expecting datagram %v but got %v", tt.expected, got)
+			}
+			if stats := r.getStats(); stats != tt.stats {
+				t.Errorf("expecting stats %+v but got %+v", tt.stats, stats)
+			}
+			if len(r.pending) != tt.numPending {
+				t.Errorf("expecting %d pending datagrams but got %d", tt.numPending, len(r.pending))
+			}
+			size := 0
+			for _, d := range r.pending {
+				size += d.size
+			}
+			if r.bytes != size {
+				t.Errorf("expecting %d buffered bytes but got %d", size, r.bytes)
+			}
+		})
+	}
+}
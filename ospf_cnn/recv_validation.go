@@ -0,0 +1,209 @@
+package ospf_cnn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// RecvRejectReason tells why a received packet or LSA has been rejected.
+type RecvRejectReason uint8
+
+const (
+	// RejectBadDestination The IP destination address is neither the IP
+	//            address of the receiving interface nor one of the IP
+	//            multicast addresses the interface is expected to receive.
+	RejectBadDestination RecvRejectReason = iota
+	// RejectOwnPacket The packet has been sent by the router itself.
+	RejectOwnPacket
+	// RejectMalformed The packet is truncated or cannot be decoded.
+	RejectMalformed
+	// RejectBadVersion The OSPF version number is not 2.
+	RejectBadVersion
+	// RejectBadChecksum The OSPF packet checksum is invalid.
+	RejectBadChecksum
+	// RejectUnknownPacketType The OSPF packet type is unknown.
+	RejectUnknownPacketType
+	// RejectAreaMismatch The Area ID does not match the area of the
+	//            receiving interface.
+	RejectAreaMismatch
+	// RejectSourceNotOnSubnet The IP source address is not on the network
+	//            of the receiving interface.
+	RejectSourceNotOnSubnet
+	// RejectAuthentication The packet fails to authenticate.
+	RejectAuthentication
+	// RejectLSAChecksum The LS checksum of an LSA in a Link State Update
+	//            packet is invalid.
+	RejectLSAChecksum
+	// RejectUnknownLSType The LS type of an LSA in a Link State Update
+	//            packet is unknown.
+	RejectUnknownLSType
+	// RejectMTUMismatch The Interface MTU of a Database Description packet
+	//            exceeds the MTU of the receiving interface.
+	RejectMTUMismatch
+
+	numRecvRejectReasons
+)
+
+var rrrName = map[RecvRejectReason]string{
+	RejectBadDestination:    "BadDestination",
+	RejectOwnPacket:         "OwnPacket",
+	RejectMalformed:         "Malformed",
+	RejectBadVersion:        "BadVersion",
+	RejectBadChecksum:       "BadChecksum",
+	RejectUnknownPacketType: "UnknownPacketType",
+	RejectAreaMismatch:      "AreaMismatch",
+	RejectSourceNotOnSubnet: "SourceNotOnSubnet",
+	RejectAuthentication:    "Authentication",
+	RejectLSAChecksum:       "LSAChecksum",
+	RejectUnknownLSType:     "UnknownLSType",
+	RejectMTUMismatch:       "MTUMismatch",
+}
+
+func (r RecvRejectReason) String() string {
+	if name, ok := rrrName[r]; ok {
+		return name
+	}
+	return strconv.FormatInt(int64(r), 10)
+}
+
+// recvRejectCounters counts the rejected packets and LSAs of an interface
+// per reason.
+type recvRejectCounters [numRecvRejectReasons]atomic.Uint64
+
+func (c *recvRejectCounters) snapshot() map[RecvRejectReason]uint64 {
+	ret := make(map[RecvRejectReason]uint64)
+	for r := range c {
+		if n := c[r].Load(); n > 0 {
+			ret[RecvRejectReason(r)] = n
+		}
+	}
+	return ret
+}
+
+// rejectPkt discards the received packet for the reason.
+func (i *Interface) rejectPkt(pkt recvPkt, reason RecvRejectReason, err error) {
+	i.rejected[reason].Add(1)
+	switch reason {
+	case RejectBadDestination, RejectOwnPacket:
+		// Common on multicast networks, e.g. packets to AllDRouters seen
+		// by routers other than DR and Backup.
+		LogDebug("interface %s rejected OSPF packet %v->%v: %v: %v", i.c.ifi.Name, pkt.h.Src, pkt.h.Dst, reason, err)
+	default:
+		LogWarn("interface %s rejected OSPF packet %v->%v: %v: %v", i.c.ifi.Name, pkt.h.Src, pkt.h.Dst, reason, err)
+	}
+}
+
+// rejectLSA discards the LSA received from the neighbor for the reason.
+func (i *Interface) rejectLSA(nb *Neighbor, l packet2.LSAdvertisement, err error) {
+	reason := RejectMalformed
+	switch {
+	case errors.Is(err, packet2.ErrLSAChecksum):
+		reason = RejectLSAChecksum
+	case errors.Is(err, packet2.ErrUnknownLSType):
+		reason = RejectUnknownLSType
+	}
+	i.rejected[reason].Add(1)
+	LogWarn("interface %s rejected LSA(%+v) from NeighborId(%v) AreaId(%v): %v: %v",
+		i.c.ifi.Name, l.GetLSAIdentity(), nb.NeighborId, i.Area.AreaId, reason, err)
+}
+
+// isReceivedOnOtherInterface checks whether the packet has been received on a
+// network interface other than the one of the OSPF interface. The raw sockets
+// of all OSPF interfaces are not bound to their network interfaces, so each of
+// them sees the packets of all the others. Virtual links take the packets
+// received on any network interface.
+func (i *Interface) isReceivedOnOtherInterface(pkt recvPkt) bool {
+	return i.vl == nil && pkt.ifIndex != 0 && pkt.ifIndex != i.c.ifi.Index
+}
+
+// isOwnAddress checks whether addr is the IP address of one of the router's
+// interfaces.
+func (i *Instance) isOwnAddress(addr uint32) bool {
+	for _, a := range i.attachedAreas() {
+		for _, ifi := range a.Interfaces {
+			if ifi.vl == nil && ipv4BytesToUint32(ifi.getAddress().IP.To4()) == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateRecvPkt performs the generic checks of received packets before
+// authentication per RFC2328 8.2. The IP checksum and protocol have been
+// verified by the IP layer.
+func (i *Interface) validateRecvPkt(pkt recvPkt) (RecvRejectReason, error) {
+	var (
+		src = ipv4BytesToUint32(pkt.h.Src.To4())
+		dst = ipv4BytesToUint32(pkt.h.Dst.To4())
+	)
+	// The IP destination address must be the IP address of the receiving
+	// interface, or one of the IP multicast addresses AllSPFRouters or
+	// AllDRouters. Packets sent over virtual links are unicast to the
+	// router's address in the Transit area.
+	if i.vl == nil {
+		switch dst {
+		case ipv4BytesToUint32(i.getAddress().IP.To4()), allSPFRouters:
+		case allDRouters:
+			// If the destination is AllDRouters, the packet should be
+			// accepted only if the state of the receiving interface is
+			// DR or Backup.
+			if st := i.currState(); st != InterfaceDR && st != InterfaceBackup {
+				return RejectBadDestination, fmt.Errorf("destined to AllDRouters while interface state is %v", st)
+			}
+		default:
+			return RejectBadDestination, errors.New("neither interface address nor AllSPFRouters or AllDRouters")
+		}
+	}
+	// Locally originated packets should not be passed on to OSPF. That is,
+	// the source IP address should be examined to make sure this is not a
+	// multicast packet that the router itself generated.
+	if i.Area.ins.isOwnAddress(src) {
+		return RejectOwnPacket, errors.New("sent by the router itself")
+	}
+	data := pkt.p
+	if len(data) < 24 {
+		return RejectMalformed, errors.New("packet too small for OSPF Version 2")
+	}
+	// The version number field must specify protocol version 2.
+	if data[0] != 2 {
+		return RejectBadVersion, fmt.Errorf("version %d", data[0])
+	}
+	pktLen := int(binary.BigEndian.Uint16(data[2:4]))
+	if pktLen < 24 || pktLen > len(data) {
+		return RejectMalformed, fmt.Errorf("packet length %d out of %d bytes received", pktLen, len(data))
+	}
+	// The checksum covers the entire OSPF packet, excluding the
+	// authentication trailer of cryptographic authentication.
+	if err := packet2.VerifyOSPFv2Checksum(data[:pktLen]); err != nil {
+		return RejectBadChecksum, err
+	}
+	if t := layers.OSPFType(data[1]); t < layers.OSPFHello || t > layers.OSPFLinkStateAcknowledgment {
+		return RejectUnknownPacketType, fmt.Errorf("packet type %d", t)
+	}
+	// The Area ID found in the OSPF header must match the Area ID of the
+	// receiving interface. Packets of virtual links, labelled with the
+	// backbone Area ID, have been delivered to the virtual links already.
+	if areaId := binary.BigEndian.Uint32(data[8:12]); areaId != i.Area.AreaId {
+		return RejectAreaMismatch, fmt.Errorf("AreaId(%v) mismatched AreaId(%v) of interface",
+			uint32ToIPv4(areaId), uint32ToIPv4(i.Area.AreaId))
+	}
+	// The packet's IP source address is required to be on the same network
+	// as the receiving interface. This comparison should not be performed
+	// on point-to-point networks and virtual links, whose IP interface
+	// masks are not defined.
+	if i.Type != IfTypePointToPoint && i.vl == nil {
+		ipNet := i.getAddress()
+		addr, mask := ipv4BytesToUint32(ipNet.IP.To4()), ipv4MaskToUint32(ipNet.Mask)
+		if src&mask != addr&mask {
+			return RejectSourceNotOnSubnet, fmt.Errorf("not on network %v", ipNet)
+		}
+	}
+	return 0, nil
+}
@@ -1,7 +1,13 @@
 package ospf_cnn
 
 import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"maps"
 	"net"
+	"slices"
 	"sync"
 
 	"golang.org/x/net/context"
@@ -38,23 +44,82 @@ type Router struct {
 	rfc1583Compatibility bool
 	// ospf2 instance
 	ins *Instance
+
+	// external routes currently announced, keyed by prefix.
+	announced   map[string]ExternalRoute
+	announcedMu sync.Mutex
+}
+
+// AnnouncedRoute is an announced external route along with the identity
+// of the AS-external-LSA describing it.
+type AnnouncedRoute struct {
+	ExternalRoute
+	LSA packet.LSAIdentity
 }
 
-func NewRouter(ifName string, addr *net.IPNet, rtid string) (*Router, error) {
+// newInstanceConfig returns the default configuration modified by modCfg,
+// or an error if the result is invalid.
+func newInstanceConfig(ifName string, addr *net.IPNet, rtid string, modCfg ...func(c *InstanceConfig)) (*InstanceConfig, error) {
+	c := &InstanceConfig{
+		RouterId:           ipv4BytesToUint32(net.ParseIP(rtid).To4()[0:4]),
+		HelloInterval:      10,
+		RouterDeadInterval: 40,
+		Network:            addr,
+		IfName:             ifName,
+		ASBR:               true,
+		// RFC1583Compatibility is set to "enabled" by default.
+		RFC1583Compatibility: true,
+	}
+	for _, fn := range modCfg {
+		fn(c)
+	}
+	if err := c.Auth.validate(); err != nil {
+		return nil, err
+	}
+	for _, ac := range c.Areas {
+		if err := ac.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.Hostname) > packet.MaxHostnameLength {
+		return nil, fmt.Errorf("hostname %q exceeds %d bytes", c.Hostname, packet.MaxHostnameLength)
+	}
+	if err := c.GracefulRestart.validate(); err != nil {
+		return nil, err
+	}
+	for _, vc := range c.VirtualLinks {
+		if err := vc.validate(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// ValidateConfig checks the configuration NewRouter would be given without
+// creating the router, so a running router is not replaced by an invalid one.
+func ValidateConfig(ifName string, addr *net.IPNet, rtid string, modCfg ...func(c *InstanceConfig)) error {
+	_, err := newInstanceConfig(ifName, addr, rtid, modCfg...)
+	return err
+}
+
+// NewRouter creates a router running on interface ifName.
+// modCfg can be used to adjust the default instance configuration.
+func NewRouter(ifName string, addr *net.IPNet, rtid string, modCfg ...func(c *InstanceConfig)) (*Router, error) {
+	c, err := newInstanceConfig(ifName, addr, rtid, modCfg...)
+	if err != nil {
+		return nil, err
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	r := &Router{
 		ctx:    ctx,
 		cancel: cancel,
-		ins: NewInstance(ctx, &InstanceConfig{
-			RouterId:           ipv4BytesToUint32(net.ParseIP(rtid).To4()[0:4]),
-			HelloInterval:      10,
-			RouterDeadInterval: 40,
-			Network:            addr,
-			IfName:             ifName,
-			ASBR:               true,
-		}),
+
+		announced: make(map[string]ExternalRoute),
 	}
+	c.reannounceExternalRoutes = r.reannounceExternalRoutes
+	r.ins = NewInstance(ctx, c)
 	r.routerId = r.ins.RouterId
+	r.rfc1583Compatibility = c.RFC1583Compatibility
 	return r, nil
 }
 
@@ -74,10 +139,86 @@ func (r *Router) Close() (err error) {
 	return r.closeErr
 }
 
-func (r *Router) AnnounceASBRRoute(ips []net.IPNet) {
-	r.ins.addASBRLSA(ips...)
+// AnnounceASBRRoute originates AS-external-LSAs for the routes.
+// Already announced routes with changed attributes are re-originated.
+func (r *Router) AnnounceASBRRoute(routes []ExternalRoute) error {
+	for _, rt := range routes {
+		if err := rt.validate(); err != nil {
+			return err
+		}
+	}
+	r.announcedMu.Lock()
+	defer r.announcedMu.Unlock()
+	for _, rt := range routes {
+		r.announced[externalRouteKey(rt.Prefix)] = rt
+	}
+	r.ins.syncASBRLSA(slices.Collect(maps.Values(r.announced))...)
+	return nil
+}
+
+// SetExternalRoutes makes the announced external routes exactly the desired ones.
+// It diffs against the self-originated AS-external-LSAs in LSDB: missing ones are
+// originated, changed ones updated and the rest prematurely aged. Calling it
+// repeatedly with the same routes does not touch the LSDB.
+func (r *Router) SetExternalRoutes(desired []ExternalRoute) error {
+	routes := make(map[string]ExternalRoute, len(desired))
+	for _, rt := range desired {
+		if err := rt.validate(); err != nil {
+			return err
+		}
+		routes[externalRouteKey(rt.Prefix)] = rt
+	}
+	r.announcedMu.Lock()
+	defer r.announcedMu.Unlock()
+	r.announced = routes
+	r.ins.syncASBRLSA(slices.Collect(maps.Values(routes))...)
+	return nil
+}
+
+// AnnouncedRoutes lists the external routes currently announced.
+func (r *Router) AnnouncedRoutes() []AnnouncedRoute {
+	r.announcedMu.Lock()
+	defer r.announcedMu.Unlock()
+	ret := make([]AnnouncedRoute, 0, len(r.announced))
+	ids := externalLinkStateIds(slices.Collect(maps.Values(r.announced)))
+	for key, rt := range r.announced {
+		var lsa packet.LSAIdentity
+		if id, ok := ids[key]; ok {
+			lsa = r.ins.announcedLSAIdentity(id)
+		}
+		ret = append(ret, AnnouncedRoute{
+			ExternalRoute: rt,
+			LSA:           lsa,
+		})
+	}
+	slices.SortFunc(ret, func(a, b AnnouncedRoute) int {
+		return cmp.Or(bytes.Compare(a.Prefix.IP.To4(), b.Prefix.IP.To4()), bytes.Compare(a.Prefix.Mask, b.Prefix.Mask))
+	})
+	return ret
 }
 
 func (r *Router) RevokeASBRRoute(ips []net.IPNet) {
-	r.ins.delASBRLSA(ips...)
+	r.announcedMu.Lock()
+	defer r.announcedMu.Unlock()
+	for _, ip := range ips {
+		delete(r.announced, externalRouteKey(ip))
+	}
+	r.ins.syncASBRLSA(slices.Collect(maps.Values(r.announced))...)
+}
+
+// SetInterfaceAuth changes the authentication of packets on the interface ifName.
+func (r *Router) SetInterfaceAuth(ifName string, c InterfaceAuth) error {
+	for _, a := range r.ins.allAreas() {
+		for _, i := range a.Interfaces {
+			if i.c.ifi.Name == ifName {
+				return i.SetAuth(c)
+			}
+		}
+	}
+	return fmt.Errorf("interface %s not found", ifName)
+}
+
+// RoutingTable returns the routing table derived from the link-state database.
+func (r *Router) RoutingTable() *RoutingTable {
+	return r.ins.getRoutingTable()
 }
@@ -0,0 +1,133 @@
+package ospf_cnn
+
+import (
+	"fmt"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"maps"
+)
+
+// routerInfoCapabilities returns the Router Informational Capabilities
+// advertised in Router Information LSAs (RFC7770 2.4).
+func (i *Instance) routerInfoCapabilities() (ret uint32) {
+	if i.grConfig.GracePeriod > 0 {
+		ret |= packet2.RICapabilityGracefulRestart
+	}
+	if !i.grHelperDisabled {
+		ret |= packet2.RICapabilityGracefulRestartHelper
+	}
+	ret |= packet2.RICapabilityStubRouter
+	return
+}
+
+// originateRouterInfoLSA originates the Router Information LSA into every
+// attached area. It is area-local, and a single instance of Opaque ID 0
+// carries all the TLVs.
+func (i *Instance) originateRouterInfoLSA() {
+	ri := packet2.RouterInfoLSA{
+		Capabilities: i.routerInfoCapabilities(),
+		Hostname:     i.hostname,
+	}
+	for _, a := range i.attachedAreas() {
+		if err := i.originateOpaqueLSA(OpaqueLSA{
+			LSType:     packet2.AreaLocalOpaqueLSAtypeV2,
+			OpaqueType: packet2.RouterInfoOpaqueType,
+			AreaId:     a.AreaId,
+			TLVs:       ri.TLVs(),
+		}); err != nil {
+			LogErr("area %v err originate Router Information LSA: %v", a.AreaId, err)
+		}
+	}
+}
+
+// riLSAKey identifies a Router Information LSA within its flooding scope.
+type riLSAKey struct {
+	// The area of area-local LSAs.
+	areaId uint32
+	// The interface of link-local LSAs.
+	ifi *Interface
+	id  packet2.LSAIdentity
+}
+
+// updateHostname keeps the dynamic hostnames up to date with the opaque LSA
+// installed into, or deleted from (l is nil), the link state database.
+func (i *Instance) updateHostname(k riLSAKey, l *LSDBOpaqueItem) {
+	if opaqueType, _ := packet2.SplitOpaqueLinkStateID(k.id.LinkStateId); opaqueType != packet2.RouterInfoOpaqueType {
+		return
+	}
+	var hostname string
+	if l != nil && l.h.LSAge < packet2.MaxAge {
+		if ri, err := l.l.AsRouterInfoLSA(); err == nil {
+			hostname = ri.Hostname
+		}
+	}
+	i.hostnamesMu.Lock()
+	defer i.hostnamesMu.Unlock()
+	if i.hostnamesByLSA[k] == hostname {
+		return
+	}
+	if hostname != "" {
+		if i.hostnamesByLSA == nil {
+			i.hostnamesByLSA = make(map[riLSAKey]string)
+		}
+		i.hostnamesByLSA[k] = hostname
+	} else {
+		delete(i.hostnamesByLSA, k)
+	}
+	// The hostname of the router is the one carried by any of its LSAs,
+	// the most recently installed one preferred.
+	rtId := k.id.AdvRouter
+	if hostname == "" {
+		for other, name := range i.hostnamesByLSA {
+			if other.id.AdvRouter == rtId {
+				hostname = name
+				break
+			}
+		}
+	}
+	if hostname != "" {
+		if i.hostnamesByRouter == nil {
+			i.hostnamesByRouter = make(map[uint32]string)
+		}
+		i.hostnamesByRouter[rtId] = hostname
+	} else {
+		delete(i.hostnamesByRouter, rtId)
+	}
+}
+
+// hostnames returns the dynamic hostnames learned from the Router Information
+// LSAs of all flooding scopes, keyed by Router ID.
+func (i *Instance) hostnames() map[uint32]string {
+	i.hostnamesMu.RLock()
+	ret := maps.Clone(i.hostnamesByRouter)
+	i.hostnamesMu.RUnlock()
+	if ret == nil {
+		ret = make(map[uint32]string)
+	}
+	if i.hostname != "" {
+		ret[i.RouterId] = i.hostname
+	}
+	return ret
+}
+
+// hostnameOf returns the dynamic hostname of the router rtId, or empty if unknown.
+func (i *Instance) hostnameOf(rtId uint32) string {
+	if rtId == i.RouterId && i.hostname != "" {
+		return i.hostname
+	}
+	i.hostnamesMu.RLock()
+	defer i.hostnamesMu.RUnlock()
+	return i.hostnamesByRouter[rtId]
+}
+
+// routerName returns the Router ID along with the hostname if known.
+func (i *Instance) routerName(rtId uint32) string {
+	if hostname := i.hostnameOf(rtId); hostname != "" {
+		return fmt.Sprintf("%v(%s)", uint32ToIPv4(rtId), hostname)
+	}
+	return uint32ToIPv4(rtId).String()
+}
+
+// Hostname returns the dynamic hostname of the router rtId, or empty if unknown.
+func (r *Router) Hostname(rtId uint32) string {
+	return r.ins.hostnameOf(rtId)
+}
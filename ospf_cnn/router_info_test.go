@@ -0,0 +1,74 @@
+package ospf_cnn
+
+import (
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"testing"
+	"time"
+)
+
+func testRouterInfoLSA(lsType uint16, advRouter, hostname string, age uint16) packet2.LSAdvertisement {
+	return packet2.LSAdvertisement{
+		LSAheader: packet2.LSAheader{
+			LSAge:       age,
+			LSType:      lsType,
+			LinkStateID: packet2.OpaqueLinkStateID(packet2.RouterInfoOpaqueType, 0),
+			AdvRouter:   ip(advRouter),
+			LSSeqNumber: packet2.InitialSequenceNumber,
+		},
+		Content: packet2.NewV2OpaqueLSA(packet2.RouterInfoLSA{Hostname: hostname}.TLVs()...),
+	}
+}
+
+func TestHostnames(t *testing.T) {
+	i := newTestInstance("1.1.1.1")
+	i.hostname = "self"
+	a := i.Backbone
+	ifi := a.testInterface("10.0.0.1/24")
+	install := func(l packet2.LSAdvertisement) {
+		t.Helper()
+		var err error
+		if l.LSType == packet2.LinkLocalOpaqueLSAtypeV2 {
+			err = ifi.lsDbInstallLinkLocalLSA(l, &lsaMeta{ctime: time.Now()})
+		} else {
+			err = a.lsDbInstallLSA(l, &lsaMeta{ctime: time.Now()})
+		}
+		if err != nil {
+			t.Fatalf("failed to install LSA: %s", err)
+		}
+	}
+	check := func(rtId, expected string) {
+		t.Helper()
+		if got := i.hostnameOf(ip(rtId)); got != expected {
+			t.Errorf("expecting hostname %q of %s but got %q", expected, rtId, got)
+		}
+		if got := i.hostnames()[ip(rtId)]; got != expected {
+			t.Errorf("expecting hostname %q of %s listed but got %q", expected, rtId, got)
+		}
+	}
+	areaLocal := testRouterInfoLSA(packet2.AreaLocalOpaqueLSAtypeV2, "2.2.2.2", "r2", 0)
+	linkLocal := testRouterInfoLSA(packet2.LinkLocalOpaqueLSAtypeV2, "2.2.2.2", "r2", 0)
+
+	check("1.1.1.1", "self")
+	install(areaLocal)
+	install(linkLocal)
+	check("2.2.2.2", "r2")
+	// still known from the link-local LSA
+	a.lsDbDeleteLSAByIdentity(areaLocal.GetLSAIdentity())
+	check("2.2.2.2", "r2")
+	install(testRouterInfoLSA(packet2.LinkLocalOpaqueLSAtypeV2, "2.2.2.2", "renamed", 0))
+	check("2.2.2.2", "renamed")
+	// flushed
+	install(testRouterInfoLSA(packet2.LinkLocalOpaqueLSAtypeV2, "2.2.2.2", "renamed", packet2.MaxAge))
+	check("2.2.2.2", "")
+
+	install(testRouterInfoLSA(packet2.ASOpaqueLSAtypeV2, "3.3.3.3", "r3", 0))
+	check("3.3.3.3", "r3")
+	i.lsDbDeleteASOpaqueLSA(testRouterInfoLSA(packet2.ASOpaqueLSAtypeV2, "3.3.3.3", "", 0).GetLSAIdentity())
+	check("3.3.3.3", "")
+
+	// LSAs other than Router Information LSAs are ignored.
+	other := testRouterInfoLSA(packet2.AreaLocalOpaqueLSAtypeV2, "4.4.4.4", "r4", 0)
+	other.LinkStateID = packet2.OpaqueLinkStateID(1, 0)
+	install(other)
+	check("4.4.4.4", "")
+}
@@ -0,0 +1,68 @@
+package ospf_cnn
+
+import (
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"net"
+	"testing"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func TestSetExternalRoutes(t *testing.T) {
+	r := &Router{ins: newTestBackbone(), announced: make(map[string]ExternalRoute)}
+	route := func(cidr string, metric uint32) ExternalRoute {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		rt := NewExternalRoute(*network)
+		rt.Metric = metric
+		return rt
+	}
+	lsa := func(id string) *LSDBASExternalItem {
+		t.Helper()
+		l, ok := r.ins.lsDbGetExtLSA(packet2.LSAIdentity{
+			LSType:      layers.ASExternalLSAtypeV2,
+			LinkStateId: ip(id),
+			AdvRouter:   r.ins.RouterId,
+		})
+		if !ok {
+			t.Fatalf("expecting AS-external-LSA %s but got none", id)
+		}
+		return l
+	}
+
+	routes := []ExternalRoute{route("192.0.2.0/24", 10), route("198.51.100.0/24", 20)}
+	if err := r.SetExternalRoutes(routes); err != nil {
+		t.Fatalf("failed to set external routes: %s", err)
+	}
+	first, second := lsa("192.0.2.0").h, lsa("198.51.100.0").h
+
+	// Setting the same routes again touches nothing.
+	if err := r.SetExternalRoutes(routes); err != nil {
+		t.Fatalf("failed to set external routes: %s", err)
+	}
+	if h := lsa("192.0.2.0").h; h.LSSeqNumber != first.LSSeqNumber {
+		t.Errorf("expecting sequence number %#x but got %#x", first.LSSeqNumber, h.LSSeqNumber)
+	}
+	if h := lsa("198.51.100.0").h; h.LSSeqNumber != second.LSSeqNumber {
+		t.Errorf("expecting sequence number %#x but got %#x", second.LSSeqNumber, h.LSSeqNumber)
+	}
+
+	// The dropped prefix is prematurely aged and the changed metric is
+	// re-originated.
+	if err := r.SetExternalRoutes([]ExternalRoute{route("192.0.2.0/24", 30)}); err != nil {
+		t.Fatalf("failed to set external routes: %s", err)
+	}
+	l := lsa("192.0.2.0")
+	if l.h.LSSeqNumber <= first.LSSeqNumber || l.l.Metric != 30 {
+		t.Errorf("expecting metric 30 re-originated after %#x but got metric %d seq %#x",
+			first.LSSeqNumber, l.l.Metric, l.h.LSSeqNumber)
+	}
+	if l := lsa("198.51.100.0"); l.h.LSAge != packet2.MaxAge {
+		t.Errorf("expecting age %d but got %d", packet2.MaxAge, l.h.LSAge)
+	}
+	if got := r.AnnouncedRoutes(); len(got) != 1 || got[0].Prefix.String() != "192.0.2.0/24" {
+		t.Errorf("expecting announced 192.0.2.0/24 but got %v", got)
+	}
+}
@@ -1,12 +1,33 @@
 package ospf_cnn
 
 import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"github.com/SvenShi/ospf-neighbor/ospf_cnn/fib"
 	"github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
 	"net"
+	"net/netip"
+	"slices"
+	"strings"
 )
 
 type RoutingPathType uint8
 
+var routingPathTypeNames = map[RoutingPathType]string{
+	RoutingPathIntraArea:     "intra-area",
+	RoutingPathInterArea:     "inter-area",
+	RoutingPathExternalType1: "type1-external",
+	RoutingPathExternalType2: "type2-external",
+}
+
+func (t RoutingPathType) String() string {
+	if n, ok := routingPathTypeNames[t]; ok {
+		return n
+	}
+	return "unknown"
+}
+
 const (
 	_ RoutingPathType = iota
 	RoutingPathIntraArea
@@ -61,6 +82,11 @@ type RoutingTableEntry struct {
 	//        the ability to process AS-external-LSAs.  For a further
 	//        discussion of OSPF's optional capabilities, see Section 4.5.
 	Options packet.BitOption
+	// Only defined for routers. Whether the destination router is an
+	//        area border router and/or an AS boundary router, as indicated
+	//        by bits B and E of its router-LSA.
+	IsABR  bool
+	IsASBR bool
 
 	//    The set of paths to use for a destination may vary based on the OSPF
 	//    area to which the paths belong.  This means that there may be
@@ -133,10 +159,311 @@ type RoutingTableEntry struct {
 	//        the destination.  On broadcast, Point-to-MultiPoint and NBMA
 	//        networks, the next hop also includes the IP address of the next
 	//        router (if any) in the path towards the destination.
-	NextHop *Interface
+	NextHops []RoutingNextHop
 
 	// Valid only for inter-area and AS external paths.  This field
 	//        indicates the Router ID of the router advertising the summary-
 	//        LSA or AS-external-LSA that led to this path.
 	AdvertisingRouter uint32
+	// Valid only for AS external paths. The routing table entry of the
+	//        ASBR or the forwarding address the path goes through, whose
+	//        intra-AS path is compared per Section 16.4.1.
+	intraASPath *RoutingTableEntry
+	// Valid only for AS external paths. The forwarding address and the
+	//        kind of the LSA that led to this path, used to choose among
+	//        functionally the same LSAs per RFC3101 2.5 (6)(e).
+	forwardingAddress uint32
+	externalLSAKind   externalLSAKind
+}
+
+// externalLSAKind ranks the LSAs describing AS external paths per RFC3101
+// 2.5 (6)(e). The lower values are preferred.
+type externalLSAKind uint8
+
+const (
+	externalLSAType7P externalLSAKind = iota // Type-7 LSA with the P-bit set
+	externalLSAType5
+	externalLSAType7
+)
+
+// RoutingNextHop is a single one of the equal-cost paths of a routing table entry.
+type RoutingNextHop struct {
+	// The outgoing router interface.
+	Interface *Interface
+	// The IP address of the next router. Nil for directly attached destinations.
+	Address net.IP
+}
+
+func (nh RoutingNextHop) String() string {
+	ifName := "-"
+	if nh.Interface != nil {
+		ifName = nh.Interface.c.ifi.Name
+	}
+	if nh.Address == nil {
+		return "directly attached via " + ifName
+	}
+	return fmt.Sprintf("via %s dev %s", nh.Address.String(), ifName)
+}
+
+func appendNextHop(nhs []RoutingNextHop, nh RoutingNextHop) []RoutingNextHop {
+	for _, exist := range nhs {
+		if exist.Interface == nh.Interface && exist.Address.Equal(nh.Address) {
+			return nhs
+		}
+	}
+	return append(nhs, nh)
+}
+
+func (e *RoutingTableEntry) String() string {
+	buf := new(strings.Builder)
+	switch e.DestinationType {
+	case RoutingDestTypeRouter:
+		buf.WriteString(fmt.Sprintf("router %s", uint32ToIPv4(e.DestinationId)))
+	default:
+		ones, _ := e.AddressMask.Size()
+		buf.WriteString(fmt.Sprintf("%s/%d", uint32ToIPv4(e.DestinationId), ones))
+	}
+	buf.WriteString(fmt.Sprintf(" %s cost %d", e.PathType, e.Cost))
+	if e.PathType == RoutingPathExternalType2 {
+		buf.WriteString(fmt.Sprintf(" type2-cost %d", e.CostType2))
+	}
+	if e.PathType <= RoutingPathInterArea {
+		buf.WriteString(fmt.Sprintf(" area %s", uint32ToIPv4(e.Area)))
+	}
+	for _, nh := range e.NextHops {
+		buf.WriteString(", ")
+		buf.WriteString(nh.String())
+	}
+	return buf.String()
+}
+
+func (rt *RoutingTable) String() string {
+	buf := new(strings.Builder)
+	for _, e := range rt.List {
+		buf.WriteString(e.String())
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// comparePreference compares two paths to the same destination per RFC2328 11 and 16.4.
+// Intra-area paths are preferred over inter-area paths, which are preferred over
+// type 1 external paths and then type 2 external paths. Within the same path
+// type, the path of the least cost is preferred.
+func (e *RoutingTableEntry) comparePreference(o *RoutingTableEntry) int {
+	if e.PathType != o.PathType {
+		return int(e.PathType) - int(o.PathType)
+	}
+	if e.PathType == RoutingPathExternalType2 && e.CostType2 != o.CostType2 {
+		return e.CostType2 - o.CostType2
+	}
+	return e.Cost - o.Cost
+}
+
+type routingNetworkKey struct {
+	addr, mask uint32
+}
+
+type routingRouterKey struct {
+	area, rtId uint32
+}
+
+type routingTableBuilder struct {
+	// Controls the preference rules used when choosing among multiple
+	// paths to AS boundary routers and forwarding addresses.
+	rfc1583Compatibility bool
+	networks             map[routingNetworkKey]*RoutingTableEntry
+	// For destinations of type "router", there may be separate sets of
+	// paths associated with each of several areas.
+	routers map[routingRouterKey]*RoutingTableEntry
+}
+
+func newRoutingTableBuilder(rfc1583Compatibility bool) *routingTableBuilder {
+	return &routingTableBuilder{
+		rfc1583Compatibility: rfc1583Compatibility,
+		networks:             make(map[routingNetworkKey]*RoutingTableEntry),
+		routers:              make(map[routingRouterKey]*RoutingTableEntry),
+	}
+}
+
+func (rt *routingTableBuilder) addNetworkRoute(e *RoutingTableEntry) {
+	k := routingNetworkKey{addr: e.DestinationId, mask: ipv4MaskToUint32(e.AddressMask)}
+	exist := rt.networks[k]
+	// If the new AS external path is still indistinguishable from the
+	// current paths in the routing table entry by path type and type 2
+	// cost, and RFC1583Compatibility is set to "disabled", select the
+	// preferred paths based on the intra-AS paths to the ASBR/forwarding
+	// addresses, as specified in Section 16.4.1 (RFC2328 16.4 (6)).
+	if exist != nil && !rt.rfc1583Compatibility && e.PathType > RoutingPathInterArea &&
+		e.PathType == exist.PathType && e.CostType2 == exist.CostType2 {
+		switch c := compareIntraASPaths(e.intraASPath, exist.intraASPath); {
+		case c < 0:
+			rt.networks[k] = e
+			return
+		case c > 0:
+			return
+		}
+	}
+	// If the current LSA is functionally the same as an installed LSA
+	// (i.e., same destination, cost and non-zero forwarding address) then
+	// apply the following priorities in deciding which LSA is preferred:
+	// 1. A Type-7 LSA with the P-bit set.
+	// 2. A Type-5 LSA.
+	// 3. The LSA with the higher router ID.
+	// per RFC3101 2.5 (6)(e)
+	if exist != nil && e.PathType > RoutingPathInterArea && e.comparePreference(exist) == 0 &&
+		e.forwardingAddress != 0 && e.forwardingAddress == exist.forwardingAddress {
+		if cmp.Or(cmp.Compare(e.externalLSAKind, exist.externalLSAKind), cmp.Compare(exist.AdvertisingRouter, e.AdvertisingRouter)) < 0 {
+			rt.networks[k] = e
+		}
+		return
+	}
+	rt.networks[k] = mergeRoutingTableEntry(exist, e)
+}
+
+func (rt *routingTableBuilder) addRouterRoute(e *RoutingTableEntry) {
+	k := routingRouterKey{area: e.Area, rtId: e.DestinationId}
+	rt.routers[k] = mergeRoutingTableEntry(rt.routers[k], e)
+}
+
+// mergeRoutingTableEntry keeps the preferred one of the existing entry and the new one.
+// Paths of equal preference are merged as equal-cost paths.
+func mergeRoutingTableEntry(exist, e *RoutingTableEntry) *RoutingTableEntry {
+	if exist == nil {
+		return e
+	}
+	c := e.comparePreference(exist)
+	switch {
+	case c < 0:
+		return e
+	case c == 0 && (e.PathType > RoutingPathInterArea || e.Area == exist.Area):
+		merged := *exist
+		merged.NextHops = slices.Clone(exist.NextHops)
+		for _, nh := range e.NextHops {
+			merged.NextHops = appendNextHop(merged.NextHops, nh)
+		}
+		return &merged
+	}
+	return exist
+}
+
+// lookupNetwork finds the best matching (longest prefix) network entry of addr.
+func (rt *routingTableBuilder) lookupNetwork(addr uint32) (ret *RoutingTableEntry) {
+	var bestMask uint32
+	for k, e := range rt.networks {
+		if addr&k.mask == k.addr && (ret == nil || k.mask > bestMask) {
+			ret, bestMask = e, k.mask
+		}
+	}
+	return
+}
+
+// compareIntraASPaths compares the paths to AS boundary routers or forwarding
+// addresses per RFC2328 16.4.1 when RFC1583Compatibility is disabled. Intra-area
+// paths using non-backbone areas are always the most preferred. The other
+// paths, intra-area backbone paths and inter-area paths, are of equal
+// preference. A negative result means e is preferred.
+func compareIntraASPaths(e, o *RoutingTableEntry) int {
+	if e == nil || o == nil {
+		return 0
+	}
+	eNonBackbone := e.PathType == RoutingPathIntraArea && e.Area != 0
+	oNonBackbone := o.PathType == RoutingPathIntraArea && o.Area != 0
+	switch {
+	case eNonBackbone == oNonBackbone:
+		return 0
+	case eNonBackbone:
+		return -1
+	}
+	return 1
+}
+
+// preferredASBR selects the preferred routing table entry of the AS boundary router
+// among all areas per RFC2328 16.4.1.
+func (rt *routingTableBuilder) preferredASBR(rtId uint32) (ret *RoutingTableEntry) {
+	for k, e := range rt.routers {
+		if k.rtId != rtId || !e.IsASBR {
+			continue
+		}
+		if ret == nil {
+			ret = e
+			continue
+		}
+		if !rt.rfc1583Compatibility {
+			if c := compareIntraASPaths(e, ret); c != 0 {
+				if c < 0 {
+					ret = e
+				}
+				continue
+			}
+		}
+		// Choose the least cost path. If there are multiple least cost
+		// paths, choose the one whose associated area has the largest
+		// OSPF Area ID.
+		if e.Cost < ret.Cost || (e.Cost == ret.Cost && e.Area > ret.Area) {
+			ret = e
+		}
+	}
+	return
+}
+
+func (rt *routingTableBuilder) build() *RoutingTable {
+	ret := &RoutingTable{
+		List: make([]*RoutingTableEntry, 0, len(rt.networks)+len(rt.routers)),
+	}
+	for _, e := range rt.networks {
+		ret.List = append(ret.List, e)
+	}
+	for _, e := range rt.routers {
+		ret.List = append(ret.List, e)
+	}
+	slices.SortFunc(ret.List, func(a, b *RoutingTableEntry) int {
+		if a.DestinationType != b.DestinationType {
+			return int(a.DestinationType) - int(b.DestinationType)
+		}
+		if a.DestinationId != b.DestinationId {
+			if a.DestinationId < b.DestinationId {
+				return -1
+			}
+			return 1
+		}
+		if c := bytes.Compare(a.AddressMask, b.AddressMask); c != 0 {
+			return c
+		}
+		return int(a.Area) - int(b.Area)
+	})
+	return ret
+}
+
+// fibRoutes converts the network entries of the routing table to FIB routes.
+// Directly attached networks are already known to the kernel and are skipped.
+func (rt *RoutingTable) fibRoutes() (ret []fib.Route) {
+	for _, e := range rt.List {
+		if e.DestinationType != RoutingDestTypeNetwork {
+			continue
+		}
+		ones, _ := e.AddressMask.Size()
+		r := fib.Route{
+			Dst: netip.PrefixFrom(netip.AddrFrom4([4]byte(uint32ToIPv4(e.DestinationId).To4())), ones),
+		}
+		for _, nh := range e.NextHops {
+			if nh.Address == nil || nh.Interface == nil {
+				continue
+			}
+			gw, _ := netip.AddrFromSlice(nh.Address.To4())
+			r.NextHops = append(r.NextHops, fib.NextHop{
+				Gateway: gw,
+				IfIndex: nh.Interface.c.ifi.Index,
+			})
+		}
+		if len(r.NextHops) > 0 {
+			// The order of equal-cost paths found by SPF varies between
+			// calculations. Sorted so that unchanged routes compare equal.
+			slices.SortFunc(r.NextHops, func(x, y fib.NextHop) int {
+				return cmp.Or(cmp.Compare(x.IfIndex, y.IfIndex), x.Gateway.Compare(y.Gateway))
+			})
+			ret = append(ret, r)
+		}
+	}
+	return
 }
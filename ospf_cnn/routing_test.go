@@ -0,0 +1,51 @@
+package ospf_cnn
+
+import (
+	"github.com/SvenShi/ospf-neighbor/ospf_cnn/fib"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestFibRoutes(t *testing.T) {
+	eth := func(index int) *Interface {
+		return &Interface{c: &Conn{ifi: &net.Interface{Index: index}}}
+	}
+	eth1, eth2 := eth(1), eth(2)
+	nh := func(ifi *Interface, addr string) RoutingNextHop {
+		return RoutingNextHop{Interface: ifi, Address: net.ParseIP(addr).To4()}
+	}
+	rt := &RoutingTable{List: []*RoutingTableEntry{
+		{
+			DestinationType: RoutingDestTypeNetwork,
+			DestinationId:   ip("10.9.0.0"),
+			AddressMask:     net.CIDRMask(24, 32),
+			NextHops:        []RoutingNextHop{nh(eth2, "10.2.0.1"), nh(eth1, "10.1.0.9"), nh(eth1, "10.1.0.2")},
+		},
+		{
+			// directly attached.
+			DestinationType: RoutingDestTypeNetwork,
+			DestinationId:   ip("10.1.0.0"),
+			AddressMask:     net.CIDRMask(24, 32),
+			NextHops:        []RoutingNextHop{{Interface: eth1}},
+		},
+		{
+			DestinationType: RoutingDestTypeRouter,
+			DestinationId:   ip("5.5.5.5"),
+			NextHops:        []RoutingNextHop{nh(eth1, "10.1.0.5")},
+		},
+	}}
+	expected := []fib.Route{{
+		Dst: netip.MustParsePrefix("10.9.0.0/24"),
+		NextHops: []fib.NextHop{
+			{Gateway: netip.MustParseAddr("10.1.0.2"), IfIndex: 1},
+			{Gateway: netip.MustParseAddr("10.1.0.9"), IfIndex: 1},
+			{Gateway: netip.MustParseAddr("10.2.0.1"), IfIndex: 2},
+		},
+	}}
+	got := rt.fibRoutes()
+	if !slices.EqualFunc(got, expected, fib.Route.Equal) {
+		t.Errorf("expecting %v but got %v", expected, got)
+	}
+}
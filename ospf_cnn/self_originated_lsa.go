@@ -1,13 +1,16 @@
 package ospf_cnn
 
 import (
+	"bytes"
 	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"slices"
 	"time"
 
 	"github.com/gopacket/gopacket/layers"
 )
 
-func (i *Interface) newRouterLSA() packet2.LSAdvertisement {
+func (a *Area) newRouterLSA() packet2.LSAdvertisement {
+	links := a.routerLSALinks()
 	routerLSA := packet2.LSAdvertisement{
 		LSAheader: packet2.LSAheader{
 			LSType: layers.RouterLSAtypeV2,
@@ -18,12 +21,12 @@ func (i *Interface) newRouterLSA() packet2.LSAdvertisement {
 			// 3         The destination network's IP address.
 			// 4         The Router ID of the described AS boundary router.
 			// 5         The destination network's IP address.
-			LinkStateID: i.Area.ins.RouterId,
-			AdvRouter:   i.Area.ins.RouterId,
+			LinkStateID: a.ins.RouterId,
+			AdvRouter:   a.ins.RouterId,
 			LSSeqNumber: packet2.InitialSequenceNumber,
 			LSOptions: func() uint8 {
 				ret := packet2.BitOption(0)
-				if i.Area.ExternalRoutingCapability {
+				if a.ExternalRoutingCapability {
 					ret = ret.SetBit(packet2.CapabilityEbit)
 				}
 				return uint8(ret)
@@ -31,50 +34,250 @@ func (i *Interface) newRouterLSA() packet2.LSAdvertisement {
 		},
 		Content: packet2.V2RouterLSA{
 			RouterLSAV2: layers.RouterLSAV2{
-				Flags: func() uint8 {
-					ret := packet2.BitOption(0)
-					if i.Area.ins.ASBR {
-						ret = ret.SetBit(packet2.RouterLSAFlagEbit)
-					}
-					return uint8(ret)
-				}(),
-				Links: 1,
+				Flags: a.routerLSAFlags(),
+				Links: uint16(len(links)),
 			},
-			Routers: []packet2.RouterV2{
-				{
-					RouterV2: layers.RouterV2{
-						// Type   Description
-						// __________________________________________________
-						// 1      Point-to-point connection to another router
-						// 2      Connection to a transit network
-						// 3      Connection to a stub network
-						// 4      Virtual link
-						Type: 2,
-						// Type   Link ID
-						// ______________________________________
-						// 1      Neighboring router's Router ID
-						// 2      IP address of Designated Router
-						// 3      IP network/subnet number
-						// 4      Neighboring router's Router ID
-						LinkID: i.DR.Load(),
-						//连接数据，其值取决于连接的类型：
-						//unnumbered P2P：接口的索引值。
-						//Stub网络：子网掩码。
-						//其他连接：设备接口的IP地址。
-						LinkData: ipv4BytesToUint32(i.Address.IP.To4()),
-						Metric:   10,
-					},
+			Routers: links,
+		},
+	}
+	return routerLSA
+}
+
+func (a *Area) routerLSAFlags() uint8 {
+	ret := packet2.BitOption(0)
+	// Stub areas cannot contain AS boundary routers, while NSSAs can.
+	if a.ins.ASBR && (a.ExternalRoutingCapability || a.NSSA) {
+		ret = ret.SetBit(packet2.RouterLSAFlagEbit)
+	}
+	// When set, the router is an area border router (B is for border).
+	if a.ins.isABR() {
+		ret = ret.SetBit(packet2.RouterLSAFlagBbit)
+		// An NSSA border router whose NSSATranslatorRole is Always
+		// sets the Nt bit in its router-LSA of the NSSA.
+		if a.NSSA && a.nssaTranslatorAlways {
+			ret = ret.SetBit(packet2.RouterLSAFlagNtbit)
+		}
+	}
+	// When set, the router is an endpoint of one or more fully adjacent
+	// virtual links having the described area as Transit area.
+	if a.hasFullVirtualLink() {
+		ret = ret.SetBit(packet2.RouterLSAFlagVbit)
+	}
+	return uint8(ret)
+}
+
+func (a *Area) routerLSALinks() (links []packet2.RouterV2) {
+	for _, ifi := range a.Interfaces {
+		links = append(links, ifi.routerLSALinks()...)
+	}
+	a.ins.applyStubRouterLinkMetrics(links)
+	return
+}
+
+// routerLSALinks describes the interface in router-LSA per RFC2328 12.4.1.
+func (i *Interface) routerLSALinks() []packet2.RouterV2 {
+	var (
+		ipNet = i.getAddress()
+		addr  = ipv4BytesToUint32(ipNet.IP.To4())
+		mask  = ipv4MaskToUint32(ipNet.Mask)
+	)
+	// Type   Description
+	// __________________________________________________
+	// 1      Point-to-point connection to another router
+	// 2      Connection to a transit network
+	// 3      Connection to a stub network
+	// 4      Virtual link
+	//
+	// Type   Link ID
+	// ______________________________________
+	// 1      Neighboring router's Router ID
+	// 2      IP address of Designated Router
+	// 3      IP network/subnet number
+	// 4      Neighboring router's Router ID
+	//
+	//连接数据，其值取决于连接的类型：
+	//unnumbered P2P：接口的索引值。
+	//Stub网络：子网掩码。
+	//其他连接：设备接口的IP地址。
+	stubLink := packet2.RouterV2{
+		RouterV2: layers.RouterV2{
+			Type:     3,
+			LinkID:   addr & mask,
+			LinkData: mask,
+			Metric:   uint16(i.getOutputCost()),
+		},
+	}
+	switch st := i.currState(); st {
+	case InterfaceDown:
+		// If the attached network does not belong to Area A, no links
+		// are added to the LSA, and the next interface should be examined.
+		return nil
+	case InterfaceLoopBack:
+		// If the state of the interface is Loopback, add a Type 3
+		// link (stub network) as long as this is not an interface to
+		// an unnumbered point-to-point network.  The Link ID should be
+		// set to the IP interface address, the Link Data set to the
+		// mask 0xffffffff (indicating a host route), and the cost set
+		// to 0.
+		return []packet2.RouterV2{{
+			RouterV2: layers.RouterV2{
+				Type:     3,
+				LinkID:   addr,
+				LinkData: 0xffffffff,
+				Metric:   0,
+			},
+		}}
+	case InterfacePointToPoint:
+		if i.Type == IfTypePointToMultiPoint {
+			return i.pointToMultiPointLinks(addr)
+		}
+		if i.Type == IfTypeVirtualLink {
+			return i.virtualLinkLinks(addr)
+		}
+		// If the interface is a point-to-point network, for each fully
+		// adjacent neighbor add a Type 1 link (point-to-point) whose
+		// Link ID is the neighbor's Router ID and Link Data is the
+		// router's own IP interface address.
+		var links []packet2.RouterV2
+		for _, nbId := range i.fullyAdjacentNeighbors() {
+			links = append(links, packet2.RouterV2{
+				RouterV2: layers.RouterV2{
+					Type:     1,
+					LinkID:   nbId,
+					LinkData: addr,
+					Metric:   uint16(i.getOutputCost()),
+				},
+			})
+		}
+		// In addition, as long as the state of the interface is
+		// "Point-to-Point" (and regardless of the neighboring router
+		// state), a Type 3 link (stub network) should be added. If a
+		// subnet has been assigned to the point-to-point link, the Link
+		// ID is the IP subnet number and the Link Data the subnet mask.
+		return append(links, stubLink)
+	case InterfaceWaiting:
+		// If the state of the interface is Waiting, add a Type 3
+		// link (stub network) with Link ID set to the IP network
+		// number of the attached network, Link Data set to the
+		// attached network's address mask, and cost equal to the
+		// interface's configured output cost.
+		return []packet2.RouterV2{stubLink}
+	default:
+		// Else, there are two cases, depending on whether a
+		// Designated Router has been elected for the network.
+		// If the router is fully adjacent to the Designated Router,
+		// or if the router itself is Designated Router and is fully
+		// adjacent to at least one other router, add a single Type 2
+		// link (transit network) with Link ID set to the IP interface
+		// address of the attached network's Designated Router (which
+		// may be the router itself) and Link Data set to the router's
+		// own IP interface address.  The cost of the link should be
+		// set to the output cost of the attached network's interface.
+		dr := i.DR.Load()
+		if (st == InterfaceDR && len(i.fullyAdjacentNeighbors()) > 0) || i.isFullyAdjacentToDR() {
+			return []packet2.RouterV2{{
+				RouterV2: layers.RouterV2{
+					Type:     2,
+					LinkID:   dr,
+					LinkData: addr,
+					Metric:   uint16(i.getOutputCost()),
 				},
+			}}
+		}
+		// Otherwise, add a link as if the interface state were Waiting.
+		return []packet2.RouterV2{stubLink}
+	}
+}
+
+// pointToMultiPointLinks describes the Point-to-MultiPoint interface in
+// router-LSA per RFC2328 12.4.1.4.
+func (i *Interface) pointToMultiPointLinks(addr uint32) []packet2.RouterV2 {
+	// If the interface is a Point-to-MultiPoint network, a single Type 3
+	// link (stub network) is added with Link ID set to the router's own
+	// IP interface address, Link Data set to the mask 0xffffffff
+	// (indicating a host route), and cost set to 0.
+	links := []packet2.RouterV2{{
+		RouterV2: layers.RouterV2{
+			Type:     3,
+			LinkID:   addr,
+			LinkData: 0xffffffff,
+			Metric:   0,
+		},
+	}}
+	// For each fully adjacent neighbor associated with the interface,
+	// add an additional Type 1 link (point-to-point) with Link ID set
+	// to the Router ID of the neighboring router, Link Data set to the
+	// IP interface address and cost equal to the interface's
+	// configured output cost.
+	for _, nbId := range i.fullyAdjacentNeighbors() {
+		links = append(links, packet2.RouterV2{
+			RouterV2: layers.RouterV2{
+				Type:     1,
+				LinkID:   nbId,
+				LinkData: addr,
+				Metric:   uint16(i.getOutputCost()),
 			},
+		})
+	}
+	return links
+}
+
+func (i *Interface) fullyAdjacentNeighbors() (rtIds []uint32) {
+	i.rangeOverNeighbors(func(nb *Neighbor) bool {
+		if nb.isFullyAdjacent() {
+			rtIds = append(rtIds, nb.NeighborId)
+		}
+		return true
+	})
+	return
+}
+
+func (i *Interface) isFullyAdjacentToDR() (ret bool) {
+	dr := i.DR.Load()
+	if dr == 0 {
+		return false
+	}
+	i.rangeOverNeighbors(func(nb *Neighbor) bool {
+		if ipv4BytesToUint32(nb.NeighborAddress.To4()) == dr {
+			ret = nb.isFullyAdjacent()
+			return false
+		}
+		return true
+	})
+	return
+}
+
+func (i *Interface) newNetworkLSA(attachedRouters []uint32) packet2.LSAdvertisement {
+	return packet2.LSAdvertisement{
+		LSAheader: packet2.LSAheader{
+			LSType: layers.NetworkLSAtypeV2,
+			// The Link State ID for a network-LSA is the IP interface
+			// address of the Designated Router.
+			LinkStateID: ipv4BytesToUint32(i.getAddress().IP.To4()),
+			AdvRouter:   i.Area.ins.RouterId,
+			LSSeqNumber: packet2.InitialSequenceNumber,
+			LSOptions: func() uint8 {
+				ret := packet2.BitOption(0)
+				if i.Area.ExternalRoutingCapability {
+					ret = ret.SetBit(packet2.CapabilityEbit)
+				}
+				return uint8(ret)
+			}(),
+		},
+		Content: packet2.V2NetworkLSA{
+			NetworkMask:    ipv4MaskToUint32(i.getAddress().Mask),
+			AttachedRouter: attachedRouters,
 		},
 	}
-	return routerLSA
 }
 
 func (a *Area) tryUpdatingExistingLSA(id packet2.LSAIdentity, i *Interface, modFn func(lsa *packet2.LSAdvertisement)) (exist bool) {
 	_, lsa, _, ok := a.lsDbGetLSAByIdentity(id, true)
 	if ok {
 		modFn(&lsa)
+		if len(a.ins.deferOriginationInGR(a, lsa)) <= 0 {
+			return true
+		}
 		// update LSA header for re-originating
 		seqIncred := lsa.PrepareReOriginating(true)
 		if err := lsa.FixLengthAndChkSum(); err != nil {
@@ -104,6 +307,38 @@ func (a *Area) tryUpdatingExistingLSA(id packet2.LSAIdentity, i *Interface, modF
 	return false
 }
 
+// skipUnchangedSelfOriginatedLSAs filters out LSAs whose live instance in LSDB
+// already has the same options and content, so they are not re-originated needlessly.
+// While restarting gracefully, the skipped LSAs are still wished to be originated.
+func (a *Area) skipUnchangedSelfOriginatedLSAs(lsas []packet2.LSAdvertisement) (ret []packet2.LSAdvertisement) {
+	var unchanged []packet2.LSAdvertisement
+	for _, l := range lsas {
+		h, exist, _, ok := a.lsDbGetLSAByIdentity(l.GetLSAIdentity(), true)
+		if ok && h.LSAge < packet2.MaxAge && h.LSOptions == l.LSOptions &&
+			sameLSAContent(exist.Content, l.Content) {
+			unchanged = append(unchanged, l)
+			continue
+		}
+		ret = append(ret, l)
+	}
+	// Otherwise they would be flushed as no longer wished once the restart
+	// is over. The unchanged ones need not be originated in any case.
+	_ = a.ins.deferOriginationInGR(a, unchanged...)
+	return
+}
+
+// sameLSAContent reports whether the LSA bodies are encoded into the same bytes.
+func sameLSAContent(x, y packet2.LSAContent) bool {
+	if x == nil || y == nil || x.Size() != y.Size() {
+		return false
+	}
+	xb, yb := make([]byte, x.Size()), make([]byte, y.Size())
+	if x.SerializeToSizedBuffer(xb) != nil || y.SerializeToSizedBuffer(yb) != nil {
+		return false
+	}
+	return bytes.Equal(xb, yb)
+}
+
 func (a *Area) batchTryUpdatingExistingLSAs(lsas []packet2.LSAdvertisement, i *Interface, modFn func(idx int, lsa *packet2.LSAdvertisement)) (nonExists []packet2.LSAdvertisement) {
 	var advLSAs []packet2.LSAheader
 	for idx, l := range lsas {
@@ -113,6 +348,9 @@ func (a *Area) batchTryUpdatingExistingLSAs(lsas []packet2.LSAdvertisement, i *I
 			continue
 		}
 		modFn(idx, &lsa)
+		if len(a.ins.deferOriginationInGR(a, lsa)) <= 0 {
+			continue
+		}
 		// update LSA header for re-originating
 		seqIncred := lsa.PrepareReOriginating(true)
 		if err := lsa.FixLengthAndChkSum(); err != nil {
@@ -172,7 +410,7 @@ func (a *Area) installAndFloodPendingLSA() (remainingLSACnt int) {
 	)
 	for _, lsa := range a.pendingWrappingLSAs {
 		stillNotAckedForPremature := false
-		for _, i := range a.Interfaces {
+		for _, i := range a.floodingScopeInterfaces(lsa.LSType) {
 			i.rangeOverNeighbors(func(nb *Neighbor) bool {
 				if nb.isInLSRetransmissionList(lsa.GetLSAIdentity()) {
 					// premature but still in retransmission list.
@@ -201,6 +439,9 @@ func (a *Area) installAndFloodPendingLSA() (remainingLSACnt int) {
 }
 
 func (a *Area) prematureLSA(ids ...packet2.LSAIdentity) {
+	if ids = a.ins.deferFlushInGR(a, ids...); len(ids) <= 0 {
+		return
+	}
 	var (
 		allLSA []packet2.LSAdvertisement
 		metas  []*lsaMeta
@@ -270,7 +511,7 @@ func (a *Area) removeMaturedLSAs() (remainingLSACnt int) {
 	)
 	for id := range a.pendingRemoveMaturedLSAs {
 		existInLSRtxmList := false
-		for _, ifi := range a.Interfaces {
+		for _, ifi := range a.floodingScopeInterfaces(id.LSType) {
 			ifi.rangeOverNeighbors(func(nb *Neighbor) bool {
 				if nb.isInLSRetransmissionList(id) {
 					existInLSRtxmList = true
@@ -302,12 +543,21 @@ func (a *Area) refreshSelfOriginatedLSA(id packet2.LSAIdentity) {
 		LogWarn("area %v err refresh self-originated LSA(%+v): previous LSA not found in LSDB", a.AreaId, id)
 		return
 	}
+	// The LSAs originated before restarting gracefully are left as they are
+	// while restarting. They are re-originated or flushed once it is over.
+	if a.ins.isGracefullyRestarting() && isTopologyLSType(id.LSType) {
+		return
+	}
 	lsa.LSAge = 0
 	LogDebug("area %v refreshing self-originated LSA(%+v)", a.AreaId, id)
 	a.originatingNewLSA(lsa)
 }
 
 func (a *Area) originatingNewLSA(lsa packet2.LSAdvertisement) {
+	if len(a.ins.deferOriginationInGR(a, lsa)) <= 0 {
+		return
+	}
+	a.ins.restoreSeqNumber(&lsa)
 	if err := lsa.FixLengthAndChkSum(); err != nil {
 		LogErr("area %v err fix chkSum while originating new LSA(%+v)", a.AreaId, lsa.GetLSAIdentity())
 		return
@@ -321,7 +571,8 @@ func (a *Area) originatingNewLSA(lsa packet2.LSAdvertisement) {
 
 func (a *Area) batchOriginatingNewLSAs(lsas []packet2.LSAdvertisement) {
 	var advLSAs []packet2.LSAheader
-	for _, lsa := range lsas {
+	for _, lsa := range a.ins.deferOriginationInGR(a, lsas...) {
+		a.ins.restoreSeqNumber(&lsa)
 		if err := lsa.FixLengthAndChkSum(); err != nil {
 			LogErr("area %v err fix chkSum while originating new LSA(%+v)", a.AreaId, lsa.GetLSAIdentity())
 			continue
@@ -336,35 +587,90 @@ func (a *Area) batchOriginatingNewLSAs(lsas []packet2.LSAdvertisement) {
 	}
 }
 
-func (a *Area) updateLSDBWhenInterfaceAdd(i *Interface) {
-	// need update RouterLSA when interface updated.
-
+// updateSelfOriginatedRouterLSA re-originates the router-LSA with links
+// describing the current state of all interfaces attached to the area.
+func (a *Area) updateSelfOriginatedRouterLSA(i *Interface) {
+	links := a.routerLSALinks()
 	if !a.tryUpdatingExistingLSA(packet2.LSAIdentity{
 		LSType:      layers.RouterLSAtypeV2,
 		LinkStateId: a.ins.RouterId,
 		AdvRouter:   a.ins.RouterId,
-	}, nil, func(lsa *packet2.LSAdvertisement) {
-		LogDebug("updating self-originated RouterLSA with newly added interface %v", i.c.ifi.Name)
-		// update existing LSA
+	}, i, func(lsa *packet2.LSAdvertisement) {
 		rtLSA, err := lsa.AsV2RouterLSA()
 		if err != nil {
-			LogErr("area %v err AsV2RouterLSA with newly added interface %v", a.AreaId, i.c.ifi.Name)
+			LogErr("area %v err AsV2RouterLSA while updating self-originated RouterLSA", a.AreaId)
 			return
 		}
-		rtLSA.Content.Routers = append(rtLSA.Content.Routers, packet2.RouterV2{
-			RouterV2: layers.RouterV2{
-				Type:     2,
-				LinkID:   i.DR.Load(),
-				LinkData: ipv4BytesToUint32(i.Address.IP.To4()),
-				Metric:   10,
-			},
-		})
-		rtLSA.Content.Links = uint16(len(rtLSA.Content.Routers))
+		rtLSA.Content.Flags = a.routerLSAFlags()
+		rtLSA.Content.Routers = links
+		rtLSA.Content.Links = uint16(len(links))
 		lsa.Content = rtLSA.Content
 	}) {
-		LogDebug("adding self-originated RouterLSA with newly added interface %v", i.c.ifi.Name)
+		LogDebug("area %v adding self-originated RouterLSA", a.AreaId)
 		// LSA not found. originating a new one.
-		a.originatingNewLSA(i.newRouterLSA())
+		a.originatingNewLSA(a.newRouterLSA())
+	}
+}
+
+// updateSelfOriginatedNetworkLSA originates, updates or flushes the
+// network-LSA of the attached network per RFC2328 12.4.2.
+func (i *Interface) updateSelfOriginatedNetworkLSA() {
+	a := i.Area
+	id := packet2.LSAIdentity{
+		LSType:      layers.NetworkLSAtypeV2,
+		LinkStateId: ipv4BytesToUint32(i.getAddress().IP.To4()),
+		AdvRouter:   a.ins.RouterId,
+	}
+	existingH, existingLSA, _, exist := a.lsDbGetLSAByIdentity(id, true)
+	isLive := exist && existingH.LSAge < packet2.MaxAge
+
+	// A network-LSA is generated for every transit broadcast or NBMA
+	// network.  The network-LSA is originated by the network's
+	// Designated Router.  The LSA is originated only if the router
+	// is fully adjacent to at least one other router on the network.
+	fullNbs := i.fullyAdjacentNeighbors()
+	if !i.shouldHaveDR() || i.currState() != InterfaceDR || len(fullNbs) <= 0 {
+		if isLive {
+			// When the router is no longer Designated Router for the
+			// network, its network-LSA must be flushed from the routing domain.
+			LogDebug("area %v flushing self-originated NetworkLSA of interface %v", a.AreaId, i.c.ifi.Name)
+			a.prematureLSA(id)
+		}
+		return
+	}
+	// The network-LSA lists those routers that are fully adjacent to the
+	// Designated Router; each fully adjacent router is identified by
+	// its OSPF Router ID.  The Designated Router includes itself in
+	// this list.
+	attached := append([]uint32{a.ins.RouterId}, fullNbs...)
+	slices.Sort(attached)
+	if isLive {
+		if ntLSA, err := existingLSA.AsV2NetworkLSA(); err == nil &&
+			ntLSA.Content.NetworkMask == ipv4MaskToUint32(i.getAddress().Mask) &&
+			slices.Equal(ntLSA.Content.AttachedRouter, attached) {
+			// nothing changed.
+			return
+		}
+	}
+	if !a.tryUpdatingExistingLSA(id, i, func(lsa *packet2.LSAdvertisement) {
+		lsa.Content = i.newNetworkLSA(attached).Content
+	}) {
+		LogDebug("area %v adding self-originated NetworkLSA of interface %v", a.AreaId, i.c.ifi.Name)
+		a.originatingNewLSA(i.newNetworkLSA(attached))
+	}
+}
+
+// updateSelfOriginatedLSAWhenAdjacencyChanged should be called whenever
+// the set of fully adjacent neighbors or the DR of the interface changes.
+func (i *Interface) updateSelfOriginatedLSAWhenAdjacencyChanged() {
+	if i.Area.shuttingDown.Load() {
+		return
+	}
+	i.Area.updateSelfOriginatedRouterLSA(i)
+	i.updateSelfOriginatedNetworkLSA()
+	if i.vl != nil {
+		// Bit V of the router-LSA of the Transit area may change as well.
+		i.vl.transit.updateSelfOriginatedRouterLSA(nil)
 	}
 }
 
@@ -385,31 +691,6 @@ func (a *Area) announceASBR() {
 	})
 }
 
-func (a *Area) updateSelfOriginatedLSAWhenDRorBDRChanged(i *Interface) {
-	// need update RouterLSA when DR updated.
-	LogDebug("updating self-originated RouterLSA with new DR/BDR")
-	if !a.tryUpdatingExistingLSA(packet2.LSAIdentity{
-		LSType:      layers.RouterLSAtypeV2,
-		LinkStateId: a.ins.RouterId,
-		AdvRouter:   a.ins.RouterId,
-	}, i, func(lsa *packet2.LSAdvertisement) {
-		rtLSA, err := lsa.AsV2RouterLSA()
-		if err != nil {
-			LogErr("area %v err AsV2RouterLSA with changed DR/BDR on interface %v", a.AreaId, i.c.ifi.Name)
-			return
-		}
-		for idx := 0; idx < len(rtLSA.Content.Routers); idx++ {
-			rt := rtLSA.Content.Routers[idx]
-			rt.LinkID = i.DR.Load()
-			rtLSA.Content.Routers[idx] = rt
-		}
-		lsa.Content = rtLSA.Content
-	}) {
-		LogWarn("area %v err update RouterLSA with changed DR/BDR on interface %v: unexpected no existing routerLSA",
-			a.AreaId, i.c.ifi.Name)
-	}
-}
-
 func (a *Area) dealWithReceivedNewerSelfOriginatedLSA(fromIfi *Interface, newerReceivedLSA packet2.LSAdvertisement) {
 	// It may be the case the router no longer wishes to originate the
 	//        received LSA. Possible examples include: 1) the LSA is a
@@ -424,9 +705,15 @@ func (a *Area) dealWithReceivedNewerSelfOriginatedLSA(fromIfi *Interface, newerR
 	//        all these cases, instead of updating the LSA, the LSA should be
 	//        flushed from the routing domain by incrementing the received
 	//        LSA's LS age to MaxAge and reflooding (see Section 14.1).
+	if newerReceivedLSA.LSType == layers.NetworkLSAtypeV2 && !a.isStillDRFor(newerReceivedLSA.LSAheader) {
+		LogDebug("area %v adapted newer self-originated NetworkLSA(%v) on interface %v but no longer DR. Flushing it",
+			a.AreaId, newerReceivedLSA.GetLSAIdentity(), fromIfi.c.ifi.Name)
+		a.prematureLSA(newerReceivedLSA.GetLSAIdentity())
+		return
+	}
 	LogDebug("area %v adapted newer self-originated LSA(%v) on interface %v. Trying incr its SeqNum and re-flood it out",
 		a.AreaId, newerReceivedLSA.GetLSAIdentity(), fromIfi.c.ifi.Name)
-	// TODO: check LSA type  and local LSDB to determine whether incr seqNum or premature it.
+	// TODO: check summary and external LSA against local config to determine whether incr seqNum or premature it.
 	// For now simply add the LSSeqNum and flood it out.
 	if !a.tryUpdatingExistingLSA(newerReceivedLSA.GetLSAIdentity(), fromIfi, func(lsa *packet2.LSAdvertisement) {
 		// it's already installed into LSDB.
@@ -437,3 +724,17 @@ func (a *Area) dealWithReceivedNewerSelfOriginatedLSA(fromIfi *Interface, newerR
 			a.AreaId, fromIfi.c.ifi.Name, newerReceivedLSA.GetLSAIdentity())
 	}
 }
+
+// isStillDRFor checks whether the router is still the Designated Router
+// of the network described by the self-originated network-LSA.
+func (a *Area) isStillDRFor(h packet2.LSAheader) bool {
+	if h.AdvRouter != a.ins.RouterId {
+		return false
+	}
+	for _, ifi := range a.Interfaces {
+		if ipv4BytesToUint32(ifi.getAddress().IP.To4()) == h.LinkStateID {
+			return ifi.currState() == InterfaceDR && len(ifi.fullyAdjacentNeighbors()) > 0
+		}
+	}
+	return false
+}
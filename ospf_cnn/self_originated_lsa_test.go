@@ -0,0 +1,224 @@
+package ospf_cnn
+
+import (
+	"cmp"
+	"context"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func TestSameLSAContent(t *testing.T) {
+	ext := packet2.V2ASExternalLSA{NetworkMask: ip("255.255.255.0"), Metric: 20, ExternalRouteTag: 7}
+	for _, tt := range []struct {
+		name     string
+		x, y     packet2.LSAContent
+		expected bool
+	}{
+		{
+			name:     "same fields",
+			x:        ext,
+			y:        ext,
+			expected: true,
+		},
+		{
+			name:     "different metric",
+			x:        ext,
+			y:        packet2.V2ASExternalLSA{NetworkMask: ext.NetworkMask, Metric: 30, ExternalRouteTag: 7},
+			expected: false,
+		},
+		{
+			name:     "different route tag",
+			x:        ext,
+			y:        packet2.V2ASExternalLSA{NetworkMask: ext.NetworkMask, Metric: 20},
+			expected: false,
+		},
+		{
+			// Only the low 24 bits of the metric are encoded.
+			name:     "same encoding",
+			x:        packet2.V2SummaryLSAImpl{NetworkMask: ip("255.255.0.0"), Metric: 10},
+			y:        packet2.V2SummaryLSAImpl{NetworkMask: ip("255.255.0.0"), Metric: 1<<24 | 10},
+			expected: true,
+		},
+		{
+			name:     "missing content",
+			x:        nil,
+			y:        ext,
+			expected: false,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameLSAContent(tt.x, tt.y); got != tt.expected {
+				t.Errorf("expecting %v but got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// testFullNeighbor adds fully adjacent neighbor rtId to the interface.
+func (i *Interface) testFullNeighbor(t *testing.T, rtId string) *Neighbor {
+	if i.c == nil {
+		i.c = &Conn{ifi: &net.Interface{Name: "eth0"}}
+	}
+	if i.Neighbors == nil {
+		i.Neighbors = make(map[uint32]*Neighbor)
+	}
+	if i.RxmtInterval == 0 {
+		i.RxmtInterval = 5
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	nb := &Neighbor{
+		ctx:              ctx,
+		cancel:           cancel,
+		i:                i,
+		State:            NeighborFull,
+		NeighborId:       ip(rtId),
+		LSRetransmission: make(map[packet2.LSAIdentity]struct{}),
+	}
+	i.Neighbors[nb.NeighborId] = nb
+	return nb
+}
+
+func TestUpdateSelfOriginatedNetworkLSA(t *testing.T) {
+	i := newTestInstance("1.1.1.1")
+	a := i.Backbone
+	ifi := a.testInterface("10.0.0.1/24")
+	ifi.testFullNeighbor(t, "2.2.2.2")
+	id := packet2.LSAIdentity{LSType: layers.NetworkLSAtypeV2, LinkStateId: ip("10.0.0.1"), AdvRouter: ip("1.1.1.1")}
+	check := func(maxAge bool, attached ...string) {
+		t.Helper()
+		h, lsa, _, ok := a.lsDbGetLSAByIdentity(id, true)
+		if !ok {
+			if len(attached) > 0 || maxAge {
+				t.Fatalf("expecting network-LSA but got none")
+			}
+			return
+		}
+		if maxAge != (h.LSAge >= packet2.MaxAge) {
+			t.Errorf("expecting network-LSA MaxAge %v but got age %d", maxAge, h.LSAge)
+		}
+		if maxAge {
+			return
+		}
+		ntLSA, err := lsa.AsV2NetworkLSA()
+		if err != nil {
+			t.Fatalf("failed to decode network-LSA: %s", err)
+		}
+		var expected []uint32
+		for _, rtId := range attached {
+			expected = append(expected, ip(rtId))
+		}
+		if ntLSA.Content.NetworkMask != ip("255.255.255.0") || !slices.Equal(ntLSA.Content.AttachedRouter, expected) {
+			t.Errorf("expecting mask 255.255.255.0 attached %v but got %v attached %v", attached,
+				uint32ToIPv4(ntLSA.Content.NetworkMask), ntLSA.Content.AttachedRouter)
+		}
+	}
+
+	// Not the DR of the network.
+	ifi.transState(InterfaceBackup)
+	ifi.updateSelfOriginatedNetworkLSA()
+	check(false)
+
+	ifi.transState(InterfaceDR)
+	ifi.updateSelfOriginatedNetworkLSA()
+	check(false, "1.1.1.1", "2.2.2.2")
+	h, _, _, _ := a.lsDbGetLSAByIdentity(id, true)
+
+	// The attached routers follow the fully adjacent neighbors.
+	ifi.testFullNeighbor(t, "3.3.3.3")
+	ifi.updateSelfOriginatedNetworkLSA()
+	check(false, "1.1.1.1", "2.2.2.2", "3.3.3.3")
+	if updated, _, _, _ := a.lsDbGetLSAByIdentity(id, true); updated.LSSeqNumber <= h.LSSeqNumber {
+		t.Errorf("expecting sequence number greater than %#x but got %#x", h.LSSeqNumber, updated.LSSeqNumber)
+	}
+
+	// Flushed once no longer the DR.
+	ifi.transState(InterfaceDROther)
+	ifi.updateSelfOriginatedNetworkLSA()
+	check(true)
+}
+
+func TestRouterLSALinks(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		typ      InterfaceType
+		state    InterfaceState
+		full     []string
+		expected []packet2.RouterV2
+	}{
+		{
+			name:  "point-to-point without neighbor",
+			typ:   IfTypePointToPoint,
+			state: InterfacePointToPoint,
+			expected: []packet2.RouterV2{
+				testLink(3, "10.0.0.0", "255.255.255.0", 10),
+			},
+		},
+		{
+			name:  "point-to-point",
+			typ:   IfTypePointToPoint,
+			state: InterfacePointToPoint,
+			full:  []string{"2.2.2.2"},
+			expected: []packet2.RouterV2{
+				testLink(1, "2.2.2.2", "10.0.0.1", 10),
+				testLink(3, "10.0.0.0", "255.255.255.0", 10),
+			},
+		},
+		{
+			name:  "point-to-point down",
+			typ:   IfTypePointToPoint,
+			state: InterfaceDown,
+			full:  []string{"2.2.2.2"},
+		},
+		{
+			name:  "point-to-multipoint without neighbor",
+			typ:   IfTypePointToMultiPoint,
+			state: InterfacePointToPoint,
+			expected: []packet2.RouterV2{
+				testLink(3, "10.0.0.1", "255.255.255.255", 0),
+			},
+		},
+		{
+			name:  "point-to-multipoint",
+			typ:   IfTypePointToMultiPoint,
+			state: InterfacePointToPoint,
+			full:  []string{"2.2.2.2", "3.3.3.3"},
+			expected: []packet2.RouterV2{
+				testLink(1, "2.2.2.2", "10.0.0.1", 10),
+				testLink(1, "3.3.3.3", "10.0.0.1", 10),
+				testLink(3, "10.0.0.1", "255.255.255.255", 0),
+			},
+		},
+		{
+			name:  "broadcast waiting",
+			typ:   IfTypeBroadcast,
+			state: InterfaceWaiting,
+			expected: []packet2.RouterV2{
+				testLink(3, "10.0.0.0", "255.255.255.0", 10),
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newTestInstance("1.1.1.1")
+			ifi := i.Backbone.testInterface("10.0.0.1/24")
+			ifi.Type = tt.typ
+			ifi.OutputCost = 10
+			ifi.State = tt.state
+			for _, rtId := range tt.full {
+				ifi.testFullNeighbor(t, rtId)
+			}
+			links := ifi.routerLSALinks()
+			// the fully adjacent neighbors are not described in any order.
+			slices.SortFunc(links, func(x, y packet2.RouterV2) int {
+				return cmp.Or(cmp.Compare(x.Type, y.Type), cmp.Compare(x.LinkID, y.LinkID))
+			})
+			if !slices.EqualFunc(links, tt.expected, func(x, y packet2.RouterV2) bool { return x.RouterV2 == y.RouterV2 }) {
+				t.Errorf("expecting %v but got %v", tt.expected, links)
+			}
+		})
+	}
+}
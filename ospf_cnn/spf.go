@@ -0,0 +1,744 @@
+package ospf_cnn
+
+import (
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"slices"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// spfDelay postpones the routing table calculation a little so that a burst
+// of LSA installations only triggers a single calculation.
+const spfDelay = 200 * time.Millisecond
+
+type SPFVertexType uint8
+
+const (
+	_ SPFVertexType = iota
+	SPFVertexRouter
+	SPFVertexNetwork
+)
+
+type SPFVertexKey struct {
+	Type SPFVertexType
+	// Router ID for routers and IP interface address of the
+	// Designated Router for transit networks.
+	Id uint32
+}
+
+type SPFTree struct {
+	Root     *SPFVertex
+	Vertices map[SPFVertexKey]*SPFVertex
+
+	// Whether any router-LSA in the tree has bit V set.
+	transitCapability bool
+}
+
+// SPFVertex is either a router or a transit network in the area's
+// shortest-path tree.
+type SPFVertex struct {
+	SPFVertexKey
+	// The LSA describing this vertex, either a router-LSA or a network-LSA.
+	LSAIdentity packet2.LSAIdentity
+	// The link state cost of the current set of shortest paths from
+	// the root to the vertex.
+	Distance int
+	// The set of next hops to use when forwarding traffic to the vertex.
+	NextHops []RoutingNextHop
+	// The vertex this one has been reached through.
+	Parent *SPFVertex
+
+	options packet2.BitOption
+	rtLSA   packet2.V2RouterLSA
+	ntLSA   packet2.V2NetworkLSA
+}
+
+type spfLSDB struct {
+	routers   map[uint32]*LSDBRouterItem
+	networks  map[uint32]*LSDBNetworkItem
+	summaries []*LSDBSummaryItem
+}
+
+// snapshotLSDBForSPF copies all usable router-LSAs, network-LSAs and summary-LSAs
+// so that the calculation does not hold the LSDB lock.
+func (a *Area) snapshotLSDBForSPF() *spfLSDB {
+	db := &spfLSDB{
+		routers:  make(map[uint32]*LSDBRouterItem),
+		networks: make(map[uint32]*LSDBNetworkItem),
+	}
+	a.lsDbRw.RLock()
+	defer a.lsDbRw.RUnlock()
+	// LSAs with age MaxAge are not used in the routing table calculation.
+	for _, l := range a.RouterLSAs {
+		if l.h.LSAge < packet2.MaxAge && l.h.LinkStateID == l.h.AdvRouter {
+			db.routers[l.h.LinkStateID] = &LSDBRouterItem{h: l.h, l: l.l}
+		}
+	}
+	for _, l := range a.NetworkLSAs {
+		if l.h.LSAge >= packet2.MaxAge {
+			continue
+		}
+		// A stale network-LSA of the same network may still exist when the
+		// DR has changed. Prefer the most recent one.
+		if exist, ok := db.networks[l.h.LinkStateID]; ok && !l.h.IsMoreRecentThan(exist.h) {
+			continue
+		}
+		db.networks[l.h.LinkStateID] = &LSDBNetworkItem{h: l.h, l: l.l}
+	}
+	for _, l := range a.SummaryLSAs {
+		if l.h.LSAge < packet2.MaxAge {
+			db.summaries = append(db.summaries, &LSDBSummaryItem{h: l.h, l: l.l})
+		}
+	}
+	return db
+}
+
+func (db *spfLSDB) vertex(k SPFVertexKey) (*SPFVertex, bool) {
+	switch k.Type {
+	case SPFVertexRouter:
+		if l, ok := db.routers[k.Id]; ok {
+			return &SPFVertex{
+				SPFVertexKey: k,
+				LSAIdentity:  l.h.GetLSAIdentity(),
+				options:      packet2.BitOption(l.h.LSOptions),
+				rtLSA:        l.l,
+			}, true
+		}
+	case SPFVertexNetwork:
+		if l, ok := db.networks[k.Id]; ok {
+			return &SPFVertex{
+				SPFVertexKey: k,
+				LSAIdentity:  l.h.GetLSAIdentity(),
+				options:      packet2.BitOption(l.h.LSOptions),
+				ntLSA:        l.l,
+			}, true
+		}
+	}
+	return nil, false
+}
+
+// hasLinkBackTo checks whether the LSA of w has a link back to v.
+func (w *SPFVertex) hasLinkBackTo(v *SPFVertex) bool {
+	if w.Type == SPFVertexNetwork {
+		for _, rtId := range w.ntLSA.AttachedRouter {
+			if rtId == v.Id {
+				return true
+			}
+		}
+		return false
+	}
+	for _, l := range w.rtLSA.Routers {
+		switch l.Type {
+		case 1, 4:
+			if v.Type == SPFVertexRouter && l.LinkID == v.Id {
+				return true
+			}
+		case 2:
+			if v.Type == SPFVertexNetwork && l.LinkID == v.Id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *SPFVertex) addNextHops(nhs ...RoutingNextHop) {
+	for _, nh := range nhs {
+		v.NextHops = appendNextHop(v.NextHops, nh)
+	}
+}
+
+// calculateShortestPathTree builds the shortest-path tree of the area per RFC2328 16.1,
+// and adds the intra-area routes found into rt.
+func (a *Area) calculateShortestPathTree(rt *routingTableBuilder, db *spfLSDB) *SPFTree {
+	tree := &SPFTree{
+		Vertices: make(map[SPFVertexKey]*SPFVertex),
+	}
+	// Initialize the algorithm's data structures.  Clear the list
+	// of candidate vertices.  Initialize the shortest-path tree to
+	// only the root (which is the router doing the calculation).
+	root, ok := db.vertex(SPFVertexKey{Type: SPFVertexRouter, Id: a.ins.RouterId})
+	if !ok {
+		return tree
+	}
+	tree.Root = root
+	candidates := make(map[SPFVertexKey]*SPFVertex)
+	for v := root; v != nil; v = nextSPFCandidate(candidates) {
+		delete(candidates, v.SPFVertexKey)
+		tree.Vertices[v.SPFVertexKey] = v
+		a.spfExamineVertex(db, tree, candidates, v)
+	}
+
+	// The first stage of the calculation has now been completed: transit
+	// networks and routers.
+	for _, v := range tree.Vertices {
+		switch v.Type {
+		case SPFVertexNetwork:
+			rt.addNetworkRoute(&RoutingTableEntry{
+				DestinationType: RoutingDestTypeNetwork,
+				DestinationId:   v.Id & v.ntLSA.NetworkMask,
+				AddressMask:     uint32ToIPv4Mask(v.ntLSA.NetworkMask),
+				Area:            a.AreaId,
+				PathType:        RoutingPathIntraArea,
+				Cost:            v.Distance,
+				NextHops:        v.NextHops,
+			})
+		case SPFVertexRouter:
+			// If this is a router-LSA, and bit V of the router-LSA is set,
+			// set Area A's TransitCapability to TRUE.
+			flags := packet2.BitOption(v.rtLSA.Flags)
+			if flags.IsBitSet(packet2.RouterLSAFlagVbit) {
+				tree.transitCapability = true
+			}
+			// If the router is an area border router or AS boundary router, a
+			// routing table entry is added whose Destination Type is "area
+			// border router" or "AS boundary router".
+			if v == root || !(flags.IsBitSet(packet2.RouterLSAFlagBbit) || flags.IsBitSet(packet2.RouterLSAFlagEbit)) {
+				continue
+			}
+			rt.addRouterRoute(&RoutingTableEntry{
+				DestinationType: RoutingDestTypeRouter,
+				DestinationId:   v.Id,
+				Options:         v.options,
+				Area:            a.AreaId,
+				PathType:        RoutingPathIntraArea,
+				Cost:            v.Distance,
+				NextHops:        v.NextHops,
+				IsABR:           flags.IsBitSet(packet2.RouterLSAFlagBbit),
+				IsASBR:          flags.IsBitSet(packet2.RouterLSAFlagEbit),
+			})
+		}
+	}
+
+	// The stub networks are added to the tree in the second stage.
+	for _, v := range tree.Vertices {
+		if v.Type != SPFVertexRouter {
+			continue
+		}
+		for _, l := range v.rtLSA.Routers {
+			if l.Type != 3 {
+				continue
+			}
+			nhs := v.NextHops
+			if v == root {
+				// stub network directly attached to the root.
+				nhs = nil
+				for _, ifi := range a.Interfaces {
+					if ifi.Type != IfTypeVirtualLink && ipv4BytesToUint32(ifi.getAddress().IP.To4())&l.LinkData == l.LinkID {
+						nhs = appendNextHop(nhs, RoutingNextHop{Interface: ifi})
+					}
+				}
+			}
+			rt.addNetworkRoute(&RoutingTableEntry{
+				DestinationType: RoutingDestTypeNetwork,
+				DestinationId:   l.LinkID & l.LinkData,
+				AddressMask:     uint32ToIPv4Mask(l.LinkData),
+				Area:            a.AreaId,
+				PathType:        RoutingPathIntraArea,
+				Cost:            v.Distance + int(l.Metric),
+				NextHops:        nhs,
+			})
+		}
+	}
+	return tree
+}
+
+func nextSPFCandidate(candidates map[SPFVertexKey]*SPFVertex) (ret *SPFVertex) {
+	// Choose the vertex belonging to the candidate list that is closest to
+	// the root. If there's a choice, network vertices must be chosen before
+	// router vertices in order to necessarily find all equal-cost paths.
+	for _, c := range candidates {
+		if ret == nil || c.Distance < ret.Distance ||
+			(c.Distance == ret.Distance && c.Type == SPFVertexNetwork && ret.Type == SPFVertexRouter) {
+			ret = c
+		}
+	}
+	return
+}
+
+// spfExamineVertex examines the LSA associated with vertex v per RFC2328 16.1 step (2).
+func (a *Area) spfExamineVertex(db *spfLSDB, tree *SPFTree, candidates map[SPFVertexKey]*SPFVertex, v *SPFVertex) {
+	examine := func(wKey SPFVertexKey, cost int, link *packet2.RouterV2) {
+		// W is a transit vertex (router or transit network).
+		// Look up the vertex W's LSA in the link state database. If the
+		// LSA does not exist, or its LS age is equal to MaxAge, or it
+		// does not have a link back to vertex V, examine the next link in V's LSA.
+		w, ok := db.vertex(wKey)
+		if !ok || !w.hasLinkBackTo(v) {
+			return
+		}
+		// If vertex W is already on the shortest-path tree, examine the
+		// next link in the LSA.
+		if _, ok = tree.Vertices[wKey]; ok {
+			return
+		}
+		// Calculate the link state cost D of the resulting path from
+		// the root to vertex W.  D is equal to the sum of the link
+		// state cost of the (already calculated) shortest path to
+		// vertex V and the advertised cost of the link between
+		// vertices V and W.
+		d := v.Distance + cost
+		cand, isCandidate := candidates[wKey]
+		// If D is greater than the value that already appears for vertex W
+		// on the candidate list, then examine the next link.
+		if isCandidate && d > cand.Distance {
+			return
+		}
+		nhs := a.spfCalculateNextHops(tree.Root, v, w, link)
+		if len(nhs) <= 0 {
+			return
+		}
+		if isCandidate && d == cand.Distance {
+			// If D is equal to the value that appears for vertex W on the
+			// candidate list, calculate the set of next hops that result
+			// from using the advertised link.  Input to this calculation
+			// is the destination (W), and its parent (V).  This
+			// calculation is shown in Section 16.1.1.  This set of hops
+			// should be added to the next hop values that appear for W on
+			// the candidate list.
+			cand.addNextHops(nhs...)
+			return
+		}
+		// If D is less than the value that appears for vertex W on the
+		// candidate list, then set W's candidate list entry to D, and
+		// recalculate the list of next hops.
+		w.Distance, w.NextHops, w.Parent = d, nhs, v
+		candidates[wKey] = w
+	}
+
+	switch v.Type {
+	case SPFVertexRouter:
+		for idx := range v.rtLSA.Routers {
+			l := &v.rtLSA.Routers[idx]
+			switch l.Type {
+			case 1, 4:
+				examine(SPFVertexKey{Type: SPFVertexRouter, Id: l.LinkID}, int(l.Metric), l)
+			case 2:
+				examine(SPFVertexKey{Type: SPFVertexNetwork, Id: l.LinkID}, int(l.Metric), l)
+			default:
+				// Links to stub networks will be considered in the second stage
+				// of the shortest path calculation.
+			}
+		}
+	case SPFVertexNetwork:
+		// The cost from a transit network to each of its attached routers is 0.
+		for _, rtId := range v.ntLSA.AttachedRouter {
+			examine(SPFVertexKey{Type: SPFVertexRouter, Id: rtId}, 0, nil)
+		}
+	}
+}
+
+// spfCalculateNextHops calculates the next hops of destination w reached
+// through its parent v per RFC2328 16.1.1.
+func (a *Area) spfCalculateNextHops(root, v, w *SPFVertex, link *packet2.RouterV2) (ret []RoutingNextHop) {
+	if v == root {
+		// If the parent vertex is the root (the calculating router itself),
+		// then the next hop is simply the outgoing interface to the
+		// destination. For a link to a transit network or point-to-point
+		// connection, the Link Data is the router's own interface address.
+		if link == nil {
+			return
+		}
+		if link.Type == 4 {
+			// Destinations reached through a virtual link use the next
+			// hops of the intra-area path to the virtual neighbor through
+			// the Transit area.
+			if ifi := a.getVirtualLink(w.Id); ifi != nil {
+				return ifi.vl.getNextHops()
+			}
+			return
+		}
+		ifi := a.getInterfaceByAddress(link.LinkData)
+		if ifi == nil {
+			return
+		}
+		nh := RoutingNextHop{Interface: ifi}
+		if w.Type == SPFVertexRouter {
+			// For point-to-point links the next hop IP address is that of
+			// the neighboring router's interface on the link.
+			if nb, ok := ifi.getNeighbor(w.Id); ok {
+				nh.Address = nb.NeighborAddress
+			}
+		}
+		return []RoutingNextHop{nh}
+	}
+	if v.Type == SPFVertexNetwork && v.Parent == root {
+		// If the destination is a router which connects to a network
+		// directly attached to the calculating router, the next hop
+		// IP address is the destination's interface address on the
+		// network, found in the destination's router-LSA.
+		for _, l := range w.rtLSA.Routers {
+			if l.Type == 2 && l.LinkID == v.Id {
+				for _, nh := range v.NextHops {
+					ret = appendNextHop(ret, RoutingNextHop{
+						Interface: nh.Interface,
+						Address:   uint32ToIPv4(l.LinkData).To4(),
+					})
+				}
+			}
+		}
+		return
+	}
+	// If there is at least one intervening router in the current shortest
+	// path between the destination and the root, the destination simply
+	// inherits the set of next hops from the parent.
+	return append([]RoutingNextHop(nil), v.NextHops...)
+}
+
+func (a *Area) getInterfaceByAddress(addr uint32) *Interface {
+	for _, ifi := range a.Interfaces {
+		if ifi.Type != IfTypeVirtualLink && ipv4BytesToUint32(ifi.getAddress().IP.To4()) == addr {
+			return ifi
+		}
+	}
+	return nil
+}
+
+// calculateInterAreaRoutes examines the summary-LSAs of the area per RFC2328 16.2.
+func (a *Area) calculateInterAreaRoutes(rt *routingTableBuilder, db *spfLSDB) {
+	for _, l := range db.summaries {
+		// If the cost specified by the LSA is LSInfinity, or if the LSA's
+		// LS age is equal to MaxAge, then examine the next LSA.
+		// If the LSA was originated by the calculating router itself,
+		// examine the next LSA.
+		if l.l.Metric >= packet2.LSInfinity || l.h.AdvRouter == a.ins.RouterId {
+			continue
+		}
+		// Look up the routing table entry for the area border router BR
+		// that originated the LSA. If no such entry exists for router BR
+		// (i.e., BR is unreachable), examine the next LSA.
+		// If it is a Type 3 summary-LSA, and the collection of destinations
+		// described by the summary-LSA equals one of the router's configured
+		// area address ranges, and the particular area address range is
+		// active, then the summary-LSA should be ignored.
+		if l.h.LSType == layers.SummaryLSANetworktypeV2 &&
+			a.ins.isActiveAreaRange(rt, l.h.LinkStateID&l.l.NetworkMask, l.l.NetworkMask) {
+			continue
+		}
+		br, ok := rt.routers[routingRouterKey{area: a.AreaId, rtId: l.h.AdvRouter}]
+		if !ok || br.PathType != RoutingPathIntraArea || !br.IsABR {
+			continue
+		}
+		// The cost of the inter-area path is the sum of the distance to BR
+		// and the cost specified in the LSA.
+		iac := br.Cost + int(l.l.Metric)
+		e := &RoutingTableEntry{
+			Area:              a.AreaId,
+			PathType:          RoutingPathInterArea,
+			Cost:              iac,
+			NextHops:          br.NextHops,
+			AdvertisingRouter: l.h.AdvRouter,
+		}
+		switch l.h.LSType {
+		case layers.SummaryLSANetworktypeV2:
+			// The destination is a network, whose address is the Link
+			// State ID masked with the LSA's Network Mask.
+			e.DestinationType = RoutingDestTypeNetwork
+			e.DestinationId = l.h.LinkStateID & l.l.NetworkMask
+			e.AddressMask = uint32ToIPv4Mask(l.l.NetworkMask)
+			rt.addNetworkRoute(e)
+		case layers.SummaryLSAASBRtypeV2:
+			// The destination is an AS boundary router, whose Router ID
+			// is the Link State ID.
+			e.DestinationType = RoutingDestTypeRouter
+			e.DestinationId = l.h.LinkStateID
+			e.Options = packet2.BitOption(l.h.LSOptions)
+			e.IsASBR = true
+			rt.addRouterRoute(e)
+		}
+	}
+}
+
+// calculateTransitAreaRoutes examines the summary-LSAs of the transit area per
+// RFC2328 16.3. Paths through the transit area may be better than the ones
+// through the backbone found in the first two stages, since the virtual links
+// use the shortest paths through the transit area.
+func (a *Area) calculateTransitAreaRoutes(rt *routingTableBuilder, db *spfLSDB) {
+	for _, l := range db.summaries {
+		// If the cost advertised by the summary-LSA is LSInfinity, or if
+		// the LSA's LS age is equal to MaxAge, then examine the next LSA.
+		// If the summary-LSA was originated by the calculating router
+		// itself, examine the next LSA.
+		if l.l.Metric >= packet2.LSInfinity || l.h.AdvRouter == a.ins.RouterId {
+			continue
+		}
+		// Look up the routing table entry for N. (If N is an area border
+		// router, look up the routing table entry associated with the
+		// backbone area).
+		var (
+			e   *RoutingTableEntry
+			set func(e *RoutingTableEntry)
+		)
+		switch l.h.LSType {
+		case layers.SummaryLSANetworktypeV2:
+			k := routingNetworkKey{addr: l.h.LinkStateID & l.l.NetworkMask, mask: l.l.NetworkMask}
+			e, set = rt.networks[k], func(e *RoutingTableEntry) { rt.networks[k] = e }
+		case layers.SummaryLSAASBRtypeV2:
+			k := routingRouterKey{area: a.ins.Backbone.AreaId, rtId: l.h.LinkStateID}
+			e, set = rt.routers[k], func(e *RoutingTableEntry) { rt.routers[k] = e }
+		}
+		// If it does not exist, or if the route type is other than
+		// intra-area or inter-area, or if the area associated with the
+		// routing table entry is not the backbone area, then examine the
+		// next LSA.
+		if e == nil || e.PathType > RoutingPathInterArea || e.Area != a.ins.Backbone.AreaId {
+			continue
+		}
+		// Look up the routing table entry for the advertising router BR
+		// associated with the Area A. If it is unreachable, examine the
+		// next LSA.
+		br, ok := rt.routers[routingRouterKey{area: a.AreaId, rtId: l.h.AdvRouter}]
+		if !ok || br.PathType != RoutingPathIntraArea {
+			continue
+		}
+		// The cost of the path through Area A is the sum of the distance
+		// to BR and the cost advertised by the LSA. If this cost is less
+		// than the cost occurring in N's routing table entry, overwrite
+		// N's list of next hops with those used for BR, and set N's
+		// routing table cost to IAC. Else, if IAC is the same as N's
+		// current cost, add BR's list of next hops to N's list of next
+		// hops. In any case, the area associated with N's routing table
+		// entry must remain the backbone area, and the path type (either
+		// intra-area or inter-area) must also remain the same.
+		iac := br.Cost + int(l.l.Metric)
+		switch updated := *e; {
+		case iac < e.Cost:
+			updated.Cost, updated.NextHops = iac, br.NextHops
+			set(&updated)
+		case iac == e.Cost:
+			updated.NextHops = slices.Clone(e.NextHops)
+			for _, nh := range br.NextHops {
+				updated.NextHops = appendNextHop(updated.NextHops, nh)
+			}
+			set(&updated)
+		}
+	}
+}
+
+// isActiveAreaRange checks whether addr/mask equals one of the active address
+// ranges configured for the attached areas.
+func (i *Instance) isActiveAreaRange(rt *routingTableBuilder, addr, mask uint32) bool {
+	for _, a := range i.attachedAreas() {
+		for _, r := range a.Addresses {
+			if r.Address != nil && ipv4MaskToUint32(r.Address.Mask) == mask && r.contains(addr, mask) &&
+				r.isActive(rt, a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// calculateASExternalRoutes examines the AS-external-LSAs per RFC2328 16.4,
+// and the Type-7 LSAs of the attached NSSAs per RFC3101 2.5.
+func (i *Instance) calculateASExternalRoutes(rt *routingTableBuilder) {
+	// If the cost specified by the LSA is LSInfinity, or if the LSA's LS
+	// age is equal to MaxAge, then examine the next LSA.
+	// If the LSA was originated by the calculating router itself,
+	// examine the next LSA.
+	usable := func(l *LSDBASExternalItem) bool {
+		return l.h.LSAge < packet2.MaxAge && l.l.Metric < packet2.LSInfinity && l.h.AdvRouter != i.RouterId
+	}
+	var extLSAs []*LSDBASExternalItem
+	i.lsDbRangeExtLSA(func(_ packet2.LSAIdentity, l *LSDBASExternalItem) bool {
+		if usable(l) {
+			extLSAs = append(extLSAs, &LSDBASExternalItem{h: l.h, l: l.l})
+		}
+		return true
+	})
+	for _, l := range extLSAs {
+		// Look up the routing table entries (potentially one per attached
+		// area) for the AS boundary router (ASBR) that originated the LSA.
+		// If no entries exist for router ASBR (i.e., ASBR is unreachable),
+		// do nothing with this LSA and consider the next in the list.
+		asbr := rt.preferredASBR(l.h.AdvRouter)
+		if asbr == nil {
+			continue
+		}
+		i.addExternalRoute(rt, asbr, l, nil)
+	}
+
+	for _, a := range i.nssaAreas() {
+		var nssaLSAs []*LSDBASExternalItem
+		a.lsDbRw.RLock()
+		for _, l := range a.NSSALSAs {
+			if usable(l) {
+				nssaLSAs = append(nssaLSAs, &LSDBASExternalItem{h: l.h, l: l.l})
+			}
+		}
+		a.lsDbRw.RUnlock()
+		for _, l := range nssaLSAs {
+			// The ASBR originating the Type-7 LSA must be reachable by an
+			// intra-area path through the NSSA.
+			asbr, ok := rt.routers[routingRouterKey{area: a.AreaId, rtId: l.h.AdvRouter}]
+			if !ok || asbr.PathType != RoutingPathIntraArea || !asbr.IsASBR {
+				continue
+			}
+			i.addExternalRoute(rt, asbr, l, a)
+		}
+	}
+}
+
+// addExternalRoute adds the route described by the AS-external-LSA or the
+// Type-7 LSA of the NSSA nssa, through the AS boundary router entry asbr.
+func (i *Instance) addExternalRoute(rt *routingTableBuilder, asbr *RoutingTableEntry, l *LSDBASExternalItem, nssa *Area) {
+	var (
+		x    = asbr.Cost
+		nhs  = asbr.NextHops
+		path = asbr
+	)
+	// If the forwarding address is non-zero, look up the forwarding
+	// address in the routing table. The matching routing table entry
+	// must specify an intra-area or inter-area path; if no such path
+	// exists, do nothing with the LSA and consider the next in the list.
+	// For Type-7 LSAs, the path must be an intra-area path through the NSSA.
+	if l.l.ForwardingAddress != 0 {
+		// A forwarding address of the router itself would make the
+		// traffic loop back to the router. Do nothing with the LSA.
+		if i.isOwnAddress(l.l.ForwardingAddress) {
+			return
+		}
+		fwd := rt.lookupNetwork(l.l.ForwardingAddress)
+		if fwd == nil || fwd.PathType > RoutingPathInterArea {
+			return
+		}
+		if nssa != nil && (fwd.PathType != RoutingPathIntraArea || fwd.Area != nssa.AreaId) {
+			return
+		}
+		x, nhs, path = fwd.Cost, nil, fwd
+		for _, nh := range fwd.NextHops {
+			if nh.Address == nil {
+				// The forwarding address is on a directly attached network.
+				nh.Address = uint32ToIPv4(l.l.ForwardingAddress).To4()
+			}
+			nhs = appendNextHop(nhs, nh)
+		}
+	}
+	// Let X be the cost specified by the preferred routing table entry
+	// for the ASBR/forwarding address, and Y the cost specified in the
+	// LSA. If it is a type 1 external path the cost is X+Y, otherwise
+	// the cost is X with a type 2 cost of Y.
+	e := &RoutingTableEntry{
+		DestinationType:   RoutingDestTypeNetwork,
+		DestinationId:     l.h.LinkStateID & l.l.NetworkMask,
+		AddressMask:       uint32ToIPv4Mask(l.l.NetworkMask),
+		NextHops:          nhs,
+		AdvertisingRouter: l.h.AdvRouter,
+		intraASPath:       path,
+		forwardingAddress: l.l.ForwardingAddress,
+		externalLSAKind:   externalLSAType5,
+	}
+	if nssa != nil {
+		e.externalLSAKind = externalLSAType7
+		if packet2.BitOption(l.h.LSOptions).IsBitSet(packet2.CapabilityNPbit) {
+			e.externalLSAKind = externalLSAType7P
+		}
+	}
+	if packet2.BitOption(l.l.ExternalBit).IsBitSet(packet2.ASExternalLSAFlagEbit) {
+		e.PathType, e.Cost, e.CostType2 = RoutingPathExternalType2, x, int(l.l.Metric)
+	} else {
+		e.PathType, e.Cost = RoutingPathExternalType1, x+int(l.l.Metric)
+	}
+	rt.addNetworkRoute(e)
+}
+
+// recalculateRoutes schedules a full routing table calculation.
+func (i *Instance) recalculateRoutes() {
+	if !i.spfScheduled.CompareAndSwap(false, true) {
+		return
+	}
+	time.AfterFunc(spfDelay, func() {
+		i.spfScheduled.Store(false)
+		i.doRecalculateRoutes()
+	})
+}
+
+func (i *Instance) doRecalculateRoutes() {
+	i.spfMu.Lock()
+	defer i.spfMu.Unlock()
+	if i.ctx.Err() != nil {
+		return
+	}
+	start := time.Now()
+	rt, trees := i.calculateRoutingTable()
+
+	table := rt.build()
+	i.rtMu.Lock()
+	for a, t := range trees {
+		a.SPF = t
+		a.TransitCapability = t.transitCapability
+	}
+	i.RoutingTable = table
+	i.rtMu.Unlock()
+	LogDebug("routing table recalculated in %v with %d entries", time.Since(start), len(table.List))
+
+	// The routes installed before restarting gracefully are kept until
+	// the restart is over (RFC3623 2.2).
+	if i.fibSink != nil && !i.isGracefullyRestarting() {
+		if err := i.fibSink.Apply(table.fibRoutes()); err != nil {
+			LogErr("err install routes into FIB: %v", err)
+		}
+	}
+	// The summary-LSAs advertised by area border routers are derived
+	// from the routing table.
+	i.originateSummaryLSAs(rt)
+	// So are the AS-external-LSAs translated from Type-7 LSAs by the
+	// NSSA border router.
+	i.translateNSSALSAs(rt)
+}
+
+// calculateRoutingTable calculates the routing table from the link state
+// databases per RFC2328 16, returning the shortest-path trees of the areas.
+func (i *Instance) calculateRoutingTable() (*routingTableBuilder, map[*Area]*SPFTree) {
+	rt := newRoutingTableBuilder(i.rfc1583Compatibility)
+	areas := i.allAreas()
+	dbs := make(map[*Area]*spfLSDB, len(areas))
+	trees := make(map[*Area]*SPFTree, len(areas))
+	// The first stage: the intra-area routes are calculated by building
+	// the shortest-path tree for each attached area.
+	for _, a := range areas {
+		if a == i.Backbone {
+			// The virtual links are brought up or down per the trees of
+			// their Transit areas before the backbone tree is built.
+			i.updateVirtualLinks(trees)
+		}
+		dbs[a] = a.snapshotLSDBForSPF()
+		trees[a] = a.calculateShortestPathTree(rt, dbs[a])
+	}
+	// The inter-area routes are calculated by examining summary-LSAs. If
+	// the router is attached to multiple areas (i.e., it is an area border
+	// router), only backbone summary-LSAs are examined.
+	if i.isABR() {
+		i.Backbone.calculateInterAreaRoutes(rt, dbs[i.Backbone])
+	} else {
+		for _, a := range areas {
+			a.calculateInterAreaRoutes(rt, dbs[a])
+		}
+	}
+	// Area border routers connecting to one or more transit areas examine
+	// the transit areas' summary-LSAs for better paths.
+	if i.isABR() {
+		for _, a := range areas {
+			if a != i.Backbone && trees[a].transitCapability {
+				a.calculateTransitAreaRoutes(rt, dbs[a])
+			}
+		}
+	}
+	// Routes to external destinations are calculated through examination
+	// of AS-external-LSAs.
+	i.calculateASExternalRoutes(rt)
+	return rt, trees
+}
+
+// closeFIB removes all routes installed into the FIB.
+func (i *Instance) closeFIB() {
+	if i.fibSink == nil {
+		return
+	}
+	i.spfMu.Lock()
+	defer i.spfMu.Unlock()
+	if err := i.fibSink.Close(); err != nil {
+		LogErr("err remove routes from FIB: %v", err)
+	}
+}
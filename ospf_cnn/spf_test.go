@@ -0,0 +1,362 @@
+package ospf_cnn
+
+import (
+	"context"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func ip(s string) uint32 {
+	return ipv4BytesToUint32(net.ParseIP(s).To4())
+}
+
+func newTestInstance(rtId string) *Instance {
+	// The instance is never started, so the scheduled routing table
+	// calculations are skipped.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	i := &Instance{
+		ctx:            ctx,
+		RouterId:       ip(rtId),
+		ASExternalLSAs: make(map[packet2.LSAIdentity]*LSDBASExternalItem),
+		ASOpaqueLSAs:   make(map[packet2.LSAIdentity]*LSDBOpaqueItem),
+	}
+	i.Backbone = NewArea(context.Background(), &AreaConfig{
+		Instance: i,
+		Options:  packet2.BitOption(0).SetBit(packet2.CapabilityEbit),
+	})
+	return i
+}
+
+func (i *Instance) testArea(areaId string) *Area {
+	if a := i.getArea(ip(areaId)); a != nil {
+		return a
+	}
+	a := NewArea(context.Background(), &AreaConfig{
+		Instance: i,
+		AreaId:   ip(areaId),
+		Options:  packet2.BitOption(0).SetBit(packet2.CapabilityEbit),
+	})
+	i.Areas = append(i.Areas, a)
+	return a
+}
+
+// testInterface attaches a broadcast interface of address cidr to the area.
+func (a *Area) testInterface(cidr string) *Interface {
+	addr, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	network.IP = addr.To4()
+	ifi := &Interface{Type: IfTypeBroadcast, Address: network, Area: a}
+	a.Interfaces = append(a.Interfaces, ifi)
+	return ifi
+}
+
+func testLink(typ uint8, id, data string, metric uint16) packet2.RouterV2 {
+	return packet2.RouterV2{RouterV2: layers.RouterV2{Type: typ, LinkID: ip(id), LinkData: ip(data), Metric: metric}}
+}
+
+func testLSAHeader(lsType uint16, id, advRouter string) packet2.LSAheader {
+	return packet2.LSAheader{LSType: lsType, LinkStateID: ip(id), AdvRouter: ip(advRouter), LSSeqNumber: packet2.InitialSequenceNumber}
+}
+
+func (a *Area) testRouterLSA(rtId string, flags packet2.BitOption, links ...packet2.RouterV2) {
+	h := testLSAHeader(layers.RouterLSAtypeV2, rtId, rtId)
+	a.RouterLSAs[h.GetLSAIdentity()] = &LSDBRouterItem{h: h, l: packet2.V2RouterLSA{
+		RouterLSAV2: layers.RouterLSAV2{Flags: uint8(flags), Links: uint16(len(links))},
+		Routers:     links,
+	}}
+}
+
+func (a *Area) testNetworkLSA(drAddr, dr, mask string, attached ...string) {
+	h := testLSAHeader(layers.NetworkLSAtypeV2, drAddr, dr)
+	l := packet2.V2NetworkLSA{NetworkMask: ip(mask)}
+	for _, rtId := range attached {
+		l.AttachedRouter = append(l.AttachedRouter, ip(rtId))
+	}
+	a.NetworkLSAs[h.GetLSAIdentity()] = &LSDBNetworkItem{h: h, l: l}
+}
+
+func (a *Area) testSummaryLSA(lsType uint16, id, advRouter, mask string, metric uint32) {
+	h := testLSAHeader(lsType, id, advRouter)
+	a.SummaryLSAs[h.GetLSAIdentity()] = &LSDBSummaryItem{h: h, l: packet2.V2SummaryLSAImpl{NetworkMask: ip(mask), Metric: metric}}
+}
+
+func (i *Instance) testExternalLSA(id, advRouter, mask string, metricType ExternalMetricType, metric uint32, fwd string) {
+	h := testLSAHeader(layers.ASExternalLSAtypeV2, id, advRouter)
+	l := packet2.V2ASExternalLSA{NetworkMask: ip(mask), Metric: metric, ForwardingAddress: ip(fwd)}
+	if metricType == ExternalMetricType2 {
+		l.ExternalBit = uint8(packet2.BitOption(0).SetBit(packet2.ASExternalLSAFlagEbit))
+	}
+	i.ASExternalLSAs[h.GetLSAIdentity()] = &LSDBASExternalItem{h: h, l: l}
+}
+
+var (
+	flagB = packet2.BitOption(0).SetBit(packet2.RouterLSAFlagBbit)
+	flagE = packet2.BitOption(0).SetBit(packet2.RouterLSAFlagEbit)
+)
+
+// newTestBackbone builds the backbone of router 1.1.1.1 attached to
+// 10.0.0.0/24, whose DR it is, along with area border router 2.2.2.2,
+// AS boundary router 5.5.5.5 and router 3.3.3.3.
+func newTestBackbone() *Instance {
+	i := newTestInstance("1.1.1.1")
+	a := i.Backbone
+	a.testInterface("10.0.0.1/24")
+	a.testRouterLSA("1.1.1.1", 0, testLink(2, "10.0.0.1", "10.0.0.1", 10))
+	a.testRouterLSA("2.2.2.2", flagB,
+		testLink(2, "10.0.0.1", "10.0.0.2", 10),
+		testLink(3, "10.2.0.0", "255.255.255.0", 5),
+		testLink(3, "10.9.0.0", "255.255.255.0", 5))
+	a.testRouterLSA("3.3.3.3", 0,
+		testLink(2, "10.0.0.1", "10.0.0.3", 10),
+		testLink(3, "10.9.0.0", "255.255.255.0", 5))
+	a.testRouterLSA("5.5.5.5", flagE, testLink(2, "10.0.0.1", "10.0.0.5", 10))
+	a.testNetworkLSA("10.0.0.1", "1.1.1.1", "255.255.255.0", "1.1.1.1", "2.2.2.2", "3.3.3.3", "5.5.5.5")
+	return i
+}
+
+// newTestABR adds area 0.0.0.1 to the backbone of newTestBackbone, in which AS
+// boundary router 4.4.4.4 is reached at cost 50 through 10.1.0.0/24.
+func newTestABR() *Instance {
+	i := newTestBackbone()
+	a := i.testArea("0.0.0.1")
+	a.testInterface("10.1.0.1/24")
+	i.Backbone.testRouterLSA("1.1.1.1", flagB, testLink(2, "10.0.0.1", "10.0.0.1", 10))
+	a.testRouterLSA("1.1.1.1", flagB, testLink(2, "10.1.0.1", "10.1.0.1", 50))
+	a.testRouterLSA("4.4.4.4", flagE, testLink(2, "10.1.0.1", "10.1.0.4", 50))
+	a.testNetworkLSA("10.1.0.1", "1.1.1.1", "255.255.255.0", "1.1.1.1", "4.4.4.4")
+	return i
+}
+
+type expectedRoute struct {
+	prefix    string
+	pathType  RoutingPathType
+	cost      int
+	costType2 int
+	// the next hop addresses, "" for directly attached
+	nextHops []string
+}
+
+func checkRoutes(t *testing.T, rt *routingTableBuilder, expected []expectedRoute) {
+	t.Helper()
+	for _, exp := range expected {
+		_, network, _ := net.ParseCIDR(exp.prefix)
+		e, ok := rt.networks[routingNetworkKey{addr: ipv4BytesToUint32(network.IP.To4()), mask: ipv4MaskToUint32(network.Mask)}]
+		if exp.cost < 0 {
+			if ok {
+				t.Errorf("expecting no route to %s but got %v %d", exp.prefix, e.PathType, e.Cost)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("expecting route to %s but got none", exp.prefix)
+			continue
+		}
+		var nhs []string
+		for _, nh := range e.NextHops {
+			if nh.Address == nil {
+				nhs = append(nhs, "")
+			} else {
+				nhs = append(nhs, nh.Address.String())
+			}
+		}
+		slices.Sort(nhs)
+		if e.PathType != exp.pathType || e.Cost != exp.cost || e.CostType2 != exp.costType2 || !slices.Equal(nhs, exp.nextHops) {
+			t.Errorf("expecting %s %v cost %d type2-cost %d via %q but got %v cost %d type2-cost %d via %q", exp.prefix,
+				exp.pathType, exp.cost, exp.costType2, exp.nextHops, e.PathType, e.Cost, e.CostType2, nhs)
+		}
+	}
+}
+
+func TestCalculateRoutingTable(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		setup    func() *Instance
+		expected []expectedRoute
+	}{
+		{
+			name:  "intra-area routes",
+			setup: newTestBackbone,
+			expected: []expectedRoute{
+				{prefix: "10.0.0.0/24", pathType: RoutingPathIntraArea, cost: 10, nextHops: []string{""}},
+				{prefix: "10.2.0.0/24", pathType: RoutingPathIntraArea, cost: 15, nextHops: []string{"10.0.0.2"}},
+				// equal-cost paths through 2.2.2.2 and 3.3.3.3
+				{prefix: "10.9.0.0/24", pathType: RoutingPathIntraArea, cost: 15, nextHops: []string{"10.0.0.2", "10.0.0.3"}},
+			},
+		},
+		{
+			name: "unreachable router ignored",
+			setup: func() *Instance {
+				i := newTestBackbone()
+				// 6.6.6.6 claims to be attached to the network, which
+				// does not list it.
+				i.Backbone.testRouterLSA("6.6.6.6", 0,
+					testLink(2, "10.0.0.1", "10.0.0.6", 1),
+					testLink(3, "10.6.0.0", "255.255.255.0", 1))
+				return i
+			},
+			expected: []expectedRoute{
+				{prefix: "10.6.0.0/24", cost: -1},
+			},
+		},
+		{
+			name: "inter-area routes",
+			setup: func() *Instance {
+				i := newTestBackbone()
+				i.Backbone.testSummaryLSA(layers.SummaryLSANetworktypeV2, "172.16.0.0", "2.2.2.2", "255.255.0.0", 20)
+				// intra-area paths are preferred over inter-area paths
+				// regardless of the cost.
+				i.Backbone.testSummaryLSA(layers.SummaryLSANetworktypeV2, "10.2.0.0", "2.2.2.2", "255.255.255.0", 1)
+				// summary-LSAs of routers other than area border
+				// routers are ignored.
+				i.Backbone.testSummaryLSA(layers.SummaryLSANetworktypeV2, "172.17.0.0", "3.3.3.3", "255.255.0.0", 1)
+				i.Backbone.testSummaryLSA(layers.SummaryLSANetworktypeV2, "172.18.0.0", "2.2.2.2", "255.255.0.0", packet2.LSInfinity)
+				return i
+			},
+			expected: []expectedRoute{
+				{prefix: "172.16.0.0/16", pathType: RoutingPathInterArea, cost: 30, nextHops: []string{"10.0.0.2"}},
+				{prefix: "10.2.0.0/24", pathType: RoutingPathIntraArea, cost: 15, nextHops: []string{"10.0.0.2"}},
+				{prefix: "172.17.0.0/16", cost: -1},
+				{prefix: "172.18.0.0/16", cost: -1},
+			},
+		},
+		{
+			name: "external routes",
+			setup: func() *Instance {
+				i := newTestBackbone()
+				i.testExternalLSA("8.8.0.0", "5.5.5.5", "255.255.0.0", ExternalMetricType2, 20, "0.0.0.0")
+				i.testExternalLSA("8.9.0.0", "5.5.5.5", "255.255.0.0", ExternalMetricType1, 20, "0.0.0.0")
+				i.testExternalLSA("8.10.0.0", "5.5.5.5", "255.255.0.0", ExternalMetricType2, 20, "10.0.0.9")
+				// 3.3.3.3 is not an AS boundary router.
+				i.testExternalLSA("8.11.0.0", "3.3.3.3", "255.255.0.0", ExternalMetricType2, 20, "0.0.0.0")
+				// The forwarding address is the router's own address.
+				i.testExternalLSA("8.12.0.0", "5.5.5.5", "255.255.0.0", ExternalMetricType2, 20, "10.0.0.1")
+				// The forwarding address is unreachable.
+				i.testExternalLSA("8.13.0.0", "5.5.5.5", "255.255.0.0", ExternalMetricType2, 20, "192.0.2.1")
+				return i
+			},
+			expected: []expectedRoute{
+				{prefix: "8.8.0.0/16", pathType: RoutingPathExternalType2, cost: 10, costType2: 20, nextHops: []string{"10.0.0.5"}},
+				{prefix: "8.9.0.0/16", pathType: RoutingPathExternalType1, cost: 30, nextHops: []string{"10.0.0.5"}},
+				{prefix: "8.10.0.0/16", pathType: RoutingPathExternalType2, cost: 10, costType2: 20, nextHops: []string{"10.0.0.9"}},
+				{prefix: "8.11.0.0/16", cost: -1},
+				{prefix: "8.12.0.0/16", cost: -1},
+				{prefix: "8.13.0.0/16", cost: -1},
+			},
+		},
+		{
+			name: "type 1 preferred over type 2",
+			setup: func() *Instance {
+				i := newTestABR()
+				i.testExternalLSA("8.8.0.0", "5.5.5.5", "255.255.0.0", ExternalMetricType2, 1, "0.0.0.0")
+				i.testExternalLSA("8.8.0.0", "4.4.4.4", "255.255.0.0", ExternalMetricType1, 100, "0.0.0.0")
+				return i
+			},
+			expected: []expectedRoute{
+				{prefix: "8.8.0.0/16", pathType: RoutingPathExternalType1, cost: 150, nextHops: []string{"10.1.0.4"}},
+			},
+		},
+		{
+			name: "smaller type 2 cost preferred over intra-AS path",
+			setup: func() *Instance {
+				i := newTestABR()
+				i.testExternalLSA("8.8.0.0", "5.5.5.5", "255.255.0.0", ExternalMetricType2, 10, "0.0.0.0")
+				i.testExternalLSA("8.8.0.0", "4.4.4.4", "255.255.0.0", ExternalMetricType2, 20, "0.0.0.0")
+				return i
+			},
+			expected: []expectedRoute{
+				{prefix: "8.8.0.0/16", pathType: RoutingPathExternalType2, cost: 10, costType2: 10, nextHops: []string{"10.0.0.5"}},
+			},
+		},
+		{
+			// RFC2328 16.4.1: the intra-area path through the
+			// non-backbone area is preferred despite the higher cost.
+			name: "non-backbone intra-area ASBR path preferred",
+			setup: func() *Instance {
+				i := newTestABR()
+				i.testExternalLSA("8.8.0.0", "5.5.5.5", "255.255.0.0", ExternalMetricType2, 20, "0.0.0.0")
+				i.testExternalLSA("8.8.0.0", "4.4.4.4", "255.255.0.0", ExternalMetricType2, 20, "0.0.0.0")
+				i.testExternalLSA("8.9.0.0", "5.5.5.5", "255.255.0.0", ExternalMetricType1, 20, "0.0.0.0")
+				i.testExternalLSA("8.9.0.0", "4.4.4.4", "255.255.0.0", ExternalMetricType1, 20, "0.0.0.0")
+				return i
+			},
+			expected: []expectedRoute{
+				{prefix: "8.8.0.0/16", pathType: RoutingPathExternalType2, cost: 50, costType2: 20, nextHops: []string{"10.1.0.4"}},
+				{prefix: "8.9.0.0/16", pathType: RoutingPathExternalType1, cost: 70, nextHops: []string{"10.1.0.4"}},
+			},
+		},
+		{
+			name: "least cost ASBR path preferred with RFC1583Compatibility",
+			setup: func() *Instance {
+				i := newTestABR()
+				i.rfc1583Compatibility = true
+				i.testExternalLSA("8.8.0.0", "5.5.5.5", "255.255.0.0", ExternalMetricType2, 20, "0.0.0.0")
+				i.testExternalLSA("8.8.0.0", "4.4.4.4", "255.255.0.0", ExternalMetricType2, 20, "0.0.0.0")
+				return i
+			},
+			expected: []expectedRoute{
+				{prefix: "8.8.0.0/16", pathType: RoutingPathExternalType2, cost: 10, costType2: 20, nextHops: []string{"10.0.0.5"}},
+			},
+		},
+		{
+			name: "preferred path to the same ASBR through multiple areas",
+			setup: func() *Instance {
+				i := newTestABR()
+				// 4.4.4.4 is attached to the backbone as well, reached
+				// at a lower cost.
+				i.Backbone.testRouterLSA("4.4.4.4", flagE, testLink(2, "10.0.0.1", "10.0.0.4", 10))
+				i.Backbone.testNetworkLSA("10.0.0.1", "1.1.1.1", "255.255.255.0", "1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4", "5.5.5.5")
+				i.testExternalLSA("8.8.0.0", "4.4.4.4", "255.255.0.0", ExternalMetricType2, 20, "0.0.0.0")
+				return i
+			},
+			expected: []expectedRoute{
+				{prefix: "8.8.0.0/16", pathType: RoutingPathExternalType2, cost: 50, costType2: 20, nextHops: []string{"10.1.0.4"}},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, _ := tt.setup().calculateRoutingTable()
+			checkRoutes(t, rt, tt.expected)
+		})
+	}
+}
+
+func TestCalculateShortestPathTree(t *testing.T) {
+	i := newTestBackbone()
+	a := i.Backbone
+	tree := a.calculateShortestPathTree(newRoutingTableBuilder(false), a.snapshotLSDBForSPF())
+	for _, exp := range []struct {
+		key      SPFVertexKey
+		distance int
+		parent   SPFVertexKey
+	}{
+		{key: SPFVertexKey{Type: SPFVertexNetwork, Id: ip("10.0.0.1")}, distance: 10, parent: SPFVertexKey{Type: SPFVertexRouter, Id: ip("1.1.1.1")}},
+		{key: SPFVertexKey{Type: SPFVertexRouter, Id: ip("2.2.2.2")}, distance: 10, parent: SPFVertexKey{Type: SPFVertexNetwork, Id: ip("10.0.0.1")}},
+		{key: SPFVertexKey{Type: SPFVertexRouter, Id: ip("5.5.5.5")}, distance: 10, parent: SPFVertexKey{Type: SPFVertexNetwork, Id: ip("10.0.0.1")}},
+	} {
+		v, ok := tree.Vertices[exp.key]
+		if !ok {
+			t.Errorf("expecting vertex %+v on the tree", exp.key)
+			continue
+		}
+		if v.Distance != exp.distance || v.Parent == nil || v.Parent.SPFVertexKey != exp.parent {
+			t.Errorf("expecting vertex %+v at distance %d from %+v but got %d from %+v", exp.key, exp.distance, exp.parent, v.Distance, v.Parent)
+		}
+	}
+	// LSAs of age MaxAge are not used in the routing table calculation.
+	for id, l := range a.RouterLSAs {
+		if id.AdvRouter == ip("2.2.2.2") {
+			l.h.LSAge = packet2.MaxAge
+		}
+	}
+	tree = a.calculateShortestPathTree(newRoutingTableBuilder(false), a.snapshotLSDBForSPF())
+	if _, ok := tree.Vertices[SPFVertexKey{Type: SPFVertexRouter, Id: ip("2.2.2.2")}]; ok {
+		t.Errorf("expecting router of MaxAge router-LSA off the tree")
+	}
+}
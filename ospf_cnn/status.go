@@ -0,0 +1,167 @@
+package ospf_cnn
+
+import (
+	"cmp"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"net"
+	"slices"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// NeighborInfo describes a neighbor of the router.
+type NeighborInfo struct {
+	IfName   string
+	AreaId   uint32
+	RouterId uint32
+	Hostname string
+	Address  net.IP
+	Priority uint8
+	State    NeighborState
+	GRHelper GRHelperState
+}
+
+// Neighbors lists the neighbors of all interfaces, sorted by Router ID.
+func (r *Router) Neighbors() []NeighborInfo {
+	var (
+		ret       []NeighborInfo
+		hostnames = r.ins.hostnames()
+	)
+	for _, a := range r.ins.attachedAreas() {
+		for _, ifi := range a.Interfaces {
+			ifi.rangeOverNeighbors(func(nb *Neighbor) bool {
+				ret = append(ret, NeighborInfo{
+					IfName:   ifi.c.ifi.Name,
+					AreaId:   a.AreaId,
+					RouterId: nb.NeighborId,
+					Hostname: hostnames[nb.NeighborId],
+					Address:  nb.NeighborAddress,
+					Priority: nb.NeighborPriority,
+					State:    nb.currState(),
+					GRHelper: nb.grHelperState(),
+				})
+				return true
+			})
+		}
+	}
+	slices.SortFunc(ret, func(x, y NeighborInfo) int {
+		return cmp.Or(cmp.Compare(x.RouterId, y.RouterId), cmp.Compare(x.IfName, y.IfName))
+	})
+	return ret
+}
+
+// InterfaceInfo describes an interface of the router.
+type InterfaceInfo struct {
+	IfName  string
+	AreaId  uint32
+	Type    InterfaceType
+	State   InterfaceState
+	Address *net.IPNet
+	MTU     uint16
+	// The IPv4 fragments received on the interface.
+	Reassembly ReassemblyStats
+	// The numbers of received packets and LSAs rejected, per reason.
+	Rejected map[RecvRejectReason]uint64
+}
+
+// Interfaces lists the interfaces of all attached areas, virtual links included.
+func (r *Router) Interfaces() []InterfaceInfo {
+	var ret []InterfaceInfo
+	for _, a := range r.ins.attachedAreas() {
+		for _, ifi := range a.Interfaces {
+			ret = append(ret, InterfaceInfo{
+				IfName:     ifi.c.ifi.Name,
+				AreaId:     a.AreaId,
+				Type:       ifi.Type,
+				State:      ifi.currState(),
+				Address:    ifi.getAddress(),
+				MTU:        ifi.MTU,
+				Reassembly: ifi.reassembly.getStats(),
+				Rejected:   ifi.rejected.snapshot(),
+			})
+		}
+	}
+	slices.SortFunc(ret, func(x, y InterfaceInfo) int {
+		return cmp.Or(cmp.Compare(x.AreaId, y.AreaId), cmp.Compare(x.IfName, y.IfName))
+	})
+	return ret
+}
+
+// LSDBEntry describes an LSA in the link state database.
+type LSDBEntry struct {
+	// The area the LSA belongs to. Not valid for AS-scoped LSAs.
+	AreaId uint32
+	// The interface of link-local LSAs. Empty for the others.
+	IfName string
+	packet2.LSAheader
+	// The hostname of the advertising router.
+	Hostname string
+}
+
+// IsASScoped checks whether the LSA is flooded throughout the AS rather
+// than belonging to an area.
+func (e LSDBEntry) IsASScoped() bool {
+	return e.LSType == layers.ASExternalLSAtypeV2 || e.LSType == packet2.ASOpaqueLSAtypeV2
+}
+
+// LSDB lists the LSAs in the link state databases of all attached areas,
+// followed by the AS-scoped ones.
+func (r *Router) LSDB() []LSDBEntry {
+	var (
+		ret       []LSDBEntry
+		hostnames = r.ins.hostnames()
+	)
+	for _, a := range r.ins.attachedAreas() {
+		var hdrs []packet2.LSAheader
+		a.lsDbRw.RLock()
+		for _, l := range a.RouterLSAs {
+			hdrs = append(hdrs, l.h)
+		}
+		for _, l := range a.NetworkLSAs {
+			hdrs = append(hdrs, l.h)
+		}
+		for _, l := range a.SummaryLSAs {
+			hdrs = append(hdrs, l.h)
+		}
+		for _, l := range a.NSSALSAs {
+			hdrs = append(hdrs, l.h)
+		}
+		for _, l := range a.OpaqueLSAs {
+			hdrs = append(hdrs, l.h)
+		}
+		a.lsDbRw.RUnlock()
+		for _, h := range hdrs {
+			ret = append(ret, LSDBEntry{AreaId: a.AreaId, LSAheader: h, Hostname: hostnames[h.AdvRouter]})
+		}
+		for _, ifi := range a.Interfaces {
+			ifi.opaqueRw.RLock()
+			for _, l := range ifi.LinkLocalOpaqueLSAs {
+				ret = append(ret, LSDBEntry{AreaId: a.AreaId, IfName: ifi.c.ifi.Name, LSAheader: l.h, Hostname: hostnames[l.h.AdvRouter]})
+			}
+			ifi.opaqueRw.RUnlock()
+		}
+	}
+	var asHdrs []packet2.LSAheader
+	r.ins.lsDbRangeExtLSA(func(_ packet2.LSAIdentity, l *LSDBASExternalItem) bool {
+		asHdrs = append(asHdrs, l.h)
+		return true
+	})
+	r.ins.lsDbRangeASOpaqueLSA(func(_ packet2.LSAIdentity, l *LSDBOpaqueItem) bool {
+		asHdrs = append(asHdrs, l.h)
+		return true
+	})
+	for _, h := range asHdrs {
+		ret = append(ret, LSDBEntry{LSAheader: h, Hostname: hostnames[h.AdvRouter]})
+	}
+	slices.SortFunc(ret, func(x, y LSDBEntry) int {
+		if xAS, yAS := x.IsASScoped(), y.IsASScoped(); xAS != yAS {
+			if xAS {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Or(cmp.Compare(x.AreaId, y.AreaId), cmp.Compare(x.LSType, y.LSType),
+			cmp.Compare(x.LinkStateID, y.LinkStateID), cmp.Compare(x.AdvRouter, y.AdvRouter))
+	})
+	return ret
+}
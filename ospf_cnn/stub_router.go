@@ -0,0 +1,180 @@
+package ospf_cnn
+
+import (
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"maps"
+	"slices"
+	"time"
+)
+
+// StubRouterConfig configures the stub router advertisement (RFC6987), with
+// which the other routers route around the router while the adjacencies are
+// kept up, e.g. for draining it before maintenance.
+type StubRouterConfig struct {
+	// Act as stub router from startup until OnStartup seconds after the first
+	// adjacency becomes full, so that the routers do not transit the router
+	// before it has learnt the routes. 0 disables it.
+	OnStartup uint32
+	// Advertise the external routes at the maximum metric as well while
+	// acting as stub router.
+	MaxMetricExternal bool
+}
+
+// maxExternalMetric is the metric of external routes advertised by the stub
+// router. It is kept below LSInfinity so that the routes remain usable as the
+// last resort.
+const maxExternalMetric = packet2.LSInfinity - 1
+
+// StubRouterState is the stub router state of the router.
+type StubRouterState struct {
+	// Whether the router is administratively configured as stub router.
+	Enabled bool
+	// Whether the router acts as stub router on startup.
+	OnStartup bool
+	// When the stub router on startup ends. Zero if it has not been
+	// scheduled yet, i.e. no adjacency has become full.
+	OnStartupEnd time.Time
+	// Whether the external routes are advertised at the maximum metric.
+	MaxMetricExternal bool
+}
+
+// Active checks whether the router is currently advertised as stub router.
+func (s StubRouterState) Active() bool {
+	return s.Enabled || s.OnStartup
+}
+
+// stubRouter is the stub router state of the instance.
+type stubRouter struct {
+	StubRouterState
+	onStartupPeriod time.Duration
+	onStartupTimer  *time.Timer
+}
+
+func (i *Instance) stubRouterState() StubRouterState {
+	i.stubMu.Lock()
+	defer i.stubMu.Unlock()
+	return i.stub.StubRouterState
+}
+
+func (i *Instance) isStubRouter() bool {
+	return i.stubRouterState().Active()
+}
+
+// maxMetricExternal checks whether the external routes are to be advertised at
+// the maximum metric.
+func (i *Instance) maxMetricExternal() bool {
+	s := i.stubRouterState()
+	return s.Active() && s.MaxMetricExternal
+}
+
+// setStubRouter administratively enables or disables the stub router.
+func (i *Instance) setStubRouter(enabled bool) {
+	i.stubMu.Lock()
+	before := i.stub.Active()
+	i.stub.Enabled = enabled
+	after := i.stub.Active()
+	i.stubMu.Unlock()
+	if before == after {
+		return
+	}
+	if enabled {
+		LogInfo("stub router enabled administratively")
+	} else {
+		LogInfo("stub router disabled administratively")
+	}
+	i.stubRouterChanged()
+}
+
+// scheduleStubRouterOnStartupEnd schedules the end of the stub router on startup
+// once the first adjacency becomes full.
+func (i *Instance) scheduleStubRouterOnStartupEnd() {
+	i.stubMu.Lock()
+	defer i.stubMu.Unlock()
+	if !i.stub.OnStartup || i.stub.onStartupTimer != nil {
+		return
+	}
+	LogInfo("first adjacency established. Acting as stub router for another %v", i.stub.onStartupPeriod)
+	i.stub.OnStartupEnd = time.Now().Add(i.stub.onStartupPeriod)
+	i.stub.onStartupTimer = time.AfterFunc(i.stub.onStartupPeriod, i.endStubRouterOnStartup)
+}
+
+func (i *Instance) endStubRouterOnStartup() {
+	i.stubMu.Lock()
+	before := i.stub.Active()
+	i.stub.OnStartup = false
+	after := i.stub.Active()
+	i.stubMu.Unlock()
+	if before != after {
+		LogInfo("stub router on startup ended")
+		i.stubRouterChanged()
+	}
+}
+
+func (i *Instance) stopStubRouterOnStartup() {
+	i.stubMu.Lock()
+	defer i.stubMu.Unlock()
+	if i.stub.onStartupTimer != nil {
+		i.stub.onStartupTimer.Stop()
+	}
+}
+
+// stubRouterChanged re-originates the router-LSAs, and the external LSAs if
+// advertised at the maximum metric, after becoming or no longer being a
+// stub router.
+func (i *Instance) stubRouterChanged() {
+	for _, a := range i.attachedAreas() {
+		if a.shuttingDown.Load() {
+			continue
+		}
+		a.updateSelfOriginatedRouterLSA(nil)
+	}
+	if i.stubRouterState().MaxMetricExternal && i.reannounceExternalRoutes != nil {
+		i.reannounceExternalRoutes()
+	}
+}
+
+// applyStubRouterLinkMetrics sets the cost of all non-stub links, i.e. links
+// of types other than 3, to MaxLinkMetric while acting as stub router. The
+// stub links keep their costs, so the networks directly connected to the
+// router remain reachable (RFC6987 2).
+func (i *Instance) applyStubRouterLinkMetrics(links []packet2.RouterV2) {
+	if !i.isStubRouter() {
+		return
+	}
+	for idx := range links {
+		if links[idx].Type != 3 {
+			links[idx].Metric = packet2.MaxLinkMetric
+		}
+	}
+}
+
+// externalLSAContent describes the external route in AS-external-LSAs and
+// Type-7 LSAs, at the maximum metric if so configured for the stub router.
+func (i *Instance) externalLSAContent(r ExternalRoute) packet2.V2ASExternalLSA {
+	ret := r.asV2ASExternalLSA()
+	if i.maxMetricExternal() {
+		ret.Metric = maxExternalMetric
+	}
+	return ret
+}
+
+// SetStubRouter administratively enables or disables the stub router. The
+// adjacencies are kept up either way.
+func (r *Router) SetStubRouter(enabled bool) {
+	r.ins.setStubRouter(enabled)
+}
+
+// StubRouter returns the stub router state of the router.
+func (r *Router) StubRouter() StubRouterState {
+	return r.ins.stubRouterState()
+}
+
+// reannounceExternalRoutes re-originates the LSAs of the announced external
+// routes whose advertised metrics have changed.
+func (r *Router) reannounceExternalRoutes() {
+	r.announcedMu.Lock()
+	defer r.announcedMu.Unlock()
+	if len(r.announced) > 0 {
+		r.ins.syncASBRLSA(slices.Collect(maps.Values(r.announced))...)
+	}
+}
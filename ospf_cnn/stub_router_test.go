@@ -0,0 +1,129 @@
+package ospf_cnn
+
+import (
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestApplyStubRouterLinkMetrics(t *testing.T) {
+	links := []packet2.RouterV2{
+		testLink(1, "2.2.2.2", "10.1.0.1", 10),
+		testLink(2, "10.0.0.1", "10.0.0.1", 10),
+		testLink(3, "10.1.0.0", "255.255.255.0", 10),
+		testLink(4, "3.3.3.3", "10.2.0.1", 10),
+	}
+	for _, tt := range []struct {
+		name     string
+		state    StubRouterState
+		expected []uint16
+	}{
+		{
+			name:     "not stub router",
+			expected: []uint16{10, 10, 10, 10},
+		},
+		{
+			name:     "administratively",
+			state:    StubRouterState{Enabled: true},
+			expected: []uint16{packet2.MaxLinkMetric, packet2.MaxLinkMetric, 10, packet2.MaxLinkMetric},
+		},
+		{
+			name:     "on startup",
+			state:    StubRouterState{OnStartup: true},
+			expected: []uint16{packet2.MaxLinkMetric, packet2.MaxLinkMetric, 10, packet2.MaxLinkMetric},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newTestInstance("1.1.1.1")
+			i.stub.StubRouterState = tt.state
+			got := slices.Clone(links)
+			i.applyStubRouterLinkMetrics(got)
+			for idx, l := range got {
+				if l.Metric != tt.expected[idx] {
+					t.Errorf("expecting type %d link metric %d but got %d", l.Type, tt.expected[idx], l.Metric)
+				}
+			}
+		})
+	}
+}
+
+func TestExternalLSAContent(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("192.168.0.0/24")
+	r := ExternalRoute{Prefix: *prefix, Metric: 20}
+	for _, tt := range []struct {
+		name     string
+		state    StubRouterState
+		expected uint32
+	}{
+		{
+			name:     "not stub router",
+			state:    StubRouterState{MaxMetricExternal: true},
+			expected: 20,
+		},
+		{
+			name:     "stub router",
+			state:    StubRouterState{Enabled: true},
+			expected: 20,
+		},
+		{
+			name:     "max metric external",
+			state:    StubRouterState{Enabled: true, MaxMetricExternal: true},
+			expected: maxExternalMetric,
+		},
+		{
+			name:     "max metric external on startup",
+			state:    StubRouterState{OnStartup: true, MaxMetricExternal: true},
+			expected: maxExternalMetric,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newTestInstance("1.1.1.1")
+			i.stub.StubRouterState = tt.state
+			if got := i.externalLSAContent(r); got.Metric != tt.expected || got.NetworkMask != ip("255.255.255.0") {
+				t.Errorf("expecting metric %d but got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestStubRouterOnStartup(t *testing.T) {
+	i := newTestInstance("1.1.1.1")
+	i.stub.OnStartup = true
+	i.stub.onStartupPeriod = 50 * time.Millisecond
+	t.Cleanup(i.stopStubRouterOnStartup)
+	ifi := i.Backbone.testInterface("10.0.0.1/24")
+	exchange := func(rtId string) {
+		t.Helper()
+		nb := ifi.testFullNeighbor(t, rtId)
+		nb.State = NeighborExchange
+		nb.consumeEvent(NbEvExchangeDone)
+		if st := nb.currState(); st != NeighborFull {
+			t.Fatalf("expecting neighbor %s %v but got %v", rtId, NeighborFull, st)
+		}
+	}
+
+	// Not scheduled until the first adjacency becomes full.
+	if s := i.stubRouterState(); !s.Active() || !s.OnStartupEnd.IsZero() {
+		t.Fatalf("expecting stub router on startup unscheduled but got %+v", s)
+	}
+	exchange("2.2.2.2")
+	end := i.stubRouterState().OnStartupEnd
+	if end.IsZero() {
+		t.Fatalf("expecting stub router on startup scheduled but got none")
+	}
+	// The adjacencies becoming full later do not postpone it.
+	exchange("3.3.3.3")
+	if got := i.stubRouterState().OnStartupEnd; !got.Equal(end) {
+		t.Errorf("expecting stub router on startup ending at %v but got %v", end, got)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for i.isStubRouter() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expecting stub router on startup ended but got %+v", i.stubRouterState())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,128 @@
+package ospf_cnn
+
+import (
+	"cmp"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"slices"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// TENode is a router advertising Traffic Engineering LSAs (RFC3630) into
+// an area, along with the TE links it advertises.
+type TENode struct {
+	AreaId   uint32
+	RouterId uint32
+	// The address of the Router Address TLV. 0 if not advertised.
+	RouterAddress uint32
+	Links         []TELink
+}
+
+// TELink is a TE link joined with the topology described by the router-LSA
+// of the advertising router and the network-LSAs of the area.
+type TELink struct {
+	packet2.TELinkTLV
+	// The Opaque ID of the TE LSA describing the link.
+	OpaqueId uint32
+	// Whether the router-LSA of the advertising router describes the link,
+	// i.e. the link takes part in the OSPF topology.
+	InTopology bool
+	// The cost of the link in the router-LSA. Valid if InTopology.
+	Metric uint16
+	// The routers at the other end of the link: the neighbor of point-to-point
+	// links, or the other routers attached to the network of multi-access links.
+	RemoteRouterIds []uint32
+}
+
+// TEDatabase returns the TE database of all attached areas. It is sorted by
+// area and Router ID, and the links by Opaque ID.
+func (r *Router) TEDatabase() []TENode {
+	var ret []TENode
+	for _, a := range r.ins.attachedAreas() {
+		ret = append(ret, a.teNodes()...)
+	}
+	slices.SortFunc(ret, func(x, y TENode) int {
+		return cmp.Or(cmp.Compare(x.AreaId, y.AreaId), cmp.Compare(x.RouterId, y.RouterId))
+	})
+	return ret
+}
+
+// teNodes collects the TE LSAs in the link state database of the area.
+func (a *Area) teNodes() []TENode {
+	a.lsDbRw.RLock()
+	defer a.lsDbRw.RUnlock()
+	nodes := make(map[uint32]*TENode)
+	for id, l := range a.OpaqueLSAs {
+		opaqueType, opaqueId := packet2.SplitOpaqueLinkStateID(id.LinkStateId)
+		if opaqueType != packet2.TEOpaqueType || l.h.LSAge >= packet2.MaxAge {
+			continue
+		}
+		te, err := l.l.AsTELSA()
+		if err != nil {
+			LogDebug("area %v skipped malformed TE LSA(%+v): %v", a.AreaId, id, err)
+			continue
+		}
+		node, ok := nodes[id.AdvRouter]
+		if !ok {
+			node = &TENode{AreaId: a.AreaId, RouterId: id.AdvRouter}
+			nodes[id.AdvRouter] = node
+		}
+		if te.Link == nil {
+			node.RouterAddress = te.RouterAddress
+			continue
+		}
+		link := TELink{TELinkTLV: *te.Link, OpaqueId: opaqueId}
+		a.joinTELinkWithTopology(id.AdvRouter, &link)
+		node.Links = append(node.Links, link)
+	}
+	ret := make([]TENode, 0, len(nodes))
+	for _, node := range nodes {
+		slices.SortFunc(node.Links, func(x, y TELink) int {
+			return cmp.Compare(x.OpaqueId, y.OpaqueId)
+		})
+		ret = append(ret, *node)
+	}
+	return ret
+}
+
+// joinTELinkWithTopology looks up the link in the router-LSA of the advertising
+// router. The Link ID of TE links is the same as the one of router-LSA links
+// of type 1 (point-to-point) or type 2 (transit network) respectively.
+// It must be called with lsDbRw held.
+func (a *Area) joinTELinkWithTopology(advRouter uint32, link *TELink) {
+	rtLSA, ok := a.RouterLSAs[packet2.LSAIdentity{
+		LSType:      layers.RouterLSAtypeV2,
+		LinkStateId: advRouter,
+		AdvRouter:   advRouter,
+	}]
+	if ok && rtLSA.h.LSAge < packet2.MaxAge {
+		for _, rl := range rtLSA.l.Routers {
+			if rl.LinkID != link.LinkID ||
+				(link.LinkType == packet2.TELinkTypePointToPoint && rl.Type != 1) ||
+				(link.LinkType == packet2.TELinkTypeMultiAccess && rl.Type != 2) {
+				continue
+			}
+			// Parallel links are told apart by the local interface address.
+			if len(link.LocalAddresses) > 0 && !slices.Contains(link.LocalAddresses, rl.LinkData) {
+				continue
+			}
+			link.InTopology, link.Metric = true, rl.Metric
+			break
+		}
+	}
+	switch link.LinkType {
+	case packet2.TELinkTypePointToPoint:
+		link.RemoteRouterIds = []uint32{link.LinkID}
+	case packet2.TELinkTypeMultiAccess:
+		for _, ntLSA := range a.NetworkLSAs {
+			if ntLSA.h.LinkStateID != link.LinkID || ntLSA.h.LSAge >= packet2.MaxAge {
+				continue
+			}
+			for _, rtId := range ntLSA.l.AttachedRouter {
+				if rtId != advRouter {
+					link.RemoteRouterIds = append(link.RemoteRouterIds, rtId)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package ospf_cnn
+
+import (
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testTELSA(advRouter string, opaqueId uint32, te packet2.TELSA) packet2.LSAdvertisement {
+	return packet2.LSAdvertisement{
+		LSAheader: packet2.LSAheader{
+			LSType:      packet2.AreaLocalOpaqueLSAtypeV2,
+			LinkStateID: packet2.OpaqueLinkStateID(packet2.TEOpaqueType, opaqueId),
+			AdvRouter:   ip(advRouter),
+			LSSeqNumber: packet2.InitialSequenceNumber,
+		},
+		Content: packet2.NewV2OpaqueLSA(te.TLVs()...),
+	}
+}
+
+func TestTENodes(t *testing.T) {
+	i := newTestInstance("1.1.1.1")
+	a := i.Backbone
+	// 2.2.2.2 has two parallel point-to-point links to 3.3.3.3 and is
+	// attached to 10.0.0.0/24 with 1.1.1.1 and 4.4.4.4.
+	a.testRouterLSA("2.2.2.2", 0,
+		testLink(1, "3.3.3.3", "10.1.0.2", 10),
+		testLink(1, "3.3.3.3", "10.2.0.2", 20),
+		testLink(2, "10.0.0.1", "10.0.0.2", 5))
+	a.testNetworkLSA("10.0.0.1", "1.1.1.1", "255.255.255.0", "1.1.1.1", "2.2.2.2", "4.4.4.4")
+	teLink := func(typ uint8, id string, local ...string) packet2.TELSA {
+		l := &packet2.TELinkTLV{LinkType: typ, LinkID: ip(id)}
+		for _, addr := range local {
+			l.LocalAddresses = append(l.LocalAddresses, ip(addr))
+		}
+		return packet2.TELSA{Link: l}
+	}
+	for _, l := range []packet2.LSAdvertisement{
+		testTELSA("2.2.2.2", 0, packet2.TELSA{RouterAddress: ip("2.2.2.2")}),
+		testTELSA("2.2.2.2", 1, teLink(packet2.TELinkTypePointToPoint, "3.3.3.3", "10.2.0.2")),
+		testTELSA("2.2.2.2", 2, teLink(packet2.TELinkTypePointToPoint, "3.3.3.3", "10.1.0.2")),
+		testTELSA("2.2.2.2", 3, teLink(packet2.TELinkTypeMultiAccess, "10.0.0.1", "10.0.0.2")),
+		testTELSA("2.2.2.2", 4, teLink(packet2.TELinkTypePointToPoint, "5.5.5.5")),
+		// the type of the link differs from the router-LSA link.
+		testTELSA("2.2.2.2", 5, teLink(packet2.TELinkTypeMultiAccess, "3.3.3.3")),
+		// none of the parallel links has the local address.
+		testTELSA("2.2.2.2", 6, teLink(packet2.TELinkTypePointToPoint, "3.3.3.3", "10.3.0.2")),
+	} {
+		if err := a.lsDbInstallLSA(l, &lsaMeta{ctime: time.Now()}); err != nil {
+			t.Fatalf("failed to install LSA: %s", err)
+		}
+	}
+
+	nodes := a.teNodes()
+	if len(nodes) != 1 || nodes[0].RouterId != ip("2.2.2.2") || nodes[0].RouterAddress != ip("2.2.2.2") {
+		t.Fatalf("expecting TE node 2.2.2.2 with Router Address 2.2.2.2 but got %+v", nodes)
+	}
+	expected := []struct {
+		inTopology bool
+		metric     uint16
+		remote     []string
+	}{
+		{inTopology: true, metric: 20, remote: []string{"3.3.3.3"}},
+		{inTopology: true, metric: 10, remote: []string{"3.3.3.3"}},
+		{inTopology: true, metric: 5, remote: []string{"1.1.1.1", "4.4.4.4"}},
+		{inTopology: false, remote: []string{"5.5.5.5"}},
+		{inTopology: false},
+		{inTopology: false, remote: []string{"3.3.3.3"}},
+	}
+	if len(nodes[0].Links) != len(expected) {
+		t.Fatalf("expecting %d TE links but got %d", len(expected), len(nodes[0].Links))
+	}
+	for n, tt := range expected {
+		link := nodes[0].Links[n]
+		var remote []uint32
+		for _, rtId := range tt.remote {
+			remote = append(remote, ip(rtId))
+		}
+		if link.OpaqueId != uint32(n+1) || link.InTopology != tt.inTopology || link.Metric != tt.metric ||
+			!slices.Equal(link.RemoteRouterIds, remote) {
+			t.Errorf("expecting TE link %d in topology %v metric %d remote %v but got %d in topology %v metric %d remote %v",
+				n+1, tt.inTopology, tt.metric, tt.remote,
+				link.OpaqueId, link.InTopology, link.Metric, link.RemoteRouterIds)
+		}
+	}
+}
@@ -23,6 +23,10 @@ func ipv4MaskToUint32(b []byte) uint32 {
 	return binary.BigEndian.Uint32(b[0:4])
 }
 
+func uint32ToIPv4Mask(mask uint32) net.IPMask {
+	return net.IPv4Mask(byte(mask>>24), byte(mask>>16), byte(mask>>8), byte(mask))
+}
+
 type TickerFunc struct {
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -0,0 +1,287 @@
+package ospf_cnn
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"net"
+	"sync"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// VirtualLinkConfig configures a virtual link to another area border router
+// through a non-backbone area, per RFC2328 15 and C.4.
+type VirtualLinkConfig struct {
+	// The non-backbone area the virtual link traverses, aka the Transit
+	// area. Virtual links cannot be configured through stub areas or NSSAs.
+	TransitAreaId uint32
+	// The Router ID of the virtual neighbor, i.e. the other endpoint.
+	RouterId uint32
+	// Defaults to the ones of the instance.
+	HelloInterval      uint16
+	RouterDeadInterval uint32
+	// Authentication of packets sent over the virtual link.
+	Auth InterfaceAuth
+}
+
+func (c *VirtualLinkConfig) validate(ic *InstanceConfig) error {
+	if c.TransitAreaId == 0 {
+		return errors.New("the backbone cannot be the transit area of virtual links")
+	}
+	if c.RouterId == 0 || c.RouterId == ic.RouterId {
+		return fmt.Errorf("invalid virtual neighbor %v through transit area %v", uint32ToIPv4(c.RouterId), uint32ToIPv4(c.TransitAreaId))
+	}
+	attached := ic.AreaId == c.TransitAreaId
+	for _, ifc := range ic.Interfaces {
+		attached = attached || ifc.AreaId == c.TransitAreaId
+	}
+	if !attached {
+		return fmt.Errorf("transit area %v of virtual link to %v is not attached", uint32ToIPv4(c.TransitAreaId), uint32ToIPv4(c.RouterId))
+	}
+	for _, ac := range ic.Areas {
+		if ac.AreaId == c.TransitAreaId && (ac.Stub || ac.NSSA) {
+			return fmt.Errorf("virtual links cannot be configured through stub area or NSSA %v", uint32ToIPv4(c.TransitAreaId))
+		}
+	}
+	return c.Auth.validate()
+}
+
+// virtualLink keeps the parameters of the virtual link derived from the
+// shortest-path tree of its Transit area.
+type virtualLink struct {
+	// The non-backbone area the virtual link traverses.
+	transit *Area
+	// The Router ID of the virtual neighbor.
+	peerId uint32
+
+	mu sync.RWMutex
+	// One of the IP addresses of the virtual neighbor's interfaces into
+	// the Transit area. 0 when the virtual neighbor is unreachable.
+	peerAddr uint32
+	// The next hops of the intra-area path to the virtual neighbor
+	// through the Transit area.
+	nextHops []RoutingNextHop
+}
+
+func (vl *virtualLink) neighborAddress() uint32 {
+	vl.mu.RLock()
+	defer vl.mu.RUnlock()
+	return vl.peerAddr
+}
+
+func (vl *virtualLink) getNextHops() []RoutingNextHop {
+	vl.mu.RLock()
+	defer vl.mu.RUnlock()
+	return append([]RoutingNextHop(nil), vl.nextHops...)
+}
+
+func (vl *virtualLink) setEndpoint(peerAddr uint32, nhs []RoutingNextHop) {
+	vl.mu.Lock()
+	defer vl.mu.Unlock()
+	vl.peerAddr = peerAddr
+	vl.nextHops = nhs
+}
+
+// attachVirtualLink adds the virtual link into the backbone. It stays Down
+// until the virtual neighbor is found reachable through the Transit area.
+func (a *Area) attachVirtualLink(c *VirtualLinkConfig, transit *Area) *Interface {
+	name := fmt.Sprintf("vlink-%v", uint32ToIPv4(c.RouterId))
+	// The virtual link goes along with the backbone it belongs to.
+	conn, err := ListenOSPFv2VirtualLink(a.ctx, name)
+	if err != nil {
+		panic(fmt.Errorf("can not bind OSPFv2 conn of virtual link: %w", err))
+	}
+	ctx, cancel := context.WithCancel(a.ctx)
+	i := &Interface{
+		ctx:               ctx,
+		cancel:            cancel,
+		c:                 conn,
+		pendingProcessPkt: make(chan recvPkt, 100),
+		pendingSendPkt:    make(chan sendPkt, 100),
+		Type:              IfTypeVirtualLink,
+		MTU:               transit.minMTU(),
+		// The IP interface mask is not defined on virtual links.
+		Address:            &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+		Area:               a,
+		HelloInterval:      c.HelloInterval,
+		RouterDeadInterval: c.RouterDeadInterval,
+		Neighbors:          make(map[uint32]*Neighbor),
+		OutputCost:         packet2.LSInfinity,
+		RxmtInterval:       5,
+		InfTransDelay:      1,
+		auth:               c.Auth,
+		vl: &virtualLink{
+			transit: transit,
+			peerId:  c.RouterId,
+		},
+	}
+	i.initNBMANeighbors(nil)
+	a.Interfaces = append(a.Interfaces, i)
+	return i
+}
+
+// minMTU returns the smallest MTU of the interfaces attached to the area, which
+// packets sent over virtual links through the area are sized to.
+func (a *Area) minMTU() uint16 {
+	var ret uint16
+	for _, ifi := range a.Interfaces {
+		if ifi.vl == nil && (ret == 0 || ifi.MTU < ret) {
+			ret = ifi.MTU
+		}
+	}
+	if ret == 0 {
+		return defaultMTU
+	}
+	return ret
+}
+
+// getVirtualLink returns the virtual link to the virtual neighbor peerId.
+func (a *Area) getVirtualLink(peerId uint32) *Interface {
+	for _, ifi := range a.Interfaces {
+		if ifi.vl != nil && ifi.vl.peerId == peerId {
+			return ifi
+		}
+	}
+	return nil
+}
+
+// hasFullVirtualLink checks whether the router is an endpoint of one or more
+// fully adjacent virtual links having the area as Transit area.
+func (a *Area) hasFullVirtualLink() bool {
+	for _, ifi := range a.ins.Backbone.Interfaces {
+		if ifi.vl != nil && ifi.vl.transit == a && len(ifi.fullyAdjacentNeighbors()) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// virtualLinkOfPkt returns the virtual link the received packet has been sent
+// over. Such packets are unicast, labelled with the backbone Area ID and sent
+// by a virtual neighbor.
+func (i *Instance) virtualLinkOfPkt(pkt recvPkt) *Interface {
+	if len(pkt.p) < 12 || pkt.h.Dst.IsMulticast() || binary.BigEndian.Uint32(pkt.p[8:12]) != 0 {
+		return nil
+	}
+	return i.Backbone.getVirtualLink(binary.BigEndian.Uint32(pkt.p[4:8]))
+}
+
+// rejectsVirtualLinkPkt checks whether the received packet is left to other
+// interfaces. Packets sent over a virtual link are only processed by that
+// virtual link, which in turn processes nothing else. Virtual links in Down
+// state receive no protocol traffic at all.
+func (i *Interface) rejectsVirtualLinkPkt(pkt recvPkt) bool {
+	vl := i.Area.ins.virtualLinkOfPkt(pkt)
+	if i.vl == nil {
+		return vl != nil
+	}
+	return vl != i || i.currState() == InterfaceDown
+}
+
+// virtualLinkLinks describes the virtual link in router-LSA per RFC2328 12.4.1.3.
+func (i *Interface) virtualLinkLinks(addr uint32) []packet2.RouterV2 {
+	// If the virtual neighbor is fully adjacent, a Type 4 link is added
+	// whose Link ID is the virtual neighbor's Router ID and Link Data is
+	// the router's IP interface address associated with the virtual link.
+	// The cost is the cost of the intra-area path through the Transit area.
+	var links []packet2.RouterV2
+	for _, nbId := range i.fullyAdjacentNeighbors() {
+		links = append(links, packet2.RouterV2{
+			RouterV2: layers.RouterV2{
+				Type:     4,
+				LinkID:   nbId,
+				LinkData: addr,
+				Metric:   uint16(min(i.getOutputCost(), packet2.MaxLinkMetric)),
+			},
+		})
+	}
+	return links
+}
+
+// virtualNeighborAddress returns one of the IP addresses of the router's
+// interfaces into the area, as found in its router-LSA. The one of the
+// link it has been reached through is preferred.
+func (v *SPFVertex) virtualNeighborAddress() (ret uint32) {
+	for _, l := range v.rtLSA.Routers {
+		if l.Type != 1 && l.Type != 2 {
+			continue
+		}
+		if v.Parent != nil && l.LinkID == v.Parent.Id && (l.Type == 2) == (v.Parent.Type == SPFVertexNetwork) {
+			return l.LinkData
+		}
+		if ret == 0 {
+			ret = l.LinkData
+		}
+	}
+	return
+}
+
+// updateVirtualLinks examines the virtual links per RFC2328 16.1 once the
+// shortest-path trees of their Transit areas have been built, and before
+// the one of the backbone is.
+func (i *Instance) updateVirtualLinks(trees map[*Area]*SPFTree) {
+	for _, ifi := range i.Backbone.Interfaces {
+		if ifi.vl != nil {
+			ifi.updateVirtualLink(trees[ifi.vl.transit])
+		}
+	}
+}
+
+// updateVirtualLink brings the virtual link up or down per the shortest-path
+// tree of its Transit area. The virtual link is operational as long as the
+// virtual neighbor is reachable through the Transit area, and its cost is the
+// cost of that intra-area path.
+func (i *Interface) updateVirtualLink(tree *SPFTree) {
+	vl := i.vl
+	var peer *SPFVertex
+	if tree != nil && tree.Root != nil {
+		peer = tree.Vertices[SPFVertexKey{Type: SPFVertexRouter, Id: vl.peerId}]
+	}
+	if peer == nil || peer == tree.Root || len(peer.NextHops) <= 0 {
+		vl.setEndpoint(0, nil)
+		if i.currState() != InterfaceDown {
+			LogInfo("virtual link %s down: virtual neighbor unreachable through transit area %v",
+				i.c.ifi.Name, uint32ToIPv4(vl.transit.AreaId))
+			i.consumeEvent(IfEvInterfaceDown)
+		}
+		return
+	}
+	// The virtual interface's IP address is set to the IP interface address
+	// of the router's interface used to send packets to the virtual neighbor.
+	// The current one is kept as long as it is still on the path.
+	localAddr := peer.NextHops[0].Interface.getAddress().IP.To4()
+	for _, nh := range peer.NextHops {
+		if nh.Interface.getAddress().IP.Equal(i.getAddress().IP) {
+			localAddr = i.getAddress().IP.To4()
+		}
+	}
+	vl.setEndpoint(peer.virtualNeighborAddress(), peer.NextHops)
+	// The cost of the intra-area path may exceed the 16-bit metric of
+	// router-LSA links.
+	cost := min(peer.Distance, packet2.MaxLinkMetric)
+
+	if i.currState() != InterfaceDown {
+		if i.getAddress().IP.Equal(localAddr) {
+			if i.getOutputCost() != cost {
+				i.addrMu.Lock()
+				i.OutputCost = cost
+				i.addrMu.Unlock()
+				i.Area.updateSelfOriginatedRouterLSA(i)
+			}
+			return
+		}
+		// The path to the virtual neighbor now leaves through another
+		// interface. The adjacency is rebuilt with the new address.
+		i.consumeEvent(IfEvInterfaceDown)
+	}
+	i.addrMu.Lock()
+	i.Address = &net.IPNet{IP: localAddr, Mask: net.CIDRMask(0, 32)}
+	i.OutputCost = cost
+	i.addrMu.Unlock()
+	LogInfo("virtual link %s up: local address %v, cost %d through transit area %v",
+		i.c.ifi.Name, localAddr, cost, uint32ToIPv4(vl.transit.AreaId))
+	i.consumeEvent(IfEvInterfaceUp)
+}
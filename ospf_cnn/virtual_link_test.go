@@ -0,0 +1,204 @@
+package ospf_cnn
+
+import (
+	"context"
+	"encoding/binary"
+	packet2 "github.com/SvenShi/ospf-neighbor/ospf_cnn/packet"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/gopacket/gopacket/layers"
+	"golang.org/x/net/ipv4"
+)
+
+// testVirtualLink attaches the virtual link to peer through the transit area
+// to the backbone. The packets sent over it are dropped.
+func (i *Instance) testVirtualLink(t *testing.T, transit *Area, peer string) *Interface {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	ifi := &Interface{
+		ctx:    ctx,
+		cancel: cancel,
+		c: &Conn{
+			ifi: &net.Interface{Name: "vlink-" + peer},
+			wMu: &sync.Mutex{},
+			rc:  &ipv4.RawConn{},
+		},
+		pendingSendPkt:     make(chan sendPkt, 100),
+		Type:               IfTypeVirtualLink,
+		Address:            &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+		Area:               i.Backbone,
+		HelloInterval:      10,
+		RouterDeadInterval: 40,
+		Neighbors:          make(map[uint32]*Neighbor),
+		OutputCost:         packet2.LSInfinity,
+		vl:                 &virtualLink{transit: transit, peerId: ip(peer)},
+	}
+	i.Backbone.Interfaces = append(i.Backbone.Interfaces, ifi)
+	return ifi
+}
+
+func TestUpdateVirtualLink(t *testing.T) {
+	i := newTestABR()
+	transit := i.getArea(ip("0.0.0.1"))
+	// 6.6.6.6 is reached through 4.4.4.4 over a point-to-point link of the
+	// maximum cost.
+	transit.testRouterLSA("4.4.4.4", flagB,
+		testLink(2, "10.1.0.1", "10.1.0.4", 50),
+		testLink(1, "6.6.6.6", "10.6.0.4", packet2.MaxLinkMetric))
+	transit.testRouterLSA("6.6.6.6", flagB, testLink(1, "4.4.4.4", "10.6.0.6", packet2.MaxLinkMetric))
+	vl := i.testVirtualLink(t, transit, "4.4.4.4")
+	far := i.testVirtualLink(t, transit, "6.6.6.6")
+	check := func(ifi *Interface, state InterfaceState, addr, peerAddr string, cost int) {
+		t.Helper()
+		if s := ifi.currState(); s != state {
+			t.Errorf("expecting %s %v but got %v", ifi.c.ifi.Name, state, s)
+		}
+		if s := ifi.currState(); s == InterfaceDown {
+			if ifi.vl.neighborAddress() != 0 || len(ifi.vl.getNextHops()) > 0 {
+				t.Errorf("expecting %s no endpoint but got %v via %v", ifi.c.ifi.Name,
+					uint32ToIPv4(ifi.vl.neighborAddress()), ifi.vl.getNextHops())
+			}
+			return
+		}
+		if got := ifi.getAddress().IP.String(); got != addr {
+			t.Errorf("expecting %s local address %s but got %s", ifi.c.ifi.Name, addr, got)
+		}
+		if got := uint32ToIPv4(ifi.vl.neighborAddress()).String(); got != peerAddr {
+			t.Errorf("expecting %s virtual neighbor address %s but got %s", ifi.c.ifi.Name, peerAddr, got)
+		}
+		if got := ifi.getOutputCost(); got != cost {
+			t.Errorf("expecting %s cost %d but got %d", ifi.c.ifi.Name, cost, got)
+		}
+	}
+
+	i.calculateRoutingTable()
+	check(vl, InterfacePointToPoint, "10.1.0.1", "10.1.0.4", 50)
+	// The cost of the path exceeding the 16-bit metric is clamped.
+	check(far, InterfacePointToPoint, "10.1.0.1", "10.6.0.6", packet2.MaxLinkMetric)
+
+	// A Type 4 link is described once the virtual neighbor is fully adjacent.
+	if links := vl.routerLSALinks(); len(links) != 0 {
+		t.Errorf("expecting no link but got %v", links)
+	}
+	vl.Neighbors[ip("4.4.4.4")] = &Neighbor{i: vl, NeighborId: ip("4.4.4.4"), State: NeighborFull}
+	links := vl.routerLSALinks()
+	if len(links) != 1 || links[0].Type != 4 || links[0].LinkID != ip("4.4.4.4") ||
+		links[0].LinkData != ip("10.1.0.1") || links[0].Metric != 50 {
+		t.Errorf("expecting virtual link to 4.4.4.4 via 10.1.0.1 cost 50 but got %v", links)
+	}
+	delete(vl.Neighbors, ip("4.4.4.4"))
+
+	// The cost follows the path through the transit area.
+	transit.testRouterLSA("1.1.1.1", flagB, testLink(2, "10.1.0.1", "10.1.0.1", 60))
+	i.calculateRoutingTable()
+	check(vl, InterfacePointToPoint, "10.1.0.1", "10.1.0.4", 60)
+
+	// The path now leaves through another interface, whose address the
+	// virtual link takes over.
+	transit.testInterface("10.3.0.1/24")
+	transit.testRouterLSA("1.1.1.1", flagB,
+		testLink(2, "10.1.0.1", "10.1.0.1", 60),
+		testLink(2, "10.3.0.1", "10.3.0.1", 20))
+	transit.testRouterLSA("4.4.4.4", flagB,
+		testLink(2, "10.1.0.1", "10.1.0.4", 60),
+		testLink(2, "10.3.0.1", "10.3.0.4", 20))
+	transit.testNetworkLSA("10.3.0.1", "1.1.1.1", "255.255.255.0", "1.1.1.1", "4.4.4.4")
+	i.calculateRoutingTable()
+	check(vl, InterfacePointToPoint, "10.3.0.1", "10.3.0.4", 20)
+
+	// The virtual neighbor is unreachable through the transit area.
+	delete(transit.RouterLSAs, testLSAHeader(layers.RouterLSAtypeV2, "4.4.4.4", "4.4.4.4").GetLSAIdentity())
+	i.calculateRoutingTable()
+	check(vl, InterfaceDown, "", "", 0)
+	check(far, InterfaceDown, "", "", 0)
+}
+
+func TestRejectsVirtualLinkPkt(t *testing.T) {
+	i := newTestABR()
+	transit := i.getArea(ip("0.0.0.1"))
+	vl := i.testVirtualLink(t, transit, "4.4.4.4")
+	ifi := transit.Interfaces[0]
+	pkt := func(dst, rtId, areaId string) recvPkt {
+		p := make([]byte, 24)
+		binary.BigEndian.PutUint32(p[4:8], ip(rtId))
+		binary.BigEndian.PutUint32(p[8:12], ip(areaId))
+		return recvPkt{h: &ipv4.Header{Dst: net.ParseIP(dst)}, p: p}
+	}
+	for _, tt := range []struct {
+		name    string
+		up      bool
+		pkt     recvPkt
+		vl, ifi bool
+	}{
+		{name: "over the virtual link", up: true, pkt: pkt("10.1.0.1", "4.4.4.4", "0.0.0.0"), vl: false, ifi: true},
+		{name: "virtual link down", up: false, pkt: pkt("10.1.0.1", "4.4.4.4", "0.0.0.0"), vl: true, ifi: true},
+		{name: "multicast", up: true, pkt: pkt("224.0.0.5", "4.4.4.4", "0.0.0.0"), vl: true, ifi: false},
+		{name: "transit area", up: true, pkt: pkt("10.1.0.1", "4.4.4.4", "0.0.0.1"), vl: true, ifi: false},
+		{name: "other router", up: true, pkt: pkt("10.1.0.1", "5.5.5.5", "0.0.0.0"), vl: true, ifi: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.up {
+				i.calculateRoutingTable()
+			} else {
+				vl.updateVirtualLink(nil)
+			}
+			if got := vl.rejectsVirtualLinkPkt(tt.pkt); got != tt.vl {
+				t.Errorf("expecting virtual link rejecting %v but got %v", tt.vl, got)
+			}
+			if got := ifi.rejectsVirtualLinkPkt(tt.pkt); got != tt.ifi {
+				t.Errorf("expecting interface rejecting %v but got %v", tt.ifi, got)
+			}
+		})
+	}
+}
+
+func TestCalculateTransitAreaRoutes(t *testing.T) {
+	flagV := packet2.BitOption(0).SetBit(packet2.RouterLSAFlagVbit)
+	for _, tt := range []struct {
+		name     string
+		transit  bool
+		expected []expectedRoute
+	}{
+		{
+			name:    "transit area",
+			transit: true,
+			expected: []expectedRoute{
+				// cheaper through the transit area.
+				{prefix: "10.9.0.0/24", pathType: RoutingPathIntraArea, cost: 6, nextHops: []string{"10.1.0.4"}},
+				// as cheap as through the backbone.
+				{prefix: "10.2.0.0/24", pathType: RoutingPathIntraArea, cost: 15, nextHops: []string{"10.0.0.2", "10.1.0.4"}},
+			},
+		},
+		{
+			name: "not transit area",
+			expected: []expectedRoute{
+				{prefix: "10.9.0.0/24", pathType: RoutingPathIntraArea, cost: 15, nextHops: []string{"10.0.0.2", "10.0.0.3"}},
+				{prefix: "10.2.0.0/24", pathType: RoutingPathIntraArea, cost: 15, nextHops: []string{"10.0.0.2"}},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newTestABR()
+			a := i.getArea(ip("0.0.0.1"))
+			flags := flagB
+			if tt.transit {
+				flags |= flagV
+			}
+			a.testRouterLSA("1.1.1.1", flags, testLink(2, "10.1.0.1", "10.1.0.1", 5))
+			a.testRouterLSA("4.4.4.4", flagB, testLink(2, "10.1.0.1", "10.1.0.4", 5))
+			a.testSummaryLSA(layers.SummaryLSANetworktypeV2, "10.9.0.0", "4.4.4.4", "255.255.255.0", 1)
+			a.testSummaryLSA(layers.SummaryLSANetworktypeV2, "10.2.0.0", "4.4.4.4", "255.255.255.0", 10)
+			rt, _ := i.calculateRoutingTable()
+			checkRoutes(t, rt, tt.expected)
+			for _, exp := range tt.expected {
+				_, network, _ := net.ParseCIDR(exp.prefix)
+				e := rt.networks[routingNetworkKey{addr: ipv4BytesToUint32(network.IP.To4()), mask: ipv4MaskToUint32(network.Mask)}]
+				if e != nil && e.Area != 0 {
+					t.Errorf("expecting %s in the backbone but got area %v", exp.prefix, uint32ToIPv4(e.Area))
+				}
+			}
+		})
+	}
+}